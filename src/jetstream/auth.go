@@ -224,14 +224,27 @@ func (p *portalProxy) loginToUAA(c echo.Context) error {
 
 func (p *portalProxy) doLoginToUAA(c echo.Context) (*interfaces.LoginRes, error) {
 	log.Debug("loginToUAA")
+
+	// Username is only available for password logins (not SSO)
+	username := c.FormValue("username")
+	clientIP := p.getLoginClientIP(c)
+	if err := p.checkLoginThrottle(c, username, clientIP); err != nil {
+		return nil, err
+	}
+	defer p.LoginThrottle.Release(username, clientIP)
+
 	uaaRes, u, err := p.login(c, p.Config.ConsoleConfig.SkipSSLValidation, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint())
 	if err != nil {
+		if isLoginRejected(err) {
+			p.LoginThrottle.RecordFailure(username, clientIP)
+		}
 		err = interfaces.NewHTTPShadowError(
 			http.StatusUnauthorized,
 			"Access Denied",
 			"Access Denied: %v", err)
 		return nil, err
 	}
+	p.LoginThrottle.RecordSuccess(username)
 
 	sessionValues := make(map[string]interface{})
 	sessionValues["user_id"] = u.UserGUID
@@ -508,6 +521,68 @@ func (p *portalProxy) ConnectOAuth2(c echo.Context, cnsiRecord interfaces.CNSIRe
 	return &tokenRecord, nil
 }
 
+// ConnectOAuth2ClientCredentials connects to an endpoint using an OAuth2 client credentials grant
+// The client secret is stored (encrypted) in place of the refresh token, so that the token can be renewed when it expires
+func (p *portalProxy) ConnectOAuth2ClientCredentials(c echo.Context, cnsiRecord interfaces.CNSIRecord) (*interfaces.TokenRecord, error) {
+	clientID := c.FormValue("client_id")
+	clientSecret := c.FormValue("client_secret")
+	if len(clientID) == 0 || len(clientSecret) == 0 {
+		return nil, errors.New("Needs client id and client secret")
+	}
+
+	tokenEndpoint := fmt.Sprintf("%s/oauth/token", cnsiRecord.TokenEndpoint)
+	uaaRes, err := p.getUAATokenWithClientCredentials(cnsiRecord.SkipSSLValidation, clientID, clientSecret, tokenEndpoint)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Login failed",
+			"Login failed: %v", err)
+	}
+
+	u, err := p.GetUserTokenInfo(uaaRes.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := json.Marshal(&interfaces.OAuth2Metadata{
+		ClientID:  clientID,
+		GrantType: interfaces.OAuth2GrantTypeClientCredentials,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRecord := p.InitEndpointTokenRecord(u.TokenExpiry, uaaRes.AccessToken, clientSecret, false)
+	tokenRecord.Metadata = string(metadata)
+	return &tokenRecord, nil
+}
+
+// ConnectBearer connects to an endpoint using a bearer token supplied by the user
+// Bearer tokens can not be refreshed - the user will need to reconnect with a new token once it expires
+func (p *portalProxy) ConnectBearer(c echo.Context, cnsiRecord interfaces.CNSIRecord) (*interfaces.TokenRecord, error) {
+	token := strings.TrimSpace(c.FormValue("token"))
+	if strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = strings.TrimSpace(token[len("bearer "):])
+	}
+
+	if len(token) == 0 {
+		return nil, errors.New("Needs bearer token")
+	}
+
+	// If the token is a JWT, then we can determine its expiry
+	var expiry int64
+	if u, err := p.GetUserTokenInfo(token); err == nil {
+		expiry = u.TokenExpiry
+	}
+
+	tokenRecord := interfaces.TokenRecord{
+		AuthToken:   token,
+		TokenExpiry: expiry,
+		AuthType:    interfaces.AuthTypeBearer,
+	}
+	return &tokenRecord, nil
+}
+
 func (p *portalProxy) fetchHttpBasicToken(cnsiRecord interfaces.CNSIRecord, c echo.Context) (*UAAResponse, *interfaces.JWTUserTokenInfo, *interfaces.CNSIRecord, error) {
 
 	uaaRes, u, err := p.loginHttpBasic(c)
@@ -590,15 +665,30 @@ func (p *portalProxy) logoutOfCNSI(c echo.Context) error {
 		}
 	}
 
+	// Close the connections made with the client certificate, if the endpoint was connected with one
+	if ok {
+		evictCertificateTransport(tr)
+	}
+
 	return nil
 }
 
-func (p *portalProxy) RefreshUAALogin(username, password string, store bool) error {
+func (p *portalProxy) RefreshUAALogin(c echo.Context, username, password string, store bool) error {
 	log.Debug("RefreshUAALogin")
+	clientIP := p.getLoginClientIP(c)
+	if err := p.checkLoginThrottle(c, username, clientIP); err != nil {
+		return err
+	}
+	defer p.LoginThrottle.Release(username, clientIP)
+
 	uaaRes, err := p.getUAATokenWithCreds(p.Config.ConsoleConfig.SkipSSLValidation, username, password, p.Config.ConsoleConfig.ConsoleClient, p.Config.ConsoleConfig.ConsoleClientSecret, p.getUAAIdentityEndpoint())
 	if err != nil {
+		if isLoginRejected(err) {
+			p.LoginThrottle.RecordFailure(username, clientIP)
+		}
 		return err
 	}
+	p.LoginThrottle.RecordSuccess(username)
 
 	u, err := p.GetUserTokenInfo(uaaRes.AccessToken)
 	if err != nil {
@@ -706,6 +796,16 @@ func (p *portalProxy) getUAATokenWithCreds(skipSSLValidation bool, username, pas
 	return p.getUAAToken(body, skipSSLValidation, client, clientSecret, authEndpoint)
 }
 
+func (p *portalProxy) getUAATokenWithClientCredentials(skipSSLValidation bool, client, clientSecret, authEndpoint string) (*UAAResponse, error) {
+	log.Debug("getUAATokenWithClientCredentials")
+
+	body := url.Values{}
+	body.Set("grant_type", "client_credentials")
+	body.Set("response_type", "token")
+
+	return p.getUAAToken(body, skipSSLValidation, client, clientSecret, authEndpoint)
+}
+
 func (p *portalProxy) getUAATokenWithRefreshToken(skipSSLValidation bool, refreshToken, client, clientSecret, authEndpoint string, scopes string) (*UAAResponse, error) {
 	log.Debug("getUAATokenWithRefreshToken")
 
@@ -1042,10 +1142,29 @@ func (p *portalProxy) GetCNSIUserFromToken(cnsiGUID string, cfTokenRecord *inter
 			GUID: cfTokenRecord.RefreshToken,
 			Name: cfTokenRecord.RefreshToken,
 		}
+	} else if cfTokenRecord.AuthType == interfaces.AuthTypeCertificate {
+		// Client certificates identify the user by the certificate subject
+		cert, err := parseCertificate(cfTokenRecord.AuthToken)
+		if err != nil {
+			msg := "Unable to parse the client certificate: %s"
+			log.Errorf(msg, err)
+			return nil, false
+		}
+		cnsiUser = &interfaces.ConnectedUser{
+			GUID: cert.Subject.CommonName,
+			Name: cert.Subject.CommonName,
+		}
 	} else {
 		// get the scope out of the JWT token data
 		userTokenInfo, err := p.GetUserTokenInfo(cfTokenRecord.AuthToken)
 		if err != nil {
+			// Bearer tokens do not have to be JWTs
+			if cfTokenRecord.AuthType == interfaces.AuthTypeBearer {
+				return &interfaces.ConnectedUser{
+					GUID: interfaces.AuthConnectTypeBearer,
+					Name: "Bearer Token",
+				}, true
+			}
 			msg := "Unable to find scope information in the CNSI UAA Auth Token: %s"
 			log.Errorf(msg, err)
 			return nil, false
@@ -1057,6 +1176,12 @@ func (p *portalProxy) GetCNSIUserFromToken(cnsiGUID string, cfTokenRecord *inter
 			Name:   userTokenInfo.UserName,
 			Scopes: userTokenInfo.Scope,
 		}
+
+		// Client credential tokens identify the client rather than a user
+		if len(cnsiUser.GUID) == 0 {
+			cnsiUser.GUID = userTokenInfo.ClientID
+			cnsiUser.Name = userTokenInfo.ClientID
+		}
 		scope = userTokenInfo.Scope
 	}
 
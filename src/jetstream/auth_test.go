@@ -688,3 +688,77 @@ func TestVerifySessionExpired(t *testing.T) {
 	})
 
 }
+
+func TestConnectOAuth2ClientCredentials(t *testing.T) {
+	t.Parallel()
+
+	Convey("Connect with client credentials", t, func() {
+
+		req := setupMockReq("POST", "", map[string]string{
+			"connect_type":  interfaces.AuthConnectTypeClientCredentials,
+			"client_id":     "ci-client",
+			"client_secret": "ci-secret",
+		})
+
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		mockUAA := setupMockServer(t,
+			msRoute("/oauth/token"),
+			msMethod("POST"),
+			msStatus(http.StatusOK),
+			msBody(jsonMust(UAAResponse{AccessToken: mockUAAToken})))
+
+		defer mockUAA.Close()
+
+		mockCNSI := interfaces.CNSIRecord{
+			GUID:              mockCNSIGUID,
+			CNSIType:          "cf",
+			TokenEndpoint:     mockUAA.URL,
+			SkipSSLValidation: true,
+		}
+
+		tokenRecord, err := pp.ConnectOAuth2ClientCredentials(ctx, mockCNSI)
+
+		Convey("Should store the client secret in place of the refresh token", func() {
+			So(err, ShouldBeNil)
+			So(tokenRecord.AuthToken, ShouldEqual, mockUAAToken)
+			So(tokenRecord.RefreshToken, ShouldEqual, "ci-secret")
+			So(tokenRecord.AuthType, ShouldEqual, interfaces.AuthTypeOAuth2)
+			So(tokenRecord.Metadata, ShouldContainSubstring, interfaces.OAuth2GrantTypeClientCredentials)
+		})
+	})
+}
+
+func TestConnectBearer(t *testing.T) {
+	t.Parallel()
+
+	Convey("Connect with bearer token", t, func() {
+
+		Convey("Should require a token", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"connect_type": interfaces.AuthConnectTypeBearer,
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			_, err := pp.ConnectBearer(ctx, interfaces.CNSIRecord{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should accept a token with a bearer prefix", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"connect_type": interfaces.AuthConnectTypeBearer,
+				"token":        "bearer " + mockUAAToken,
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			tokenRecord, err := pp.ConnectBearer(ctx, interfaces.CNSIRecord{})
+			So(err, ShouldBeNil)
+			So(tokenRecord.AuthToken, ShouldEqual, mockUAAToken)
+			So(tokenRecord.AuthType, ShouldEqual, interfaces.AuthTypeBearer)
+			So(tokenRecord.TokenExpiry, ShouldBeGreaterThan, 0)
+		})
+	})
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func (p *portalProxy) doBearerFlowRequest(cnsiRequest *interfaces.CNSIRequest, req *http.Request) (*http.Response, error) {
+	log.Debug("doBearerFlowRequest")
+
+	// get a cnsi token record and a cnsi record
+	tokenRec, cnsi, err := p.getCNSIRequestRecords(cnsiRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve Endpoint records: %v", err)
+	}
+
+	// Bearer tokens supplied by the user can not be refreshed - pass the token through as-is
+	req.Header.Set("Authorization", "bearer "+tokenRec.AuthToken)
+	client := p.GetHttpClientForRequest(req, cnsi.SkipSSLValidation)
+	return client.Do(req)
+}
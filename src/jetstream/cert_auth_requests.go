@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Transports that have not been used for this long are evicted, e.g. after a certificate has been rotated
+const certTransportIdleTimeout = 15 * time.Minute
+
+// certTransport is a transport for a client certificate, and the endpoints that it has been used for
+type certTransport struct {
+	transport *http.Transport
+	endpoints map[string]bool
+	lastUsed  time.Time
+}
+
+// Transports are cached per client certificate so that connections can be re-used across requests
+var (
+	certTransports     = make(map[string]*certTransport)
+	certTransportsLock sync.Mutex
+)
+
+// ConnectCertificate connects to an endpoint using a client certificate and key (mutual TLS)
+// The PEM encoded certificate is stored as the auth token and the key as the refresh token - both are encrypted at rest
+func (p *portalProxy) ConnectCertificate(c echo.Context, cnsiRecord interfaces.CNSIRecord) (*interfaces.TokenRecord, error) {
+	cert := strings.TrimSpace(c.FormValue("cert"))
+	certKey := strings.TrimSpace(c.FormValue("cert_key"))
+	if len(cert) == 0 || len(certKey) == 0 {
+		return nil, errors.New("Needs certificate and certificate key")
+	}
+
+	if _, err := tls.X509KeyPair([]byte(cert), []byte(certKey)); err != nil {
+		return nil, fmt.Errorf("Invalid certificate or certificate key: %v", err)
+	}
+
+	x509Cert, err := parseCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRecord := interfaces.TokenRecord{
+		AuthToken:    cert,
+		RefreshToken: certKey,
+		TokenExpiry:  x509Cert.NotAfter.Unix(),
+		AuthType:     interfaces.AuthTypeCertificate,
+	}
+	return &tokenRecord, nil
+}
+
+// GetHttpClientForCertificate gets an HTTP Client that will present the client certificate in the token record
+func (p *portalProxy) GetHttpClientForCertificate(tokenRecord *interfaces.TokenRecord, skipSSLValidation bool) (http.Client, error) {
+	return getCertificateClient("", tokenRecord, skipSSLValidation, p.Config.HTTPClientTimeoutInSecs)
+}
+
+// getCertificateClient gets an HTTP Client that will present the client certificate in the token record to the
+// endpoint, so that the client's transport is evicted when the endpoint is unregistered
+func getCertificateClient(cnsiGUID string, tokenRecord *interfaces.TokenRecord, skipSSLValidation bool, timeoutInSecs int64) (http.Client, error) {
+	var client http.Client
+	if tokenRecord.AuthType != interfaces.AuthTypeCertificate {
+		return client, errors.New("Token is not a client certificate")
+	}
+
+	transport, err := getCertificateTransport(cnsiGUID, tokenRecord.AuthToken, tokenRecord.RefreshToken, skipSSLValidation)
+	if err != nil {
+		return client, err
+	}
+
+	client.Transport = transport
+	client.Timeout = time.Duration(timeoutInSecs) * time.Second
+	return client, nil
+}
+
+func certTransportKey(cert, certKey string, skipSSLValidation bool) string {
+	return fmt.Sprintf("%x:%t", sha256.Sum256([]byte(cert+certKey)), skipSSLValidation)
+}
+
+func getCertificateTransport(cnsiGUID, cert, certKey string, skipSSLValidation bool) (*http.Transport, error) {
+	key := certTransportKey(cert, certKey, skipSSLValidation)
+
+	certTransportsLock.Lock()
+	defer certTransportsLock.Unlock()
+
+	now := time.Now()
+	for otherKey, cached := range certTransports {
+		if otherKey != key && now.Sub(cached.lastUsed) > certTransportIdleTimeout {
+			evictCertTransport(otherKey, cached)
+		}
+	}
+
+	if cached, ok := certTransports[key]; ok {
+		cached.lastUsed = now
+		if len(cnsiGUID) > 0 {
+			cached.endpoints[cnsiGUID] = true
+		}
+		return cached.transport, nil
+	}
+
+	keyPair, err := tls.X509KeyPair([]byte(cert), []byte(certKey))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load client certificate: %v", err)
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig: &tls.Config{
+			Certificates:       []tls.Certificate{keyPair},
+			InsecureSkipVerify: skipSSLValidation,
+		},
+		MaxIdleConnsPerHost: 6,
+	}
+	cached := &certTransport{transport: transport, endpoints: make(map[string]bool), lastUsed: now}
+	if len(cnsiGUID) > 0 {
+		cached.endpoints[cnsiGUID] = true
+	}
+	certTransports[key] = cached
+	return transport, nil
+}
+
+// evictCertTransport removes a transport from the cache and closes its connections. The lock must be held
+func evictCertTransport(key string, cached *certTransport) {
+	delete(certTransports, key)
+	cached.transport.CloseIdleConnections()
+}
+
+// evictCertificateTransport evicts the transport for the client certificate in a token record, when the token is
+// disconnected
+func evictCertificateTransport(tokenRecord interfaces.TokenRecord) {
+	if tokenRecord.AuthType != interfaces.AuthTypeCertificate {
+		return
+	}
+
+	certTransportsLock.Lock()
+	defer certTransportsLock.Unlock()
+
+	for _, skipSSLValidation := range []bool{false, true} {
+		key := certTransportKey(tokenRecord.AuthToken, tokenRecord.RefreshToken, skipSSLValidation)
+		if cached, ok := certTransports[key]; ok {
+			evictCertTransport(key, cached)
+		}
+	}
+}
+
+// evictEndpointCertificateTransports evicts the transports that have been used for an endpoint, when it is
+// unregistered
+func evictEndpointCertificateTransports(cnsiGUID string) {
+	certTransportsLock.Lock()
+	defer certTransportsLock.Unlock()
+
+	for key, cached := range certTransports {
+		if cached.endpoints[cnsiGUID] {
+			evictCertTransport(key, cached)
+		}
+	}
+}
+
+func parseCertificate(cert string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil {
+		return nil, errors.New("Unable to decode certificate")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func (p *portalProxy) doCertificateFlowRequest(cnsiRequest *interfaces.CNSIRequest, req *http.Request) (*http.Response, error) {
+	log.Debug("doCertificateFlowRequest")
+
+	// get a cnsi token record and a cnsi record
+	tokenRec, cnsi, err := p.getCNSIRequestRecords(cnsiRequest)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve Endpoint records: %v", err)
+	}
+
+	// Client certificates can not be refreshed - the user will need to reconnect with a new certificate when it expires
+	if time.Unix(tokenRec.TokenExpiry, 0).Before(time.Now()) {
+		return nil, fmt.Errorf("Client certificate for Endpoint with GUID %s has expired", cnsiRequest.GUID)
+	}
+
+	client, err := getCertificateClient(cnsiRequest.GUID, &tokenRec, cnsi.SkipSSLValidation, p.Config.HTTPClientTimeoutInSecs)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
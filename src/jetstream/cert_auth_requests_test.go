@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func generateTestClientCertificate(commonName string, notAfter time.Time) (string, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return string(cert), string(certKey), nil
+}
+
+func TestConnectCertificate(t *testing.T) {
+	t.Parallel()
+
+	notAfter := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	cert, certKey, err := generateTestClientCertificate("test-client", notAfter)
+	if err != nil {
+		t.Fatalf("Unable to generate test certificate: %v", err)
+	}
+
+	Convey("Connect with client certificate", t, func() {
+
+		Convey("Should require a certificate and key", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"connect_type": interfaces.AuthConnectTypeCertificate,
+				"cert":         cert,
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			_, err := pp.ConnectCertificate(ctx, interfaces.CNSIRecord{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should reject an invalid certificate", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"connect_type": interfaces.AuthConnectTypeCertificate,
+				"cert":         "not a certificate",
+				"cert_key":     certKey,
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			_, err := pp.ConnectCertificate(ctx, interfaces.CNSIRecord{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should accept a valid certificate and key", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"connect_type": interfaces.AuthConnectTypeCertificate,
+				"cert":         cert,
+				"cert_key":     certKey,
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			tokenRecord, err := pp.ConnectCertificate(ctx, interfaces.CNSIRecord{})
+			So(err, ShouldBeNil)
+			So(tokenRecord.AuthType, ShouldEqual, interfaces.AuthTypeCertificate)
+			So(tokenRecord.AuthToken, ShouldEqual, cert[:len(cert)-1])
+			So(tokenRecord.TokenExpiry, ShouldEqual, notAfter.Unix())
+
+			Convey("Should create an HTTP client that presents the certificate", func() {
+				client, err := pp.GetHttpClientForCertificate(tokenRecord, true)
+				So(err, ShouldBeNil)
+				So(client.Transport, ShouldNotBeNil)
+
+				other, err := pp.GetHttpClientForCertificate(tokenRecord, true)
+				So(err, ShouldBeNil)
+				So(other.Transport, ShouldEqual, client.Transport)
+			})
+
+			Convey("Should evict the transport when the token is disconnected", func() {
+				client, err := pp.GetHttpClientForCertificate(tokenRecord, true)
+				So(err, ShouldBeNil)
+
+				evictCertificateTransport(*tokenRecord)
+
+				other, err := pp.GetHttpClientForCertificate(tokenRecord, true)
+				So(err, ShouldBeNil)
+				So(other.Transport, ShouldNotEqual, client.Transport)
+			})
+
+			Convey("Should evict the transports of an endpoint when it is unregistered", func() {
+				client, err := getCertificateClient(mockCNSIGUID, tokenRecord, true, 0)
+				So(err, ShouldBeNil)
+
+				evictEndpointCertificateTransports("another-endpoint")
+				other, err := getCertificateClient(mockCNSIGUID, tokenRecord, true, 0)
+				So(err, ShouldBeNil)
+				So(other.Transport, ShouldEqual, client.Transport)
+
+				evictEndpointCertificateTransports(mockCNSIGUID)
+				other, err = getCertificateClient(mockCNSIGUID, tokenRecord, true, 0)
+				So(err, ShouldBeNil)
+				So(other.Transport, ShouldNotEqual, client.Transport)
+			})
+		})
+
+		Convey("Should not create a certificate HTTP client for other token types", func() {
+			req := setupMockReq("GET", "", nil)
+			_, _, _, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			_, err := pp.GetHttpClientForCertificate(&interfaces.TokenRecord{AuthType: interfaces.AuthTypeBearer}, true)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -62,11 +62,24 @@ func (p *portalProxy) RegisterEndpoint(c echo.Context, fetchInfo interfaces.Info
 		cnsiClientSecret = p.GetConfig().CFClientSecret
 	}
 
+	attributes, hasAttributes, err := getCNSIAttributeParams(c)
+	if err != nil {
+		return err
+	}
+
 	newCNSI, err := p.DoRegisterEndpoint(cnsiName, apiEndpoint, skipSSLValidation, cnsiClientId, cnsiClientSecret, ssoAllowed, fetchInfo)
 	if err != nil {
 		return err
 	}
 
+	if hasAttributes {
+		if err = p.setCNSIAttributes(newCNSI.GUID, attributes); err != nil {
+			log.Warnf("Unable to save attributes of endpoint %s: %v", newCNSI.Name, err)
+		} else {
+			newCNSI.CNSIAttributes = attributes
+		}
+	}
+
 	c.JSON(http.StatusCreated, newCNSI)
 	return nil
 }
@@ -82,15 +95,6 @@ func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, sk
 
 	apiEndpoint = strings.TrimRight(apiEndpoint, "/")
 
-	// Remove trailing slash, if there is one
-	apiEndpointURL, err := url.Parse(apiEndpoint)
-	if err != nil {
-		return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
-			http.StatusBadRequest,
-			"Failed to get API Endpoint",
-			"Failed to get API Endpoint: %v", err)
-	}
-
 	// check if we've already got this endpoint in the DB
 	ok := p.cnsiRecordExists(apiEndpoint)
 	if ok {
@@ -102,6 +106,40 @@ func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, sk
 		)
 	}
 
+	newCNSI, err := p.newCNSIRecord(cnsiName, apiEndpoint, skipSSLValidation, clientId, clientSecret, ssoAllowed, fetchInfo)
+	if err != nil {
+		return interfaces.CNSIRecord{}, err
+	}
+
+	// Unregistered endpoints are only kept while there is a restore window
+	if p.Config.EndpointRestoreWindowInSecs > 0 {
+		if err = p.purgeDeletedEndpointWithGUID(newCNSI.GUID); err != nil {
+			return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
+				http.StatusInternalServerError,
+				"Failed to replace unregistered endpoint",
+				"Failed to purge unregistered endpoint %s: %v", newCNSI.GUID, err)
+		}
+	}
+
+	err = p.setCNSIRecord(newCNSI.GUID, newCNSI)
+
+	return newCNSI, err
+}
+
+// newCNSIRecord builds the record of an endpoint's registration from the endpoint's info, without saving it
+func (p *portalProxy) newCNSIRecord(cnsiName string, apiEndpoint string, skipSSLValidation bool, clientId string, clientSecret string, ssoAllowed bool, fetchInfo interfaces.InfoFunc) (interfaces.CNSIRecord, error) {
+
+	// Remove trailing slash, if there is one
+	apiEndpoint = strings.TrimRight(apiEndpoint, "/")
+
+	apiEndpointURL, err := url.Parse(apiEndpoint)
+	if err != nil {
+		return interfaces.CNSIRecord{}, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Failed to get API Endpoint",
+			"Failed to get API Endpoint: %v", err)
+	}
+
 	newCNSI, _, err := fetchInfo(apiEndpoint, skipSSLValidation)
 	if err != nil {
 		if ok, detail := isSSLRelatedError(err); ok {
@@ -120,8 +158,9 @@ func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, sk
 
 	h := sha1.New()
 	h.Write([]byte(apiEndpointURL.String()))
-	guid := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 
+	// set the guid on the object so it's returned in the response
+	newCNSI.GUID = base64.RawURLEncoding.EncodeToString(h.Sum(nil))
 	newCNSI.Name = cnsiName
 	newCNSI.APIEndpoint = apiEndpointURL
 	newCNSI.SkipSSLValidation = skipSSLValidation
@@ -129,15 +168,9 @@ func (p *portalProxy) DoRegisterEndpoint(cnsiName string, apiEndpoint string, sk
 	newCNSI.ClientSecret = clientSecret
 	newCNSI.SSOAllowed = ssoAllowed
 
-	err = p.setCNSIRecord(guid, newCNSI)
-
-	// set the guid on the object so it's returned in the response
-	newCNSI.GUID = guid
-
-	return newCNSI, err
+	return newCNSI, nil
 }
 
-// TODO (wchrisjohnson) We need do this as a TRANSACTION, vs a set of single calls
 func (p *portalProxy) unregisterCluster(c echo.Context) error {
 	cnsiGUID := c.FormValue("cnsi_guid")
 	log.WithField("cnsiGUID", cnsiGUID).Debug("unregisterCluster")
@@ -149,9 +182,20 @@ func (p *portalProxy) unregisterCluster(c echo.Context) error {
 			"Need CNSI GUID passed as form param")
 	}
 
-	p.unsetCNSIRecord(cnsiGUID)
+	cnsiRecord, err := p.GetCNSIRecord(cnsiGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested endpoint not registered",
+			"No Endpoint registered with GUID %s: %s", cnsiGUID, err)
+	}
 
-	p.unsetCNSITokenRecords(cnsiGUID)
+	if err = p.doUnregisterEndpoint(cnsiRecord); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to unregister endpoint",
+			"Failed to unregister endpoint %s: %v", cnsiGUID, err)
+	}
 
 	return nil
 }
@@ -171,11 +215,24 @@ func (p *portalProxy) buildCNSIList(c echo.Context) ([]*interfaces.CNSIRecord, e
 		return cnsiList, err
 	}
 
+	if err = p.addCNSIAttributes(cnsiList); err != nil {
+		log.Warnf("Unable to retrieve endpoint attributes: %v", err)
+	}
+
 	return cnsiList, nil
 }
 
 func (p *portalProxy) listCNSIs(c echo.Context) error {
 	log.Debug("listCNSIs")
+	selector, err := parseLabelSelector(c.QueryParam("labels"))
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			err.Error(),
+			"Invalid label selector: %v", err,
+		)
+	}
+
 	cnsiList, err := p.buildCNSIList(c)
 	if err != nil {
 		return interfaces.NewHTTPShadowError(
@@ -185,6 +242,8 @@ func (p *portalProxy) listCNSIs(c echo.Context) error {
 		)
 	}
 
+	cnsiList = filterCNSIList(cnsiList, selector, c.QueryParam("group"))
+
 	jsonString, err := marshalCNSIlist(cnsiList)
 	if err != nil {
 		return err
@@ -298,6 +357,58 @@ func (p *portalProxy) GetCNSIRecordByEndpoint(endpoint string) (interfaces.CNSIR
 	return rec, nil
 }
 
+// GetCNSIMetadata gets the metadata that the endpoint's plugin set when the endpoint was registered
+func (p *portalProxy) GetCNSIMetadata(cnsiGUID string) (map[string]string, error) {
+	log.Debug("GetCNSIMetadata")
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, err
+	}
+
+	return cnsiRepo.GetMetadata(cnsiGUID)
+}
+
+// SetCNSIMetadata replaces the metadata of an endpoint
+func (p *portalProxy) SetCNSIMetadata(cnsiGUID string, metadata map[string]string) error {
+	log.Debug("SetCNSIMetadata")
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	err = cnsiRepo.SaveMetadata(cnsiGUID, metadata)
+	if err != nil {
+		msg := "Unable to save CNSI metadata: %v"
+		log.Errorf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	return nil
+}
+
+// mergeCNSIMetadata adds the given metadata to an endpoint's metadata, replacing any existing values of the same keys
+func (p *portalProxy) mergeCNSIMetadata(cnsiGUID string, metadata map[string]string) error {
+	existing, err := p.GetCNSIMetadata(cnsiGUID)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range metadata {
+		existing[key] = value
+	}
+	return p.SetCNSIMetadata(cnsiGUID, existing)
+}
+
+// getEndpointInfoFunc gets the function used to fetch the info of an endpoint that is registered with the given
+// metadata. The metadata is ignored if the endpoint's plugin does not keep registration settings in it
+func getEndpointInfoFunc(endpointPlugin interfaces.EndpointPlugin, metadata map[string]string) (interfaces.InfoFunc, bool) {
+	if metadataPlugin, ok := endpointPlugin.(interfaces.EndpointMetadataPlugin); ok {
+		return metadataPlugin.InfoWithMetadata(metadata), true
+	}
+	return endpointPlugin.Info, false
+}
+
 func (p *portalProxy) cnsiRecordExists(endpoint string) bool {
 	log.Debug("cnsiRecordExists")
 
@@ -323,6 +434,26 @@ func (p *portalProxy) setCNSIRecord(guid string, c interfaces.CNSIRecord) error
 	return nil
 }
 
+// overwriteCNSIRecord replaces the registration of an endpoint, keeping its GUID so that its tokens, token shares,
+// attributes and metadata still belong to it
+func (p *portalProxy) overwriteCNSIRecord(guid string, c interfaces.CNSIRecord) error {
+	log.Debug("overwriteCNSIRecord")
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	err = cnsiRepo.Overwrite(guid, c, p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		msg := "Unable to overwrite a CNSI record: %v"
+		log.Errorf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	return nil
+}
+
 func (p *portalProxy) unsetCNSIRecord(guid string) error {
 	log.Debug("unsetCNSIRecord")
 	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
@@ -343,12 +474,7 @@ func (p *portalProxy) unsetCNSIRecord(guid string) error {
 
 func (p *portalProxy) GetCNSITokenRecord(cnsiGUID string, userGUID string) (interfaces.TokenRecord, bool) {
 	log.Debug("GetCNSITokenRecord")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
-	if err != nil {
-		return interfaces.TokenRecord{}, false
-	}
-
-	tr, err := tokenRepo.FindCNSIToken(cnsiGUID, userGUID, p.Config.EncryptionKeyInBytes)
+	tr, err := p.findCNSITokenRecord(cnsiGUID, userGUID, false)
 	if err != nil {
 		return interfaces.TokenRecord{}, false
 	}
@@ -358,12 +484,7 @@ func (p *portalProxy) GetCNSITokenRecord(cnsiGUID string, userGUID string) (inte
 
 func (p *portalProxy) GetCNSITokenRecordWithDisconnected(cnsiGUID string, userGUID string) (interfaces.TokenRecord, bool) {
 	log.Debug("GetCNSITokenRecordWithDisconnected")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
-	if err != nil {
-		return interfaces.TokenRecord{}, false
-	}
-
-	tr, err := tokenRepo.FindCNSITokenIncludeDisconnected(cnsiGUID, userGUID, p.Config.EncryptionKeyInBytes)
+	tr, err := p.findCNSITokenRecord(cnsiGUID, userGUID, true)
 	if err != nil {
 		return interfaces.TokenRecord{}, false
 	}
@@ -442,22 +563,3 @@ func (p *portalProxy) unsetCNSITokenRecord(cnsiGUID string, userGUID string) err
 
 	return nil
 }
-
-func (p *portalProxy) unsetCNSITokenRecords(cnsiGUID string) error {
-	log.Debug("unsetCNSITokenRecord")
-	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
-	if err != nil {
-		msg := "Unable to establish a database reference: '%v'"
-		log.Errorf(msg, err)
-		return fmt.Errorf(msg, err)
-	}
-
-	err = tokenRepo.DeleteCNSITokens(cnsiGUID)
-	if err != nil {
-		msg := "Unable to delete a CNSI Token: %v"
-		log.Errorf(msg, err)
-		return fmt.Errorf(msg, err)
-	}
-
-	return nil
-}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Maximum length of an endpoint's description and group
+const maxCNSIAttributeLength = 255
+
+// addCNSIAttributes adds the user defined attributes (description, group and labels) to the endpoints
+func (p *portalProxy) addCNSIAttributes(cnsiList []*interfaces.CNSIRecord) error {
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	attributesList, err := cnsiRepo.ListAttributes()
+	if err != nil {
+		return err
+	}
+
+	for _, cnsi := range cnsiList {
+		if attributes, ok := attributesList[cnsi.GUID]; ok {
+			cnsi.CNSIAttributes = *attributes
+		}
+	}
+
+	return nil
+}
+
+// getCNSIAttributeParams gets the endpoint attributes from the form values of the request.
+// Returns false if no attributes were supplied
+func getCNSIAttributeParams(c echo.Context) (interfaces.CNSIAttributes, bool, error) {
+	attributes := interfaces.CNSIAttributes{
+		Description: strings.TrimSpace(c.FormValue("description")),
+		Group:       strings.TrimSpace(c.FormValue("group")),
+	}
+
+	if len(attributes.Description) > maxCNSIAttributeLength || len(attributes.Group) > maxCNSIAttributeLength {
+		return attributes, false, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			fmt.Sprintf("Description and group must be at most %d characters", maxCNSIAttributeLength),
+			"Endpoint description or group is too long")
+	}
+
+	labels, err := parseLabels(c.FormValue("labels"))
+	if err != nil {
+		return attributes, false, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			err.Error(),
+			"Invalid endpoint labels: %v", err)
+	}
+	if len(labels) > 0 {
+		attributes.Labels = labels
+	}
+
+	supplied := len(attributes.Description) > 0 || len(attributes.Group) > 0 || len(attributes.Labels) > 0
+	return attributes, supplied, nil
+}
+
+func (p *portalProxy) setCNSIAttributes(guid string, attributes interfaces.CNSIAttributes) error {
+	log.Debug("setCNSIAttributes")
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	err = cnsiRepo.SaveAttributes(guid, attributes)
+	if err != nil {
+		msg := "Unable to save CNSI attributes: %v"
+		log.Errorf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	return nil
+}
+
+// Admin endpoint to update the description, group and labels of an endpoint.
+// The existing attributes are replaced with those supplied
+func (p *portalProxy) updateCNSIAttributes(c echo.Context) error {
+	cnsiGUID := c.FormValue("cnsi_guid")
+	log.WithField("cnsiGUID", cnsiGUID).Debug("updateCNSIAttributes")
+
+	if len(cnsiGUID) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Missing target endpoint",
+			"Need CNSI GUID passed as form param")
+	}
+
+	cnsiRecord, err := p.GetCNSIRecord(cnsiGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested endpoint not registered",
+			"No Endpoint registered with GUID %s: %s", cnsiGUID, err)
+	}
+
+	attributes, _, err := getCNSIAttributeParams(c)
+	if err != nil {
+		return err
+	}
+
+	if err = p.setCNSIAttributes(cnsiGUID, attributes); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to update endpoint",
+			"Failed to update endpoint attributes: %v", err)
+	}
+
+	cnsiRecord.CNSIAttributes = attributes
+	return c.JSON(http.StatusOK, cnsiRecord)
+}
+
+// filterCNSIList returns the endpoints that match the label selector and are in the group (if supplied)
+func filterCNSIList(cnsiList []*interfaces.CNSIRecord, selector labelSelector, group string) []*interfaces.CNSIRecord {
+	filtered := make([]*interfaces.CNSIRecord, 0, len(cnsiList))
+	for _, cnsi := range cnsiList {
+		if len(group) > 0 && cnsi.Group != group {
+			continue
+		}
+		if !selector.Matches(cnsi.Labels) {
+			continue
+		}
+		filtered = append(filtered, cnsi)
+	}
+	return filtered
+}
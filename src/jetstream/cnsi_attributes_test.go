@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetCNSIAttributeParams(t *testing.T) {
+	t.Parallel()
+
+	Convey("Endpoint attributes of a request", t, func() {
+
+		Convey("Should get the description, group and labels", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"description": " Production ",
+				"group":       "prod",
+				"labels":      "env=prod,region=eu",
+			})
+			_, ctx := setupEchoContext(httptest.NewRecorder(), req)
+
+			attributes, ok, err := getCNSIAttributeParams(ctx)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(attributes.Description, ShouldEqual, "Production")
+			So(attributes.Group, ShouldEqual, "prod")
+			So(attributes.Labels, ShouldResemble, map[string]string{"env": "prod", "region": "eu"})
+		})
+
+		Convey("Should report that no attributes were supplied", func() {
+			_, ctx := setupEchoContext(httptest.NewRecorder(), setupMockReq("POST", "", map[string]string{}))
+
+			_, ok, err := getCNSIAttributeParams(ctx)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Should reject invalid attributes", func() {
+			for _, form := range []map[string]string{
+				{"description": strings.Repeat("x", maxCNSIAttributeLength+1)},
+				{"group": strings.Repeat("x", maxCNSIAttributeLength+1)},
+				{"labels": "env"},
+			} {
+				_, ctx := setupEchoContext(httptest.NewRecorder(), setupMockReq("POST", "", form))
+
+				_, _, err := getCNSIAttributeParams(ctx)
+				So(err, ShouldNotBeNil)
+				So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
+
+func TestFilterCNSIList(t *testing.T) {
+	t.Parallel()
+
+	Convey("Filter endpoints", t, func() {
+		cnsiList := []*interfaces.CNSIRecord{
+			{GUID: "1", CNSIAttributes: interfaces.CNSIAttributes{Group: "prod", Labels: map[string]string{"env": "prod"}}},
+			{GUID: "2", CNSIAttributes: interfaces.CNSIAttributes{Group: "dev", Labels: map[string]string{"env": "dev"}}},
+			{GUID: "3"},
+		}
+
+		Convey("Should filter by labels", func() {
+			selector, _ := parseLabelSelector("env")
+			filtered := filterCNSIList(cnsiList, selector, "")
+			So(filtered, ShouldHaveLength, 2)
+		})
+
+		Convey("Should filter by group", func() {
+			filtered := filterCNSIList(cnsiList, nil, "dev")
+			So(filtered, ShouldHaveLength, 1)
+			So(filtered[0].GUID, ShouldEqual, "2")
+		})
+
+		Convey("Should filter by labels and group", func() {
+			selector, _ := parseLabelSelector("env=prod")
+			So(filterCNSIList(cnsiList, selector, "prod"), ShouldHaveLength, 1)
+			So(filterCNSIList(cnsiList, selector, "dev"), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// How often unregistered endpoints are checked to see if their restore window has passed
+const deletedEndpointPurgeInterval = time.Minute
+
+// doUnregisterEndpoint unregisters an endpoint. If a restore window is configured the endpoint is soft deleted and
+// can be restored until the window passes, otherwise the endpoint and its tokens are purged immediately
+func (p *portalProxy) doUnregisterEndpoint(cnsiRecord interfaces.CNSIRecord) error {
+	log.Debug("doUnregisterEndpoint")
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	if p.Config.EndpointRestoreWindowInSecs > 0 {
+		if err = cnsiRepo.SoftDelete(cnsiRecord.GUID, time.Now().Unix()); err != nil {
+			msg := "Unable to unregister a CNSI record: %v"
+			log.Errorf(msg, err)
+			return fmt.Errorf(msg, err)
+		}
+		evictEndpointCertificateTransports(cnsiRecord.GUID)
+		p.notifyEndpointPlugins(interfaces.EndpointUnregisterAction, &cnsiRecord)
+		return nil
+	}
+
+	if err = p.purgeCNSIRecord(cnsiRecord.GUID); err != nil {
+		msg := "Unable to delete a CNSI record: %v"
+		log.Errorf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+	evictEndpointCertificateTransports(cnsiRecord.GUID)
+	p.notifyEndpointPlugins(interfaces.EndpointUnregisterAction, &cnsiRecord)
+	p.notifyEndpointPlugins(interfaces.EndpointPurgeAction, &cnsiRecord)
+	return nil
+}
+
+// purgeCNSIRecord permanently removes an endpoint, along with its tokens, token shares, attributes and metadata. This
+// is done in a single transaction, so either everything is removed or nothing is
+func (p *portalProxy) purgeCNSIRecord(cnsiGUID string) error {
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	txn, err := p.DatabaseConnectionPool.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to start transaction to purge endpoint: %v", err)
+	}
+
+	if err = tokenRepo.PurgeCNSITokensInTransaction(txn, cnsiGUID); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if err = cnsiRepo.PurgeInTransaction(txn, cnsiGUID); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to commit transaction to purge endpoint: %v", err)
+	}
+
+	return nil
+}
+
+// notifyEndpointPlugins tells the plugins that implement the endpoint notification hook about a change to an endpoint
+func (p *portalProxy) notifyEndpointPlugins(action interfaces.EndpointAction, cnsiRecord *interfaces.CNSIRecord) {
+	for _, plugin := range p.Plugins {
+		if notifier, ok := plugin.(interfaces.EndpointNotificationPlugin); ok {
+			notifier.OnEndpointNotification(action, cnsiRecord)
+		}
+	}
+}
+
+func (p *portalProxy) listDeletedCNSIRecords() ([]*interfaces.DeletedCNSIRecord, error) {
+	log.Debug("listDeletedCNSIRecords")
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+
+	deleted, err := cnsiRepo.ListDeleted(p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cnsi := range deleted {
+		cnsi.PurgeAt = cnsi.DeletedAt + p.Config.EndpointRestoreWindowInSecs
+	}
+
+	return deleted, nil
+}
+
+func findDeletedCNSIRecord(deleted []*interfaces.DeletedCNSIRecord, cnsiGUID string) (*interfaces.DeletedCNSIRecord, bool) {
+	for _, cnsi := range deleted {
+		if cnsi.GUID == cnsiGUID {
+			return cnsi, true
+		}
+	}
+	return nil, false
+}
+
+// Admin endpoint to list the endpoints that have been unregistered and can still be restored
+func (p *portalProxy) listDeletedEndpoints(c echo.Context) error {
+	log.Debug("listDeletedEndpoints")
+	deleted, err := p.listDeletedCNSIRecords()
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to retrieve list of unregistered endpoints",
+			"Failed to retrieve list of unregistered endpoints: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, deleted)
+}
+
+// Admin endpoint to restore an unregistered endpoint, along with its tokens
+func (p *portalProxy) restoreEndpoint(c echo.Context) error {
+	cnsiGUID := c.FormValue("cnsi_guid")
+	log.WithField("cnsiGUID", cnsiGUID).Debug("restoreEndpoint")
+
+	deletedRecord, err := p.getDeletedEndpointParam(cnsiGUID)
+	if err != nil {
+		return err
+	}
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to restore endpoint",
+			dbReferenceError, err)
+	}
+
+	if err = cnsiRepo.Restore(cnsiGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to restore endpoint",
+			"Failed to restore endpoint %s: %v", cnsiGUID, err)
+	}
+
+	p.notifyEndpointPlugins(interfaces.EndpointRestoreAction, deletedRecord.CNSIRecord)
+	return c.JSON(http.StatusOK, deletedRecord.CNSIRecord)
+}
+
+// Admin endpoint to permanently remove an unregistered endpoint without waiting for the restore window to pass
+func (p *portalProxy) purgeEndpoint(c echo.Context) error {
+	cnsiGUID := c.FormValue("cnsi_guid")
+	log.WithField("cnsiGUID", cnsiGUID).Debug("purgeEndpoint")
+
+	deletedRecord, err := p.getDeletedEndpointParam(cnsiGUID)
+	if err != nil {
+		return err
+	}
+
+	if err = p.purgeDeletedEndpoint(deletedRecord.CNSIRecord); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to purge endpoint",
+			"Failed to purge endpoint %s: %v", cnsiGUID, err)
+	}
+
+	return nil
+}
+
+func (p *portalProxy) getDeletedEndpointParam(cnsiGUID string) (*interfaces.DeletedCNSIRecord, error) {
+	if len(cnsiGUID) == 0 {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Missing target endpoint",
+			"Need CNSI GUID passed as form param")
+	}
+
+	deleted, err := p.listDeletedCNSIRecords()
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to retrieve list of unregistered endpoints",
+			"Failed to retrieve list of unregistered endpoints: %v", err)
+	}
+
+	deletedRecord, ok := findDeletedCNSIRecord(deleted, cnsiGUID)
+	if !ok {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested endpoint has not been unregistered",
+			"No unregistered Endpoint with GUID %s", cnsiGUID)
+	}
+
+	return deletedRecord, nil
+}
+
+// purgeDeletedEndpointWithGUID purges an unregistered endpoint that has not been purged yet, if there is one with the
+// GUID. Endpoint GUIDs are derived from their URLs, so an endpoint that is registered again with the same URL within
+// the restore window replaces the unregistered one
+func (p *portalProxy) purgeDeletedEndpointWithGUID(cnsiGUID string) error {
+	deleted, err := p.listDeletedCNSIRecords()
+	if err != nil {
+		return err
+	}
+
+	deletedRecord, ok := findDeletedCNSIRecord(deleted, cnsiGUID)
+	if !ok {
+		return nil
+	}
+
+	log.Infof("Purging unregistered endpoint %s (%s) as it has been registered again", deletedRecord.Name, cnsiGUID)
+	return p.purgeDeletedEndpoint(deletedRecord.CNSIRecord)
+}
+
+func (p *portalProxy) purgeDeletedEndpoint(cnsiRecord *interfaces.CNSIRecord) error {
+	if err := p.purgeCNSIRecord(cnsiRecord.GUID); err != nil {
+		return err
+	}
+
+	p.notifyEndpointPlugins(interfaces.EndpointPurgeAction, cnsiRecord)
+	return nil
+}
+
+// purgeDeletedEndpoints permanently removes the unregistered endpoints whose restore window has passed
+func (p *portalProxy) purgeDeletedEndpoints() {
+	deleted, err := p.listDeletedCNSIRecords()
+	if err != nil {
+		log.Warnf("Unable to purge unregistered endpoints: %v", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, cnsi := range deleted {
+		if cnsi.PurgeAt > now {
+			continue
+		}
+
+		log.Infof("Purging unregistered endpoint %s (%s)", cnsi.Name, cnsi.GUID)
+		if err := p.purgeDeletedEndpoint(cnsi.CNSIRecord); err != nil {
+			log.Warnf("Unable to purge unregistered endpoint %s: %v", cnsi.Name, err)
+		}
+	}
+}
+
+// startDeletedEndpointPurger starts the background purge of unregistered endpoints (if a restore window is configured).
+// Endpoints that were soft deleted before the restore window was disabled are purged at startup
+func (p *portalProxy) startDeletedEndpointPurger() {
+	p.purgeDeletedEndpoints()
+
+	if p.Config.EndpointRestoreWindowInSecs <= 0 {
+		return
+	}
+
+	log.Infof("Unregistered endpoints can be restored for %ds", p.Config.EndpointRestoreWindowInSecs)
+	go func() {
+		ticker := time.NewTicker(deletedEndpointPurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.purgeDeletedEndpoints()
+		}
+	}()
+}
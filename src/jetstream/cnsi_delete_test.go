@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+type mockNotificationPlugin struct {
+	actions []interfaces.EndpointAction
+}
+
+func (m *mockNotificationPlugin) Init() error { return nil }
+
+func (m *mockNotificationPlugin) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented")
+}
+
+func (m *mockNotificationPlugin) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return nil, errors.New("Not implemented")
+}
+
+func (m *mockNotificationPlugin) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return nil, errors.New("Not implemented")
+}
+
+func (m *mockNotificationPlugin) OnEndpointNotification(action interfaces.EndpointAction, endpoint *interfaces.CNSIRecord) {
+	m.actions = append(m.actions, action)
+}
+
+func TestUnregisterEndpoint(t *testing.T) {
+	t.Parallel()
+
+	Convey("Unregister an endpoint", t, func() {
+		req := setupMockReq("POST", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		notifier := &mockNotificationPlugin{}
+		pp.Plugins["notifier"] = notifier
+		cnsiRecord := interfaces.CNSIRecord{GUID: mockCFGUID, Name: "Some fancy CF Cluster"}
+
+		Convey("Should purge the endpoint and its tokens if there is no restore window", func() {
+			expectPurgeEndpoint(mock, mockCFGUID)
+
+			err := pp.doUnregisterEndpoint(cnsiRecord)
+			So(err, ShouldBeNil)
+			So(notifier.actions, ShouldResemble, []interfaces.EndpointAction{interfaces.EndpointUnregisterAction, interfaces.EndpointPurgeAction})
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should soft delete the endpoint if there is a restore window", func() {
+			pp.Config.EndpointRestoreWindowInSecs = 3600
+			mock.ExpectExec(`UPDATE cnsis SET deleted_at`).
+				WithArgs(sqlmock.AnyArg(), mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			err := pp.doUnregisterEndpoint(cnsiRecord)
+			So(err, ShouldBeNil)
+			So(notifier.actions, ShouldResemble, []interfaces.EndpointAction{interfaces.EndpointUnregisterAction})
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should report a failure and not notify plugins", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(`DELETE FROM tokens`).
+				WillReturnError(errors.New("Unknown Database Error"))
+			mock.ExpectRollback()
+
+			err := pp.doUnregisterEndpoint(cnsiRecord)
+			So(err, ShouldNotBeNil)
+			So(notifier.actions, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should keep the tokens if the endpoint can not be purged", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(`DELETE FROM tokens`).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(`DELETE FROM token_shares`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM cnsi_attributes`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM cnsi_metadata`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM cnsis`).
+				WillReturnError(errors.New("Unknown Database Error"))
+			mock.ExpectRollback()
+
+			err := pp.doUnregisterEndpoint(cnsiRecord)
+			So(err, ShouldNotBeNil)
+			So(notifier.actions, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+// expectPurgeEndpoint expects an endpoint and its tokens to be removed in a single transaction
+func expectPurgeEndpoint(mock sqlmock.Sqlmock, cnsiGUID string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM tokens`).
+		WithArgs(cnsiGUID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM token_shares`).
+		WithArgs(cnsiGUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM cnsi_attributes`).
+		WithArgs(cnsiGUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM cnsi_metadata`).
+		WithArgs(cnsiGUID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM cnsis`).
+		WithArgs(cnsiGUID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestRegisterUnregisteredEndpointAgain(t *testing.T) {
+	t.Parallel()
+
+	Convey("Register an endpoint that was unregistered within the restore window", t, func() {
+		mockV2Info := setupMockServer(t,
+			msRoute("/v2/info"),
+			msMethod("GET"),
+			msStatus(http.StatusOK),
+			msBody(jsonMust(mockV2InfoResponse)))
+		defer mockV2Info.Close()
+
+		req := setupMockReq("POST", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		pp.Config.EndpointRestoreWindowInSecs = 3600
+		notifier := &mockNotificationPlugin{}
+		pp.Plugins["notifier"] = notifier
+
+		h := sha1.New()
+		h.Write([]byte(mockV2Info.URL))
+		cnsiGUID := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+		cnsiRecord := interfaces.CNSIRecord{GUID: cnsiGUID, Name: "Some fancy CF Cluster"}
+
+		mock.ExpectExec(`UPDATE cnsis SET deleted_at`).
+			WithArgs(sqlmock.AnyArg(), cnsiGUID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		So(pp.doUnregisterEndpoint(cnsiRecord), ShouldBeNil)
+
+		encryptedClientSecret, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockClientSecret)
+		mock.ExpectQuery(`SELECT (.+) FROM cnsis WHERE deleted_at IS NOT NULL`).
+			WillReturnRows(sqlmock.NewRows([]string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "sso_allowed", "deleted_at"}).
+				AddRow(cnsiGUID, "Some fancy CF Cluster", "cf", mockV2Info.URL, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, mockClientId, encryptedClientSecret, false, 1500000000))
+		expectPurgeEndpoint(mock, cnsiGUID)
+		mock.ExpectExec(insertIntoCNSIs).
+			WithArgs(cnsiGUID, "Some fancy CF Cluster", "cf", mockV2Info.URL, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, mockClientId, sqlmock.AnyArg(), false).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		newCNSI, err := pp.DoRegisterEndpoint("Some fancy CF Cluster", mockV2Info.URL, true, mockClientId, mockClientSecret, false, getCFPlugin(pp, "cf").Info)
+		So(err, ShouldBeNil)
+		So(newCNSI.GUID, ShouldEqual, cnsiGUID)
+		So(notifier.actions, ShouldResemble, []interfaces.EndpointAction{interfaces.EndpointUnregisterAction, interfaces.EndpointPurgeAction})
+		So(mock.ExpectationsWereMet(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Formats that endpoint registrations can be exported in
+const (
+	endpointExportFormatJSON = "json"
+	endpointExportFormatYAML = "yaml"
+)
+
+// How an import should handle an endpoint that is already registered
+const (
+	endpointImportConflictSkip      = "skip"
+	endpointImportConflictOverwrite = "overwrite"
+	endpointImportConflictFail      = "fail"
+)
+
+// Result of importing a single endpoint
+const (
+	endpointImportCreated     = "created"
+	endpointImportOverwritten = "overwritten"
+	endpointImportSkipped     = "skipped"
+	endpointImportConflict    = "conflict"
+	endpointImportFailed      = "failed"
+)
+
+// EndpointExport is the exported form of an endpoint registration.
+// The client secret is only included if requested and is encrypted with the Jetstream encryption key,
+// so it can only be imported into a Stratos that uses the same key
+type EndpointExport struct {
+	Name              string `json:"name" yaml:"name"`
+	CNSIType          string `json:"cnsi_type" yaml:"cnsi_type"`
+	APIEndpoint       string `json:"api_endpoint" yaml:"api_endpoint"`
+	SkipSSLValidation bool   `json:"skip_ssl_validation" yaml:"skip_ssl_validation"`
+	SSOAllowed        bool   `json:"sso_allowed" yaml:"sso_allowed"`
+	ClientId          string `json:"client_id,omitempty" yaml:"client_id,omitempty"`
+	ClientSecret      string `json:"client_secret,omitempty" yaml:"client_secret,omitempty"`
+	// Settings the endpoint was registered with, for the endpoint types that keep them in the endpoint's metadata
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// EndpointExportList is the document produced by an export and consumed by an import
+type EndpointExportList struct {
+	Endpoints []*EndpointExport `json:"endpoints" yaml:"endpoints"`
+}
+
+// EndpointImportResult is the outcome of importing a single endpoint
+type EndpointImportResult struct {
+	Name        string `json:"name"`
+	CNSIType    string `json:"cnsi_type"`
+	APIEndpoint string `json:"api_endpoint"`
+	GUID        string `json:"guid,omitempty"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+}
+
+// EndpointImportReport is the response to an import
+type EndpointImportReport struct {
+	DryRun  bool                    `json:"dry_run"`
+	Results []*EndpointImportResult `json:"results"`
+}
+
+// Admin endpoint to export all of the endpoint registrations
+func (p *portalProxy) exportEndpoints(c echo.Context) error {
+	log.Debug("exportEndpoints")
+
+	format := strings.ToLower(c.QueryParam("format"))
+	if len(format) == 0 {
+		format = endpointExportFormatJSON
+	}
+	if format != endpointExportFormatJSON && format != endpointExportFormatYAML {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Export format must be 'json' or 'yaml'",
+			"Invalid export format: %s", format)
+	}
+
+	includeSecrets, err := strconv.ParseBool(c.QueryParam("include_secrets"))
+	if err != nil {
+		// default to false
+		includeSecrets = false
+	}
+
+	export, err := p.buildEndpointExport(includeSecrets)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to export endpoints",
+			"Failed to export endpoints: %v", err)
+	}
+
+	if format == endpointExportFormatYAML {
+		data, err := yaml.Marshal(export)
+		if err != nil {
+			return interfaces.NewHTTPShadowError(
+				http.StatusInternalServerError,
+				"Failed to export endpoints",
+				"Failed to marshal endpoints as YAML: %v", err)
+		}
+		c.Response().Header().Set("Content-Disposition", "attachment; filename=endpoints.yaml")
+		return c.Blob(http.StatusOK, "application/x-yaml", data)
+	}
+
+	c.Response().Header().Set("Content-Disposition", "attachment; filename=endpoints.json")
+	return c.JSON(http.StatusOK, export)
+}
+
+func (p *portalProxy) buildEndpointExport(includeSecrets bool) (*EndpointExportList, error) {
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return nil, fmt.Errorf(dbReferenceError, err)
+	}
+
+	cnsiList, err := cnsiRepo.List(p.Config.EncryptionKeyInBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &EndpointExportList{
+		Endpoints: make([]*EndpointExport, 0, len(cnsiList)),
+	}
+	for _, cnsi := range cnsiList {
+		endpoint := &EndpointExport{
+			Name:              cnsi.Name,
+			CNSIType:          cnsi.CNSIType,
+			SkipSSLValidation: cnsi.SkipSSLValidation,
+			SSOAllowed:        cnsi.SSOAllowed,
+			ClientId:          cnsi.ClientId,
+		}
+		if cnsi.APIEndpoint != nil {
+			endpoint.APIEndpoint = cnsi.APIEndpoint.String()
+		}
+
+		if includeSecrets && len(cnsi.ClientSecret) > 0 {
+			ciphertext, err := crypto.EncryptToken(p.Config.EncryptionKeyInBytes, cnsi.ClientSecret)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to encrypt client secret for endpoint %s: %v", cnsi.Name, err)
+			}
+			endpoint.ClientSecret = base64.StdEncoding.EncodeToString(ciphertext)
+		}
+
+		if endpointPlugin, err := p.GetEndpointTypeSpec(cnsi.CNSIType); err == nil {
+			if _, ok := getEndpointInfoFunc(endpointPlugin, nil); ok {
+				metadata, err := cnsiRepo.GetMetadata(cnsi.GUID)
+				if err != nil {
+					return nil, err
+				}
+				// The credentials of a bootstrapped endpoint belong to the Stratos it was bootstrapped in
+				delete(metadata, bootstrapCredentialsMetadataKey)
+				if len(metadata) > 0 {
+					endpoint.Metadata = metadata
+				}
+			}
+		}
+		export.Endpoints = append(export.Endpoints, endpoint)
+	}
+
+	return export, nil
+}
+
+// Admin endpoint to import endpoint registrations, as produced by an export.
+// Supports a dry run and skipping, overwriting or failing on endpoints that are already registered
+func (p *portalProxy) importEndpoints(c echo.Context) error {
+	log.Debug("importEndpoints")
+
+	dryRun, err := strconv.ParseBool(c.QueryParam("dry_run"))
+	if err != nil {
+		// default to false
+		dryRun = false
+	}
+
+	conflict := strings.ToLower(c.QueryParam("conflict"))
+	if len(conflict) == 0 {
+		conflict = endpointImportConflictFail
+	}
+	if conflict != endpointImportConflictSkip && conflict != endpointImportConflictOverwrite && conflict != endpointImportConflictFail {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Conflict handling must be 'skip', 'overwrite' or 'fail'",
+			"Invalid conflict handling: %s", conflict)
+	}
+
+	data, err := ioutil.ReadAll(c.Request().Body())
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Unable to read endpoints to import",
+			"Unable to read import request body: %v", err)
+	}
+
+	var endpoints EndpointExportList
+	// YAML is a superset of JSON, so this handles both formats
+	if err = yaml.Unmarshal(data, &endpoints); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Unable to parse endpoints to import",
+			"Unable to parse endpoints to import: %v", err)
+	}
+
+	report := p.doImportEndpoints(endpoints.Endpoints, dryRun, conflict)
+
+	status := http.StatusOK
+	for _, result := range report.Results {
+		if result.Result == endpointImportConflict {
+			status = http.StatusConflict
+			break
+		}
+	}
+
+	return c.JSON(status, report)
+}
+
+func (p *portalProxy) doImportEndpoints(endpoints []*EndpointExport, dryRun bool, conflict string) *EndpointImportReport {
+	report := &EndpointImportReport{
+		DryRun:  dryRun,
+		Results: make([]*EndpointImportResult, 0, len(endpoints)),
+	}
+
+	// When failing on conflicts, check all of the endpoints before importing any of them
+	if conflict == endpointImportConflictFail {
+		conflicts := false
+		for _, endpoint := range endpoints {
+			if p.cnsiRecordExists(strings.TrimRight(endpoint.APIEndpoint, "/")) {
+				conflicts = true
+				break
+			}
+		}
+
+		if conflicts {
+			for _, endpoint := range endpoints {
+				result := newEndpointImportResult(endpoint)
+				if existing, err := p.GetCNSIRecordByEndpoint(strings.TrimRight(endpoint.APIEndpoint, "/")); err == nil {
+					result.GUID = existing.GUID
+					result.Result = endpointImportConflict
+					result.Error = "Endpoint is already registered"
+				} else {
+					result.Result = endpointImportSkipped
+				}
+				report.Results = append(report.Results, result)
+			}
+			return report
+		}
+	}
+
+	for _, endpoint := range endpoints {
+		report.Results = append(report.Results, p.importEndpoint(endpoint, dryRun, conflict))
+	}
+
+	return report
+}
+
+func (p *portalProxy) importEndpoint(endpoint *EndpointExport, dryRun bool, conflict string) *EndpointImportResult {
+	result := newEndpointImportResult(endpoint)
+
+	if len(endpoint.Name) == 0 || len(endpoint.APIEndpoint) == 0 {
+		return failEndpointImport(result, "Needs name and API Endpoint")
+	}
+
+	endpointPlugin, err := p.GetEndpointTypeSpec(endpoint.CNSIType)
+	if err != nil {
+		return failEndpointImport(result, fmt.Sprintf("Unsupported endpoint type: %s", endpoint.CNSIType))
+	}
+
+	clientId := endpoint.ClientId
+	var clientSecret string
+	if len(endpoint.ClientSecret) > 0 {
+		ciphertext, err := base64.StdEncoding.DecodeString(endpoint.ClientSecret)
+		if err != nil {
+			return failEndpointImport(result, fmt.Sprintf("Unable to decode client secret: %v", err))
+		}
+		clientSecret, err = crypto.DecryptToken(p.Config.EncryptionKeyInBytes, ciphertext)
+		if err != nil {
+			return failEndpointImport(result, fmt.Sprintf("Unable to decrypt client secret: %v", err))
+		}
+	}
+	if len(clientId) == 0 {
+		clientId, clientSecret = p.getDefaultEndpointClient(endpointPlugin)
+	}
+
+	fetchInfo, keepsMetadata := getEndpointInfoFunc(endpointPlugin, endpoint.Metadata)
+
+	existing, err := p.GetCNSIRecordByEndpoint(strings.TrimRight(endpoint.APIEndpoint, "/"))
+	exists := err == nil
+	if exists {
+		result.GUID = existing.GUID
+		if conflict == endpointImportConflictSkip {
+			result.Result = endpointImportSkipped
+			return result
+		}
+	}
+
+	if dryRun {
+		result.Result = endpointImportCreated
+		if exists {
+			result.Result = endpointImportOverwritten
+		}
+		return result
+	}
+
+	if exists {
+		// Update the existing registration in place, so that the endpoint keeps its GUID and with it its tokens,
+		// token shares, attributes and metadata
+		newCNSI, err := p.newCNSIRecord(endpoint.Name, endpoint.APIEndpoint, endpoint.SkipSSLValidation, clientId, clientSecret, endpoint.SSOAllowed, fetchInfo)
+		if err != nil {
+			return failEndpointImport(result, endpointImportErrorMessage(err))
+		}
+		newCNSI.GUID = existing.GUID
+		if err = p.overwriteCNSIRecord(existing.GUID, newCNSI); err != nil {
+			return failEndpointImport(result, err.Error())
+		}
+		result.Result = endpointImportOverwritten
+	} else {
+		newCNSI, err := p.DoRegisterEndpoint(endpoint.Name, endpoint.APIEndpoint, endpoint.SkipSSLValidation, clientId, clientSecret, endpoint.SSOAllowed, fetchInfo)
+		if err != nil {
+			return failEndpointImport(result, endpointImportErrorMessage(err))
+		}
+		result.GUID = newCNSI.GUID
+		result.Result = endpointImportCreated
+	}
+
+	if keepsMetadata && len(endpoint.Metadata) > 0 {
+		if err = p.mergeCNSIMetadata(result.GUID, endpoint.Metadata); err != nil {
+			return failEndpointImport(result, fmt.Sprintf("Endpoint was registered, but its metadata could not be saved: %v", err))
+		}
+	}
+	return result
+}
+
+func newEndpointImportResult(endpoint *EndpointExport) *EndpointImportResult {
+	return &EndpointImportResult{
+		Name:        endpoint.Name,
+		CNSIType:    endpoint.CNSIType,
+		APIEndpoint: endpoint.APIEndpoint,
+	}
+}
+
+func failEndpointImport(result *EndpointImportResult, msg string) *EndpointImportResult {
+	result.Result = endpointImportFailed
+	result.Error = msg
+	return result
+}
+
+// endpointImportErrorMessage gets the user facing message for an error from registering an endpoint
+func endpointImportErrorMessage(err error) string {
+	if shadowErr, ok := err.(interfaces.ErrHTTPShadow); ok {
+		return shadowErr.UserFacingError
+	}
+	return err.Error()
+}
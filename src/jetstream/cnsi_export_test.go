@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestExportEndpoints(t *testing.T) {
+	t.Parallel()
+
+	Convey("Export endpoints", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT (.+) FROM cnsis`).
+			WillReturnRows(expectCFRow())
+
+		Convey("Should not include secrets by default", func() {
+			export, err := pp.buildEndpointExport(false)
+			So(err, ShouldBeNil)
+			So(export.Endpoints, ShouldHaveLength, 1)
+			So(export.Endpoints[0].Name, ShouldEqual, "Some fancy CF Cluster")
+			So(export.Endpoints[0].CNSIType, ShouldEqual, "cf")
+			So(export.Endpoints[0].APIEndpoint, ShouldEqual, mockAPIEndpoint)
+			So(export.Endpoints[0].ClientId, ShouldEqual, mockClientId)
+			So(export.Endpoints[0].ClientSecret, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should include encrypted secrets if requested", func() {
+			export, err := pp.buildEndpointExport(true)
+			So(err, ShouldBeNil)
+			So(export.Endpoints, ShouldHaveLength, 1)
+			So(export.Endpoints[0].ClientSecret, ShouldNotEqual, mockClientSecret)
+
+			ciphertext, err := base64.StdEncoding.DecodeString(export.Endpoints[0].ClientSecret)
+			So(err, ShouldBeNil)
+			secret, err := crypto.DecryptToken(pp.Config.EncryptionKeyInBytes, ciphertext)
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, mockClientSecret)
+		})
+	})
+}
+
+func TestImportEndpoints(t *testing.T) {
+	t.Parallel()
+
+	Convey("Import endpoints", t, func() {
+		req := setupMockReq("POST", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		endpoints := []*EndpointExport{
+			{Name: "Existing", CNSIType: "cf", APIEndpoint: mockAPIEndpoint},
+			{Name: "New", CNSIType: "cf", APIEndpoint: "https://api.new.127.0.0.1"},
+		}
+
+		Convey("Should not import anything if there is a conflict and conflicts should fail", func() {
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WillReturnRows(expectCFRow())
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WillReturnRows(expectCFRow())
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WillReturnError(errors.New("not found"))
+
+			report := pp.doImportEndpoints(endpoints, false, endpointImportConflictFail)
+			So(report.Results, ShouldHaveLength, 2)
+			So(report.Results[0].Result, ShouldEqual, endpointImportConflict)
+			So(report.Results[0].GUID, ShouldEqual, mockCFGUID)
+			So(report.Results[1].Result, ShouldEqual, endpointImportSkipped)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should report what would happen in a dry run", func() {
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WillReturnRows(expectCFRow())
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WillReturnError(errors.New("not found"))
+
+			report := pp.doImportEndpoints(endpoints, true, endpointImportConflictSkip)
+			So(report.DryRun, ShouldBeTrue)
+			So(report.Results, ShouldHaveLength, 2)
+			So(report.Results[0].Result, ShouldEqual, endpointImportSkipped)
+			So(report.Results[1].Result, ShouldEqual, endpointImportCreated)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should fail entries with an unknown endpoint type", func() {
+			report := pp.doImportEndpoints([]*EndpointExport{{Name: "Unknown", CNSIType: "unknown", APIEndpoint: mockAPIEndpoint}}, true, endpointImportConflictSkip)
+			So(report.Results, ShouldHaveLength, 1)
+			So(report.Results[0].Result, ShouldEqual, endpointImportFailed)
+			So(report.Results[0].Error, ShouldNotBeEmpty)
+		})
+	})
+}
+
+func TestImportEndpointsOverwrite(t *testing.T) {
+	t.Parallel()
+
+	mockV2Info := setupMockServer(t,
+		msRoute("/v2/info"),
+		msMethod("GET"),
+		msStatus(http.StatusOK),
+		msBody(jsonMust(mockV2InfoResponse)))
+
+	defer mockV2Info.Close()
+
+	Convey("Import endpoints that overwrite existing endpoints", t, func() {
+		req := setupMockReq("POST", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		endpoints := []*EndpointExport{
+			{Name: "Overwritten", CNSIType: "cf", APIEndpoint: mockV2Info.URL, SkipSSLValidation: true, ClientId: mockClientId},
+		}
+
+		Convey("Should update the existing endpoint in place, so its tokens still resolve", func() {
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WillReturnRows(expectCFRow())
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE cnsis SET name`).
+				WithArgs("Overwritten", "cf", mockV2Info.URL, mockAuthEndpoint, mockTokenEndpoint, mockDopplerEndpoint, true, mockClientId, sqlmock.AnyArg(), false, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			report := pp.doImportEndpoints(endpoints, false, endpointImportConflictOverwrite)
+			So(report.Results, ShouldHaveLength, 1)
+			So(report.Results[0].Result, ShouldEqual, endpointImportOverwritten)
+			So(report.Results[0].GUID, ShouldEqual, mockCFGUID)
+
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCFGUID, mockUserGUID, tokens.SystemSharedUserGuid).
+				WillReturnRows(expectEncryptedTokenRow(pp.Config.EncryptionKeyInBytes))
+
+			tr, ok := pp.GetCNSITokenRecord(mockCFGUID, mockUserGUID)
+			So(ok, ShouldBeTrue)
+			So(tr.TokenGUID, ShouldEqual, mockTokenGUID)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should leave the existing endpoint as it is if its info can not be fetched", func() {
+			mock.ExpectQuery(selectAnyFromCNSIs).
+				WillReturnRows(expectCFRow())
+
+			endpoints[0].APIEndpoint = mockV2Info.URL + "/missing"
+			report := pp.doImportEndpoints(endpoints, false, endpointImportConflictOverwrite)
+			So(report.Results, ShouldHaveLength, 1)
+			So(report.Results[0].Result, ShouldEqual, endpointImportFailed)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// Header and query parameter used to select the endpoints that a request should be proxied to, as an
+// alternative to listing them in the x-cap-cnsi-list header
+const (
+	cnsiSelectorHeader     = "x-cap-cnsi-selector"
+	cnsiSelectorQueryParam = "cnsi_selector"
+	cnsiLabelsHeader       = "x-cap-cnsi-labels"
+	cnsiSelectorContextKey = "cnsi_selector"
+)
+
+// Fields of an endpoint that can be used in an endpoint selector, e.g. type=cf,connected,label.env=prod
+const (
+	cnsiSelectorType        = "type"
+	cnsiSelectorGroup       = "group"
+	cnsiSelectorConnected   = "connected"
+	cnsiSelectorLabelPrefix = "label."
+)
+
+// cnsiSelector selects endpoints by their fields and labels
+type cnsiSelector struct {
+	selector  string
+	fields    labelSelector
+	labels    labelSelector
+	connected bool
+}
+
+// parseCNSISelector parses an endpoint selector. The requirements use the same syntax as label selectors, where
+// the key is one of type, group or connected, or a label key prefixed with "label."
+func parseCNSISelector(selector string) (*cnsiSelector, error) {
+	requirements, err := parseLabelSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &cnsiSelector{selector: selector}
+	for _, requirement := range requirements {
+		switch {
+		case strings.HasPrefix(requirement.key, cnsiSelectorLabelPrefix):
+			requirement.key = strings.TrimPrefix(requirement.key, cnsiSelectorLabelPrefix)
+			s.labels = append(s.labels, requirement)
+		case requirement.key == cnsiSelectorConnected:
+			// "connected" and "!connected" are shorthand for connected=true and connected=false
+			switch requirement.operator {
+			case labelSelectorExists:
+				requirement = labelRequirement{key: cnsiSelectorConnected, operator: labelSelectorEquals, value: "true"}
+			case labelSelectorNotExists:
+				requirement = labelRequirement{key: cnsiSelectorConnected, operator: labelSelectorEquals, value: "false"}
+			}
+			if requirement.value != "true" && requirement.value != "false" {
+				return nil, fmt.Errorf("Invalid value for connected in selector: %q", requirement.value)
+			}
+			s.connected = true
+			s.fields = append(s.fields, requirement)
+		case requirement.key == cnsiSelectorType, requirement.key == cnsiSelectorGroup:
+			s.fields = append(s.fields, requirement)
+		default:
+			return nil, fmt.Errorf("Unknown field in selector: %q - labels must be prefixed with %q", requirement.key, cnsiSelectorLabelPrefix)
+		}
+	}
+
+	return s, nil
+}
+
+// getCNSISelector gets the endpoint selector of the request, if there is one.
+// The selector is taken from the x-cap-cnsi-selector header or cnsi_selector query parameter.
+// The x-cap-cnsi-labels header can be used to select endpoints by their labels alone
+func getCNSISelector(c echo.Context) (*cnsiSelector, bool, error) {
+	selector := c.Request().Header().Get(cnsiSelectorHeader)
+	if len(selector) == 0 {
+		selector = c.QueryParam(cnsiSelectorQueryParam)
+	}
+
+	labels := c.Request().Header().Get(cnsiLabelsHeader)
+	if len(selector) == 0 && len(labels) == 0 {
+		return nil, false, nil
+	}
+
+	s, err := parseCNSISelector(selector)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(labels) > 0 {
+		labelRequirements, err := parseLabelSelector(labels)
+		if err != nil {
+			return nil, false, err
+		}
+		s.labels = append(s.labels, labelRequirements...)
+
+		// Report the label requirements as part of the selector
+		parts := make([]string, 0)
+		if len(s.selector) > 0 {
+			parts = append(parts, s.selector)
+		}
+		for _, requirement := range labelRequirements {
+			parts = append(parts, cnsiSelectorLabelPrefix+requirement.String())
+		}
+		s.selector = strings.Join(parts, ",")
+	}
+
+	return s, true, nil
+}
+
+// removeCNSISelectorQueryParam removes the endpoint selector from the query parameters, so that it is not
+// forwarded to the endpoints
+func removeCNSISelectorQueryParam(uri *url.URL) *url.URL {
+	query := uri.Query()
+	if _, ok := query[cnsiSelectorQueryParam]; !ok {
+		return uri
+	}
+
+	stripped := *uri
+	query.Del(cnsiSelectorQueryParam)
+	stripped.RawQuery = query.Encode()
+	return &stripped
+}
+
+// Matches determines if the endpoint meets all of the requirements of the selector
+func (s *cnsiSelector) Matches(cnsi *interfaces.CNSIRecord, connected bool) bool {
+	fields := map[string]string{
+		cnsiSelectorType:      cnsi.CNSIType,
+		cnsiSelectorConnected: fmt.Sprintf("%t", connected),
+	}
+	if len(cnsi.Group) > 0 {
+		fields[cnsiSelectorGroup] = cnsi.Group
+	}
+
+	return s.fields.Matches(fields) && s.labels.Matches(cnsi.Labels)
+}
+
+// resolveCNSISelector gets the GUIDs of the endpoints that match the selector.
+// The connected state is that of the given user
+func (p *portalProxy) resolveCNSISelector(selector *cnsiSelector, userGUID string) ([]string, error) {
+	log.Debugf("resolveCNSISelector: %s", selector.selector)
+	cnsiList, err := p.buildCNSIList(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only look up the user's tokens if the selector needs them
+	connected := make(map[string]bool)
+	if selector.connected {
+		if connected, err = p.listConnectedCNSIs(userGUID); err != nil {
+			return nil, err
+		}
+	}
+
+	guids := make([]string, 0)
+	for _, cnsi := range cnsiList {
+		if selector.Matches(cnsi, connected[cnsi.GUID]) {
+			guids = append(guids, cnsi.GUID)
+		}
+	}
+
+	sort.Strings(guids)
+	return guids, nil
+}
+
+// listConnectedCNSIs gets the GUIDs of the endpoints that the user is connected to, either with their own token
+// or with a system shared token
+func (p *portalProxy) listConnectedCNSIs(userGUID string) (map[string]bool, error) {
+	connected := make(map[string]bool)
+	for _, tokenUserGUID := range []string{userGUID, tokens.SystemSharedUserGuid} {
+		endpoints, err := p.ListEndpointsByUser(tokenUserGUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, endpoint := range endpoints {
+			connected[endpoint.GUID] = true
+		}
+	}
+	return connected, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestCNSISelector(t *testing.T) {
+	t.Parallel()
+
+	Convey("Endpoint selectors", t, func() {
+		cf := &interfaces.CNSIRecord{
+			GUID:           "1",
+			CNSIType:       "cf",
+			CNSIAttributes: interfaces.CNSIAttributes{Group: "prod", Labels: map[string]string{"env": "prod"}},
+		}
+		metrics := &interfaces.CNSIRecord{GUID: "2", CNSIType: "metrics"}
+
+		Convey("Should select by type", func() {
+			selector, err := parseCNSISelector("type=cf")
+			So(err, ShouldBeNil)
+			So(selector.connected, ShouldBeFalse)
+			So(selector.Matches(cf, false), ShouldBeTrue)
+			So(selector.Matches(metrics, false), ShouldBeFalse)
+		})
+
+		Convey("Should select by group and labels", func() {
+			selector, err := parseCNSISelector("group=prod,label.env=prod")
+			So(err, ShouldBeNil)
+			So(selector.Matches(cf, false), ShouldBeTrue)
+			So(selector.Matches(metrics, false), ShouldBeFalse)
+		})
+
+		Convey("Should select by connected state", func() {
+			selector, err := parseCNSISelector("connected")
+			So(err, ShouldBeNil)
+			So(selector.connected, ShouldBeTrue)
+			So(selector.Matches(cf, true), ShouldBeTrue)
+			So(selector.Matches(cf, false), ShouldBeFalse)
+
+			selector, err = parseCNSISelector("!connected")
+			So(err, ShouldBeNil)
+			So(selector.Matches(cf, false), ShouldBeTrue)
+			So(selector.Matches(cf, true), ShouldBeFalse)
+		})
+
+		Convey("Should reject unknown fields", func() {
+			_, err := parseCNSISelector("env=prod")
+			So(err, ShouldNotBeNil)
+			_, err = parseCNSISelector("connected=maybe")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Request with an endpoint selector", t, func() {
+
+		Convey("Should combine the selector and label headers", func() {
+			req := setupMockReq("GET", "", nil)
+			req.Header.Set("x-cap-cnsi-selector", "type=cf")
+			req.Header.Set("x-cap-cnsi-labels", "env!=dev")
+			_, _, ctx, _, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			selector, ok, err := getCNSISelector(ctx)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(selector.selector, ShouldEqual, "type=cf,label.env!=dev")
+			So(selector.labels, ShouldHaveLength, 1)
+		})
+
+		Convey("Should not have a selector if none is supplied", func() {
+			req := setupMockReq("GET", "", nil)
+			_, _, ctx, _, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			_, ok, err := getCNSISelector(ctx)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Should not forward the selector query parameter", func() {
+			uri, _ := url.Parse("https://api.127.0.0.1/v2/apps?cnsi_selector=type%3Dcf&page=2")
+			stripped := removeCNSISelectorQueryParam(uri)
+			So(stripped.RawQuery, ShouldEqual, "page=2")
+			So(uri.RawQuery, ShouldContainSubstring, "cnsi_selector")
+		})
+	})
+
+	Convey("Resolve an endpoint selector", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		mock.ExpectQuery(`SELECT (.+) FROM cnsis`).
+			WillReturnRows(expectCFRow())
+		mock.ExpectQuery(`SELECT (.+) FROM cnsi_attributes`).
+			WillReturnError(errors.New("no attributes"))
+
+		Convey("Should not look up tokens if the selector does not need them", func() {
+			selector, _ := parseCNSISelector("type=cf")
+			guids, err := pp.resolveCNSISelector(selector, mockUserGUID)
+			So(err, ShouldBeNil)
+			So(guids, ShouldResemble, []string{mockCFGUID})
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should list the tokens of the user and the shared tokens once", func() {
+			mock.ExpectQuery(selectFromCNSIsAndTokens).
+				WithArgs("cnsi", mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConnectedEndpoint))
+			mock.ExpectQuery(selectFromCNSIsAndTokens).
+				WithArgs("cnsi", tokens.SystemSharedUserGuid).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConnectedEndpoint).
+					AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockDopplerEndpoint, tokens.SystemSharedUserGuid, mockTokenExpiry, true, false, ""))
+
+			selector, _ := parseCNSISelector("connected")
+			guids, err := pp.resolveCNSISelector(selector, mockUserGUID)
+			So(err, ShouldBeNil)
+			So(guids, ShouldResemble, []string{mockCFGUID})
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should not select endpoints that the user is not connected to", func() {
+			mock.ExpectQuery(selectFromCNSIsAndTokens).
+				WithArgs("cnsi", mockUserGUID).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConnectedEndpoint))
+			mock.ExpectQuery(selectFromCNSIsAndTokens).
+				WithArgs("cnsi", tokens.SystemSharedUserGuid).
+				WillReturnRows(sqlmock.NewRows(rowFieldsForConnectedEndpoint))
+
+			selector, _ := parseCNSISelector("connected")
+			guids, err := pp.resolveCNSISelector(selector, mockUserGUID)
+			So(err, ShouldBeNil)
+			So(guids, ShouldBeEmpty)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
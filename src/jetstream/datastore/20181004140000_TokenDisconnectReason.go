@@ -0,0 +1,20 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20181004140000, "TokenDisconnectReason", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		addDisconnectReason := "ALTER TABLE tokens ADD disconnect_reason VARCHAR(255)"
+		_, err := txn.Exec(addDisconnectReason)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,25 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20181011103000, "TokenShares", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createTokenShares := "CREATE TABLE IF NOT EXISTS token_shares ("
+		createTokenShares += "cnsi_guid   VARCHAR(36)  NOT NULL, "
+		createTokenShares += "share_type  VARCHAR(16)  NOT NULL, "
+		createTokenShares += "share_with  VARCHAR(255) NOT NULL, "
+		createTokenShares += "PRIMARY KEY (cnsi_guid, share_type, share_with));"
+
+		_, err := txn.Exec(createTokenShares)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
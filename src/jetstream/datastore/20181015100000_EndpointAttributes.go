@@ -0,0 +1,26 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20181015100000, "EndpointAttributes", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		createCNSIAttributes := "CREATE TABLE IF NOT EXISTS cnsi_attributes ("
+		createCNSIAttributes += "cnsi_guid       VARCHAR(36)  NOT NULL, "
+		createCNSIAttributes += "description     VARCHAR(255), "
+		createCNSIAttributes += "endpoint_group  VARCHAR(255), "
+		createCNSIAttributes += "labels          TEXT, "
+		createCNSIAttributes += "PRIMARY KEY (cnsi_guid));"
+
+		_, err := txn.Exec(createCNSIAttributes)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
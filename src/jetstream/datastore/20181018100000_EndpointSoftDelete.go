@@ -0,0 +1,21 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20181018100000, "EndpointSoftDelete", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Time (unix seconds) that an endpoint was unregistered - endpoints can be restored until they are purged
+		addDeletedAt := "ALTER TABLE cnsis ADD deleted_at BIGINT"
+		_, err := txn.Exec(addDeletedAt)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
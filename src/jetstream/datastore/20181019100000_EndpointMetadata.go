@@ -0,0 +1,25 @@
+package datastore
+
+import (
+	"database/sql"
+
+	"bitbucket.org/liamstask/goose/lib/goose"
+)
+
+func init() {
+	RegisterMigration(20181019100000, "EndpointMetadata", func(txn *sql.Tx, conf *goose.DBConf) error {
+
+		// Metadata set by endpoint plugins when an endpoint is registered
+		createCNSIMetadata := "CREATE TABLE IF NOT EXISTS cnsi_metadata ("
+		createCNSIMetadata += "cnsi_guid  VARCHAR(36)  NOT NULL, "
+		createCNSIMetadata += "metadata   TEXT, "
+		createCNSIMetadata += "PRIMARY KEY (cnsi_guid));"
+
+		_, err := txn.Exec(createCNSIMetadata)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
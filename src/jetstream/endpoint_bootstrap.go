@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/cnsis"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// EndpointsConfig is the endpoints config file, which lists the endpoints that should be registered at startup.
+// The file can be YAML or JSON
+type EndpointsConfig struct {
+	// Unregister any endpoints that are not listed in the file
+	Prune     bool                   `yaml:"prune"`
+	Endpoints []*EndpointConfigEntry `yaml:"endpoints"`
+}
+
+// EndpointConfigEntry is an endpoint in the endpoints config file.
+// The client secret and system shared credentials can reference environment variables, e.g. ${CF_ADMIN_PASSWORD}
+type EndpointConfigEntry struct {
+	Name              string `yaml:"name"`
+	Type              string `yaml:"type"`
+	URL               string `yaml:"url"`
+	SkipSSLValidation bool   `yaml:"skip_ssl_validation"`
+	SSOAllowed        bool   `yaml:"sso_allowed"`
+	ClientId          string `yaml:"client_id"`
+	ClientSecret      string `yaml:"client_secret"`
+	// Settings to register the endpoint with, for the endpoint types that keep them in the endpoint's metadata,
+	// e.g. the issuer_url of an api endpoint
+	Metadata map[string]string `yaml:"metadata"`
+	// Form values used to connect the endpoint as a system shared endpoint, e.g. connect_type, username and password
+	SystemShared map[string]string `yaml:"system_shared"`
+}
+
+// Endpoint metadata key of the fingerprint of the system shared credentials an endpoint was last connected with
+const bootstrapCredentialsMetadataKey = "bootstrap_credentials"
+
+// bootstrapEndpoints converges the registered endpoints to those listed in the endpoints config file (if configured).
+// This is idempotent - endpoints are only registered, updated or connected if they differ from the file
+func (p *portalProxy) bootstrapEndpoints() error {
+	if len(p.Config.EndpointsConfigFile) == 0 {
+		return nil
+	}
+
+	log.Infof("Bootstrapping endpoints from %s", p.Config.EndpointsConfigFile)
+	data, err := ioutil.ReadFile(p.Config.EndpointsConfigFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read endpoints config file: %v", err)
+	}
+
+	config, err := parseEndpointsConfig(data)
+	if err != nil {
+		return err
+	}
+
+	p.convergeEndpoints(config)
+	return nil
+}
+
+func parseEndpointsConfig(data []byte) (*EndpointsConfig, error) {
+	config := &EndpointsConfig{}
+	// YAML is a superset of JSON, so this handles both formats
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Unable to parse endpoints config file: %v", err)
+	}
+
+	for i, entry := range config.Endpoints {
+		if len(entry.Name) == 0 || len(entry.Type) == 0 || len(entry.URL) == 0 {
+			return nil, fmt.Errorf("Endpoint %d in endpoints config file needs a name, type and url", i+1)
+		}
+		entry.URL = strings.TrimRight(entry.URL, "/")
+	}
+
+	return config, nil
+}
+
+// convergeEndpoints registers, updates and connects the endpoints in the config.
+// Failures are logged and do not prevent the remaining endpoints from being processed
+func (p *portalProxy) convergeEndpoints(config *EndpointsConfig) {
+	listed := make(map[string]bool)
+	for _, entry := range config.Endpoints {
+		cnsiRecord, err := p.convergeEndpoint(entry)
+		if err != nil {
+			log.Warnf("Unable to bootstrap endpoint %s (%s): %v", entry.Name, entry.URL, err)
+			continue
+		}
+		listed[cnsiRecord.GUID] = true
+
+		if len(entry.SystemShared) > 0 {
+			if err := p.connectBootstrapEndpoint(cnsiRecord, entry.SystemShared); err != nil {
+				log.Warnf("Unable to connect bootstrapped endpoint %s as a system shared endpoint: %v", entry.Name, err)
+			}
+		}
+	}
+
+	if !config.Prune {
+		return
+	}
+
+	cnsiList, err := p.buildCNSIList(nil)
+	if err != nil {
+		log.Warnf("Unable to prune endpoints: %v", err)
+		return
+	}
+
+	for _, cnsi := range cnsiList {
+		if listed[cnsi.GUID] {
+			continue
+		}
+
+		// Don't prune endpoints that failed to bootstrap
+		if cnsi.APIEndpoint != nil && isEndpointInConfig(config, cnsi.APIEndpoint.String()) {
+			continue
+		}
+
+		log.Infof("Unregistering endpoint %s (%s) - not in endpoints config file", cnsi.Name, cnsi.GUID)
+		if err := p.doUnregisterEndpoint(*cnsi); err != nil {
+			log.Warnf("Unable to unregister endpoint %s: %v", cnsi.Name, err)
+		}
+	}
+}
+
+func isEndpointInConfig(config *EndpointsConfig, apiEndpoint string) bool {
+	apiEndpoint = strings.TrimRight(apiEndpoint, "/")
+	for _, entry := range config.Endpoints {
+		if entry.URL == apiEndpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// convergeEndpoint registers the endpoint if it is not already registered, or updates it if it has changed
+func (p *portalProxy) convergeEndpoint(entry *EndpointConfigEntry) (interfaces.CNSIRecord, error) {
+	endpointPlugin, err := p.GetEndpointTypeSpec(entry.Type)
+	if err != nil {
+		return interfaces.CNSIRecord{}, fmt.Errorf("Unsupported endpoint type: %s", entry.Type)
+	}
+
+	clientId := entry.ClientId
+	clientSecret := os.ExpandEnv(entry.ClientSecret)
+	if len(clientId) == 0 {
+		clientId, clientSecret = p.getDefaultEndpointClient(endpointPlugin)
+	}
+
+	fetchInfo, keepsMetadata := getEndpointInfoFunc(endpointPlugin, entry.Metadata)
+	keepsMetadata = keepsMetadata && len(entry.Metadata) > 0
+
+	existing, err := p.GetCNSIRecordByEndpoint(entry.URL)
+	if err != nil {
+		log.Infof("Registering endpoint %s (%s)", entry.Name, entry.URL)
+		cnsiRecord, err := p.DoRegisterEndpoint(entry.Name, entry.URL, entry.SkipSSLValidation, clientId, clientSecret, entry.SSOAllowed, fetchInfo)
+		if err == nil && keepsMetadata {
+			err = p.mergeCNSIMetadata(cnsiRecord.GUID, entry.Metadata)
+		}
+		return cnsiRecord, err
+	}
+
+	if existing.CNSIType != entry.Type {
+		return existing, fmt.Errorf("Endpoint is already registered with type %s", existing.CNSIType)
+	}
+
+	// The endpoint's info depends on its metadata, so if that has changed the endpoint is registered again in place
+	if keepsMetadata {
+		metadata, err := p.GetCNSIMetadata(existing.GUID)
+		if err != nil {
+			return existing, err
+		}
+
+		if !containsMetadata(metadata, entry.Metadata) {
+			log.Infof("Updating endpoint %s (%s)", entry.Name, entry.URL)
+			newCNSI, err := p.newCNSIRecord(entry.Name, entry.URL, entry.SkipSSLValidation, clientId, clientSecret, entry.SSOAllowed, fetchInfo)
+			if err != nil {
+				return existing, err
+			}
+			newCNSI.GUID = existing.GUID
+			if err = p.overwriteCNSIRecord(existing.GUID, newCNSI); err != nil {
+				return existing, err
+			}
+			return newCNSI, p.mergeCNSIMetadata(existing.GUID, entry.Metadata)
+		}
+	}
+
+	if existing.Name == entry.Name && existing.SkipSSLValidation == entry.SkipSSLValidation && existing.SSOAllowed == entry.SSOAllowed &&
+		existing.ClientId == clientId && existing.ClientSecret == clientSecret {
+		log.Debugf("Endpoint %s (%s) is up to date", entry.Name, entry.URL)
+		return existing, nil
+	}
+
+	existing.Name = entry.Name
+	existing.SkipSSLValidation = entry.SkipSSLValidation
+	existing.SSOAllowed = entry.SSOAllowed
+	existing.ClientId = clientId
+	existing.ClientSecret = clientSecret
+
+	cnsiRepo, err := cnsis.NewPostgresCNSIRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return existing, fmt.Errorf(dbReferenceError, err)
+	}
+
+	log.Infof("Updating endpoint %s (%s)", entry.Name, entry.URL)
+	if err = cnsiRepo.UpdateRegistration(existing.GUID, existing, p.Config.EncryptionKeyInBytes); err != nil {
+		return existing, err
+	}
+
+	return existing, nil
+}
+
+func containsMetadata(metadata, expected map[string]string) bool {
+	for key, value := range expected {
+		if existing, ok := metadata[key]; !ok || existing != value {
+			return false
+		}
+	}
+	return true
+}
+
+// connectBootstrapEndpoint connects the endpoint as a system shared endpoint, unless it is already connected with
+// the credentials in the endpoints config file
+func (p *portalProxy) connectBootstrapEndpoint(cnsiRecord interfaces.CNSIRecord, credentials map[string]string) error {
+	form := url.Values{}
+	for name, value := range credentials {
+		form.Set(name, os.ExpandEnv(value))
+	}
+	fingerprint := p.bootstrapCredentialsFingerprint(form)
+
+	metadata, err := p.GetCNSIMetadata(cnsiRecord.GUID)
+	if err != nil {
+		return err
+	}
+
+	if tr, ok := p.GetCNSITokenRecordWithDisconnected(cnsiRecord.GUID, tokens.SystemSharedUserGuid); ok && tr.SystemShared && !tr.Disconnected &&
+		metadata[bootstrapCredentialsMetadataKey] == fingerprint {
+		log.Debugf("Endpoint %s is already connected as a system shared endpoint", cnsiRecord.Name)
+		return nil
+	}
+
+	endpointPlugin, err := p.GetEndpointTypeSpec(cnsiRecord.CNSIType)
+	if err != nil {
+		return err
+	}
+
+	// The endpoint plugins read the credentials from the form values of the connect request
+	req, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := &bootstrapResponseWriter{header: make(http.Header)}
+	ec := echo.New().NewContext(standard.NewRequest(req, nil), standard.NewResponse(res, nil))
+
+	tokenRecord, _, err := endpointPlugin.Connect(ec, cnsiRecord, tokens.SystemSharedUserGuid)
+	if err != nil {
+		return err
+	}
+
+	if err = p.setCNSITokenRecord(cnsiRecord.GUID, tokens.SystemSharedUserGuid, *tokenRecord); err != nil {
+		return err
+	}
+	log.Infof("Connected endpoint %s as a system shared endpoint", cnsiRecord.Name)
+
+	metadata[bootstrapCredentialsMetadataKey] = fingerprint
+	return p.SetCNSIMetadata(cnsiRecord.GUID, metadata)
+}
+
+// bootstrapCredentialsFingerprint identifies the credentials an endpoint is connected with, so that it can be
+// reconnected when they change. It is keyed with the encryption key, so the credentials can not be guessed from it
+func (p *portalProxy) bootstrapCredentialsFingerprint(form url.Values) string {
+	names := make([]string, 0, len(form))
+	for name := range form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mac := hmac.New(sha256.New, p.Config.EncryptionKeyInBytes)
+	for _, name := range names {
+		fmt.Fprintf(mac, "%q=%q\n", name, form.Get(name))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// bootstrapResponseWriter is the response to the connect requests made when bootstrapping endpoints.
+// There is no client to send it to, so anything written to it is discarded
+type bootstrapResponseWriter struct {
+	header http.Header
+}
+
+func (w *bootstrapResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bootstrapResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w *bootstrapResponseWriter) WriteHeader(int) {}
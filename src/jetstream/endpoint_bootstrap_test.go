@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+func TestParseEndpointsConfig(t *testing.T) {
+	t.Parallel()
+
+	Convey("Parse endpoints config file", t, func() {
+
+		Convey("Should parse YAML", func() {
+			config, err := parseEndpointsConfig([]byte(`
+prune: true
+endpoints:
+- name: Prod CF
+  type: cf
+  url: https://api.example.com/
+  skip_ssl_validation: true
+  metadata:
+    issuer_url: https://login.example.com
+  system_shared:
+    connect_type: creds
+    username: admin
+    password: ${CF_ADMIN_PASSWORD}
+`))
+			So(err, ShouldBeNil)
+			So(config.Prune, ShouldBeTrue)
+			So(config.Endpoints, ShouldHaveLength, 1)
+			So(config.Endpoints[0].Name, ShouldEqual, "Prod CF")
+			So(config.Endpoints[0].URL, ShouldEqual, "https://api.example.com")
+			So(config.Endpoints[0].SkipSSLValidation, ShouldBeTrue)
+			So(config.Endpoints[0].Metadata, ShouldResemble, map[string]string{"issuer_url": "https://login.example.com"})
+			So(config.Endpoints[0].SystemShared["password"], ShouldEqual, "${CF_ADMIN_PASSWORD}")
+		})
+
+		Convey("Should parse JSON", func() {
+			config, err := parseEndpointsConfig([]byte(`{"endpoints": [{"name": "Metrics", "type": "metrics", "url": "https://metrics.example.com"}]}`))
+			So(err, ShouldBeNil)
+			So(config.Prune, ShouldBeFalse)
+			So(config.Endpoints, ShouldHaveLength, 1)
+			So(config.Endpoints[0].Type, ShouldEqual, "metrics")
+		})
+
+		Convey("Should reject endpoints without a url", func() {
+			_, err := parseEndpointsConfig([]byte(`{"endpoints": [{"name": "Metrics", "type": "metrics"}]}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestContainsMetadata(t *testing.T) {
+	t.Parallel()
+
+	Convey("Compare stored endpoint metadata with the endpoints config file", t, func() {
+		stored := map[string]string{"issuer_url": "https://login.example.com", "type_name": "API"}
+
+		So(containsMetadata(stored, nil), ShouldBeTrue)
+		So(containsMetadata(stored, map[string]string{"issuer_url": "https://login.example.com"}), ShouldBeTrue)
+		So(containsMetadata(stored, map[string]string{"issuer_url": "https://other.example.com"}), ShouldBeFalse)
+		So(containsMetadata(stored, map[string]string{"icon": "widgets"}), ShouldBeFalse)
+	})
+}
+
+// mockClientPlugin is an endpoint plugin that has its own default client
+type mockClientPlugin struct {
+	interfaces.StratosPlugin
+	clientID string
+}
+
+func (m *mockClientPlugin) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	endpointPlugin, err := m.StratosPlugin.GetEndpointPlugin()
+	if err != nil {
+		return nil, err
+	}
+	return &mockClientEndpointPlugin{EndpointPlugin: endpointPlugin, clientID: m.clientID}, nil
+}
+
+type mockClientEndpointPlugin struct {
+	interfaces.EndpointPlugin
+	clientID string
+}
+
+func (m *mockClientEndpointPlugin) DefaultClient() (string, string) {
+	return m.clientID, ""
+}
+
+func TestConvergeEndpoint(t *testing.T) {
+	t.Parallel()
+
+	Convey("Converge a bootstrapped endpoint", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		entry := &EndpointConfigEntry{
+			Name:              "Some fancy CF Cluster",
+			Type:              "cf",
+			URL:               mockAPIEndpoint,
+			SkipSSLValidation: true,
+			SSOAllowed:        true,
+			ClientId:          mockClientId,
+			ClientSecret:      mockClientSecret,
+		}
+
+		mock.ExpectQuery(selectAnyFromCNSIs).
+			WillReturnRows(expectCFRow())
+
+		Convey("Should not update an endpoint that is up to date", func() {
+			cnsi, err := pp.convergeEndpoint(entry)
+			So(err, ShouldBeNil)
+			So(cnsi.GUID, ShouldEqual, mockCFGUID)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should update an endpoint that has changed", func() {
+			entry.Name = "Renamed CF"
+			mock.ExpectExec(`UPDATE cnsis SET name`).
+				WithArgs("Renamed CF", true, mockClientId, sqlmock.AnyArg(), true, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			cnsi, err := pp.convergeEndpoint(entry)
+			So(err, ShouldBeNil)
+			So(cnsi.Name, ShouldEqual, "Renamed CF")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should use the default client of the endpoint's plugin when none is given", func() {
+			cfPlugin := pp.Plugins["cf"]
+			pp.Plugins["cf"] = &mockClientPlugin{StratosPlugin: cfPlugin, clientID: "plugin-client"}
+			entry.ClientId = ""
+			entry.ClientSecret = ""
+			mock.ExpectExec(`UPDATE cnsis SET name`).
+				WithArgs("Some fancy CF Cluster", true, "plugin-client", sqlmock.AnyArg(), true, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			cnsi, err := pp.convergeEndpoint(entry)
+			So(err, ShouldBeNil)
+			So(cnsi.ClientId, ShouldEqual, "plugin-client")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should not change the type of an endpoint", func() {
+			entry.Type = "metrics"
+			_, err := pp.convergeEndpoint(entry)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestConnectBootstrapEndpoint(t *testing.T) {
+	t.Parallel()
+
+	Convey("Connect a bootstrapped endpoint as a system shared endpoint", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		cnsiRecord := interfaces.CNSIRecord{GUID: mockCFGUID, Name: "Some fancy CF Cluster", CNSIType: "cf"}
+		credentials := map[string]string{"connect_type": interfaces.AuthConnectTypeBearer, "token": mockUAAToken}
+		form := url.Values{}
+		for name, value := range credentials {
+			form.Set(name, value)
+		}
+		fingerprint := pp.bootstrapCredentialsFingerprint(form)
+
+		expectConnected := func(fingerprint string) {
+			mock.ExpectQuery(`SELECT metadata FROM cnsi_metadata`).
+				WithArgs(mockCFGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow(fmt.Sprintf(`{"%s":"%s"}`, bootstrapCredentialsMetadataKey, fingerprint)))
+			encryptedToken, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockUAAToken)
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCFGUID, tokens.SystemSharedUserGuid, tokens.SystemSharedUserGuid).
+				WillReturnRows(sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "disconnected", "auth_type", "meta_data", "user_guid", "linked_token"}).
+					AddRow(mockTokenGUID, encryptedToken, encryptedToken, mockTokenExpiry, false, interfaces.AuthTypeBearer, "", tokens.SystemSharedUserGuid, nil))
+		}
+
+		Convey("Should not reconnect an endpoint connected with the same credentials", func() {
+			expectConnected(fingerprint)
+
+			So(pp.connectBootstrapEndpoint(cnsiRecord, credentials), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should reconnect an endpoint when its credentials have changed", func() {
+			expectConnected("stale")
+			mock.ExpectQuery(`SELECT COUNT`).
+				WithArgs(mockCFGUID, tokens.SystemSharedUserGuid).
+				WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow("1"))
+			mock.ExpectExec(`UPDATE tokens`).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`UPDATE cnsi_metadata`).
+				WithArgs(fmt.Sprintf(`{"%s":"%s"}`, bootstrapCredentialsMetadataKey, fingerprint), mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(pp.connectBootstrapEndpoint(cnsiRecord, credentials), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should identify the credentials by their values", func() {
+			form.Set("token", "another-token")
+			So(pp.bootstrapCredentialsFingerprint(form), ShouldNotEqual, fingerprint)
+		})
+	})
+}
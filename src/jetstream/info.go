@@ -84,6 +84,8 @@ func (p *portalProxy) getInfo(c echo.Context) (*interfaces.Info, error) {
 			endpoint.User = cnsiUser
 			endpoint.TokenMetadata = token.Metadata
 			endpoint.SystemSharedToken = token.SystemShared
+		} else if reason, disconnected := p.GetCNSITokenDisconnectReason(cnsi.GUID, userGUID); disconnected {
+			endpoint.DisconnectReason = reason
 		}
 		cnsiType := cnsi.CNSIType
 		s.Endpoints[cnsiType][cnsi.GUID] = endpoint
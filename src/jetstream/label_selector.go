@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Label keys and values are restricted to a safe set of characters so that they can be used in selectors
+var labelKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_./-]{0,61}[A-Za-z0-9])?$`)
+var labelValueRegexp = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9_.-]{0,61}[A-Za-z0-9])?)?$`)
+
+// Operators supported in a label selector requirement
+const (
+	labelSelectorEquals    = "="
+	labelSelectorNotEquals = "!="
+	labelSelectorExists    = "exists"
+	labelSelectorNotExists = "!exists"
+)
+
+// labelRequirement is a single requirement of a label selector, e.g. env=prod
+type labelRequirement struct {
+	key      string
+	operator string
+	value    string
+}
+
+// labelSelector selects endpoints by their labels. All of the requirements must be met for an endpoint to match
+type labelSelector []labelRequirement
+
+// parseLabelSelector parses a comma separated list of label requirements.
+// Supported requirements are: key=value, key==value, key!=value, key (label exists) and !key (label does not exist)
+func parseLabelSelector(selector string) (labelSelector, error) {
+	requirements := make(labelSelector, 0)
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		var requirement labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			requirement = labelRequirement{key: kv[0], operator: labelSelectorNotEquals, value: kv[1]}
+		case strings.Contains(part, "=="):
+			kv := strings.SplitN(part, "==", 2)
+			requirement = labelRequirement{key: kv[0], operator: labelSelectorEquals, value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			requirement = labelRequirement{key: kv[0], operator: labelSelectorEquals, value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			requirement = labelRequirement{key: strings.TrimPrefix(part, "!"), operator: labelSelectorNotExists}
+		default:
+			requirement = labelRequirement{key: part, operator: labelSelectorExists}
+		}
+
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if !labelKeyRegexp.MatchString(requirement.key) {
+			return nil, fmt.Errorf("Invalid label key in selector: %q", requirement.key)
+		}
+		if !labelValueRegexp.MatchString(requirement.value) {
+			return nil, fmt.Errorf("Invalid label value in selector: %q", requirement.value)
+		}
+		requirements = append(requirements, requirement)
+	}
+
+	return requirements, nil
+}
+
+// String formats the requirement using the selector syntax
+func (r labelRequirement) String() string {
+	switch r.operator {
+	case labelSelectorNotEquals:
+		return r.key + "!=" + r.value
+	case labelSelectorExists:
+		return r.key
+	case labelSelectorNotExists:
+		return "!" + r.key
+	default:
+		return r.key + "=" + r.value
+	}
+}
+
+// Matches determines if the labels meet all of the requirements of the selector
+func (s labelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.key]
+		switch requirement.operator {
+		case labelSelectorEquals:
+			if !ok || value != requirement.value {
+				return false
+			}
+		case labelSelectorNotEquals:
+			if ok && value == requirement.value {
+				return false
+			}
+		case labelSelectorExists:
+			if !ok {
+				return false
+			}
+		case labelSelectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseLabels parses a comma separated list of labels, e.g. env=prod,region=eu
+func parseLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid label %q - labels must be of the form key=value", part)
+		}
+
+		key := strings.TrimSpace(kv[0])
+		val := strings.TrimSpace(kv[1])
+		if !labelKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("Invalid label key: %q", key)
+		}
+		if !labelValueRegexp.MatchString(val) {
+			return nil, fmt.Errorf("Invalid label value: %q", val)
+		}
+		labels[key] = val
+	}
+
+	return labels, nil
+}
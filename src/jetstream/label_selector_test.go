@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLabelSelector(t *testing.T) {
+	t.Parallel()
+
+	Convey("Label selectors", t, func() {
+		labels := map[string]string{"env": "prod", "region": "eu"}
+
+		Convey("Should match equality requirements", func() {
+			selector, err := parseLabelSelector("env=prod, region==eu")
+			So(err, ShouldBeNil)
+			So(selector, ShouldHaveLength, 2)
+			So(selector.Matches(labels), ShouldBeTrue)
+			So(selector.Matches(map[string]string{"env": "prod"}), ShouldBeFalse)
+		})
+
+		Convey("Should match inequality requirements", func() {
+			selector, err := parseLabelSelector("env!=dev")
+			So(err, ShouldBeNil)
+			So(selector.Matches(labels), ShouldBeTrue)
+			So(selector.Matches(nil), ShouldBeTrue)
+			So(selector.Matches(map[string]string{"env": "dev"}), ShouldBeFalse)
+		})
+
+		Convey("Should match existence requirements", func() {
+			selector, err := parseLabelSelector("region,!team")
+			So(err, ShouldBeNil)
+			So(selector.Matches(labels), ShouldBeTrue)
+			So(selector.Matches(map[string]string{"region": "eu", "team": "a"}), ShouldBeFalse)
+			So(selector.Matches(nil), ShouldBeFalse)
+		})
+
+		Convey("Should match everything with an empty selector", func() {
+			selector, err := parseLabelSelector("")
+			So(err, ShouldBeNil)
+			So(selector.Matches(nil), ShouldBeTrue)
+		})
+
+		Convey("Should reject invalid keys and values", func() {
+			_, err := parseLabelSelector("env=prod!")
+			So(err, ShouldNotBeNil)
+			_, err = parseLabelSelector("=prod")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Labels", t, func() {
+
+		Convey("Should parse a list of labels", func() {
+			labels, err := parseLabels("env=prod, region=eu")
+			So(err, ShouldBeNil)
+			So(labels, ShouldResemble, map[string]string{"env": "prod", "region": "eu"})
+		})
+
+		Convey("Should reject labels without a value", func() {
+			_, err := parseLabels("env")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
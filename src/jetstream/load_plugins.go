@@ -1,11 +1,16 @@
 package main
 
 import (
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/bosh"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cfapppush"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cfappssh"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cloudfoundry"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cloudfoundryhosting"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/concourse"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/git"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/helm"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/metrics"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/oidcapi"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/userinfo"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 	log "github.com/sirupsen/logrus"
@@ -21,11 +26,16 @@ func (pp *portalProxy) loadPlugins() {
 		Name string
 		Init func(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error)
 	}{
+		{"bosh", bosh.Init},
 		{"cfapppush", cfapppush.Init},
 		{"cfappssh", cfappssh.Init},
 		{"cloudfoundry", cloudfoundry.Init},
 		{"cloudfoundryhosting", cloudfoundryhosting.Init},
+		{"concourse", concourse.Init},
+		{"git", git.Init},
+		{"helm", helm.Init},
 		{"metrics", metrics.Init},
+		{"oidcapi", oidcapi.Init},
 		{"userinfo", userinfo.Init},
 	} {
 		plugin, err := p.Init(pp)
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Defaults used when the login throttle settings are not configured
+const (
+	defaultLoginMaxFailedAttempts      = 5
+	defaultLoginMaxFailedAttemptsPerIP = 20
+	defaultLoginLockoutInSecs          = 300
+)
+
+// Most usernames and IPs that failed logins are tracked for. Beyond this, the least recently failed are forgotten
+const maxLoginThrottleEntries = 10000
+
+const (
+	loginThrottleUserPrefix = "user:"
+	loginThrottleIPPrefix   = "ip:"
+)
+
+// loginAttempts tracks the failed logins for a single username or client IP
+type loginAttempts struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// LoginLockout describes a username or client IP that is currently locked out
+type LoginLockout struct {
+	Username    string    `json:"username,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// loginThrottle tracks failed login attempts per username and per client IP.
+// Each failure introduces a progressively longer backoff before the next attempt is allowed
+// and once the maximum number of failures is reached, the username or IP is locked out for a period
+type loginThrottle struct {
+	mutex            sync.Mutex
+	maxAttempts      int
+	maxAttemptsPerIP int
+	lockout          time.Duration
+	attempts         map[string]*loginAttempts
+	inFlight         map[string]int
+	lastPrune        time.Time
+	now              func() time.Time
+}
+
+func newLoginThrottle(maxAttempts, maxAttemptsPerIP int, lockoutInSecs int64) *loginThrottle {
+	return &loginThrottle{
+		maxAttempts:      maxAttempts,
+		maxAttemptsPerIP: maxAttemptsPerIP,
+		lockout:          time.Duration(lockoutInSecs) * time.Second,
+		attempts:         make(map[string]*loginAttempts),
+		inFlight:         make(map[string]int),
+		now:              time.Now,
+	}
+}
+
+func loginThrottleKeys(username, ip string) []string {
+	keys := make([]string, 0, 2)
+	if len(username) > 0 {
+		keys = append(keys, loginThrottleUserPrefix+strings.ToLower(username))
+	}
+	if len(ip) > 0 {
+		keys = append(keys, loginThrottleIPPrefix+ip)
+	}
+	return keys
+}
+
+// expired determines if the failures (and any lockout) of a username or IP no longer count
+func (t *loginThrottle) expired(a *loginAttempts, now time.Time) bool {
+	return now.Sub(a.LastFailure) >= t.lockout && !a.LockedUntil.After(now)
+}
+
+// prune removes the expired failures, so that failed logins for many usernames or IPs do not accumulate.
+// If there are still too many, the least recently failed are removed
+func (t *loginThrottle) prune(now time.Time) {
+	t.lastPrune = now
+	for key, a := range t.attempts {
+		if t.expired(a, now) {
+			delete(t.attempts, key)
+		}
+	}
+
+	for len(t.attempts) >= maxLoginThrottleEntries {
+		var oldestKey string
+		var oldest *loginAttempts
+		for key, a := range t.attempts {
+			if oldest == nil || a.LastFailure.Before(oldest.LastFailure) {
+				oldestKey, oldest = key, a
+			}
+		}
+		delete(t.attempts, oldestKey)
+	}
+}
+
+// backoff returns the delay enforced after the given number of consecutive failures
+func (t *loginThrottle) backoff(failures int) time.Duration {
+	if failures <= 1 {
+		return 0
+	}
+	delay := time.Duration(math.Pow(2, float64(failures-2))) * time.Second
+	if delay > t.lockout {
+		delay = t.lockout
+	}
+	return delay
+}
+
+// limit returns the number of failures after which the username or IP is locked out
+func (t *loginThrottle) limit(key string) int {
+	if strings.HasPrefix(key, loginThrottleIPPrefix) {
+		return t.maxAttemptsPerIP
+	}
+	return t.maxAttempts
+}
+
+// Check returns how long the caller must wait before a login attempt for the username/IP is allowed.
+// If the attempt is allowed, it is reserved until Release is called, so that attempts made in parallel count
+// towards the backoff and lockout as if they had failed
+func (t *loginThrottle) Check(username, ip string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	keys := loginThrottleKeys(username, ip)
+	var wait time.Duration
+	for _, key := range keys {
+		failures := 0
+		if a, ok := t.attempts[key]; ok {
+			if t.expired(a, now) {
+				delete(t.attempts, key)
+			} else {
+				failures = a.Failures
+				until := a.LockedUntil
+				if backoffUntil := a.LastFailure.Add(t.backoff(a.Failures)); backoffUntil.After(until) {
+					until = backoffUntil
+				}
+				if remaining := until.Sub(now); remaining > wait {
+					wait = remaining
+				}
+			}
+		}
+
+		// Wait for the attempts in progress if this one would be delayed should they fail
+		if pending := t.inFlight[key]; pending > 0 {
+			delay := t.backoff(failures + pending)
+			if failures+pending >= t.limit(key) && delay < time.Second {
+				delay = time.Second
+			}
+			if delay > wait {
+				wait = delay
+			}
+		}
+	}
+
+	if wait == 0 {
+		for _, key := range keys {
+			t.inFlight[key]++
+		}
+	}
+	return wait
+}
+
+// Release ends an attempt reserved by Check. Any failure must be recorded before the attempt is released
+func (t *loginThrottle) Release(username, ip string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, key := range loginThrottleKeys(username, ip) {
+		if t.inFlight[key] <= 1 {
+			delete(t.inFlight, key)
+		} else {
+			t.inFlight[key]--
+		}
+	}
+}
+
+// RecordFailure records a failed login for the username/IP, locking them out if the limit has been reached
+func (t *loginThrottle) RecordFailure(username, ip string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	if now.Sub(t.lastPrune) >= t.lockout || len(t.attempts) >= maxLoginThrottleEntries {
+		t.prune(now)
+	}
+
+	for _, key := range loginThrottleKeys(username, ip) {
+		a, ok := t.attempts[key]
+		if !ok || t.expired(a, now) {
+			// First failure or previous failures (and any lockout) have expired - start counting again
+			a = &loginAttempts{}
+			t.attempts[key] = a
+		}
+
+		a.Failures++
+		a.LastFailure = now
+
+		if a.Failures >= t.limit(key) && a.LockedUntil.Before(now) {
+			a.LockedUntil = now.Add(t.lockout)
+			log.Warnf("Login locked out for %s after %d failed attempts - locked until %s", key, a.Failures, a.LockedUntil.Format(time.RFC3339))
+		}
+	}
+}
+
+// RecordSuccess clears the failed login history for the username.
+// The client IP history is retained so that a successful login to one account does not reset the IP limit
+func (t *loginThrottle) RecordSuccess(username string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, key := range loginThrottleKeys(username, "") {
+		delete(t.attempts, key)
+	}
+}
+
+// Unlock clears any failed login history and lockout for the username and/or IP
+func (t *loginThrottle) Unlock(username, ip string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	found := false
+	for _, key := range loginThrottleKeys(username, ip) {
+		if _, ok := t.attempts[key]; ok {
+			delete(t.attempts, key)
+			found = true
+			log.Infof("Login lockout cleared for %s", key)
+		}
+	}
+	return found
+}
+
+// Lockouts returns the usernames and IPs that are currently locked out.
+// Expired entries are removed as a side effect
+func (t *loginThrottle) Lockouts() []*LoginLockout {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	lockouts := make([]*LoginLockout, 0)
+	for key, a := range t.attempts {
+		if a.LockedUntil.After(now) {
+			lockout := &LoginLockout{
+				Failures:    a.Failures,
+				LockedUntil: a.LockedUntil,
+			}
+			if strings.HasPrefix(key, loginThrottleIPPrefix) {
+				lockout.IP = strings.TrimPrefix(key, loginThrottleIPPrefix)
+			} else {
+				lockout.Username = strings.TrimPrefix(key, loginThrottleUserPrefix)
+			}
+			lockouts = append(lockouts, lockout)
+		} else if t.expired(a, now) {
+			delete(t.attempts, key)
+		}
+	}
+	return lockouts
+}
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges of the proxies that are trusted to set X-Forwarded-For
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	trusted := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if len(proxy) == 0 {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil {
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 8*net.IPv4len
+				}
+				trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+				continue
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Warnf("Ignoring invalid trusted proxy: %s", proxy)
+			continue
+		}
+		trusted = append(trusted, ipNet)
+	}
+	return trusted
+}
+
+func isTrustedProxy(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginClientIP determines the IP of the client from the address of the connection. X-Forwarded-For is only used
+// if the connection is from a trusted proxy, in which case the client is the nearest address that is not one
+func loginClientIP(remoteAddress, forwardedFor string, trusted []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		ip = remoteAddress
+	}
+
+	if len(forwardedFor) == 0 || !isTrustedProxy(ip, trusted) {
+		return ip
+	}
+
+	forwarded := strings.Split(forwardedFor, ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = strings.TrimSpace(forwarded[i])
+		if !isTrustedProxy(ip, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// getLoginClientIP gets the IP of the client that is logging in, for throttling
+func (p *portalProxy) getLoginClientIP(c echo.Context) string {
+	return loginClientIP(c.Request().RemoteAddress(), c.Request().Header().Get(echo.HeaderXForwardedFor), p.TrustedProxies)
+}
+
+// isLoginRejected determines if a login error was due to the credentials being rejected by the UAA
+// (as opposed to the UAA being unavailable)
+func isLoginRejected(err error) bool {
+	if httpErr, ok := err.(interfaces.ErrHTTPRequest); ok {
+		return httpErr.Status == http.StatusUnauthorized || httpErr.Status == http.StatusBadRequest
+	}
+	return false
+}
+
+func newLoginThrottledError(wait time.Duration) error {
+	secs := int64(math.Ceil(wait.Seconds()))
+	return interfaces.NewHTTPShadowError(
+		http.StatusTooManyRequests,
+		fmt.Sprintf("Too many failed login attempts - try again in %d seconds", secs),
+		"Login throttled - next attempt allowed in %d seconds", secs)
+}
+
+// checkLoginThrottle returns an error if login attempts for the username/IP are currently blocked.
+// Otherwise the attempt is reserved and the caller must release it with LoginThrottle.Release once it completes
+func (p *portalProxy) checkLoginThrottle(c echo.Context, username, ip string) error {
+	wait := p.LoginThrottle.Check(username, ip)
+	if wait > 0 {
+		c.Response().Header().Set("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(wait.Seconds()))))
+		return newLoginThrottledError(wait)
+	}
+	return nil
+}
+
+// Admin endpoint to list the usernames and IPs that are currently locked out
+func (p *portalProxy) listLoginLockouts(c echo.Context) error {
+	log.Debug("listLoginLockouts")
+	return c.JSON(http.StatusOK, p.LoginThrottle.Lockouts())
+}
+
+// Admin endpoint to unlock a username and/or IP
+func (p *portalProxy) unlockLogin(c echo.Context) error {
+	log.Debug("unlockLogin")
+	username := c.FormValue("username")
+	ip := c.FormValue("ip")
+
+	if len(username) == 0 && len(ip) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Need username or ip to unlock",
+			"Need username or ip passed as form param")
+	}
+
+	if !p.LoginThrottle.Unlock(username, ip) {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"No failed login attempts found",
+			"No failed login attempts found for username: %s, ip: %s", username, ip)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	t.Parallel()
+
+	Convey("Login throttle tests", t, func() {
+		now := time.Now()
+		throttle := newLoginThrottle(3, 5, 60)
+		throttle.now = func() time.Time { return now }
+
+		Convey("Should allow login with no failures", func() {
+			So(throttle.Check("admin", "10.0.0.1"), ShouldEqual, 0)
+		})
+
+		Convey("Should not delay after a single failure", func() {
+			throttle.RecordFailure("admin", "10.0.0.1")
+			So(throttle.Check("admin", "10.0.0.1"), ShouldEqual, 0)
+		})
+
+		Convey("Should back off progressively", func() {
+			throttle.RecordFailure("admin", "10.0.0.1")
+			throttle.RecordFailure("admin", "10.0.0.1")
+			So(throttle.Check("admin", "10.0.0.1"), ShouldEqual, time.Second)
+			So(throttle.Check("other", "10.0.0.2"), ShouldEqual, 0)
+
+			now = now.Add(time.Second)
+			So(throttle.Check("admin", "10.0.0.1"), ShouldEqual, 0)
+		})
+
+		Convey("Should lock out username after max failures", func() {
+			for i := 0; i < 3; i++ {
+				throttle.RecordFailure("Admin", "10.0.0.1")
+			}
+			So(throttle.Check("admin", "10.0.0.2"), ShouldEqual, 60*time.Second)
+
+			lockouts := throttle.Lockouts()
+			So(lockouts, ShouldHaveLength, 1)
+			So(lockouts[0].Username, ShouldEqual, "admin")
+
+			Convey("Lockout should expire", func() {
+				now = now.Add(61 * time.Second)
+				So(throttle.Check("admin", "10.0.0.2"), ShouldEqual, 0)
+			})
+
+			Convey("Lockout can be cleared by an admin", func() {
+				So(throttle.Unlock("admin", ""), ShouldBeTrue)
+				So(throttle.Check("admin", "10.0.0.2"), ShouldEqual, 0)
+				So(throttle.Unlock("admin", ""), ShouldBeFalse)
+			})
+		})
+
+		Convey("Should lock out IP after max failures across usernames", func() {
+			for _, username := range []string{"a", "b", "c", "d", "e"} {
+				throttle.RecordFailure(username, "10.0.0.1")
+			}
+			So(throttle.Check("f", "10.0.0.1"), ShouldEqual, 60*time.Second)
+			So(throttle.Check("f", "10.0.0.2"), ShouldEqual, 0)
+		})
+
+		Convey("Successful login should reset username but not IP", func() {
+			throttle.RecordFailure("admin", "10.0.0.1")
+			throttle.RecordFailure("admin", "10.0.0.1")
+			throttle.RecordSuccess("admin")
+			So(throttle.Check("admin", ""), ShouldEqual, 0)
+			So(throttle.Check("", "10.0.0.1"), ShouldEqual, time.Second)
+		})
+
+		Convey("Should forget expired failures", func() {
+			throttle.RecordFailure("a", "10.0.0.1")
+			throttle.RecordFailure("b", "10.0.0.2")
+			So(throttle.attempts, ShouldHaveLength, 4)
+
+			now = now.Add(61 * time.Second)
+			So(throttle.Check("a", ""), ShouldEqual, 0)
+			So(throttle.attempts, ShouldHaveLength, 3)
+
+			throttle.RecordFailure("c", "")
+			So(throttle.attempts, ShouldHaveLength, 1)
+		})
+
+		Convey("Should count attempts in progress towards the limit", func() {
+			throttle.RecordFailure("admin", "10.0.0.1")
+			So(throttle.Check("admin", "10.0.0.1"), ShouldEqual, 0)
+			So(throttle.Check("admin", "10.0.0.2"), ShouldEqual, time.Second)
+			So(throttle.Check("other", "10.0.0.3"), ShouldEqual, 0)
+
+			throttle.Release("admin", "10.0.0.1")
+			So(throttle.Check("admin", "10.0.0.2"), ShouldEqual, 0)
+			throttle.Release("admin", "10.0.0.2")
+			throttle.Release("other", "10.0.0.3")
+			So(throttle.inFlight, ShouldBeEmpty)
+		})
+
+		Convey("Should not allow more parallel attempts than the limit", func() {
+			throttle = newLoginThrottle(3, 5, 60)
+			var wg sync.WaitGroup
+			var allowed int32
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if throttle.Check("admin", "10.0.0.1") == 0 {
+						atomic.AddInt32(&allowed, 1)
+					}
+				}()
+			}
+			wg.Wait()
+			So(allowed, ShouldBeLessThanOrEqualTo, 3)
+		})
+
+		Convey("Should not track more than the maximum number of failures", func() {
+			for i := 0; i < maxLoginThrottleEntries+10; i++ {
+				now = now.Add(time.Millisecond)
+				throttle.RecordFailure(fmt.Sprintf("user%d", i), "")
+			}
+			So(len(throttle.attempts), ShouldBeLessThanOrEqualTo, maxLoginThrottleEntries)
+			So(throttle.attempts, ShouldContainKey, fmt.Sprintf("user:user%d", maxLoginThrottleEntries+9))
+			So(throttle.attempts, ShouldNotContainKey, "user:user0")
+		})
+	})
+}
+
+func TestLoginClientIP(t *testing.T) {
+	t.Parallel()
+
+	Convey("Login client IP tests", t, func() {
+		trusted := parseTrustedProxies([]string{"10.0.0.0/8", " 192.168.1.1", "not-an-ip"})
+		So(trusted, ShouldHaveLength, 2)
+
+		Convey("Should use the connection address without trusted proxies", func() {
+			So(loginClientIP("10.1.1.1:1234", "1.2.3.4", nil), ShouldEqual, "10.1.1.1")
+		})
+
+		Convey("Should ignore X-Forwarded-For from untrusted connections", func() {
+			So(loginClientIP("8.8.8.8:1234", "1.2.3.4", trusted), ShouldEqual, "8.8.8.8")
+		})
+
+		Convey("Should use X-Forwarded-For from a trusted proxy", func() {
+			So(loginClientIP("192.168.1.1:1234", "1.2.3.4", trusted), ShouldEqual, "1.2.3.4")
+		})
+
+		Convey("Should skip trusted proxies and ignore addresses added by the client", func() {
+			So(loginClientIP("10.1.1.1:1234", "6.6.6.6, 1.2.3.4, 10.2.2.2", trusted), ShouldEqual, "1.2.3.4")
+		})
+	})
+}
+
+func TestLoginToUAAWhenLockedOut(t *testing.T) {
+	t.Parallel()
+
+	Convey("UAA login when locked out", t, func() {
+		req := setupMockReq("POST", "", map[string]string{
+			"username": "admin",
+			"password": "busted",
+		})
+
+		_, _, ctx, pp, db, _ := setupHTTPTest(req)
+		defer db.Close()
+
+		mockUAA := setupMockServer(t,
+			msRoute("/oauth/token"),
+			msMethod("POST"),
+			msStatus(http.StatusUnauthorized),
+			msBody(""))
+
+		defer mockUAA.Close()
+		pp.Config.ConsoleConfig = new(interfaces.ConsoleConfig)
+		uaaUrl, _ := url.Parse(mockUAA.URL)
+		pp.Config.ConsoleConfig.UAAEndpoint = uaaUrl
+		pp.Config.ConsoleConfig.SkipSSLValidation = true
+		pp.LoginThrottle = newLoginThrottle(1, 1, 60)
+
+		So(pp.loginToUAA(ctx), ShouldNotBeNil)
+
+		Convey("Should reject further attempts with too many requests", func() {
+			err := pp.loginToUAA(ctx)
+			So(err, ShouldNotBeNil)
+			shadowErr, ok := err.(interfaces.ErrHTTPShadow)
+			So(ok, ShouldBeTrue)
+			So(shadowErr.HTTPError.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(ctx.Response().Header().Get("Retry-After"), ShouldEqual, "60")
+		})
+	})
+}
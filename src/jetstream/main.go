@@ -206,6 +206,17 @@ func main() {
 
 	log.Info("Plugins initialized")
 
+	// Register the endpoints listed in the endpoints config file (if configured)
+	if err := portalProxy.bootstrapEndpoints(); err != nil {
+		log.Fatalf("Unable to bootstrap endpoints: %v", err)
+	}
+
+	// Start the background token refresher (if enabled)
+	portalProxy.startTokenRefresher()
+
+	// Purge unregistered endpoints once they can no longer be restored
+	portalProxy.startDeletedEndpointPurger()
+
 	// Get Diagnostics and store them once - ensure this is done after plugins are loaded
 	portalProxy.StoreDiagnostics()
 
@@ -420,6 +431,21 @@ func loadPortalConfig(pc interfaces.PortalConfig) (interfaces.PortalConfig, erro
 		pc.HTTPClientTimeoutMutatingInSecs = pc.HTTPClientTimeoutInSecs
 	}
 
+	// Default login throttling settings if not configured
+	if pc.LoginMaxFailedAttempts == 0 {
+		pc.LoginMaxFailedAttempts = defaultLoginMaxFailedAttempts
+	}
+	if pc.LoginMaxFailedAttemptsPerIP == 0 {
+		pc.LoginMaxFailedAttemptsPerIP = defaultLoginMaxFailedAttemptsPerIP
+	}
+	if pc.LoginLockoutInSecs == 0 {
+		pc.LoginLockoutInSecs = defaultLoginLockoutInSecs
+	}
+
+	if pc.TokenRefreshThresholdInSecs == 0 {
+		pc.TokenRefreshThresholdInSecs = defaultTokenRefreshThresholdInSecs
+	}
+
 	return pc, nil
 }
 
@@ -505,6 +531,9 @@ func newPortalProxy(pc interfaces.PortalConfig, dcp *sql.DB, ss HttpSessionStore
 		SessionStoreOptions:    sessionStoreOptions,
 		SessionCookieName:      cookieName,
 		EmptyCookieMatcher:     regexp.MustCompile(cookieName + "=(?:;[ ]*|$)"),
+		LoginThrottle:          newLoginThrottle(pc.LoginMaxFailedAttempts, pc.LoginMaxFailedAttemptsPerIP, pc.LoginLockoutInSecs),
+		TokenRefresh:           newTokenRefreshGroup(),
+		TrustedProxies:         parseTrustedProxies(pc.TrustedProxies),
 	}
 
 	return pp
@@ -628,6 +657,14 @@ func (p *portalProxy) GetEndpointTypeSpec(typeName string) (interfaces.EndpointP
 	return nil, errors.New("Endpoint type plugin not loaded")
 }
 
+// getDefaultEndpointClient gets the client used for endpoints of the plugin's type that are registered without one
+func (p *portalProxy) getDefaultEndpointClient(endpointPlugin interfaces.EndpointPlugin) (string, string) {
+	if clientPlugin, ok := endpointPlugin.(interfaces.EndpointClientPlugin); ok {
+		return clientPlugin.DefaultClient()
+	}
+	return p.GetConfig().CFClient, p.GetConfig().CFClientSecret
+}
+
 func (p *portalProxy) GetHttpClient(skipSSLValidation bool) http.Client {
 	return p.getHttpClient(skipSSLValidation, false)
 }
@@ -767,6 +804,25 @@ func (p *portalProxy) registerRoutes(e *echo.Echo, addSetupMiddleware *setupMidd
 	}
 
 	adminGroup.POST("/unregister", p.unregisterCluster)
+	adminGroup.GET("/unregister/deleted", p.listDeletedEndpoints)
+	adminGroup.POST("/unregister/restore", p.restoreEndpoint)
+	adminGroup.POST("/unregister/purge", p.purgeEndpoint)
+
+	// Login lockouts
+	adminGroup.GET("/auth/lockouts", p.listLoginLockouts)
+	adminGroup.POST("/auth/lockouts/unlock", p.unlockLogin)
+
+	// Users and groups that system shared endpoint tokens are shared with
+	adminGroup.GET("/cnsis/shares", p.listCNSITokenShares)
+	adminGroup.POST("/cnsis/shares", p.addCNSITokenShare)
+	adminGroup.POST("/cnsis/shares/remove", p.removeCNSITokenShare)
+
+	// Bulk export and import of endpoint registrations
+	adminGroup.GET("/cnsis/export", p.exportEndpoints)
+	adminGroup.POST("/cnsis/import", p.importEndpoints)
+
+	// Endpoint description, group and labels
+	adminGroup.POST("/cnsis/attributes", p.updateCNSIAttributes)
 	// sessionGroup.DELETE("/cnsis", p.removeCluster)
 
 	// Serve up static resources
@@ -274,10 +274,14 @@ const (
 	selectAnyFromCNSIs  = `SELECT (.+) FROM cnsis WHERE (.+)`
 	insertIntoCNSIs     = `INSERT INTO cnsis`
 	getDbVersion        = `SELECT version_id FROM goose_db_version WHERE is_applied = '1' ORDER BY id DESC LIMIT 1`
+
+	selectFromCNSIsAndTokens = `SELECT (.+) FROM cnsis c, tokens t WHERE (.+)`
 )
 
 var rowFieldsForCNSI = []string{"guid", "name", "cnsi_type", "api_endpoint", "auth_endpoint", "token_endpoint", "doppler_logging_endpoint", "skip_ssl_validation", "client_id", "client_secret", "allow_sso"}
 
+var rowFieldsForConnectedEndpoint = []string{"guid", "name", "cnsi_type", "api_endpoint", "doppler_logging_endpoint", "user_guid", "token_expiry", "skip_ssl_validation", "disconnected", "meta_data"}
+
 var mockEncryptionKey = make([]byte, 32)
 
 var cipherClientSecret, _ = crypto.EncryptToken(mockEncryptionKey, mockClientSecret)
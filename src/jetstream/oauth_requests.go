@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -67,17 +68,32 @@ func (p *portalProxy) getCNSIRequestRecords(r *interfaces.CNSIRequest) (t interf
 	return t, c, nil
 }
 
+// RefreshOAuthToken refreshes the user's OAuth token for the endpoint - only one refresh runs at a time for a given token
 func (p *portalProxy) RefreshOAuthToken(skipSSLValidation bool, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint string) (t interfaces.TokenRecord, err error) {
+	return p.refreshCNSIToken(cnsiGUID, userGUID, func(userToken interfaces.TokenRecord) (interfaces.TokenRecord, error) {
+		return p.doRefreshOAuthToken(skipSSLValidation, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint, userToken)
+	})
+}
+
+func (p *portalProxy) doRefreshOAuthToken(skipSSLValidation bool, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint string, userToken interfaces.TokenRecord) (t interfaces.TokenRecord, err error) {
 	log.Debug("refreshToken")
-	userToken, ok := p.GetCNSITokenRecordWithDisconnected(cnsiGUID, userGUID)
-	if !ok {
-		return t, fmt.Errorf("Info could not be found for user with GUID %s", userGUID)
-	}
 
 	tokenEndpointWithPath := fmt.Sprintf("%s/oauth/token", tokenEndpoint)
 
-	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, "")
+	// Client credential tokens have no refresh token - the client secret is stored in its place
+	var uaaRes *UAAResponse
+	metadata := &interfaces.OAuth2Metadata{}
+	if len(userToken.Metadata) > 0 && json.Unmarshal([]byte(userToken.Metadata), metadata) == nil &&
+		metadata.GrantType == interfaces.OAuth2GrantTypeClientCredentials {
+		uaaRes, err = p.getUAATokenWithClientCredentials(skipSSLValidation, metadata.ClientID, userToken.RefreshToken, tokenEndpointWithPath)
+		if err == nil {
+			uaaRes.RefreshToken = userToken.RefreshToken
+		}
+	} else {
+		uaaRes, err = p.getUAATokenWithRefreshToken(skipSSLValidation, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, "")
+	}
 	if err != nil {
+		p.disconnectRejectedToken(cnsiGUID, userGUID, userToken, err)
 		return t, fmt.Errorf("Token refresh request failed: %v", err)
 	}
 
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -52,12 +53,54 @@ func (p *portalProxy) doOidcFlowRequest(cnsiRequest *interfaces.CNSIRequest, req
 	}
 }
 
+// ConnectOidc connects to an endpoint using the user's credentials with the OIDC provider (resource owner password grant).
+// The endpoint's token endpoint must be the full URL of the provider's token endpoint, e.g. as found through OIDC discovery
+func (p *portalProxy) ConnectOidc(c echo.Context, cnsiRecord interfaces.CNSIRecord) (*interfaces.TokenRecord, error) {
+	log.Debug("ConnectOidc")
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+	if len(username) == 0 || len(password) == 0 {
+		return nil, errors.New("Needs username and password")
+	}
+
+	uaaRes, err := p.getUAATokenWithCreds(cnsiRecord.SkipSSLValidation, username, password, cnsiRecord.ClientId, cnsiRecord.ClientSecret, cnsiRecord.TokenEndpoint)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"Login failed",
+			"Login failed: %v", err)
+	}
+
+	// The access token is used to determine the expiry and the user - fall back to the ID token if it is not a JWT
+	u, err := p.GetUserTokenInfo(uaaRes.AccessToken)
+	if err != nil {
+		if u, err = p.GetUserTokenInfo(uaaRes.IDToken); err != nil {
+			return nil, fmt.Errorf("Could not get user token info from the access or id token: %v", err)
+		}
+	}
+
+	metadata, err := json.Marshal(&interfaces.OAuth2Metadata{
+		TokenEndpoint: cnsiRecord.TokenEndpoint,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRecord := p.InitEndpointTokenRecord(u.TokenExpiry, uaaRes.AccessToken, uaaRes.RefreshToken, false)
+	tokenRecord.AuthType = interfaces.AuthTypeOIDC
+	tokenRecord.Metadata = string(metadata)
+	return &tokenRecord, nil
+}
+
+// RefreshOidcToken refreshes the user's OIDC token for the endpoint - only one refresh runs at a time for a given token
 func (p *portalProxy) RefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint string) (t interfaces.TokenRecord, err error) {
+	return p.refreshCNSIToken(cnsiGUID, userGUID, func(userToken interfaces.TokenRecord) (interfaces.TokenRecord, error) {
+		return p.doRefreshOidcToken(skipSSLValidation, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint, userToken)
+	})
+}
+
+func (p *portalProxy) doRefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUID, client, clientSecret, tokenEndpoint string, userToken interfaces.TokenRecord) (t interfaces.TokenRecord, err error) {
 	log.Debug("refreshToken")
-	userToken, ok := p.GetCNSITokenRecordWithDisconnected(cnsiGUID, userGUID)
-	if !ok {
-		return t, fmt.Errorf("Info could not be found for user with GUID %s", userGUID)
-	}
 
 	tokenEndpointWithPath := fmt.Sprintf("%s/oauth/token", tokenEndpoint)
 
@@ -83,22 +126,37 @@ func (p *portalProxy) RefreshOidcToken(skipSSLValidation bool, cnsiGUID, userGUI
 				tokenEndpoint = metadata.IssuerURL
 				tokenEndpointWithPath = fmt.Sprintf("%s/token", tokenEndpoint)
 			}
+			// Token endpoint found through OIDC discovery
+			if len(metadata.TokenEndpoint) > 0 {
+				tokenEndpointWithPath = metadata.TokenEndpoint
+			}
 		}
 	}
 
 	uaaRes, err := p.getUAATokenWithRefreshToken(skipSSLValidation, userToken.RefreshToken, client, clientSecret, tokenEndpointWithPath, scopes)
 	if err != nil {
+		p.disconnectRejectedToken(cnsiGUID, userGUID, userToken, err)
 		return t, fmt.Errorf("Token refresh request failed: %v", err)
 	}
 
-	u, err := p.GetUserTokenInfo(uaaRes.IDToken)
+	// Not all providers return a new ID token or refresh token when a token is refreshed
+	idToken := uaaRes.IDToken
+	if len(idToken) == 0 {
+		idToken = uaaRes.AccessToken
+	}
+	refreshToken := uaaRes.RefreshToken
+	if len(refreshToken) == 0 {
+		refreshToken = userToken.RefreshToken
+	}
+
+	u, err := p.GetUserTokenInfo(idToken)
 	if err != nil {
 		return t, fmt.Errorf("Could not get user token info from id token")
 	}
 
 	u.UserGUID = userGUID
 
-	tokenRecord := p.InitEndpointTokenRecord(u.TokenExpiry, uaaRes.AccessToken, uaaRes.RefreshToken, userToken.Disconnected)
+	tokenRecord := p.InitEndpointTokenRecord(u.TokenExpiry, uaaRes.AccessToken, refreshToken, userToken.Disconnected)
 	tokenRecord.AuthType = interfaces.AuthTypeOIDC
 	// Copy across the metadata from the original token
 	tokenRecord.Metadata = userToken.Metadata
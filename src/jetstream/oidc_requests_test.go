@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestConnectOidc(t *testing.T) {
+	t.Parallel()
+
+	Convey("Connect to an OIDC endpoint", t, func() {
+		mockProvider := setupMockServer(t,
+			msRoute("/protocol/openid-connect/token"),
+			msMethod("POST"),
+			msStatus(http.StatusOK),
+			msBody(jsonMust(mockUAAResponse)))
+		defer mockProvider.Close()
+
+		cnsiRecord := interfaces.CNSIRecord{
+			GUID:              mockCNSIGUID,
+			CNSIType:          "api",
+			TokenEndpoint:     mockProvider.URL + "/protocol/openid-connect/token",
+			SkipSSLValidation: true,
+		}
+
+		Convey("Should connect with a username and password", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": "admin",
+				"password": "changeme",
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			tokenRecord, err := pp.ConnectOidc(ctx, cnsiRecord)
+			So(err, ShouldBeNil)
+			So(tokenRecord.AuthType, ShouldEqual, interfaces.AuthTypeOIDC)
+			So(tokenRecord.AuthToken, ShouldEqual, mockUAAToken)
+			So(tokenRecord.TokenExpiry, ShouldBeGreaterThan, 0)
+
+			// The discovered token endpoint is needed to refresh the token
+			metadata := &interfaces.OAuth2Metadata{}
+			So(json.Unmarshal([]byte(tokenRecord.Metadata), metadata), ShouldBeNil)
+			So(metadata.TokenEndpoint, ShouldEqual, cnsiRecord.TokenEndpoint)
+		})
+
+		Convey("Should require a username and password", func() {
+			req := setupMockReq("POST", "", map[string]string{
+				"username": "admin",
+			})
+			_, _, ctx, pp, db, _ := setupHTTPTest(req)
+			defer db.Close()
+
+			_, err := pp.ConnectOidc(ctx, cnsiRecord)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
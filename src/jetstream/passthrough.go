@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/labstack/echo"
@@ -32,6 +33,14 @@ type PassthroughError struct {
 	ErrorResponse *json.RawMessage        `json:"errorResponse"`
 }
 
+// SelectedEndpointsResponse is the response to a proxied request whose endpoints were chosen with a selector.
+// It reports the endpoints that the selector resolved to alongside their responses
+type SelectedEndpointsResponse struct {
+	Selector  string                      `json:"selector"`
+	CNSIList  []string                    `json:"cnsi_list"`
+	Responses map[string]*json.RawMessage `json:"responses"`
+}
+
 func getEchoURL(c echo.Context) url.URL {
 	log.Debug("getEchoURL")
 	u := c.Request().URL().(*standard.URL).URL
@@ -215,18 +224,34 @@ func (p *portalProxy) ProxyRequest(c echo.Context, uri *url.URL) (map[string]*in
 	cnsiList := strings.Split(c.Request().Header().Get("x-cap-cnsi-list"), ",")
 	shouldPassthrough := "true" == c.Request().Header().Get("x-cap-passthrough")
 
-	if err := p.validateCNSIList(cnsiList); err != nil {
+	portalUserGUID, err := getPortalUserGUID(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// Endpoints can be chosen with a selector instead of a list of GUIDs
+	selector, hasSelector, err := getCNSISelector(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if hasSelector && len(c.Request().Header().Get("x-cap-cnsi-list")) == 0 {
+		if cnsiList, err = p.resolveCNSISelector(selector, portalUserGUID); err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		if shouldPassthrough && len(cnsiList) != 1 {
+			err := fmt.Errorf("Selector matched %d endpoints. Passthrough requires the selector to match a single endpoint", len(cnsiList))
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		uri = removeCNSISelectorQueryParam(uri)
+		c.Set(cnsiSelectorContextKey, selector)
+		c.Response().Header().Set("x-cap-cnsi-list", strings.Join(cnsiList, ","))
+	} else if err := p.validateCNSIList(cnsiList); err != nil {
 		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	header := getEchoHeaders(c)
 	header.Del("Cookie")
 
-	portalUserGUID, err := getPortalUserGUID(c)
-	if err != nil {
-		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
 	req, body, err := getRequestParts(c)
 	if err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
@@ -325,6 +350,25 @@ func (p *portalProxy) SendProxiedResponse(c echo.Context, responses map[string]*
 
 	jsonResponse := buildJSONResponse(cnsiList, responses)
 	e := json.NewEncoder(c.Response())
+
+	// Report the endpoints that the selector resolved to
+	if selector, ok := c.Get(cnsiSelectorContextKey).(*cnsiSelector); ok {
+		sort.Strings(cnsiList)
+		selectedResponse := &SelectedEndpointsResponse{
+			Selector:  selector.selector,
+			CNSIList:  cnsiList,
+			Responses: jsonResponse,
+		}
+		if selectedResponse.CNSIList == nil {
+			selectedResponse.CNSIList = make([]string, 0)
+		}
+		err := e.Encode(selectedResponse)
+		if err != nil {
+			log.Errorf("Failed to encode JSON: %v\n%#v\n", err, selectedResponse)
+		}
+		return err
+	}
+
 	err := e.Encode(jsonResponse)
 	if err != nil {
 		log.Errorf("Failed to encode JSON: %v\n%#v\n", err, jsonResponse)
@@ -372,6 +416,10 @@ func (p *portalProxy) doRequest(cnsiRequest *interfaces.CNSIRequest, done chan<-
 		res, err = p.doHttpBasicFlowRequest(cnsiRequest, req)
 	case interfaces.AuthTypeOIDC:
 		res, err = p.doOidcFlowRequest(cnsiRequest, req)
+	case interfaces.AuthTypeBearer:
+		res, err = p.doBearerFlowRequest(cnsiRequest, req)
+	case interfaces.AuthTypeCertificate:
+		res, err = p.doCertificateFlowRequest(cnsiRequest, req)
 	default:
 		res, err = p.doOauthFlowRequest(cnsiRequest, req)
 	}
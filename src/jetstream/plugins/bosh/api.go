@@ -0,0 +1,285 @@
+package bosh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Types of task output
+const (
+	eventOutputType  = "event"
+	resultOutputType = "result"
+	debugOutputType  = "debug"
+)
+
+// Names of deployments, and the task states that tasks can be filtered by
+var (
+	deploymentNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	taskStates           = map[string]bool{
+		"queued":     true,
+		"processing": true,
+		"cancelling": true,
+		"cancelled":  true,
+		"done":       true,
+		"error":      true,
+		"timeout":    true,
+	}
+)
+
+// get makes an unauthenticated request to the director
+func (b *BoshSpecification) get(requestURL string, skipSSLValidation bool) ([]byte, error) {
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request for %s: %v", requestURL, err)
+	}
+
+	client := b.portalProxy.GetHttpClientForRequest(req, skipSSLValidation)
+	res, err := client.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error performing BOSH request - response: %v, error: %v", res, err)
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// directorRequest makes a request to the director on behalf of the user. Requests are made through the proxy, so
+// that the user's UAA token is refreshed when it expires
+func (b *BoshSpecification) directorRequest(cnsiGUID, userGUID, path string, headers http.Header) (*interfaces.CNSIRequest, error) {
+	uri, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []interfaces.ProxyRequestInfo{
+		{
+			EndpointGUID: cnsiGUID,
+			ResultGUID:   cnsiGUID,
+			UserGUID:     userGUID,
+			Method:       "GET",
+			URI:          uri,
+			Headers:      headers,
+		},
+	}
+
+	responses, err := b.portalProxy.DoProxyRequest(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	res, ok := responses[cnsiGUID]
+	if !ok {
+		return nil, fmt.Errorf("No response from BOSH director %s", cnsiGUID)
+	}
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return res, nil
+}
+
+// getDirector checks that the request is for a BOSH director that the user is connected to
+func (b *BoshSpecification) getDirector(c echo.Context) (string, string, error) {
+	cnsiGUID := c.Param("cnsiGuid")
+	userGUID := c.Get("user_id").(string)
+
+	cnsiRecord, err := b.portalProxy.GetCNSIRecord(cnsiGUID)
+	if err != nil || cnsiRecord.CNSIType != EndpointType {
+		return "", "", interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested BOSH director not registered",
+			"No BOSH director registered with GUID %s: %v", cnsiGUID, err)
+	}
+
+	tokenRecord, ok := b.portalProxy.GetCNSITokenRecord(cnsiGUID, userGUID)
+	if !ok || tokenRecord.Disconnected {
+		return "", "", interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"User has not connected to the BOSH director",
+			"User %s has not connected to BOSH director %s", userGUID, cnsiGUID)
+	}
+
+	return cnsiGUID, userGUID, nil
+}
+
+// relay makes a request to the director on behalf of the user and sends them the response
+func (b *BoshSpecification) relay(c echo.Context, userMsg string, path string) error {
+	cnsiGUID, userGUID, err := b.getDirector(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.directorRequest(cnsiGUID, userGUID, path, nil)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadGateway,
+			userMsg,
+			"%s for BOSH director %s: %v", userMsg, cnsiGUID, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		// Pass on client errors, such as an unknown deployment
+		status := http.StatusBadGateway
+		if res.StatusCode >= 400 && res.StatusCode < 500 {
+			status = res.StatusCode
+		}
+		return interfaces.NewHTTPShadowError(
+			status,
+			userMsg,
+			"%s for BOSH director %s: %d %s", userMsg, cnsiGUID, res.StatusCode, string(res.Response))
+	}
+
+	return c.JSONBlob(http.StatusOK, res.Response)
+}
+
+func getDeploymentParam(c echo.Context) (string, error) {
+	deployment := c.Param("deployment")
+	if !deploymentNameRegexp.MatchString(deployment) {
+		return "", interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid deployment name",
+			"Invalid deployment name: %s", deployment)
+	}
+	return deployment, nil
+}
+
+func getTaskIDParam(c echo.Context) (string, error) {
+	taskID := c.Param("taskId")
+	if _, err := strconv.Atoi(taskID); err != nil {
+		return "", interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid task ID",
+			"Invalid task ID: %s", taskID)
+	}
+	return taskID, nil
+}
+
+func getOutputTypeParam(c echo.Context) (string, error) {
+	outputType := c.QueryParam("type")
+	switch outputType {
+	case "":
+		return eventOutputType, nil
+	case eventOutputType, resultOutputType, debugOutputType:
+		return outputType, nil
+	default:
+		return "", interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Output type must be one of event, result or debug",
+			"Invalid task output type: %s", outputType)
+	}
+}
+
+// listDeployments lists the deployments of the director
+func (b *BoshSpecification) listDeployments(c echo.Context) error {
+	log.Debug("listDeployments")
+	return b.relay(c, "Unable to list deployments", "/deployments")
+}
+
+// listVMs lists the VMs of a deployment
+func (b *BoshSpecification) listVMs(c echo.Context) error {
+	log.Debug("listVMs")
+	deployment, err := getDeploymentParam(c)
+	if err != nil {
+		return err
+	}
+	return b.relay(c, "Unable to list VMs", fmt.Sprintf("/deployments/%s/vms", deployment))
+}
+
+// listInstances lists the instances of a deployment, including those that do not have a VM
+func (b *BoshSpecification) listInstances(c echo.Context) error {
+	log.Debug("listInstances")
+	deployment, err := getDeploymentParam(c)
+	if err != nil {
+		return err
+	}
+	return b.relay(c, "Unable to list instances", fmt.Sprintf("/deployments/%s/instances", deployment))
+}
+
+// listTasks lists the director's tasks, newest first. Tasks can be filtered by their state and deployment
+func (b *BoshSpecification) listTasks(c echo.Context) error {
+	log.Debug("listTasks")
+	query := url.Values{}
+	query.Set("verbose", "1")
+
+	if limit := c.QueryParam("limit"); len(limit) > 0 {
+		if _, err := strconv.Atoi(limit); err != nil {
+			return interfaces.NewHTTPShadowError(http.StatusBadRequest, "Invalid limit", "Invalid limit: %s", limit)
+		}
+		query.Set("limit", limit)
+	}
+
+	if state := c.QueryParam("state"); len(state) > 0 {
+		if !taskStates[state] {
+			return interfaces.NewHTTPShadowError(http.StatusBadRequest, "Invalid task state", "Invalid task state: %s", state)
+		}
+		query.Set("state", state)
+	}
+
+	if deployment := c.QueryParam("deployment"); len(deployment) > 0 {
+		if !deploymentNameRegexp.MatchString(deployment) {
+			return interfaces.NewHTTPShadowError(http.StatusBadRequest, "Invalid deployment name", "Invalid deployment name: %s", deployment)
+		}
+		query.Set("deployment", deployment)
+	}
+
+	return b.relay(c, "Unable to list tasks", "/tasks?"+query.Encode())
+}
+
+// getTask gets a task
+func (b *BoshSpecification) getTask(c echo.Context) error {
+	log.Debug("getTask")
+	taskID, err := getTaskIDParam(c)
+	if err != nil {
+		return err
+	}
+	return b.relay(c, "Unable to get task", fmt.Sprintf("/tasks/%s", taskID))
+}
+
+// getTaskOutput gets the output of a task so far
+func (b *BoshSpecification) getTaskOutput(c echo.Context) error {
+	log.Debug("getTaskOutput")
+	taskID, err := getTaskIDParam(c)
+	if err != nil {
+		return err
+	}
+
+	outputType, err := getOutputTypeParam(c)
+	if err != nil {
+		return err
+	}
+
+	cnsiGUID, userGUID, err := b.getDirector(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.directorRequest(cnsiGUID, userGUID, taskOutputPath(taskID, outputType), nil)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadGateway,
+			"Unable to get task output",
+			"Unable to get output of task %s for BOSH director %s: %v", taskID, cnsiGUID, taskResponseError(res, err))
+	}
+
+	return c.Blob(http.StatusOK, "text/plain", res.Response)
+}
+
+func taskOutputPath(taskID, outputType string) string {
+	return fmt.Sprintf("/tasks/%s/output?type=%s", taskID, outputType)
+}
+
+func taskResponseError(res *interfaces.CNSIRequest, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%d %s", res.StatusCode, string(res.Response))
+}
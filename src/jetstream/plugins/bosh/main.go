@@ -0,0 +1,190 @@
+package bosh
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	log "github.com/sirupsen/logrus"
+)
+
+// BoshSpecification is a plugin to support the BOSH Director endpoint type
+type BoshSpecification struct {
+	portalProxy  interfaces.PortalProxy
+	endpointType string
+}
+
+const (
+	EndpointType = "bosh"
+
+	infoPath = "/info"
+
+	// UAA client used by the BOSH CLI, which directors trust by default
+	boshCLIClientID = "bosh_cli"
+
+	// Ways in which a director can authenticate its users
+	uaaAuthenticationType   = "uaa"
+	basicAuthenticationType = "basic"
+)
+
+// DirectorInfo is the response to the director's info request
+type DirectorInfo struct {
+	Name               string                 `json:"name"`
+	UUID               string                 `json:"uuid"`
+	Version            string                 `json:"version"`
+	CPI                string                 `json:"cpi,omitempty"`
+	UserAuthentication DirectorAuthentication `json:"user_authentication"`
+}
+
+// DirectorAuthentication describes how the director authenticates its users
+type DirectorAuthentication struct {
+	Type    string `json:"type"`
+	Options struct {
+		URL string `json:"url,omitempty"`
+	} `json:"options"`
+}
+
+// Init creates a new BoshSpecification
+func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
+	return &BoshSpecification{portalProxy: portalProxy, endpointType: EndpointType}, nil
+}
+
+// GetEndpointPlugin gets the endpoint plugin for this plugin
+func (b *BoshSpecification) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return b, nil
+}
+
+// GetRoutePlugin gets the route plugin for this plugin
+func (b *BoshSpecification) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return b, nil
+}
+
+// GetMiddlewarePlugin gets the middleware plugin for this plugin
+func (b *BoshSpecification) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
+func (b *BoshSpecification) AddAdminGroupRoutes(echoGroup *echo.Group) {
+	// no-op
+}
+
+// AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
+func (b *BoshSpecification) AddSessionGroupRoutes(echoGroup *echo.Group) {
+	echoGroup.GET("/bosh/:cnsiGuid/deployments", b.listDeployments)
+	echoGroup.GET("/bosh/:cnsiGuid/deployments/:deployment/vms", b.listVMs)
+	echoGroup.GET("/bosh/:cnsiGuid/deployments/:deployment/instances", b.listInstances)
+	echoGroup.GET("/bosh/:cnsiGuid/tasks", b.listTasks)
+	echoGroup.GET("/bosh/:cnsiGuid/tasks/:taskId", b.getTask)
+	echoGroup.GET("/bosh/:cnsiGuid/tasks/:taskId/output", b.getTaskOutput)
+	echoGroup.GET("/bosh/:cnsiGuid/tasks/:taskId/stream", b.streamTaskOutput)
+}
+
+// Init performs plugin initialization
+func (b *BoshSpecification) Init() error {
+	return nil
+}
+
+func (b *BoshSpecification) GetType() string {
+	return EndpointType
+}
+
+// Register registers a BOSH director. Unless another client is supplied, users connect through the director's UAA
+// with the BOSH CLI's client rather than the Cloud Foundry client that is used for other endpoints
+func (b *BoshSpecification) Register(echoContext echo.Context) error {
+	log.Debug("BOSH Register...")
+
+	if len(echoContext.FormValue("cnsi_client_id")) == 0 {
+		clientID, clientSecret := b.DefaultClient()
+		req := echoContext.Request().(*standard.Request).Request
+		req.Form.Set("cnsi_client_id", clientID)
+		req.Form.Set("cnsi_client_secret", clientSecret)
+	}
+
+	return b.portalProxy.RegisterEndpoint(echoContext, b.Info)
+}
+
+// DefaultClient gets the client used for directors that are registered without one, including those that are
+// imported or bootstrapped
+func (b *BoshSpecification) DefaultClient() (string, string) {
+	return boshCLIClientID, ""
+}
+
+// Connect connects to the director through its UAA, or with a username and password if the director does not use UAA
+func (b *BoshSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
+	log.Debug("BOSH Connect...")
+
+	connectType := ec.FormValue("connect_type")
+	if connectType != interfaces.AuthConnectTypeCreds {
+		return nil, false, errors.New("Only username/password is accepted for BOSH directors")
+	}
+
+	if len(cnsiRecord.AuthorizationEndpoint) > 0 {
+		tokenRecord, err := b.portalProxy.ConnectOAuth2(ec, cnsiRecord)
+		if err != nil {
+			return nil, false, err
+		}
+		return tokenRecord, false, nil
+	}
+
+	// The director authenticates its users itself
+	username := ec.FormValue("username")
+	password := ec.FormValue("password")
+	if len(username) == 0 || len(password) == 0 {
+		return nil, false, errors.New("Need username and password")
+	}
+
+	authString := fmt.Sprintf("%s:%s", username, password)
+	return &interfaces.TokenRecord{
+		AuthType:     interfaces.AuthTypeHttpBasic,
+		AuthToken:    base64.StdEncoding.EncodeToString([]byte(authString)),
+		RefreshToken: username,
+	}, false, nil
+}
+
+// Info gets the director's info, which reports the UAA that it uses to authenticate users
+func (b *BoshSpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	log.Debug("BOSH Info")
+	var newCNSI interfaces.CNSIRecord
+
+	newCNSI.CNSIType = EndpointType
+
+	if _, err := url.Parse(apiEndpoint); err != nil {
+		return newCNSI, nil, err
+	}
+
+	infoURL := strings.TrimRight(apiEndpoint, "/") + infoPath
+	body, err := b.get(infoURL, skipSSLValidation)
+	if err != nil {
+		return newCNSI, nil, err
+	}
+
+	info := DirectorInfo{}
+	if err = json.Unmarshal(body, &info); err != nil || len(info.UUID) == 0 {
+		return newCNSI, nil, fmt.Errorf("Endpoint %s does not appear to be a BOSH director - unexpected response to %s", apiEndpoint, infoPath)
+	}
+
+	switch info.UserAuthentication.Type {
+	case uaaAuthenticationType:
+		if len(info.UserAuthentication.Options.URL) == 0 {
+			return newCNSI, nil, fmt.Errorf("BOSH director %s does not report the URL of its UAA", apiEndpoint)
+		}
+		newCNSI.AuthorizationEndpoint = strings.TrimRight(info.UserAuthentication.Options.URL, "/")
+		newCNSI.TokenEndpoint = newCNSI.AuthorizationEndpoint
+	case basicAuthenticationType:
+		// Users are authenticated by the director
+	default:
+		return newCNSI, nil, fmt.Errorf("Unsupported BOSH director user authentication: %s", info.UserAuthentication.Type)
+	}
+
+	return newCNSI, info, nil
+}
+
+func (b *BoshSpecification) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
+}
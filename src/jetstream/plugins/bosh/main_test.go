@@ -0,0 +1,112 @@
+package bosh
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// mockPortalProxy provides the HTTP client used for the director's info and answers the requests made to the
+// director on behalf of users. Any other use of the portal proxy is unexpected
+type mockPortalProxy struct {
+	interfaces.PortalProxy
+	directorRequest func(request interfaces.ProxyRequestInfo) *interfaces.CNSIRequest
+}
+
+func (p *mockPortalProxy) GetHttpClientForRequest(req *http.Request, skipSSLValidation bool) http.Client {
+	return http.Client{}
+}
+
+func (p *mockPortalProxy) DoProxyRequest(requests []interfaces.ProxyRequestInfo) (map[string]*interfaces.CNSIRequest, error) {
+	responses := make(map[string]*interfaces.CNSIRequest)
+	for _, request := range requests {
+		responses[request.ResultGUID] = p.directorRequest(request)
+	}
+	return responses, nil
+}
+
+// startTestDirector starts a server that responds to the info request with the given user authentication
+func startTestDirector(userAuthentication string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != infoPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"name":"bosh","uuid":"director-uuid","version":"270.2.0","user_authentication":%s}`, userAuthentication)
+	}))
+}
+
+func TestInfo(t *testing.T) {
+	t.Parallel()
+
+	Convey("BOSH director info", t, func() {
+		b := &BoshSpecification{portalProxy: &mockPortalProxy{}, endpointType: EndpointType}
+
+		Convey("Should connect through the UAA of a director that uses one", func() {
+			director := startTestDirector(`{"type":"uaa","options":{"url":"https://uaa.example.com:8443/"}}`)
+			defer director.Close()
+
+			cnsi, info, err := b.Info(director.URL+"/", true)
+			So(err, ShouldBeNil)
+			So(cnsi.CNSIType, ShouldEqual, EndpointType)
+			So(cnsi.AuthorizationEndpoint, ShouldEqual, "https://uaa.example.com:8443")
+			So(cnsi.TokenEndpoint, ShouldEqual, "https://uaa.example.com:8443")
+			So(info.(DirectorInfo).UUID, ShouldEqual, "director-uuid")
+		})
+
+		Convey("Should fail for a UAA director that does not report its UAA", func() {
+			director := startTestDirector(`{"type":"uaa","options":{}}`)
+			defer director.Close()
+
+			_, _, err := b.Info(director.URL, true)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should not use a UAA for a director that authenticates its users itself", func() {
+			director := startTestDirector(`{"type":"basic","options":{}}`)
+			defer director.Close()
+
+			cnsi, _, err := b.Info(director.URL, true)
+			So(err, ShouldBeNil)
+			So(cnsi.AuthorizationEndpoint, ShouldBeEmpty)
+			So(cnsi.TokenEndpoint, ShouldBeEmpty)
+		})
+
+		Convey("Should fail for an unsupported type of user authentication", func() {
+			director := startTestDirector(`{"type":"ldap","options":{}}`)
+			defer director.Close()
+
+			_, _, err := b.Info(director.URL, true)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should reject an endpoint that is not a BOSH director", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"name":"something else"}`)
+			}))
+			defer server.Close()
+
+			_, _, err := b.Info(server.URL, true)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestDefaultClient(t *testing.T) {
+	t.Parallel()
+
+	Convey("BOSH directors should be connected to with the BOSH CLI client by default", t, func() {
+		var plugin interfaces.EndpointPlugin = &BoshSpecification{}
+		clientPlugin, ok := plugin.(interfaces.EndpointClientPlugin)
+		So(ok, ShouldBeTrue)
+
+		clientID, clientSecret := clientPlugin.DefaultClient()
+		So(clientID, ShouldEqual, boshCLIClientID)
+		So(clientSecret, ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,220 @@
+package bosh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	// How often the director is asked for new task output
+	taskPollInterval = 2 * time.Second
+
+	closeWriteTimeout = 5 * time.Second
+)
+
+// States of a task that has finished
+var finishedTaskStates = map[string]bool{
+	"done":      true,
+	"error":     true,
+	"cancelled": true,
+	"timeout":   true,
+}
+
+type taskStatus struct {
+	ID    int    `json:"id"`
+	State string `json:"state"`
+}
+
+// taskOutputStream follows the output of a task. The director has no streaming API, so new output is fetched with
+// ranged requests, in the same way as the BOSH CLI
+type taskOutputStream struct {
+	b          *BoshSpecification
+	cnsiGUID   string
+	userGUID   string
+	taskID     string
+	outputType string
+	offset     int
+}
+
+// streamTaskOutput relays the output of a task to the client over a WebSocket, one line per message. The
+// WebSocket is closed once the task has finished and all of its output has been sent
+func (b *BoshSpecification) streamTaskOutput(c echo.Context) error {
+	log.Debug("streamTaskOutput")
+	taskID, err := getTaskIDParam(c)
+	if err != nil {
+		return err
+	}
+
+	outputType, err := getOutputTypeParam(c)
+	if err != nil {
+		return err
+	}
+
+	cnsiGUID, userGUID, err := b.getDirector(c)
+	if err != nil {
+		return err
+	}
+
+	stream := &taskOutputStream{
+		b:          b,
+		cnsiGUID:   cnsiGUID,
+		userGUID:   userGUID,
+		taskID:     taskID,
+		outputType: outputType,
+	}
+
+	// Check that the task exists before upgrading the connection
+	if _, err = stream.state(); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadGateway,
+			"Unable to get task",
+			"Unable to get task %s for BOSH director %s: %v", taskID, cnsiGUID, err)
+	}
+
+	clientWebSocket, pingTicker, err := interfaces.UpgradeToWebSocket(c)
+	if err != nil {
+		return err
+	}
+	defer clientWebSocket.Close()
+	defer pingTicker.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		// This blocks until the WebSocket is closed
+		drainClientMessages(clientWebSocket)
+		close(done)
+	}()
+
+	log.Infof("Now streaming output of task %s - on BOSH director: %s", taskID, cnsiGUID)
+	stream.relay(clientWebSocket, done)
+	<-done
+	return nil
+}
+
+// relay sends new output to the client until the task finishes or the client disconnects
+func (s *taskOutputStream) relay(clientWebSocket *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(taskPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Get the state before the output, so that no output is missed when the task finishes
+		state, err := s.state()
+		if err != nil {
+			log.Warnf("Unable to get state of task %s: %v", s.taskID, err)
+			closeWebSocket(clientWebSocket, websocket.CloseInternalServerErr, "Unable to get task state")
+			return
+		}
+
+		finished := finishedTaskStates[state]
+		lines, err := s.next(finished)
+		if err != nil {
+			log.Warnf("Unable to get output of task %s: %v", s.taskID, err)
+			closeWebSocket(clientWebSocket, websocket.CloseInternalServerErr, "Unable to get task output")
+			return
+		}
+
+		for _, line := range lines {
+			if err := clientWebSocket.WriteMessage(websocket.TextMessage, line); err != nil {
+				log.Errorf("Error writing data to WebSocket, %v", err)
+				return
+			}
+		}
+
+		if finished {
+			closeWebSocket(clientWebSocket, websocket.CloseNormalClosure, fmt.Sprintf("Task %s", state))
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// state gets the current state of the task
+func (s *taskOutputStream) state() (string, error) {
+	res, err := s.b.directorRequest(s.cnsiGUID, s.userGUID, fmt.Sprintf("/tasks/%s", s.taskID), nil)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return "", taskResponseError(res, err)
+	}
+
+	status := taskStatus{}
+	if err = json.Unmarshal(res.Response, &status); err != nil {
+		return "", err
+	}
+	return status.State, nil
+}
+
+// next gets the complete lines of output that have been written since the last call. Once the task has finished
+// no more output will be written, so the final call also gets any partial line at the end of the output
+func (s *taskOutputStream) next(final bool) ([][]byte, error) {
+	headers := http.Header{}
+	headers.Set("Range", fmt.Sprintf("bytes=%d-", s.offset))
+
+	res, err := s.b.directorRequest(s.cnsiGUID, s.userGUID, taskOutputPath(s.taskID, s.outputType), headers)
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// There is no new output
+		return nil, nil
+	default:
+		return nil, taskResponseError(res, nil)
+	}
+
+	// A director that does not support ranged requests sends all of the output
+	output := res.Response
+	if res.StatusCode == http.StatusOK {
+		if s.offset >= len(output) {
+			return nil, nil
+		}
+		output = output[s.offset:]
+	}
+
+	if final {
+		if len(output) == 0 {
+			return nil, nil
+		}
+		s.offset += len(output)
+		return bytes.Split(bytes.TrimSuffix(output, []byte("\n")), []byte("\n")), nil
+	}
+
+	// Hold back a partial line until the rest of it has been written
+	end := bytes.LastIndexByte(output, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	s.offset += end + 1
+
+	return bytes.Split(output[:end], []byte("\n")), nil
+}
+
+func closeWebSocket(clientWebSocket *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	clientWebSocket.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteTimeout))
+}
+
+// Drain and discard incoming messages from the WebSocket client, effectively making our WebSocket read-only
+func drainClientMessages(clientWebSocket *websocket.Conn) {
+	for {
+		_, _, err := clientWebSocket.ReadMessage()
+		if err != nil {
+			// We get here when the client (browser) disconnects
+			break
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package bosh
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// testTask is a task on a director whose output is written as the test goes
+type testTask struct {
+	mutex         sync.Mutex
+	state         string
+	output        string
+	ignoreRange   bool
+	rangeRequests []string
+}
+
+func (t *testTask) write(output string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.output += output
+}
+
+func (t *testTask) finish(state string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.state = state
+}
+
+// directorRequest responds to the requests for the task's state and output in the same way as a director
+func (t *testTask) directorRequest(request interfaces.ProxyRequestInfo) *interfaces.CNSIRequest {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if request.URI.Path == "/tasks/42" {
+		return &interfaces.CNSIRequest{StatusCode: http.StatusOK, Response: []byte(fmt.Sprintf(`{"id":42,"state":"%s"}`, t.state))}
+	}
+
+	byteRange := request.Headers.Get("Range")
+	t.rangeRequests = append(t.rangeRequests, byteRange)
+	if t.ignoreRange {
+		return &interfaces.CNSIRequest{StatusCode: http.StatusOK, Response: []byte(t.output)}
+	}
+
+	offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(byteRange, "bytes="), "-"))
+	if offset >= len(t.output) {
+		return &interfaces.CNSIRequest{StatusCode: http.StatusRequestedRangeNotSatisfiable}
+	}
+	return &interfaces.CNSIRequest{StatusCode: http.StatusPartialContent, Response: []byte(t.output[offset:])}
+}
+
+func newTestTaskOutputStream(task *testTask) *taskOutputStream {
+	b := &BoshSpecification{portalProxy: &mockPortalProxy{directorRequest: task.directorRequest}, endpointType: EndpointType}
+	return &taskOutputStream{b: b, cnsiGUID: "director-guid", userGUID: "user-guid", taskID: "42", outputType: "event"}
+}
+
+func lineStrings(lines [][]byte) []string {
+	strs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		strs = append(strs, string(line))
+	}
+	return strs
+}
+
+func TestTaskOutputStream(t *testing.T) {
+	t.Parallel()
+
+	Convey("Following the output of a task", t, func() {
+		task := &testTask{state: "processing"}
+		stream := newTestTaskOutputStream(task)
+
+		Convey("Should get new complete lines with ranged requests", func() {
+			task.write("first\nsecond\nthi")
+			lines, err := stream.next(false)
+			So(err, ShouldBeNil)
+			So(lineStrings(lines), ShouldResemble, []string{"first", "second"})
+
+			task.write("rd\nfourth\n")
+			lines, err = stream.next(false)
+			So(err, ShouldBeNil)
+			So(lineStrings(lines), ShouldResemble, []string{"third", "fourth"})
+			So(task.rangeRequests, ShouldResemble, []string{"bytes=0-", "bytes=13-"})
+		})
+
+		Convey("Should not get anything when there is no new output", func() {
+			task.write("first\n")
+			_, err := stream.next(false)
+			So(err, ShouldBeNil)
+
+			lines, err := stream.next(false)
+			So(err, ShouldBeNil)
+			So(lines, ShouldBeEmpty)
+		})
+
+		Convey("Should hold back a partial line until the task has finished", func() {
+			task.write("first\nlast")
+			lines, err := stream.next(false)
+			So(err, ShouldBeNil)
+			So(lineStrings(lines), ShouldResemble, []string{"first"})
+
+			lines, err = stream.next(true)
+			So(err, ShouldBeNil)
+			So(lineStrings(lines), ShouldResemble, []string{"last"})
+
+			lines, err = stream.next(true)
+			So(err, ShouldBeNil)
+			So(lines, ShouldBeEmpty)
+		})
+
+		Convey("Should only get the new output from a director that ignores the range", func() {
+			task.ignoreRange = true
+			task.write("first\nsec")
+			lines, err := stream.next(false)
+			So(err, ShouldBeNil)
+			So(lineStrings(lines), ShouldResemble, []string{"first"})
+
+			task.write("ond\n")
+			lines, err = stream.next(false)
+			So(err, ShouldBeNil)
+			So(lineStrings(lines), ShouldResemble, []string{"second"})
+		})
+
+		Convey("Should fail when the director can not get the output", func() {
+			stream.b.portalProxy = &mockPortalProxy{directorRequest: func(request interfaces.ProxyRequestInfo) *interfaces.CNSIRequest {
+				return &interfaces.CNSIRequest{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+			}}
+			_, err := stream.next(false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRelayTaskOutput(t *testing.T) {
+	t.Parallel()
+
+	Convey("Relaying the output of a finished task", t, func() {
+		task := &testTask{state: "processing"}
+		task.write("first\nsecond\nlast without a newline")
+		task.finish("done")
+		stream := newTestTaskOutputStream(task)
+
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientWebSocket, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer clientWebSocket.Close()
+
+			done := make(chan struct{})
+			go func() {
+				drainClientMessages(clientWebSocket)
+				close(done)
+			}()
+			stream.relay(clientWebSocket, done)
+			<-done
+		}))
+		defer server.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		var messages []string
+		var closeErr *websocket.CloseError
+		for closeErr == nil {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				var ok bool
+				closeErr, ok = err.(*websocket.CloseError)
+				So(ok, ShouldBeTrue)
+				break
+			}
+			messages = append(messages, string(data))
+		}
+
+		Convey("Should send all of the output, including a partial last line, then close the WebSocket", func() {
+			So(messages, ShouldResemble, []string{"first", "second", "last without a newline"})
+			So(closeErr.Code, ShouldEqual, websocket.CloseNormalClosure)
+			So(closeErr.Text, ShouldEqual, "Task done")
+		})
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/cfapppush/pushapp"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/git"
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo"
@@ -142,11 +143,11 @@ func (cfAppPush *CFAppPush) deploy(echoContext echo.Context) error {
 	// Get the source, depending on the source type
 	switch msg.Type {
 	case SOURCE_GITHUB:
-		stratosProject, appDir, err = getGitHubSource(clientWebSocket, tempDir, msg)
+		stratosProject, appDir, err = cfAppPush.getGitHubSource(echoContext, clientWebSocket, tempDir, msg)
 	case SOURCE_FOLDER:
 		stratosProject, appDir, err = getFolderSource(clientWebSocket, tempDir, msg)
 	case SOURCE_GITURL:
-		stratosProject, appDir, err = getGitURLSource(clientWebSocket, tempDir, msg)
+		stratosProject, appDir, err = cfAppPush.getGitURLSource(echoContext, clientWebSocket, tempDir, msg)
 	default:
 		err = errors.New("Unsupported source type; don't know how to get the source for the application")
 	}
@@ -356,7 +357,7 @@ func getArchiverFor(filePath string) archiver.Archiver {
 	return nil
 }
 
-func getGitHubSource(clientWebSocket *websocket.Conn, tempDir string, msg SocketMessage) (StratosProject, string, error) {
+func (cfAppPush *CFAppPush) getGitHubSource(echoContext echo.Context, clientWebSocket *websocket.Conn, tempDir string, msg SocketMessage) (StratosProject, string, error) {
 	var (
 		err error
 	)
@@ -367,13 +368,24 @@ func getGitHubSource(clientWebSocket *websocket.Conn, tempDir string, msg Socket
 		return StratosProject{}, tempDir, err
 	}
 
-	info.Url = fmt.Sprintf("https://github.com/%s", info.Project)
-	log.Debugf("GitHub Source: %s, branch %s, url: %s", info.Project, info.Branch, info.Url)
 	cloneDetails := CloneDetails{
-		Url:    info.Url,
 		Branch: info.Branch,
 		Commit: info.CommitHash,
 	}
+
+	// The project is hosted by the git endpoint (e.g. GitHub Enterprise) if one is given
+	if len(info.EndpointGUID) > 0 {
+		cloneDetails.Credentials, err = cfAppPush.getCloneCredentials(echoContext, clientWebSocket, info.EndpointGUID)
+		if err != nil {
+			return StratosProject{}, tempDir, err
+		}
+		info.Url = fmt.Sprintf("%s/%s", strings.TrimRight(cloneDetails.Credentials.URL.String(), "/"), info.Project)
+	} else {
+		info.Url = fmt.Sprintf("https://github.com/%s", info.Project)
+	}
+
+	log.Debugf("GitHub Source: %s, branch %s, url: %s", info.Project, info.Branch, info.Url)
+	cloneDetails.Url = info.Url
 	info.CommitHash, err = cloneRepository(cloneDetails, clientWebSocket, tempDir)
 	if err != nil {
 		return StratosProject{}, tempDir, err
@@ -390,7 +402,7 @@ func getGitHubSource(clientWebSocket *websocket.Conn, tempDir string, msg Socket
 	return stratosProject, tempDir, nil
 }
 
-func getGitURLSource(clientWebSocket *websocket.Conn, tempDir string, msg SocketMessage) (StratosProject, string, error) {
+func (cfAppPush *CFAppPush) getGitURLSource(echoContext echo.Context, clientWebSocket *websocket.Conn, tempDir string, msg SocketMessage) (StratosProject, string, error) {
 
 	var (
 		err error
@@ -409,6 +421,20 @@ func getGitURLSource(clientWebSocket *websocket.Conn, tempDir string, msg Socket
 		Branch: info.Branch,
 		Commit: info.CommitHash,
 	}
+
+	if len(info.EndpointGUID) > 0 {
+		cloneDetails.Credentials, err = cfAppPush.getCloneCredentials(echoContext, clientWebSocket, info.EndpointGUID)
+		if err != nil {
+			return StratosProject{}, tempDir, err
+		}
+
+		// Only send the credentials to the host they are for
+		if !cloneDetails.Credentials.Matches(info.Url) {
+			err = fmt.Errorf("Repository %s is not hosted by the git endpoint", info.Url)
+			sendErrorMessage(clientWebSocket, err, CLOSE_FAILED_CLONE)
+			return StratosProject{}, tempDir, err
+		}
+	}
 	info.CommitHash, err = cloneRepository(cloneDetails, clientWebSocket, tempDir)
 	if err != nil {
 		return StratosProject{}, tempDir, err
@@ -425,6 +451,25 @@ func getGitURLSource(clientWebSocket *websocket.Conn, tempDir string, msg Socket
 	return stratosProject, tempDir, nil
 }
 
+// getCloneCredentials gets the credentials the user has connected to a git endpoint with
+func (cfAppPush *CFAppPush) getCloneCredentials(echoContext echo.Context, clientWebSocket *websocket.Conn, endpointGUID string) (*git.CloneCredentials, error) {
+	userID, err := cfAppPush.portalProxy.GetSessionStringValue(echoContext, "user_id")
+	if err != nil {
+		log.Warnf("Failed to retrieve session user")
+		sendErrorMessage(clientWebSocket, err, CLOSE_NO_SESSION)
+		return nil, err
+	}
+
+	credentials, err := git.GetCloneCredentials(cfAppPush.portalProxy, endpointGUID, userID)
+	if err != nil {
+		log.Warnf("Failed to retrieve credentials for git endpoint %s: %v", endpointGUID, err)
+		sendErrorMessage(clientWebSocket, err, CLOSE_NO_CNSI_USERTOKEN)
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
 func getMarshalledSocketMessage(data string, messageType MessageType) ([]byte, error) {
 
 	messageStruct := SocketMessage{
@@ -488,7 +533,12 @@ func cloneRepository(cloneDetails CloneDetails, clientWebSocket *websocket.Conn,
 
 	vcsGit := GetVCS()
 
-	err := vcsGit.Create(tempDir, cloneDetails.Url, cloneDetails.Branch)
+	var err error
+	if cloneDetails.Credentials != nil {
+		err = vcsGit.CreateWithCredentials(tempDir, cloneDetails.Url, cloneDetails.Branch, cloneDetails.Credentials.Username, cloneDetails.Credentials.Token)
+	} else {
+		err = vcsGit.Create(tempDir, cloneDetails.Url, cloneDetails.Branch)
+	}
 	if err != nil {
 		log.Infof("Failed to clone repo %s due to %+v", cloneDetails.Url, err)
 		sendErrorMessage(clientWebSocket, err, CLOSE_FAILED_CLONE)
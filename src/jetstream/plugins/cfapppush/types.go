@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/plugins/git"
 )
 
 type ManifestResponse struct {
@@ -44,6 +46,8 @@ type GitHubSourceInfo struct {
 	Branch     string `json:"branch"`
 	Url        string `json:"url"`
 	CommitHash string `json:"commit"`
+	// Git endpoint whose credentials are used to clone a private repository
+	EndpointGUID string `json:"endpointGuid,omitempty"`
 }
 
 // Structure used to provide metadata about the Git Url source
@@ -53,6 +57,8 @@ type GitUrlSourceInfo struct {
 	Branch     string `json:"branch"`
 	Url        string `json:"url"`
 	CommitHash string `json:"commit"`
+	// Git endpoint whose credentials are used to clone a private repository
+	EndpointGUID string `json:"endpointGuid,omitempty"`
 }
 
 type FolderSourceInfo struct {
@@ -112,7 +118,8 @@ type Applications struct {
 }
 
 type CloneDetails struct {
-	Url    string
-	Branch string
-	Commit string
+	Url         string
+	Branch      string
+	Commit      string
+	Credentials *git.CloneCredentials
 }
@@ -4,6 +4,7 @@ package cfapppush
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
 	"strings"
@@ -44,6 +45,36 @@ func (vcs *vcsCmd) Create(dir string, repo string, branch string) error {
 	return nil
 }
 
+// Environment variables that the credential helper used by CreateWithCredentials reads the credentials from
+const (
+	vcsUsernameEnv = "STRATOS_VCS_USERNAME"
+	vcsPasswordEnv = "STRATOS_VCS_PASSWORD"
+)
+
+// vcsCredentialArgs configure git to only use a credential helper that gives it the credentials from its environment.
+// Clearing the configured helpers first stops any of them from saving the credentials
+var vcsCredentialArgs = []string{
+	"-c", "credential.helper=",
+	"-c", `credential.helper=!f() { test "$1" = get && printf 'username=%s\npassword=%s\n' "$` + vcsUsernameEnv + `" "$` + vcsPasswordEnv + `"; }; f`,
+}
+
+// CreateWithCredentials downloads a fresh copy of a repository that requires authentication. The credentials are
+// passed to git through its environment, so that they are not visible in the command line or saved in the copy
+func (vcs *vcsCmd) CreateWithCredentials(dir string, repo string, branch string, username string, password string) error {
+	env := []string{
+		"GIT_TERMINAL_PROMPT=0",
+		vcsUsernameEnv + "=" + username,
+		vcsPasswordEnv + "=" + password,
+	}
+	for _, cmd := range vcs.createCmd {
+		args := append(append([]string{}, vcsCredentialArgs...), expandArgs(cmd, []string{"dir", dir, "repo", repo, "branch", branch})...)
+		if _, err := vcs.runArgs(".", args, env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (vcs *vcsCmd) ResetBranchToCommit(dir string, commit string) error {
 	for _, cmd := range vcs.resetToCommitCmd {
 		if err := vcs.run(dir, cmd, "commit", commit); err != nil {
@@ -81,7 +112,15 @@ func (v *vcsCmd) run(dir string, cmd string, keyval ...string) error {
 }
 
 func (v *vcsCmd) run1(dir string, cmdline string, keyval []string, verbose bool) ([]byte, error) {
+	return v.run1WithEnv(dir, cmdline, keyval, nil)
+}
 
+func (v *vcsCmd) run1WithEnv(dir string, cmdline string, keyval []string, env []string) ([]byte, error) {
+	return v.runArgs(dir, expandArgs(cmdline, keyval), env)
+}
+
+// expandArgs splits a command line into its arguments, replacing the {key} placeholders with their values
+func expandArgs(cmdline string, keyval []string) []string {
 	m := make(map[string]string)
 	for i := 0; i < len(keyval); i += 2 {
 		m[keyval[i]] = keyval[i+1]
@@ -90,16 +129,21 @@ func (v *vcsCmd) run1(dir string, cmdline string, keyval []string, verbose bool)
 	for i, arg := range args {
 		args[i] = expand(m, arg)
 	}
+	return args
+}
 
+// runArgs runs the command with the given arguments. If it fails the error includes its output, which has git's
+// reason for failing
+func (v *vcsCmd) runArgs(dir string, args []string, env []string) ([]byte, error) {
 	_, err := exec.LookPath(v.cmd)
 	if err != nil {
-		log.Warnf("Missing %s command. Make sure %s is in your path", v.name)
+		log.Warnf("Missing %s command. Make sure %s is in your path", v.name, v.cmd)
 		return nil, err
 	}
 
 	cmd := exec.Command(v.cmd, args...)
 	cmd.Dir = dir
-	cmd.Env = EnvForDir(cmd.Dir, os.Environ())
+	cmd.Env = MergeEnvLists(env, EnvForDir(cmd.Dir, os.Environ()))
 
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
@@ -107,6 +151,9 @@ func (v *vcsCmd) run1(dir string, cmdline string, keyval []string, verbose bool)
 	err = cmd.Run()
 	out := buf.Bytes()
 	if err != nil {
+		if output := strings.TrimSpace(string(out)); len(output) > 0 {
+			return out, fmt.Errorf("%v: %s", err, output)
+		}
 		return out, err
 	}
 	return out, nil
@@ -0,0 +1,71 @@
+package cfapppush
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCreateWithCredentials(t *testing.T) {
+	t.Parallel()
+
+	Convey("Cloning a repository with credentials", t, func() {
+		dir, err := ioutil.TempDir("", "vcs-test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		// Stand-in for git that records its arguments and environment
+		script := filepath.Join(dir, "git")
+		err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > \""+dir+"/args\"\nenv > \""+dir+"/env\"\n"), 0700)
+		So(err, ShouldBeNil)
+
+		vcs := &vcsCmd{name: "Git", cmd: script, createCmd: vcsGit.createCmd}
+		err = vcs.CreateWithCredentials("clone", "https://github.com/owner/repo.git", "main", "x-access-token", "s3cr3t-token")
+		So(err, ShouldBeNil)
+
+		args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+		So(err, ShouldBeNil)
+		env, err := ioutil.ReadFile(filepath.Join(dir, "env"))
+		So(err, ShouldBeNil)
+
+		Convey("Should not pass the credentials as arguments", func() {
+			So(string(args), ShouldNotContainSubstring, "s3cr3t-token")
+			So(string(args), ShouldNotContainSubstring, "x-access-token")
+			So(string(args), ShouldContainSubstring, "clone -b main https://github.com/owner/repo.git clone")
+		})
+
+		Convey("Should pass the credentials to the credential helper through the environment", func() {
+			So(string(args), ShouldContainSubstring, "credential.helper=!f()")
+			So(strings.Split(string(env), "\n"), ShouldContain, vcsUsernameEnv+"=x-access-token")
+			So(strings.Split(string(env), "\n"), ShouldContain, vcsPasswordEnv+"=s3cr3t-token")
+			So(strings.Split(string(env), "\n"), ShouldContain, "GIT_TERMINAL_PROMPT=0")
+		})
+	})
+}
+
+func TestVCSCredentialHelper(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	Convey("The credential helper", t, func() {
+		args := append(append([]string{}, vcsCredentialArgs...), "credential", "fill")
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", vcsUsernameEnv+"=x-access-token", vcsPasswordEnv+"=s3cr3t-token")
+		cmd.Stdin = strings.NewReader("protocol=https\nhost=github.com\n\n")
+
+		Convey("Should give git the credentials from the environment", func() {
+			out, err := cmd.Output()
+			So(err, ShouldBeNil)
+			So(string(out), ShouldContainSubstring, "username=x-access-token\n")
+			So(string(out), ShouldContainSubstring, "password=s3cr3t-token\n")
+		})
+	})
+}
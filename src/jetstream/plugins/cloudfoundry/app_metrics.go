@@ -0,0 +1,277 @@
+package cloudfoundry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	noaa_errors "github.com/cloudfoundry/noaa/errors"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// Where metrics were read from
+const (
+	logCacheMetricsSource = "log-cache"
+	dopplerMetricsSource  = "doppler"
+)
+
+const (
+	// Default and longest periods that metrics can be read for
+	defaultMetricsPeriod = 5 * time.Minute
+	maxMetricsPeriod     = 1 * time.Hour
+
+	// Period that the latest container metrics are looked for in
+	containerMetricsPeriod = 2 * time.Minute
+)
+
+// AppMetric is a value of one of an app's metrics
+type AppMetric struct {
+	Name      string  `json:"name"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Instance  string  `json:"instance"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// AppMetrics are the metrics of an app, and where they were read from
+type AppMetrics struct {
+	Source  string      `json:"source"`
+	Metrics []AppMetric `json:"metrics"`
+}
+
+// ContainerMetric is the usage of one of an app's containers
+type ContainerMetric struct {
+	InstanceIndex    int     `json:"instance_index"`
+	CPUPercentage    float64 `json:"cpu_percentage"`
+	MemoryBytes      uint64  `json:"memory_bytes"`
+	DiskBytes        uint64  `json:"disk_bytes"`
+	MemoryBytesQuota uint64  `json:"memory_bytes_quota"`
+	DiskBytesQuota   uint64  `json:"disk_bytes_quota"`
+	Timestamp        int64   `json:"timestamp,omitempty"`
+}
+
+// ContainerMetrics are the latest usages of an app's containers, and where they were read from
+type ContainerMetrics struct {
+	Source     string            `json:"source"`
+	Containers []ContainerMetric `json:"containers"`
+}
+
+// appMetrics gets the gauge and counter metrics of an app for the number of seconds given in the since query
+// parameter. Only container metrics are available from Cloud Foundries without a log-cache
+func (c CloudFoundrySpecification) appMetrics(echoContext echo.Context) error {
+	log.Debug("appMetrics")
+	cnsiGUID := echoContext.Param("cnsiGuid")
+	appGUID := echoContext.Param("appGuid")
+
+	period := defaultMetricsPeriod
+	if since := echoContext.QueryParam("since"); len(since) > 0 {
+		seconds, err := strconv.Atoi(since)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxMetricsPeriod {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Since must be a number of seconds, up to %d", int(maxMetricsPeriod.Seconds())))
+		}
+		period = time.Duration(seconds) * time.Second
+	}
+
+	ac, err := c.openNoaaConsumer(echoContext)
+	if err != nil {
+		return err
+	}
+	defer ac.Close()
+
+	if ac.logCache != nil {
+		metrics, err := getLogCacheAppMetrics(ac, appGUID, period)
+		if err == nil {
+			return echoContext.JSON(http.StatusOK, AppMetrics{Source: logCacheMetricsSource, Metrics: metrics})
+		}
+		log.Warnf("Failed to get metrics for App %s on CNSI %s from log-cache, using Doppler [%v]", appGUID, cnsiGUID, err)
+	}
+
+	containerMetrics, err := getDopplerContainerMetrics(ac, cnsiGUID, appGUID)
+	if err != nil {
+		return err
+	}
+
+	metrics := make([]AppMetric, 0, len(containerMetrics)*5)
+	for _, m := range containerMetrics {
+		instance := strconv.Itoa(m.InstanceIndex)
+		metrics = append(metrics,
+			AppMetric{Name: "cpu", Unit: "percentage", Value: m.CPUPercentage, Instance: instance},
+			AppMetric{Name: "memory", Unit: "bytes", Value: float64(m.MemoryBytes), Instance: instance},
+			AppMetric{Name: "disk", Unit: "bytes", Value: float64(m.DiskBytes), Instance: instance},
+			AppMetric{Name: "memory_quota", Unit: "bytes", Value: float64(m.MemoryBytesQuota), Instance: instance},
+			AppMetric{Name: "disk_quota", Unit: "bytes", Value: float64(m.DiskBytesQuota), Instance: instance},
+		)
+	}
+	return echoContext.JSON(http.StatusOK, AppMetrics{Source: dopplerMetricsSource, Metrics: metrics})
+}
+
+// appContainerMetrics gets the latest usage of each of an app's containers
+func (c CloudFoundrySpecification) appContainerMetrics(echoContext echo.Context) error {
+	log.Debug("appContainerMetrics")
+	cnsiGUID := echoContext.Param("cnsiGuid")
+	appGUID := echoContext.Param("appGuid")
+
+	ac, err := c.openNoaaConsumer(echoContext)
+	if err != nil {
+		return err
+	}
+	defer ac.Close()
+
+	containerMetrics, source, err := getContainerMetrics(ac, cnsiGUID, appGUID)
+	if err != nil {
+		return err
+	}
+	return echoContext.JSON(http.StatusOK, ContainerMetrics{Source: source, Containers: containerMetrics})
+}
+
+// getContainerMetrics gets the latest usage of each of an app's containers from log-cache, falling back to Doppler
+func getContainerMetrics(ac *AuthorizedConsumer, cnsiGUID, appGUID string) ([]ContainerMetric, string, error) {
+	if ac.logCache != nil {
+		containerMetrics, err := getLogCacheContainerMetrics(ac, appGUID)
+		if err == nil {
+			return containerMetrics, logCacheMetricsSource, nil
+		}
+		log.Warnf("Failed to get container metrics for App %s on CNSI %s from log-cache, using Doppler [%v]", appGUID, cnsiGUID, err)
+	}
+
+	containerMetrics, err := getDopplerContainerMetrics(ac, cnsiGUID, appGUID)
+	return containerMetrics, dopplerMetricsSource, err
+}
+
+// getLogCacheAppMetrics reads the app's gauge and counter metrics from log-cache
+func getLogCacheAppMetrics(ac *AuthorizedConsumer, appGUID string, period time.Duration) ([]AppMetric, error) {
+	query := url.Values{}
+	query.Add("envelope_types", logCacheGaugeType)
+	query.Add("envelope_types", logCacheCounterType)
+	query.Set("start_time", strconv.FormatInt(time.Now().Add(-period).UnixNano(), 10))
+	query.Set("descending", "true")
+	query.Set("limit", strconv.Itoa(logCacheReadLimit))
+
+	envelopes, err := ac.readLogCache(appGUID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]AppMetric, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		timestamp := envelope.timestamp()
+		switch {
+		case envelope.Gauge != nil:
+			for name, metric := range envelope.Gauge.Metrics {
+				metrics = append(metrics, AppMetric{
+					Name:      name,
+					Unit:      metric.Unit,
+					Value:     metric.Value,
+					Instance:  envelope.InstanceID,
+					Timestamp: timestamp,
+				})
+			}
+		case envelope.Counter != nil:
+			total, _ := strconv.ParseUint(envelope.Counter.Total, 10, 64)
+			metrics = append(metrics, AppMetric{
+				Name:      envelope.Counter.Name,
+				Value:     float64(total),
+				Instance:  envelope.InstanceID,
+				Timestamp: timestamp,
+			})
+		}
+	}
+
+	// Oldest first, as log-cache was asked for the newest metrics
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Timestamp < metrics[j].Timestamp })
+	return metrics, nil
+}
+
+// getLogCacheContainerMetrics reads the latest container metrics of each of the app's instances from log-cache
+func getLogCacheContainerMetrics(ac *AuthorizedConsumer, appGUID string) ([]ContainerMetric, error) {
+	query := url.Values{}
+	query.Set("envelope_types", logCacheGaugeType)
+	query.Set("start_time", strconv.FormatInt(time.Now().Add(-containerMetricsPeriod).UnixNano(), 10))
+	query.Set("descending", "true")
+	query.Set("limit", strconv.Itoa(logCacheReadLimit))
+
+	envelopes, err := ac.readLogCache(appGUID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[int]ContainerMetric)
+	for _, envelope := range envelopes {
+		if envelope.Gauge == nil {
+			continue
+		}
+		// Container metrics are the gauges that report CPU usage, rather than those sent by the app itself
+		cpu, ok := envelope.Gauge.Metrics["cpu"]
+		if !ok {
+			continue
+		}
+		index, err := strconv.Atoi(envelope.InstanceID)
+		if err != nil {
+			continue
+		}
+		// The envelopes are newest first
+		if _, ok := latest[index]; ok {
+			continue
+		}
+
+		gauge := envelope.Gauge.Metrics
+		latest[index] = ContainerMetric{
+			InstanceIndex:    index,
+			CPUPercentage:    cpu.Value,
+			MemoryBytes:      uint64(gauge["memory"].Value),
+			DiskBytes:        uint64(gauge["disk"].Value),
+			MemoryBytesQuota: uint64(gauge["memory_quota"].Value),
+			DiskBytesQuota:   uint64(gauge["disk_quota"].Value),
+			Timestamp:        envelope.timestamp(),
+		}
+	}
+
+	return sortContainerMetrics(latest), nil
+}
+
+// getDopplerContainerMetrics gets the latest container metrics of the app from Doppler. If we get an unauthorized
+// error we will refresh the auth token and retry once
+func getDopplerContainerMetrics(ac *AuthorizedConsumer, cnsiGUID, appGUID string) ([]ContainerMetric, error) {
+	metrics, err := ac.consumer.ContainerMetrics(appGUID, ac.authToken)
+	if _, ok := err.(*noaa_errors.UnauthorizedError); ok {
+		if err := ac.refreshToken(); err != nil {
+			return nil, err
+		}
+		metrics, err = ac.consumer.ContainerMetrics(appGUID, ac.authToken)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("Failed to get container metrics for App %s on CNSI %s [%v]", appGUID, cnsiGUID, err)
+		return nil, echo.NewHTTPError(http.StatusBadGateway, msg)
+	}
+
+	containers := make(map[int]ContainerMetric)
+	for _, metric := range metrics {
+		containers[int(metric.GetInstanceIndex())] = fromDopplerContainerMetric(metric)
+	}
+	return sortContainerMetrics(containers), nil
+}
+
+func fromDopplerContainerMetric(metric *events.ContainerMetric) ContainerMetric {
+	return ContainerMetric{
+		InstanceIndex:    int(metric.GetInstanceIndex()),
+		CPUPercentage:    metric.GetCpuPercentage(),
+		MemoryBytes:      metric.GetMemoryBytes(),
+		DiskBytes:        metric.GetDiskBytes(),
+		MemoryBytesQuota: metric.GetMemoryBytesQuota(),
+		DiskBytesQuota:   metric.GetDiskBytesQuota(),
+	}
+}
+
+func sortContainerMetrics(containers map[int]ContainerMetric) []ContainerMetric {
+	sorted := make([]ContainerMetric, 0, len(containers))
+	for _, metric := range containers {
+		sorted = append(sorted, metric)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].InstanceIndex < sorted[j].InstanceIndex })
+	return sorted
+}
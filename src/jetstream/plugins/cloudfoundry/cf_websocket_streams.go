@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
@@ -43,12 +44,20 @@ func (c CloudFoundrySpecification) appFirehose(echoContext echo.Context) error {
 	return c.commonStreamHandler(echoContext, appFirehoseStreamHandler)
 }
 
-func (c CloudFoundrySpecification) commonStreamHandler(echoContext echo.Context, bespokeStreamHandler func(echo.Context, *AuthorizedConsumer, *websocket.Conn) error) error {
+// clientMessageHandler handles a message sent by the WebSocket client
+type clientMessageHandler func(data []byte)
+
+func (c CloudFoundrySpecification) commonStreamHandler(echoContext echo.Context, bespokeStreamHandler func(echo.Context, *AuthorizedConsumer, *clientStream) (clientMessageHandler, error)) error {
+	overflowPolicy, err := getOverflowPolicy(echoContext)
+	if err != nil {
+		return err
+	}
+
 	ac, err := c.openNoaaConsumer(echoContext)
 	if err != nil {
 		return err
 	}
-	defer ac.consumer.Close()
+	defer ac.Close()
 
 	clientWebSocket, pingTicker, err := interfaces.UpgradeToWebSocket(echoContext)
 	if err != nil {
@@ -57,30 +66,62 @@ func (c CloudFoundrySpecification) commonStreamHandler(echoContext echo.Context,
 	defer clientWebSocket.Close()
 	defer pingTicker.Stop()
 
-	if err := bespokeStreamHandler(echoContext, ac, clientWebSocket); err != nil {
+	stream := newWebSocketStream(clientWebSocket, overflowPolicy)
+	defer stream.stop()
+
+	onClientMessage, err := bespokeStreamHandler(echoContext, ac, stream)
+	if err != nil {
 		return err
 	}
 
 	// This blocks until the WebSocket is closed
-	drainClientMessages(clientWebSocket)
+	readClientMessages(clientWebSocket, onClientMessage)
 	return nil
 }
 
 type AuthorizedConsumer struct {
-	consumer     *consumer.Consumer
-	authToken    string
-	refreshToken func() error
+	consumer       *consumer.Consumer
+	dopplerAddress string
+	authToken      string
+	refreshToken   func() error
+	logCache       *logCacheClient
+	done           chan struct{}
+	closeOnce      sync.Once
 }
 
-// Refresh the Authorization token if needed and create a new Noaa consumer
-func (c CloudFoundrySpecification) openNoaaConsumer(echoContext echo.Context) (*AuthorizedConsumer, error) {
+// Close closes the consumer's streams and stops them from reconnecting
+func (ac *AuthorizedConsumer) Close() error {
+	var err error
+	ac.closeOnce.Do(func() {
+		close(ac.done)
+		err = ac.consumer.Close()
+	})
+	return err
+}
 
-	ac := &AuthorizedConsumer{}
+func (ac *AuthorizedConsumer) isClosed() bool {
+	select {
+	case <-ac.done:
+		return true
+	default:
+		return false
+	}
+}
 
+// Refresh the Authorization token if needed and create a new Noaa consumer
+func (c CloudFoundrySpecification) openNoaaConsumer(echoContext echo.Context) (*AuthorizedConsumer, error) {
 	// Get the CNSI and app IDs from route parameters
 	cnsiGUID := echoContext.Param("cnsiGuid")
 	userGUID := echoContext.Get("user_id").(string)
 
+	return c.newAuthorizedConsumer(cnsiGUID, userGUID)
+}
+
+// Refresh the user's Authorization token for the CNSI if needed and create a new Noaa consumer
+func (c CloudFoundrySpecification) newAuthorizedConsumer(cnsiGUID, userGUID string) (*AuthorizedConsumer, error) {
+
+	ac := &AuthorizedConsumer{done: make(chan struct{})}
+
 	// Extract the Doppler endpoint from the CNSI record
 	cnsiRecord, err := c.portalProxy.GetCNSIRecord(cnsiGUID)
 	if err != nil {
@@ -115,15 +156,33 @@ func (c CloudFoundrySpecification) openNoaaConsumer(echoContext echo.Context) (*
 	}
 
 	// Open a Noaa consumer to the doppler endpoint
-	log.Debugf("Creating Noaa consumer for Doppler endpoint %s", dopplerAddress)
-	ac.consumer = consumer.New(dopplerAddress, &tls.Config{InsecureSkipVerify: true}, http.ProxyFromEnvironment)
+	ac.dopplerAddress = dopplerAddress
+	ac.consumer = newNoaaConsumer(dopplerAddress)
+
+	// Newer Cloud Foundries keep recent logs and metrics in log-cache, which is used in preference to Doppler
+	ac.logCache = c.getLogCache(cnsiRecord)
 
 	return ac, nil
 }
 
-// Attempts to get the recent logs, if we get an unauthorized error we will refresh the auth token and retry once
+// newNoaaConsumer creates a Noaa consumer for a Doppler endpoint. It does not connect until a stream is opened
+func newNoaaConsumer(dopplerAddress string) *consumer.Consumer {
+	log.Debugf("Creating Noaa consumer for Doppler endpoint %s", dopplerAddress)
+	return consumer.New(dopplerAddress, &tls.Config{InsecureSkipVerify: true}, http.ProxyFromEnvironment)
+}
+
+// Attempts to get the recent logs, if we get an unauthorized error we will refresh the auth token and retry once.
+// The logs are read from log-cache if the CNSI has one, falling back to Doppler if it fails
 func getRecentLogs(ac *AuthorizedConsumer, cnsiGUID, appGUID string) ([]*events.LogMessage, error) {
 	log.Debug("getRecentLogs")
+	if ac.logCache != nil {
+		messages, err := getLogCacheRecentLogs(ac, appGUID)
+		if err == nil {
+			return messages, nil
+		}
+		log.Warnf("Failed to get recent messages for App %s on CNSI %s from log-cache, using Doppler [%v]", appGUID, cnsiGUID, err)
+	}
+
 	messages, err := ac.consumer.RecentLogs(appGUID, ac.authToken)
 	if err != nil {
 		errorPattern := "Failed to get recent messages for App %s on CNSI %s [%v]"
@@ -167,57 +226,94 @@ func drainFirehoseEvents(eventChan <-chan *events.Envelope, callback func(msg *e
 	}
 }
 
-// Drain and discard incoming messages from the WebSocket client, effectively making our WebSocket read-only
-func drainClientMessages(clientWebSocket *websocket.Conn) {
+// Read incoming messages from the WebSocket client and pass them to the handler. Messages are discarded if there is
+// no handler, effectively making our WebSocket read-only
+func readClientMessages(clientWebSocket *websocket.Conn, onClientMessage clientMessageHandler) {
 	for {
-		_, _, err := clientWebSocket.ReadMessage()
+		_, data, err := clientWebSocket.ReadMessage()
 		if err != nil {
 			// We get here when the client (browser) disconnects
 			break
 		}
+		if onClientMessage != nil {
+			onClientMessage(data)
+		}
 	}
 }
 
-func appStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, clientWebSocket *websocket.Conn) error {
+// appStreamHandler relays the app's recent and new log messages to the client. The client can send a
+// LogStreamFilter at any time to choose which messages it receives; an initial filter can also be given in the
+// filter query parameter, so that it applies to the recent messages too
+func appStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, stream *clientStream) (clientMessageHandler, error) {
 	// Get the CNSI and app IDs from route parameters
 	cnsiGUID := echoContext.Param("cnsiGuid")
 	appGUID := echoContext.Param("appGuid")
 
 	log.Infof("Received request for log stream for App ID: %s - in CNSI: %s", appGUID, cnsiGUID)
 
+	filter := &logStreamFilter{}
+	if initialFilter := echoContext.QueryParam("filter"); len(initialFilter) > 0 {
+		f, err := parseLogMessageFilter([]byte(initialFilter))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		filter.Set(f)
+	}
+
 	messages, err := getRecentLogs(ac, cnsiGUID, appGUID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Reusable closure to pump messages from Noaa to the client WebSocket
 	// N.B. We convert protobuf messages to JSON for ease of use in the frontend
 	relayLogMsg := func(msg *events.LogMessage) {
+		if !filter.Matches(msg) {
+			return
+		}
 		if jsonMsg, err := json.Marshal(msg); err != nil {
 			log.Errorf("Received unparsable message from Doppler %v, %v", jsonMsg, err)
 		} else {
-			err := clientWebSocket.WriteMessage(websocket.TextMessage, jsonMsg)
+			err := stream.writeWithID(strconv.FormatInt(msg.GetTimestamp(), 10), jsonMsg)
 			if err != nil {
 				log.Errorf("Error writing data to WebSocket, %v", err)
 			}
 		}
 	}
 
-	// Send the recent messages, sorted in Chronological order
+	// Send the recent messages, sorted in Chronological order. A client resuming a stream of server-sent events
+	// already has those up to the last event it received
+	lastEventID := getLastEventID(echoContext)
 	for _, msg := range noaa.SortRecent(messages) {
-		relayLogMsg(msg)
+		if msg.GetTimestamp() > lastEventID {
+			relayLogMsg(msg)
+		}
 	}
 
-	msgChan, errorChan := ac.consumer.TailingLogs(appGUID, ac.authToken)
-
-	// Process the app stream
-	go drainErrors(errorChan)
-	go drainLogMessages(msgChan, relayLogMsg)
+	// Process the app stream, reconnecting if the connection to Doppler is lost
+	go ac.keepStreaming(stream, "log for App ID: "+appGUID, func(authToken string) <-chan error {
+		msgChan, errorChan := ac.consumer.TailingLogsWithoutReconnect(appGUID, authToken)
+		go drainLogMessages(msgChan, relayLogMsg)
+		return errorChan
+	})
 
 	log.Infof("Now streaming log for App ID: %s - on CNSI: %s", appGUID, cnsiGUID)
-	return nil
+
+	// Apply the filters sent by the client. An invalid filter leaves the current filter in place
+	return func(data []byte) {
+		f, err := parseLogMessageFilter(data)
+		if err != nil {
+			log.Warnf("Ignoring log stream filter for App ID: %s - %v", appGUID, err)
+			return
+		}
+		log.Debugf("Applying log stream filter for App ID: %s", appGUID)
+		filter.Set(f)
+	}, nil
 }
 
-func firehoseStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, clientWebSocket *websocket.Conn) error {
+// firehoseStreamHandler relays the Firehose to the client. The client can send a FirehoseFilter at any time to
+// choose which envelopes it receives, and to have them sampled or aggregated; an initial filter can also be given in
+// the filter query parameter
+func firehoseStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, stream *clientStream) (clientMessageHandler, error) {
 	log.Debug("firehose")
 
 	// Get the CNSI and app IDs from route parameters
@@ -225,30 +321,80 @@ func firehoseStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, cli
 
 	log.Infof("Received request for Firehose stream for CNSI: %s", cnsiGUID)
 
+	processor := &firehoseProcessor{}
+	if initialFilter := echoContext.QueryParam("filter"); len(initialFilter) > 0 {
+		f, err := parseEnvelopeFilter([]byte(initialFilter))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		processor.SetFilter(f)
+	}
+
 	userGUID := echoContext.Get("user_id").(string)
 	firehoseSubscriptionId := userGUID + "@" + strconv.FormatInt(time.Now().UnixNano(), 10)
 	log.Debugf("Connecting the Firehose with subscription ID: %s", firehoseSubscriptionId)
 
-	eventChan, errorChan := ac.consumer.Firehose(firehoseSubscriptionId, ac.authToken)
-
-	// Process the app stream
-	go drainErrors(errorChan)
-	go drainFirehoseEvents(eventChan, func(msg *events.Envelope) {
+	relayEvent := func(msg *events.Envelope) {
+		if !processor.Process(msg) {
+			return
+		}
 		if jsonMsg, err := json.Marshal(msg); err != nil {
 			log.Errorf("Received unparsable message from Doppler %v, %v", jsonMsg, err)
 		} else {
-			err := clientWebSocket.WriteMessage(websocket.TextMessage, jsonMsg)
+			err := stream.writeWithID(strconv.FormatInt(msg.GetTimestamp(), 10), jsonMsg)
 			if err != nil {
 				log.Errorf("Error writing data to WebSocket, %v", err)
 			}
 		}
+	}
+
+	// Process the Firehose stream, reconnecting if the connection to Doppler is lost
+	go ac.keepStreaming(stream, "Firehose for CNSI: "+cnsiGUID, func(authToken string) <-chan error {
+		eventChan, errorChan := ac.consumer.FirehoseWithoutReconnect(firehoseSubscriptionId, authToken)
+		go drainFirehoseEvents(eventChan, relayEvent)
+		return errorChan
 	})
+	go relayFirehoseSummaries(ac, stream, processor)
 
 	log.Infof("Firehose connected and streaming for CNSI: %s - subscription ID: %s", cnsiGUID, firehoseSubscriptionId)
-	return nil
+
+	// Apply the filters sent by the client. An invalid filter leaves the current filter in place
+	return func(data []byte) {
+		f, err := parseEnvelopeFilter(data)
+		if err != nil {
+			log.Warnf("Ignoring Firehose filter for CNSI: %s - %v", cnsiGUID, err)
+			return
+		}
+		log.Debugf("Applying Firehose filter for CNSI: %s", cnsiGUID)
+		processor.SetFilter(f)
+	}, nil
+}
+
+// relayFirehoseSummaries sends the client a summary of the dropped and aggregated envelopes each second, until the
+// consumer is closed
+func relayFirehoseSummaries(ac *AuthorizedConsumer, stream *clientStream, processor *firehoseProcessor) {
+	ticker := time.NewTicker(firehoseSummaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ac.done:
+			return
+		case now := <-ticker.C:
+			summary := processor.Summarise(now)
+			if summary == nil {
+				continue
+			}
+			if jsonMsg, err := json.Marshal(firehoseSummaryMessage{Summary: summary}); err != nil {
+				log.Errorf("Unable to marshal Firehose summary, %v", err)
+			} else if err = stream.write(jsonMsg); err != nil {
+				log.Errorf("Error writing data to WebSocket, %v", err)
+			}
+		}
+	}
 }
 
-func appFirehoseStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, clientWebSocket *websocket.Conn) error {
+func appFirehoseStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, stream *clientStream) (clientMessageHandler, error) {
 	log.Debug("appFirehoseStreamHandler")
 
 	// Get the CNSI and app IDs from route parameters
@@ -257,21 +403,24 @@ func appFirehoseStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer,
 
 	log.Infof("Received request for log stream for App ID: %s - in CNSI: %s", appGUID, cnsiGUID)
 
-	msgChan, errorChan := ac.consumer.Stream(appGUID, ac.authToken)
-
-	// Process the app stream
-	go drainErrors(errorChan)
-	go drainFirehoseEvents(msgChan, func(msg *events.Envelope) {
+	relayEvent := func(msg *events.Envelope) {
 		if jsonMsg, err := json.Marshal(msg); err != nil {
 			log.Errorf("Received unparsable message from Doppler %v, %v", jsonMsg, err)
 		} else {
-			err := clientWebSocket.WriteMessage(websocket.TextMessage, jsonMsg)
+			err := stream.writeWithID(strconv.FormatInt(msg.GetTimestamp(), 10), jsonMsg)
 			if err != nil {
 				log.Errorf("Error writing data to WebSocket, %v", err)
 			}
 		}
+	}
+
+	// Process the app stream, reconnecting if the connection to Doppler is lost
+	go ac.keepStreaming(stream, "App ID: "+appGUID, func(authToken string) <-chan error {
+		msgChan, errorChan := ac.consumer.StreamWithoutReconnect(appGUID, authToken)
+		go drainFirehoseEvents(msgChan, relayEvent)
+		return errorChan
 	})
 
 	log.Infof("Now streaming for App ID: %s - on CNSI: %s", appGUID, cnsiGUID)
-	return nil
+	return nil, nil
 }
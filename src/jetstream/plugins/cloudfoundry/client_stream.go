@@ -0,0 +1,287 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// What happens to a stream when its client cannot keep up with its messages
+const (
+	// The oldest messages that are waiting to be sent are dropped to make room for new ones
+	dropOldestOverflowPolicy = "drop-oldest"
+	// The client is disconnected
+	disconnectOverflowPolicy = "disconnect"
+)
+
+const (
+	// Number of messages that can be waiting to be written to the client
+	clientStreamQueueSize = 1024
+
+	// Time allowed to write a message to the client
+	clientStreamWriteTimeout = 10 * time.Second
+
+	// How often the client is told how many messages have been dropped, if any have
+	clientStreamDroppedReportInterval = 1 * time.Second
+
+	closeWriteTimeout = 5 * time.Second
+)
+
+var (
+	errClientStreamClosed = errors.New("Stream to the client has been closed")
+	errClientTooSlow      = errors.New("Client is too slow to keep up with its stream")
+)
+
+// streamTransport carries a stream's messages to its client, over a WebSocket or as server-sent events. Only one
+// goroutine writes to a transport at a time
+type streamTransport interface {
+	// writeMessage writes a message, with an ID that the client can resume the stream from if it has one
+	writeMessage(id string, data []byte) error
+	// writeClose tells the client that the stream has ended
+	writeClose(code int, reason string) error
+	// keepAlive stops idle connections from being closed, if the transport needs it
+	keepAlive() error
+	// abort disconnects the client immediately. It may be called while another goroutine is writing
+	abort(code int, reason string)
+}
+
+type clientStreamMessage struct {
+	id      string
+	data    []byte
+	closing bool
+	code    int
+}
+
+// clientStream is the connection to a stream's client. Messages are queued and written by a single goroutine, so
+// that relaying a stream is never held up by a slow client
+type clientStream struct {
+	transport         streamTransport
+	overflowPolicy    string
+	keepAliveInterval time.Duration
+
+	lock    sync.Mutex
+	queue   chan clientStreamMessage
+	closed  bool
+	dropped int
+	// Messages dropped that the client has not been told about yet
+	unreported int
+
+	done     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+}
+
+// getOverflowPolicy gets the overflow policy that the client asked for in the overflow query parameter
+func getOverflowPolicy(echoContext echo.Context) (string, error) {
+	switch policy := echoContext.QueryParam("overflow"); policy {
+	case "":
+		return dropOldestOverflowPolicy, nil
+	case dropOldestOverflowPolicy, disconnectOverflowPolicy:
+		return policy, nil
+	default:
+		msg := fmt.Sprintf("Overflow must be %s or %s", dropOldestOverflowPolicy, disconnectOverflowPolicy)
+		return "", echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+}
+
+// newClientStream starts writing messages to the client. Keep-alives are written when the stream has been idle for
+// keepAliveInterval, unless it is 0. stop must be called once the stream has ended
+func newClientStream(transport streamTransport, overflowPolicy string, keepAliveInterval time.Duration) *clientStream {
+	s := &clientStream{
+		transport:         transport,
+		overflowPolicy:    overflowPolicy,
+		keepAliveInterval: keepAliveInterval,
+		queue:             make(chan clientStreamMessage, clientStreamQueueSize),
+		done:              make(chan struct{}),
+		finished:          make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *clientStream) run() {
+	defer close(s.finished)
+
+	var keepAlive <-chan time.Time
+	if s.keepAliveInterval > 0 {
+		ticker := time.NewTicker(s.keepAliveInterval)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	droppedReport := time.NewTicker(clientStreamDroppedReportInterval)
+	defer droppedReport.Stop()
+
+	for {
+		var err error
+		select {
+		case <-s.done:
+			return
+		case <-keepAlive:
+			err = s.transport.keepAlive()
+		case <-droppedReport.C:
+			err = s.reportDropped()
+		case msg := <-s.queue:
+			if msg.closing {
+				if err = s.transport.writeClose(msg.code, string(msg.data)); err != nil {
+					log.Errorf("Error writing close to stream client, %v", err)
+				}
+				s.stop()
+				return
+			}
+			err = s.transport.writeMessage(msg.id, msg.data)
+		}
+
+		if err != nil {
+			log.Errorf("Error writing data to stream client, %v", err)
+			// The client has gone, or is too slow to be worth waiting for
+			s.transport.abort(websocket.CloseGoingAway, "Unable to write to client")
+			s.stop()
+			return
+		}
+	}
+}
+
+// write queues a message for the client
+func (s *clientStream) write(data []byte) error {
+	return s.writeWithID("", data)
+}
+
+// writeWithID queues a message for the client, with an ID that the client can resume the stream from
+func (s *clientStream) writeWithID(id string, data []byte) error {
+	err := s.enqueue(clientStreamMessage{id: id, data: data})
+	if err == errClientTooSlow {
+		s.stop()
+	}
+	return err
+}
+
+func (s *clientStream) enqueue(msg clientStreamMessage) error {
+	s.lock.Lock()
+
+	if s.closed {
+		s.lock.Unlock()
+		return errClientStreamClosed
+	}
+
+	for {
+		select {
+		case s.queue <- msg:
+			// Nothing can be sent after the stream is closed
+			s.closed = msg.closing
+			s.lock.Unlock()
+			return nil
+		default:
+		}
+
+		if s.overflowPolicy == disconnectOverflowPolicy {
+			s.closed = true
+			s.lock.Unlock()
+
+			// Aborting can block on the connection, so it is done without holding the lock
+			log.Warnf("Disconnecting stream client that is too slow to keep up with its stream")
+			s.transport.abort(websocket.CloseTryAgainLater, "Client is too slow")
+			return errClientTooSlow
+		}
+
+		// Make room by dropping the oldest message
+		select {
+		case <-s.queue:
+			s.dropped++
+			s.unreported++
+		default:
+		}
+	}
+}
+
+// reportDropped tells the client how many messages have been dropped since it was last told, if any have. The status
+// is written straight to the client, so that it is not dropped itself
+func (s *clientStream) reportDropped() error {
+	s.lock.Lock()
+	dropped := s.unreported
+	s.unreported = 0
+	s.lock.Unlock()
+
+	if dropped == 0 {
+		return nil
+	}
+
+	jsonMsg, err := json.Marshal(StreamStatus{Status: streamDropped, Dropped: dropped})
+	if err != nil {
+		log.Errorf("Unable to marshal stream status, %v", err)
+		return nil
+	}
+	return s.transport.writeMessage("", jsonMsg)
+}
+
+func (s *clientStream) sendStatus(status StreamStatus) {
+	jsonMsg, err := json.Marshal(status)
+	if err != nil {
+		log.Errorf("Unable to marshal stream status, %v", err)
+		return
+	}
+	if err = s.write(jsonMsg); err != nil {
+		log.Errorf("Error writing data to stream client, %v", err)
+	}
+}
+
+// close ends the stream once the messages that are waiting have been written
+func (s *clientStream) close(reason string) {
+	s.enqueue(clientStreamMessage{data: []byte(reason), closing: true, code: websocket.CloseInternalServerErr})
+}
+
+// stop stops writing messages to the client
+func (s *clientStream) stop() {
+	s.stopOnce.Do(func() {
+		s.lock.Lock()
+		s.closed = true
+		dropped := s.dropped
+		s.lock.Unlock()
+
+		close(s.done)
+		if dropped > 0 {
+			log.Warnf("Dropped %d messages for stream client that could not keep up with its stream", dropped)
+		}
+	})
+}
+
+// wait waits for the stream to stop writing to the client
+func (s *clientStream) wait() {
+	<-s.finished
+}
+
+// webSocketTransport carries a stream over a WebSocket. The WebSocket is kept alive by the pings sent by
+// interfaces.UpgradeToWebSocket
+type webSocketTransport struct {
+	conn *websocket.Conn
+}
+
+func newWebSocketStream(clientWebSocket *websocket.Conn, overflowPolicy string) *clientStream {
+	return newClientStream(&webSocketTransport{conn: clientWebSocket}, overflowPolicy, 0)
+}
+
+func (t *webSocketTransport) writeMessage(id string, data []byte) error {
+	t.conn.SetWriteDeadline(time.Now().Add(clientStreamWriteTimeout))
+	return t.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (t *webSocketTransport) writeClose(code int, reason string) error {
+	t.conn.SetWriteDeadline(time.Now().Add(closeWriteTimeout))
+	return t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
+
+func (t *webSocketTransport) keepAlive() error {
+	return nil
+}
+
+func (t *webSocketTransport) abort(code int, reason string) {
+	t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeWriteTimeout))
+	t.conn.Close()
+}
@@ -0,0 +1,83 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientStream(t *testing.T) {
+	t.Parallel()
+
+	Convey("Client stream", t, func() {
+		transport := &mockStreamTransport{paused: make(chan struct{})}
+
+		// fill writes a message that the stream starts writing, and so blocks on, and then fills the queue and
+		// overflows it by the given number of messages
+		fill := func(s *clientStream, overflow int) error {
+			s.write([]byte("0"))
+			for len(s.queue) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			var err error
+			for i := 1; i <= clientStreamQueueSize+overflow && err == nil; i++ {
+				err = s.write([]byte(strconv.Itoa(i)))
+			}
+			return err
+		}
+
+		Convey("With the drop oldest policy", func() {
+			s := newClientStream(transport, dropOldestOverflowPolicy, 0)
+			defer s.wait()
+			defer s.stop()
+
+			Convey("Should drop the oldest messages and tell the client how many were dropped", func() {
+				So(fill(s, 5), ShouldBeNil)
+				close(transport.paused)
+
+				messages := transport.waitForMessages(clientStreamQueueSize + 2)
+				So(messages, ShouldHaveLength, clientStreamQueueSize+2)
+
+				var relayed []string
+				var statuses []StreamStatus
+				for _, msg := range messages {
+					status := StreamStatus{}
+					if json.Unmarshal([]byte(msg), &status) == nil && len(status.Status) > 0 {
+						statuses = append(statuses, status)
+					} else {
+						relayed = append(relayed, msg)
+					}
+				}
+
+				So(statuses, ShouldResemble, []StreamStatus{{Status: streamDropped, Dropped: 5}})
+				So(relayed, ShouldHaveLength, clientStreamQueueSize+1)
+				So(relayed[0], ShouldEqual, "0")
+				So(relayed[1], ShouldEqual, "6")
+				So(relayed[clientStreamQueueSize], ShouldEqual, strconv.Itoa(clientStreamQueueSize+5))
+				So(transport.aborted, ShouldBeFalse)
+			})
+		})
+
+		Convey("With the disconnect policy", func() {
+			s := newClientStream(transport, disconnectOverflowPolicy, 0)
+			defer s.wait()
+			defer close(transport.paused)
+
+			Convey("Should disconnect the client, without holding up other writers, when it is too slow", func() {
+				var writeDuringAbort error
+				transport.onAbort = func() {
+					writeDuringAbort = s.write([]byte("during abort"))
+				}
+
+				So(fill(s, 1), ShouldEqual, errClientTooSlow)
+				So(transport.aborted, ShouldBeTrue)
+				So(writeDuringAbort, ShouldEqual, errClientStreamClosed)
+				So(s.write([]byte("after abort")), ShouldEqual, errClientStreamClosed)
+			})
+		})
+	})
+}
@@ -0,0 +1,236 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Default, shortest and longest intervals between container metrics samples
+	defaultContainerMetricsInterval = 30 * time.Second
+	minContainerMetricsInterval     = 5 * time.Second
+	maxContainerMetricsInterval     = 5 * time.Minute
+
+	// Number of intervals without metrics after which an instance is no longer included in samples, e.g. because
+	// the app has been scaled down
+	containerMetricsStaleIntervals = 3
+)
+
+// MetricSummary summarises the values of a metric over an interval
+type MetricSummary struct {
+	Current float64 `json:"current"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Avg     float64 `json:"avg"`
+}
+
+// InstanceMetricsSample is the usage of one of an app's containers over an interval
+type InstanceMetricsSample struct {
+	InstanceIndex    int           `json:"instance_index"`
+	Samples          int           `json:"samples"`
+	CPUPercentage    MetricSummary `json:"cpu_percentage"`
+	MemoryBytes      MetricSummary `json:"memory_bytes"`
+	DiskBytes        MetricSummary `json:"disk_bytes"`
+	MemoryBytesQuota uint64        `json:"memory_bytes_quota"`
+	DiskBytesQuota   uint64        `json:"disk_bytes_quota"`
+}
+
+// ContainerMetricsSample is sent to the client at each interval with the usage of each of the app's containers over
+// the interval. An instance without new metrics in the interval reports its last known usage, with no samples
+type ContainerMetricsSample struct {
+	Timestamp int64                   `json:"timestamp"`
+	Interval  int                     `json:"interval"`
+	Instances []InstanceMetricsSample `json:"instances"`
+}
+
+func (c CloudFoundrySpecification) appContainerMetricsStream(echoContext echo.Context) error {
+	return c.commonStreamHandler(echoContext, containerMetricsStreamHandler)
+}
+
+func (c CloudFoundrySpecification) appContainerMetricsStreamSSE(echoContext echo.Context) error {
+	return c.commonSSEStreamHandler(echoContext, containerMetricsStreamHandler)
+}
+
+// containerMetricsStreamHandler sends the client a ContainerMetricsSample for the app every interval, which is
+// given in seconds in the interval query parameter. Samples are made from the container metrics in the app's
+// Doppler stream, starting with its latest container metrics, so no metrics endpoint is needed
+func containerMetricsStreamHandler(echoContext echo.Context, ac *AuthorizedConsumer, stream *clientStream) (clientMessageHandler, error) {
+	log.Debug("containerMetricsStreamHandler")
+
+	// Get the CNSI and app IDs from route parameters
+	cnsiGUID := echoContext.Param("cnsiGuid")
+	appGUID := echoContext.Param("appGuid")
+
+	interval := defaultContainerMetricsInterval
+	if intervalParam := echoContext.QueryParam("interval"); len(intervalParam) > 0 {
+		seconds, err := strconv.Atoi(intervalParam)
+		interval = time.Duration(seconds) * time.Second
+		if err != nil || interval < minContainerMetricsInterval || interval > maxContainerMetricsInterval {
+			msg := fmt.Sprintf("Interval must be a number of seconds, from %d to %d", int(minContainerMetricsInterval.Seconds()), int(maxContainerMetricsInterval.Seconds()))
+			return nil, echo.NewHTTPError(http.StatusBadRequest, msg)
+		}
+	}
+
+	log.Infof("Received request for container metrics stream for App ID: %s - in CNSI: %s", appGUID, cnsiGUID)
+
+	window := newContainerMetricsWindow()
+	latest, _, err := getContainerMetrics(ac, cnsiGUID, appGUID)
+	if err != nil {
+		// The first sample will be empty, but the stream will still fill in later ones
+		log.Warnf("Unable to get the latest container metrics for App ID: %s - %v", appGUID, err)
+	}
+	for _, metric := range latest {
+		window.add(metric)
+	}
+
+	relayEvent := func(msg *events.Envelope) {
+		if msg.GetEventType() == events.Envelope_ContainerMetric {
+			window.add(fromDopplerContainerMetric(msg.GetContainerMetric()))
+		}
+	}
+
+	// Process the app stream, reconnecting if the connection to Doppler is lost
+	go ac.keepStreaming(stream, "container metrics for App ID: "+appGUID, func(authToken string) <-chan error {
+		msgChan, errorChan := ac.consumer.StreamWithoutReconnect(appGUID, authToken)
+		go drainFirehoseEvents(msgChan, relayEvent)
+		return errorChan
+	})
+	go relayContainerMetricsSamples(ac, stream, window, interval)
+
+	log.Infof("Now streaming container metrics for App ID: %s - on CNSI: %s", appGUID, cnsiGUID)
+	return nil, nil
+}
+
+// relayContainerMetricsSamples sends the client a sample straight away, so that it has the latest usage, and then
+// every interval until the consumer is closed
+func relayContainerMetricsSamples(ac *AuthorizedConsumer, stream *clientStream, window *containerMetricsWindow, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	now := time.Now()
+	for {
+		sample := window.sample(now, interval)
+		if jsonMsg, err := json.Marshal(sample); err != nil {
+			log.Errorf("Unable to marshal container metrics sample, %v", err)
+		} else if err = stream.write(jsonMsg); err != nil {
+			log.Errorf("Error writing data to WebSocket, %v", err)
+		}
+
+		select {
+		case <-ac.done:
+			return
+		case now = <-ticker.C:
+		}
+	}
+}
+
+// metricWindow collects the values of a metric over an interval
+type metricWindow struct {
+	count int
+	min   float64
+	max   float64
+	total float64
+}
+
+func (w *metricWindow) add(value float64) {
+	if w.count == 0 || value < w.min {
+		w.min = value
+	}
+	if w.count == 0 || value > w.max {
+		w.max = value
+	}
+	w.count++
+	w.total += value
+}
+
+func (w *metricWindow) summary(current float64) MetricSummary {
+	if w.count == 0 {
+		return MetricSummary{Current: current, Min: current, Max: current, Avg: current}
+	}
+	return MetricSummary{Current: current, Min: w.min, Max: w.max, Avg: w.total / float64(w.count)}
+}
+
+type instanceMetricsWindow struct {
+	latest ContainerMetric
+	cpu    metricWindow
+	memory metricWindow
+	disk   metricWindow
+	// Number of intervals in a row without metrics
+	idle int
+}
+
+// containerMetricsWindow collects the container metrics of each of an app's instances over an interval
+type containerMetricsWindow struct {
+	lock      sync.Mutex
+	instances map[int]*instanceMetricsWindow
+}
+
+func newContainerMetricsWindow() *containerMetricsWindow {
+	return &containerMetricsWindow{instances: make(map[int]*instanceMetricsWindow)}
+}
+
+func (w *containerMetricsWindow) add(metric ContainerMetric) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	instance, ok := w.instances[metric.InstanceIndex]
+	if !ok {
+		instance = &instanceMetricsWindow{}
+		w.instances[metric.InstanceIndex] = instance
+	}
+	instance.latest = metric
+	instance.cpu.add(metric.CPUPercentage)
+	instance.memory.add(float64(metric.MemoryBytes))
+	instance.disk.add(float64(metric.DiskBytes))
+}
+
+// sample summarises the interval that has ended and starts the next one
+func (w *containerMetricsWindow) sample(now time.Time, interval time.Duration) ContainerMetricsSample {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	sample := ContainerMetricsSample{
+		Timestamp: now.UnixNano(),
+		Interval:  int(interval.Seconds()),
+		Instances: make([]InstanceMetricsSample, 0, len(w.instances)),
+	}
+
+	for index, instance := range w.instances {
+		if instance.cpu.count == 0 {
+			instance.idle++
+			if instance.idle > containerMetricsStaleIntervals {
+				delete(w.instances, index)
+				continue
+			}
+		} else {
+			instance.idle = 0
+		}
+
+		latest := instance.latest
+		sample.Instances = append(sample.Instances, InstanceMetricsSample{
+			InstanceIndex:    index,
+			Samples:          instance.cpu.count,
+			CPUPercentage:    instance.cpu.summary(latest.CPUPercentage),
+			MemoryBytes:      instance.memory.summary(float64(latest.MemoryBytes)),
+			DiskBytes:        instance.disk.summary(float64(latest.DiskBytes)),
+			MemoryBytesQuota: latest.MemoryBytesQuota,
+			DiskBytesQuota:   latest.DiskBytesQuota,
+		})
+
+		instance.cpu = metricWindow{}
+		instance.memory = metricWindow{}
+		instance.disk = metricWindow{}
+	}
+
+	sort.Slice(sample.Instances, func(i, j int) bool { return sample.Instances[i].InstanceIndex < sample.Instances[j].InstanceIndex })
+	return sample
+}
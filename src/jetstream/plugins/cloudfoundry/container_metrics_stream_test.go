@@ -0,0 +1,99 @@
+package cloudfoundry
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestContainerMetric(index int, cpu float64, memory uint64) ContainerMetric {
+	return ContainerMetric{
+		InstanceIndex:    index,
+		CPUPercentage:    cpu,
+		MemoryBytes:      memory,
+		DiskBytes:        2 * memory,
+		MemoryBytesQuota: 1024,
+		DiskBytesQuota:   2048,
+	}
+}
+
+func TestContainerMetricsWindow(t *testing.T) {
+	t.Parallel()
+
+	Convey("Container metrics window", t, func() {
+		w := newContainerMetricsWindow()
+		now := time.Unix(1500000000, 0)
+		interval := 30 * time.Second
+
+		Convey("Should summarise the metrics of an instance over the interval", func() {
+			w.add(newTestContainerMetric(0, 10, 100))
+			w.add(newTestContainerMetric(0, 30, 300))
+			w.add(newTestContainerMetric(0, 20, 200))
+
+			sample := w.sample(now, interval)
+			So(sample.Timestamp, ShouldEqual, now.UnixNano())
+			So(sample.Interval, ShouldEqual, 30)
+			So(sample.Instances, ShouldHaveLength, 1)
+
+			instance := sample.Instances[0]
+			So(instance.InstanceIndex, ShouldEqual, 0)
+			So(instance.Samples, ShouldEqual, 3)
+			So(instance.CPUPercentage, ShouldResemble, MetricSummary{Current: 20, Min: 10, Max: 30, Avg: 20})
+			So(instance.MemoryBytes, ShouldResemble, MetricSummary{Current: 200, Min: 100, Max: 300, Avg: 200})
+			So(instance.DiskBytes, ShouldResemble, MetricSummary{Current: 400, Min: 200, Max: 600, Avg: 400})
+			So(instance.MemoryBytesQuota, ShouldEqual, 1024)
+			So(instance.DiskBytesQuota, ShouldEqual, 2048)
+		})
+
+		Convey("Should report the last values of an idle instance with no samples", func() {
+			w.add(newTestContainerMetric(0, 10, 100))
+			w.add(newTestContainerMetric(0, 30, 300))
+			w.sample(now, interval)
+
+			sample := w.sample(now.Add(interval), interval)
+			So(sample.Instances, ShouldHaveLength, 1)
+
+			instance := sample.Instances[0]
+			So(instance.Samples, ShouldEqual, 0)
+			So(instance.CPUPercentage, ShouldResemble, MetricSummary{Current: 30, Min: 30, Max: 30, Avg: 30})
+			So(instance.MemoryBytes, ShouldResemble, MetricSummary{Current: 300, Min: 300, Max: 300, Avg: 300})
+		})
+
+		Convey("Should leave out an instance once it has been idle for too long", func() {
+			w.add(newTestContainerMetric(0, 10, 100))
+			w.add(newTestContainerMetric(1, 10, 100))
+			w.sample(now, interval)
+
+			for i := 1; i <= containerMetricsStaleIntervals; i++ {
+				w.add(newTestContainerMetric(1, 10, 100))
+				So(w.sample(now.Add(time.Duration(i)*interval), interval).Instances, ShouldHaveLength, 2)
+			}
+
+			w.add(newTestContainerMetric(1, 10, 100))
+			sample := w.sample(now.Add(time.Duration(containerMetricsStaleIntervals+1)*interval), interval)
+			So(sample.Instances, ShouldHaveLength, 1)
+			So(sample.Instances[0].InstanceIndex, ShouldEqual, 1)
+
+			Convey("and include it again when it has new metrics", func() {
+				w.add(newTestContainerMetric(0, 50, 500))
+				sample := w.sample(now.Add(time.Duration(containerMetricsStaleIntervals+2)*interval), interval)
+				So(sample.Instances, ShouldHaveLength, 2)
+				So(sample.Instances[0].Samples, ShouldEqual, 1)
+				So(sample.Instances[0].CPUPercentage.Current, ShouldEqual, 50)
+			})
+		})
+
+		Convey("Should order the instances by index", func() {
+			for _, index := range []int{3, 0, 2, 1} {
+				w.add(newTestContainerMetric(index, 10, 100))
+			}
+
+			sample := w.sample(now, interval)
+			So(sample.Instances, ShouldHaveLength, 4)
+			for i, instance := range sample.Instances {
+				So(instance.InstanceIndex, ShouldEqual, i)
+			}
+		})
+	})
+}
@@ -0,0 +1,231 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// How often a summary of the Firehose is sent to the client
+const firehoseSummaryInterval = 1 * time.Second
+
+// FirehoseFilter is sent by the client to choose which envelopes of the Firehose it receives. Empty fields do not
+// filter, so an empty filter lets every envelope through
+type FirehoseFilter struct {
+	// Event types, such as HttpStartStop, LogMessage, ValueMetric, CounterEvent, Error or ContainerMetric
+	EventTypes  []string `json:"eventTypes,omitempty"`
+	Origins     []string `json:"origins,omitempty"`
+	Deployments []string `json:"deployments,omitempty"`
+	Jobs        []string `json:"jobs,omitempty"`
+	// Largest number of envelopes sent each second. When the Firehose is busier than this, one in every N envelopes
+	// is sent, with N chosen from the number of envelopes in the previous second. The rest are dropped and counted in
+	// the summary
+	MaxEventsPerSecond int `json:"maxEventsPerSecond,omitempty"`
+	// Summarise HttpStartStop envelopes each second, rather than sending each of them
+	AggregateHTTP bool `json:"aggregateHttp,omitempty"`
+}
+
+// FirehoseSummary is sent to the client each second when envelopes have been dropped or aggregated
+type FirehoseSummary struct {
+	Timestamp int64 `json:"timestamp"`
+	Dropped   int   `json:"dropped,omitempty"`
+	// One in every SampleRate envelopes was sent, when the Firehose was sampled
+	SampleRate int                  `json:"sampleRate,omitempty"`
+	HTTP       *FirehoseHTTPSummary `json:"http,omitempty"`
+}
+
+// FirehoseHTTPSummary counts the HTTP requests of the Firehose
+type FirehoseHTTPSummary struct {
+	Requests int `json:"requests"`
+	// Counts of requests by the class of their status code - 1xx, 2xx, 3xx, 4xx or 5xx
+	StatusCodes map[string]int `json:"statusCodes"`
+	Methods     map[string]int `json:"methods"`
+	// Mean duration of the requests, in milliseconds
+	MeanDuration float64 `json:"meanDuration"`
+
+	totalDuration time.Duration
+}
+
+// firehoseSummaryMessage wraps a summary so that the client can tell it apart from an envelope
+type firehoseSummaryMessage struct {
+	Summary *FirehoseSummary `json:"firehoseSummary"`
+}
+
+// envelopeFilter is a FirehoseFilter that is ready to apply to envelopes
+type envelopeFilter struct {
+	eventTypes         map[events.Envelope_EventType]bool
+	origins            map[string]bool
+	deployments        map[string]bool
+	jobs               map[string]bool
+	maxEventsPerSecond int
+	aggregateHTTP      bool
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+func newEnvelopeFilter(f FirehoseFilter) (*envelopeFilter, error) {
+	filter := &envelopeFilter{
+		origins:            toSet(f.Origins),
+		deployments:        toSet(f.Deployments),
+		jobs:               toSet(f.Jobs),
+		maxEventsPerSecond: f.MaxEventsPerSecond,
+		aggregateHTTP:      f.AggregateHTTP,
+	}
+
+	if len(f.EventTypes) > 0 {
+		filter.eventTypes = make(map[events.Envelope_EventType]bool)
+		for _, eventType := range f.EventTypes {
+			value, ok := events.Envelope_EventType_value[eventType]
+			if !ok {
+				return nil, fmt.Errorf("Unknown event type %s", eventType)
+			}
+			filter.eventTypes[events.Envelope_EventType(value)] = true
+		}
+	}
+
+	if f.MaxEventsPerSecond < 0 {
+		return nil, fmt.Errorf("Invalid maximum events per second: %d", f.MaxEventsPerSecond)
+	}
+
+	return filter, nil
+}
+
+// parseEnvelopeFilter parses a filter sent by the client
+func parseEnvelopeFilter(data []byte) (*envelopeFilter, error) {
+	f := FirehoseFilter{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Unable to parse Firehose filter: %v", err)
+	}
+	return newEnvelopeFilter(f)
+}
+
+// Matches returns whether the envelope passes the filter
+func (f *envelopeFilter) Matches(env *events.Envelope) bool {
+	if f.eventTypes != nil && !f.eventTypes[env.GetEventType()] {
+		return false
+	}
+	if f.origins != nil && !f.origins[env.GetOrigin()] {
+		return false
+	}
+	if f.deployments != nil && !f.deployments[env.GetDeployment()] {
+		return false
+	}
+	if f.jobs != nil && !f.jobs[env.GetJob()] {
+		return false
+	}
+	return true
+}
+
+// firehoseProcessor applies the client's filter to the Firehose, and samples and aggregates its envelopes
+type firehoseProcessor struct {
+	lock   sync.Mutex
+	filter *envelopeFilter
+
+	// Envelopes that could be sampled, sent and dropped, and HTTP requests aggregated, since the last summary
+	matched int
+	sent    int
+	dropped int
+	http    *FirehoseHTTPSummary
+
+	// One in every sampleEvery envelopes is sent
+	sampleEvery int
+}
+
+// SetFilter replaces the filter. A nil filter lets every envelope through
+func (p *firehoseProcessor) SetFilter(filter *envelopeFilter) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.filter = filter
+	p.sampleEvery = 1
+}
+
+// Process returns whether the envelope should be sent to the client
+func (p *firehoseProcessor) Process(env *events.Envelope) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.filter == nil {
+		return true
+	}
+	if !p.filter.Matches(env) {
+		return false
+	}
+
+	if p.filter.aggregateHTTP && env.GetEventType() == events.Envelope_HttpStartStop {
+		p.aggregateHTTP(env.GetHttpStartStop())
+		return false
+	}
+
+	if p.filter.maxEventsPerSecond > 0 {
+		// Sample the envelopes at the rate chosen from the previous second. If the Firehose gets busier during the
+		// second, envelopes over the limit are dropped until the rate is next chosen
+		p.matched++
+		if (p.sampleEvery > 1 && (p.matched-1)%p.sampleEvery != 0) || p.sent >= p.filter.maxEventsPerSecond {
+			p.dropped++
+			return false
+		}
+	}
+	p.sent++
+	return true
+}
+
+func (p *firehoseProcessor) aggregateHTTP(httpStartStop *events.HttpStartStop) {
+	if p.http == nil {
+		p.http = &FirehoseHTTPSummary{
+			StatusCodes: make(map[string]int),
+			Methods:     make(map[string]int),
+		}
+	}
+
+	p.http.Requests++
+	p.http.StatusCodes[fmt.Sprintf("%dxx", httpStartStop.GetStatusCode()/100)]++
+	p.http.Methods[httpStartStop.GetMethod().String()]++
+	p.http.totalDuration += time.Duration(httpStartStop.GetStopTimestamp() - httpStartStop.GetStartTimestamp())
+}
+
+// Summarise returns the summary of the envelopes since the last call, or nil if none have been dropped or
+// aggregated
+func (p *firehoseProcessor) Summarise(now time.Time) *FirehoseSummary {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	summary := &FirehoseSummary{
+		Timestamp: now.UnixNano(),
+		Dropped:   p.dropped,
+		HTTP:      p.http,
+	}
+	if p.sampleEvery > 1 {
+		summary.SampleRate = p.sampleEvery
+	}
+
+	// Choose the sample rate for the next second, so that the envelopes sent are spread across the second rather than
+	// being the first ones in it
+	p.sampleEvery = 1
+	if p.filter != nil && p.filter.maxEventsPerSecond > 0 && p.matched > p.filter.maxEventsPerSecond {
+		p.sampleEvery = (p.matched + p.filter.maxEventsPerSecond - 1) / p.filter.maxEventsPerSecond
+	}
+
+	p.matched = 0
+	p.sent = 0
+	p.dropped = 0
+	p.http = nil
+
+	if summary.HTTP != nil {
+		summary.HTTP.MeanDuration = float64(summary.HTTP.totalDuration) / float64(summary.HTTP.Requests) / float64(time.Millisecond)
+	} else if summary.Dropped == 0 {
+		return nil
+	}
+	return summary
+}
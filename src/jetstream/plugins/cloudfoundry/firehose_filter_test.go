@@ -0,0 +1,162 @@
+package cloudfoundry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestEnvelope(eventType events.Envelope_EventType, origin string) *events.Envelope {
+	deployment := "cf"
+	job := "router"
+	return &events.Envelope{
+		Origin:     &origin,
+		EventType:  &eventType,
+		Deployment: &deployment,
+		Job:        &job,
+	}
+}
+
+func newTestHTTPEnvelope(method events.Method, statusCode int32, duration time.Duration) *events.Envelope {
+	env := newTestEnvelope(events.Envelope_HttpStartStop, "gorouter")
+	start := int64(1500000000000000000)
+	stop := start + int64(duration)
+	env.HttpStartStop = &events.HttpStartStop{
+		StartTimestamp: &start,
+		StopTimestamp:  &stop,
+		Method:         &method,
+		StatusCode:     &statusCode,
+	}
+	return env
+}
+
+func TestEnvelopeFilter(t *testing.T) {
+	t.Parallel()
+
+	logMessage := newTestEnvelope(events.Envelope_LogMessage, "rep")
+	valueMetric := newTestEnvelope(events.Envelope_ValueMetric, "gorouter")
+
+	Convey("Firehose filter", t, func() {
+		Convey("An empty filter should let every envelope through", func() {
+			filter, err := newEnvelopeFilter(FirehoseFilter{})
+			So(err, ShouldBeNil)
+			So(filter.Matches(logMessage), ShouldBeTrue)
+			So(filter.Matches(valueMetric), ShouldBeTrue)
+		})
+
+		Convey("Should match event types and origins", func() {
+			filter, err := newEnvelopeFilter(FirehoseFilter{EventTypes: []string{"ValueMetric"}})
+			So(err, ShouldBeNil)
+			So(filter.Matches(logMessage), ShouldBeFalse)
+			So(filter.Matches(valueMetric), ShouldBeTrue)
+
+			filter, err = newEnvelopeFilter(FirehoseFilter{Origins: []string{"rep"}})
+			So(err, ShouldBeNil)
+			So(filter.Matches(logMessage), ShouldBeTrue)
+			So(filter.Matches(valueMetric), ShouldBeFalse)
+		})
+
+		Convey("Should match deployments and jobs", func() {
+			filter, err := newEnvelopeFilter(FirehoseFilter{Deployments: []string{"cf"}, Jobs: []string{"diego-cell"}})
+			So(err, ShouldBeNil)
+			So(filter.Matches(logMessage), ShouldBeFalse)
+		})
+
+		Convey("Should reject unknown event types", func() {
+			_, err := newEnvelopeFilter(FirehoseFilter{EventTypes: []string{"Heartbeat"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should reject a negative maximum rate", func() {
+			_, err := parseEnvelopeFilter([]byte(`{"maxEventsPerSecond":-1}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFirehoseProcessor(t *testing.T) {
+	t.Parallel()
+
+	Convey("Firehose processor", t, func() {
+		processor := &firehoseProcessor{}
+		now := time.Now()
+
+		process := func(count int) []int {
+			var sent []int
+			for i := 0; i < count; i++ {
+				if processor.Process(newTestEnvelope(events.Envelope_ValueMetric, "gorouter")) {
+					sent = append(sent, i)
+				}
+			}
+			return sent
+		}
+
+		Convey("Should send everything, with no summary, without a filter", func() {
+			So(process(100), ShouldHaveLength, 100)
+			So(processor.Summarise(now), ShouldBeNil)
+		})
+
+		Convey("Should not send envelopes that do not match the filter", func() {
+			filter, _ := newEnvelopeFilter(FirehoseFilter{EventTypes: []string{"LogMessage"}})
+			processor.SetFilter(filter)
+			So(process(10), ShouldBeEmpty)
+			So(processor.Summarise(now), ShouldBeNil)
+		})
+
+		Convey("Should sample a busy Firehose at a rate chosen from the previous second", func() {
+			filter, _ := newEnvelopeFilter(FirehoseFilter{MaxEventsPerSecond: 10})
+			processor.SetFilter(filter)
+
+			// The rate is not known in the first second, so only the limit applies
+			So(process(100), ShouldHaveLength, 10)
+			summary := processor.Summarise(now)
+			So(summary.Dropped, ShouldEqual, 90)
+			So(summary.SampleRate, ShouldEqual, 0)
+
+			sent := process(100)
+			So(sent, ShouldResemble, []int{0, 10, 20, 30, 40, 50, 60, 70, 80, 90})
+			summary = processor.Summarise(now)
+			So(summary.Dropped, ShouldEqual, 90)
+			So(summary.SampleRate, ShouldEqual, 10)
+
+			// Once the Firehose is quiet again everything is sent from the next second
+			So(process(5), ShouldResemble, []int{0})
+			processor.Summarise(now)
+			So(process(5), ShouldHaveLength, 5)
+			So(processor.Summarise(now), ShouldBeNil)
+		})
+
+		Convey("Should still limit the envelopes sent if the Firehose gets busier during a second", func() {
+			filter, _ := newEnvelopeFilter(FirehoseFilter{MaxEventsPerSecond: 10})
+			processor.SetFilter(filter)
+			process(20)
+			processor.Summarise(now)
+
+			So(process(1000), ShouldHaveLength, 10)
+			So(processor.Summarise(now).Dropped, ShouldEqual, 990)
+		})
+
+		Convey("Should summarise HTTP requests rather than sending them when asked to", func() {
+			filter, _ := newEnvelopeFilter(FirehoseFilter{AggregateHTTP: true})
+			processor.SetFilter(filter)
+
+			So(processor.Process(newTestHTTPEnvelope(events.Method_GET, 200, 10*time.Millisecond)), ShouldBeFalse)
+			So(processor.Process(newTestHTTPEnvelope(events.Method_GET, 404, 20*time.Millisecond)), ShouldBeFalse)
+			So(processor.Process(newTestHTTPEnvelope(events.Method_POST, 201, 60*time.Millisecond)), ShouldBeFalse)
+			So(processor.Process(newTestEnvelope(events.Envelope_ValueMetric, "gorouter")), ShouldBeTrue)
+
+			summary := processor.Summarise(now)
+			So(summary, ShouldNotBeNil)
+			So(summary.Timestamp, ShouldEqual, now.UnixNano())
+			So(summary.Dropped, ShouldEqual, 0)
+			So(summary.HTTP.Requests, ShouldEqual, 3)
+			So(summary.HTTP.StatusCodes, ShouldResemble, map[string]int{"2xx": 2, "4xx": 1})
+			So(summary.HTTP.Methods, ShouldResemble, map[string]int{"GET": 2, "POST": 1})
+			So(summary.HTTP.MeanDuration, ShouldEqual, 30)
+
+			So(processor.Summarise(now), ShouldBeNil)
+		})
+	})
+}
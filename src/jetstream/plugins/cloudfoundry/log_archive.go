@@ -0,0 +1,238 @@
+package cloudfoundry
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/noaa"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	log "github.com/sirupsen/logrus"
+)
+
+// Formats of a log archive
+const (
+	textLogArchiveFormat = "text"
+	jsonLogArchiveFormat = "json"
+	gzipLogArchiveFormat = "gzip"
+)
+
+const (
+	// Longest time that new log messages can be collected for
+	maxLogArchiveTail = 5 * time.Minute
+
+	// Most messages, and bytes of messages, in a log archive. New messages stop being collected when either is reached
+	maxLogArchiveMessages = 50000
+	maxLogArchiveBytes    = 32 * 1024 * 1024
+
+	// Format of the timestamps in a log archive, which matches the cf CLI
+	logArchiveTimestampFormat = "2006-01-02T15:04:05.00-0700"
+)
+
+// logArchiveEntry is a log message in a JSON lines log archive
+type logArchiveEntry struct {
+	Timestamp      string `json:"timestamp"`
+	SourceType     string `json:"source_type"`
+	SourceInstance string `json:"source_instance"`
+	Output         string `json:"output"`
+	Message        string `json:"message"`
+}
+
+// appLogArchive downloads the app's recent log messages as a file. Unless the tail query parameter is 0, new messages
+// are collected for the given number of seconds before the file is sent. Messages can be filtered in the same way as
+// the app's log stream, with a LogStreamFilter in the filter query parameter
+func (c CloudFoundrySpecification) appLogArchive(echoContext echo.Context) error {
+	log.Debug("appLogArchive")
+	cnsiGUID := echoContext.Param("cnsiGuid")
+	appGUID := echoContext.Param("appGuid")
+
+	format := echoContext.QueryParam("format")
+	switch format {
+	case "":
+		format = textLogArchiveFormat
+	case textLogArchiveFormat, jsonLogArchiveFormat, gzipLogArchiveFormat:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Format must be one of %s, %s or %s", textLogArchiveFormat, jsonLogArchiveFormat, gzipLogArchiveFormat))
+	}
+
+	var tail time.Duration
+	if tailParam := echoContext.QueryParam("tail"); len(tailParam) > 0 {
+		seconds, err := strconv.Atoi(tailParam)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxLogArchiveTail {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Tail must be a number of seconds, up to %d", int(maxLogArchiveTail.Seconds())))
+		}
+		tail = time.Duration(seconds) * time.Second
+	}
+
+	filter := &logStreamFilter{}
+	if filterParam := echoContext.QueryParam("filter"); len(filterParam) > 0 {
+		f, err := parseLogMessageFilter([]byte(filterParam))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		filter.Set(f)
+	}
+
+	ac, err := c.openNoaaConsumer(echoContext)
+	if err != nil {
+		return err
+	}
+	defer ac.Close()
+
+	messages, err := getRecentLogs(ac, cnsiGUID, appGUID)
+	if err != nil {
+		return err
+	}
+
+	if tail > 0 {
+		log.Infof("Collecting log messages for %v for App ID: %s - on CNSI: %s", tail, appGUID, cnsiGUID)
+		size := 0
+		for _, msg := range messages {
+			size += len(msg.GetMessage())
+		}
+		request := echoContext.Request().(*standard.Request).Request
+		messages = append(messages, tailLogs(ac, appGUID, tail, request.Context().Done(), maxLogArchiveMessages-len(messages), maxLogArchiveBytes-size)...)
+	}
+
+	archive := make([]*events.LogMessage, 0, len(messages))
+	for _, msg := range noaa.SortRecent(messages) {
+		if filter.Matches(msg) {
+			archive = append(archive, msg)
+		}
+	}
+
+	var contentType, extension string
+	switch format {
+	case jsonLogArchiveFormat:
+		contentType, extension = "application/x-ndjson", "jsonl"
+	case gzipLogArchiveFormat:
+		contentType, extension = "application/gzip", "log.gz"
+	default:
+		contentType, extension = "text/plain; charset=utf-8", "log"
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", appGUID, time.Now().UTC().Format("20060102T150405Z"), extension)
+	response := echoContext.Response()
+	response.Header().Set(echo.HeaderContentType, contentType)
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	response.WriteHeader(http.StatusOK)
+
+	// The archive is written to the client as it is formatted, rather than being built up in memory first
+	if err = writeLogArchive(response, format, archive); err != nil {
+		// The response has started, so the error can not be reported to the client
+		log.Warnf("Unable to send log archive for App %s on CNSI %s: %v", appGUID, cnsiGUID, err)
+	}
+	return nil
+}
+
+// tailLogs collects the app's new log messages for the given time. It stops early if the client goes away, or when
+// the given number of messages or bytes of messages have been collected
+func tailLogs(ac *AuthorizedConsumer, appGUID string, tail time.Duration, cancel <-chan struct{}, maxMessages, maxBytes int) []*events.LogMessage {
+	if maxMessages <= 0 || maxBytes <= 0 {
+		log.Infof("Not collecting new log messages for App ID: %s - the archive is full", appGUID)
+		return nil
+	}
+
+	msgChan, errorChan := ac.consumer.TailingLogs(appGUID, ac.authToken)
+	go drainErrors(errorChan)
+
+	timer := time.NewTimer(tail)
+	defer timer.Stop()
+
+	var messages []*events.LogMessage
+	size := 0
+	for {
+		select {
+		case msg, ok := <-msgChan:
+			if !ok {
+				return messages
+			}
+			messages = append(messages, msg)
+			size += len(msg.GetMessage())
+			if len(messages) >= maxMessages || size >= maxBytes {
+				log.Infof("Stopped collecting new log messages for App ID: %s - the archive is full", appGUID)
+				return messages
+			}
+		case <-timer.C:
+			return messages
+		case <-cancel:
+			return messages
+		}
+	}
+}
+
+// writeLogArchive writes the messages to the writer in the given format
+func writeLogArchive(w io.Writer, format string, messages []*events.LogMessage) error {
+	buffered := bufio.NewWriter(w)
+
+	var err error
+	switch format {
+	case jsonLogArchiveFormat:
+		err = writeJSONLogArchive(buffered, messages)
+	case gzipLogArchiveFormat:
+		gz := gzip.NewWriter(buffered)
+		if err = writeTextLogArchive(gz, messages); err == nil {
+			err = gz.Close()
+		}
+	default:
+		err = writeTextLogArchive(buffered, messages)
+	}
+	if err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+func formatLogArchiveEntry(msg *events.LogMessage) logArchiveEntry {
+	output := stdoutLogOutput
+	if msg.GetMessageType() == events.LogMessage_ERR {
+		output = stderrLogOutput
+	}
+
+	return logArchiveEntry{
+		Timestamp:      time.Unix(0, msg.GetTimestamp()).UTC().Format(logArchiveTimestampFormat),
+		SourceType:     msg.GetSourceType(),
+		SourceInstance: msg.GetSourceInstance(),
+		Output:         output,
+		Message:        strings.TrimRight(string(msg.GetMessage()), "\r\n"),
+	}
+}
+
+// writeTextLogArchive writes each message as a line, in the same way as the cf CLI
+func writeTextLogArchive(w io.Writer, messages []*events.LogMessage) error {
+	for _, msg := range messages {
+		entry := formatLogArchiveEntry(msg)
+		source := entry.SourceType
+		if len(entry.SourceInstance) > 0 {
+			source = source + "/" + entry.SourceInstance
+		}
+		messageType := "OUT"
+		if entry.Output == stderrLogOutput {
+			messageType = "ERR"
+		}
+		if _, err := fmt.Fprintf(w, "%s [%s] %s %s\n", entry.Timestamp, source, messageType, entry.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeJSONLogArchive writes each message as a line of JSON
+func writeJSONLogArchive(w io.Writer, messages []*events.LogMessage) error {
+	encoder := json.NewEncoder(w)
+	for _, msg := range messages {
+		if err := encoder.Encode(formatLogArchiveEntry(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
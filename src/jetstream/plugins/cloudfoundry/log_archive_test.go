@@ -0,0 +1,52 @@
+package cloudfoundry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteLogArchive(t *testing.T) {
+	t.Parallel()
+
+	messages := []*events.LogMessage{
+		newTestLogMessage("APP/PROC/WEB", "0", events.LogMessage_OUT, "Started\n"),
+		newTestLogMessage("RTR", "", events.LogMessage_ERR, "Timed out"),
+	}
+	text := "2017-07-14T02:40:00.00+0000 [APP/PROC/WEB/0] OUT Started\n" +
+		"2017-07-14T02:40:00.00+0000 [RTR] ERR Timed out\n"
+
+	Convey("Write a log archive", t, func() {
+		var buf bytes.Buffer
+
+		Convey("Should write a line for each message in the same way as the cf CLI", func() {
+			So(writeLogArchive(&buf, textLogArchiveFormat, messages), ShouldBeNil)
+			So(buf.String(), ShouldEqual, text)
+		})
+
+		Convey("Should write a line of JSON for each message", func() {
+			So(writeLogArchive(&buf, jsonLogArchiveFormat, messages), ShouldBeNil)
+			So(buf.String(), ShouldEqual,
+				`{"timestamp":"2017-07-14T02:40:00.00+0000","source_type":"APP/PROC/WEB","source_instance":"0","output":"stdout","message":"Started"}`+"\n"+
+					`{"timestamp":"2017-07-14T02:40:00.00+0000","source_type":"RTR","source_instance":"","output":"stderr","message":"Timed out"}`+"\n")
+		})
+
+		Convey("Should gzip the text archive", func() {
+			So(writeLogArchive(&buf, gzipLogArchiveFormat, messages), ShouldBeNil)
+			gz, err := gzip.NewReader(&buf)
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadAll(gz)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, text)
+		})
+
+		Convey("Should write nothing if there are no messages", func() {
+			So(writeLogArchive(&buf, textLogArchiveFormat, nil), ShouldBeNil)
+			So(buf.Len(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,229 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	// How long the result of looking for a Cloud Foundry's log-cache is remembered
+	logCacheDetectionTTL = 10 * time.Minute
+
+	// Largest number of envelopes that log-cache returns for a request
+	logCacheReadLimit = 1000
+)
+
+// Types of envelope that can be read from log-cache
+const (
+	logCacheLogType     = "LOG"
+	logCacheGaugeType   = "GAUGE"
+	logCacheCounterType = "COUNTER"
+)
+
+// cfRootInfo is the response to a request for the root of the Cloud Foundry API, which links to its log-cache
+type cfRootInfo struct {
+	Links struct {
+		LogCache *struct {
+			Href string `json:"href"`
+		} `json:"log_cache"`
+	} `json:"links"`
+}
+
+// logCacheEnvelope is a Loggregator V2 envelope read from log-cache
+type logCacheEnvelope struct {
+	Timestamp  string            `json:"timestamp"`
+	SourceID   string            `json:"source_id"`
+	InstanceID string            `json:"instance_id"`
+	Tags       map[string]string `json:"tags"`
+	Log        *struct {
+		Payload []byte `json:"payload"`
+		Type    string `json:"type"`
+	} `json:"log"`
+	Gauge *struct {
+		Metrics map[string]struct {
+			Unit  string  `json:"unit"`
+			Value float64 `json:"value"`
+		} `json:"metrics"`
+	} `json:"gauge"`
+	Counter *struct {
+		Name  string `json:"name"`
+		Total string `json:"total"`
+	} `json:"counter"`
+}
+
+type logCacheReadResponse struct {
+	Envelopes struct {
+		Batch []logCacheEnvelope `json:"batch"`
+	} `json:"envelopes"`
+}
+
+// logCacheClient reads from the log-cache of a Cloud Foundry
+type logCacheClient struct {
+	url    string
+	client http.Client
+}
+
+type logCacheDetection struct {
+	url     string
+	checked time.Time
+}
+
+// logCacheDetector remembers which Cloud Foundry endpoints have a log-cache
+type logCacheDetector struct {
+	lock       sync.Mutex
+	detections map[string]logCacheDetection
+}
+
+func newLogCacheDetector() *logCacheDetector {
+	return &logCacheDetector{detections: make(map[string]logCacheDetection)}
+}
+
+func (d *logCacheDetector) remove(cnsiGUID string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.detections, cnsiGUID)
+}
+
+// getLogCache gets a client for the endpoint's log-cache, or nil if it does not have one
+func (c CloudFoundrySpecification) getLogCache(cnsiRecord interfaces.CNSIRecord) *logCacheClient {
+	c.logCache.lock.Lock()
+	detection, ok := c.logCache.detections[cnsiRecord.GUID]
+	c.logCache.lock.Unlock()
+
+	if !ok || time.Since(detection.checked) > logCacheDetectionTTL {
+		detection = logCacheDetection{url: c.detectLogCache(cnsiRecord), checked: time.Now()}
+		c.logCache.lock.Lock()
+		c.logCache.detections[cnsiRecord.GUID] = detection
+		c.logCache.lock.Unlock()
+	}
+
+	if len(detection.url) == 0 {
+		return nil
+	}
+	return &logCacheClient{url: detection.url, client: c.portalProxy.GetHttpClient(cnsiRecord.SkipSSLValidation)}
+}
+
+// detectLogCache looks for a log-cache in the links at the root of the Cloud Foundry API. Older Cloud Foundries
+// do not have one
+func (c CloudFoundrySpecification) detectLogCache(cnsiRecord interfaces.CNSIRecord) string {
+	rootURL := strings.TrimRight(cnsiRecord.APIEndpoint.String(), "/") + "/"
+	client := c.portalProxy.GetHttpClient(cnsiRecord.SkipSSLValidation)
+
+	res, err := client.Get(rootURL)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Warnf("Unable to look for log-cache of CNSI %s: %v", cnsiRecord.GUID, interfaces.LogHTTPError(res, err))
+		return ""
+	}
+	defer res.Body.Close()
+
+	info := cfRootInfo{}
+	if err = json.NewDecoder(res.Body).Decode(&info); err != nil || info.Links.LogCache == nil {
+		log.Infof("CNSI %s does not have a log-cache, so Doppler will be used", cnsiRecord.GUID)
+		return ""
+	}
+
+	log.Infof("Using log-cache %s for CNSI %s", info.Links.LogCache.Href, cnsiRecord.GUID)
+	return strings.TrimRight(info.Links.LogCache.Href, "/")
+}
+
+// read reads the envelopes of a source, such as an app, from log-cache
+func (l *logCacheClient) read(sourceID, authToken string, query url.Values) ([]logCacheEnvelope, error) {
+	readURL := fmt.Sprintf("%s/api/v1/read/%s?%s", l.url, url.PathEscape(sourceID), query.Encode())
+	req, err := http.NewRequest("GET", readURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request for %s: %v", readURL, err)
+	}
+	req.Header.Set("Authorization", authToken)
+
+	res, err := l.client.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	response := logCacheReadResponse{}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("Unable to parse log-cache response: %v", err)
+	}
+	return response.Envelopes.Batch, nil
+}
+
+// readLogCache reads from log-cache, refreshing the token and retrying once if it has expired
+func (ac *AuthorizedConsumer) readLogCache(sourceID string, query url.Values) ([]logCacheEnvelope, error) {
+	envelopes, err := ac.logCache.read(sourceID, ac.authToken, query)
+	if httpErr, ok := err.(interfaces.ErrHTTPRequest); ok && httpErr.Status == http.StatusUnauthorized {
+		if err := ac.refreshToken(); err != nil {
+			return nil, err
+		}
+		envelopes, err = ac.logCache.read(sourceID, ac.authToken, query)
+	}
+	return envelopes, err
+}
+
+// getLogCacheRecentLogs reads the app's most recent log messages from log-cache
+func getLogCacheRecentLogs(ac *AuthorizedConsumer, appGUID string) ([]*events.LogMessage, error) {
+	query := url.Values{}
+	query.Set("envelope_types", logCacheLogType)
+	query.Set("descending", "true")
+	query.Set("limit", strconv.Itoa(logCacheReadLimit))
+
+	envelopes, err := ac.readLogCache(appGUID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*events.LogMessage, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		if msg := envelope.toLogMessage(); msg != nil {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func (e *logCacheEnvelope) timestamp() int64 {
+	timestamp, _ := strconv.ParseInt(e.Timestamp, 10, 64)
+	return timestamp
+}
+
+// toLogMessage converts a log envelope to the Doppler message used by the rest of the plugin
+func (e *logCacheEnvelope) toLogMessage() *events.LogMessage {
+	if e.Log == nil {
+		return nil
+	}
+
+	messageType := events.LogMessage_OUT
+	if e.Log.Type == "ERR" {
+		messageType = events.LogMessage_ERR
+	}
+	timestamp := e.timestamp()
+	appID := e.SourceID
+	sourceType := e.Tags["source_type"]
+	sourceInstance := e.InstanceID
+
+	return &events.LogMessage{
+		Message:        e.Log.Payload,
+		MessageType:    &messageType,
+		Timestamp:      &timestamp,
+		AppId:          &appID,
+		SourceType:     &sourceType,
+		SourceInstance: &sourceInstance,
+	}
+}
@@ -0,0 +1,122 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// A log-cache read response, as returned by the log-cache API. Payloads are base64 encoded
+const testLogCacheReadResponse = `{
+	"envelopes": {
+		"batch": [
+			{
+				"timestamp": "1500000000000000000",
+				"source_id": "app-guid",
+				"instance_id": "1",
+				"tags": {"source_type": "APP/PROC/WEB"},
+				"log": {"payload": "aGVsbG8gd29ybGQ=", "type": "OUT"}
+			},
+			{
+				"timestamp": "1500000001000000000",
+				"source_id": "app-guid",
+				"instance_id": "0",
+				"tags": {"source_type": "STG"},
+				"log": {"payload": "ZmFpbGVk", "type": "ERR"}
+			},
+			{
+				"timestamp": "1500000002000000000",
+				"source_id": "app-guid",
+				"instance_id": "0",
+				"gauge": {"metrics": {"cpu": {"unit": "percentage", "value": 1.5}}}
+			}
+		]
+	}
+}`
+
+func parseTestLogCacheEnvelopes() []logCacheEnvelope {
+	response := logCacheReadResponse{}
+	if err := json.Unmarshal([]byte(testLogCacheReadResponse), &response); err != nil {
+		panic(err)
+	}
+	return response.Envelopes.Batch
+}
+
+func TestLogCacheEnvelopeToLogMessage(t *testing.T) {
+	t.Parallel()
+
+	Convey("Converting log-cache envelopes to log messages", t, func() {
+		envelopes := parseTestLogCacheEnvelopes()
+		So(envelopes, ShouldHaveLength, 3)
+
+		Convey("Should convert a log envelope", func() {
+			msg := envelopes[0].toLogMessage()
+			So(msg, ShouldNotBeNil)
+			So(string(msg.GetMessage()), ShouldEqual, "hello world")
+			So(msg.GetMessageType(), ShouldEqual, events.LogMessage_OUT)
+			So(msg.GetTimestamp(), ShouldEqual, int64(1500000000000000000))
+			So(msg.GetAppId(), ShouldEqual, "app-guid")
+			So(msg.GetSourceType(), ShouldEqual, "APP/PROC/WEB")
+			So(msg.GetSourceInstance(), ShouldEqual, "1")
+		})
+
+		Convey("Should convert an error log envelope", func() {
+			msg := envelopes[1].toLogMessage()
+			So(msg, ShouldNotBeNil)
+			So(string(msg.GetMessage()), ShouldEqual, "failed")
+			So(msg.GetMessageType(), ShouldEqual, events.LogMessage_ERR)
+			So(msg.GetSourceType(), ShouldEqual, "STG")
+		})
+
+		Convey("Should not convert an envelope that is not a log", func() {
+			So(envelopes[2].toLogMessage(), ShouldBeNil)
+		})
+
+		Convey("Should use a zero timestamp when it can not be parsed", func() {
+			envelope := envelopes[0]
+			envelope.Timestamp = "yesterday"
+			So(envelope.toLogMessage().GetTimestamp(), ShouldEqual, int64(0))
+		})
+	})
+}
+
+func TestLogCacheRead(t *testing.T) {
+	t.Parallel()
+
+	Convey("Reading from log-cache", t, func() {
+		var request *http.Request
+		status := http.StatusOK
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			w.WriteHeader(status)
+			if status == http.StatusOK {
+				w.Write([]byte(testLogCacheReadResponse))
+			}
+		}))
+		defer server.Close()
+
+		client := &logCacheClient{url: server.URL}
+		query := url.Values{}
+		query.Set("envelope_types", logCacheLogType)
+
+		Convey("Should read the envelopes of the source with the token", func() {
+			envelopes, err := client.read("app-guid", "bearer token", query)
+			So(err, ShouldBeNil)
+			So(envelopes, ShouldHaveLength, 3)
+			So(request.URL.Path, ShouldEqual, "/api/v1/read/app-guid")
+			So(request.URL.Query().Get("envelope_types"), ShouldEqual, logCacheLogType)
+			So(request.Header.Get("Authorization"), ShouldEqual, "bearer token")
+		})
+
+		Convey("Should fail when log-cache rejects the request", func() {
+			status = http.StatusUnauthorized
+			_, err := client.read("app-guid", "bearer token", query)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
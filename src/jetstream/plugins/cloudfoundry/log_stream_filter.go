@@ -0,0 +1,146 @@
+package cloudfoundry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// Outputs of an app that log messages can be filtered by
+const (
+	stdoutLogOutput = "stdout"
+	stderrLogOutput = "stderr"
+)
+
+// LogStreamFilter is sent by the client to choose which log messages of an app stream it receives. Empty fields
+// do not filter, so an empty filter lets every message through
+type LogStreamFilter struct {
+	// Source types, such as APP, RTR or STG. APP also matches the source types of processes, such as APP/PROC/WEB
+	SourceTypes []string `json:"sourceTypes,omitempty"`
+	// Instance indexes
+	Instances []string `json:"instances,omitempty"`
+	// stdout and/or stderr
+	Outputs []string `json:"outputs,omitempty"`
+	// Text that the message must contain, or a regular expression that it must match if Regex is set
+	Match string `json:"match,omitempty"`
+	Regex bool   `json:"regex,omitempty"`
+}
+
+// logMessageFilter is a LogStreamFilter that is ready to apply to log messages
+type logMessageFilter struct {
+	sourceTypes  []string
+	instances    map[string]bool
+	messageTypes map[events.LogMessage_MessageType]bool
+	match        []byte
+	regex        *regexp.Regexp
+}
+
+func newLogMessageFilter(f LogStreamFilter) (*logMessageFilter, error) {
+	filter := &logMessageFilter{}
+
+	for _, sourceType := range f.SourceTypes {
+		filter.sourceTypes = append(filter.sourceTypes, strings.ToUpper(sourceType))
+	}
+
+	if len(f.Instances) > 0 {
+		filter.instances = make(map[string]bool)
+		for _, instance := range f.Instances {
+			filter.instances[instance] = true
+		}
+	}
+
+	if len(f.Outputs) > 0 {
+		filter.messageTypes = make(map[events.LogMessage_MessageType]bool)
+		for _, output := range f.Outputs {
+			switch strings.ToLower(output) {
+			case stdoutLogOutput:
+				filter.messageTypes[events.LogMessage_OUT] = true
+			case stderrLogOutput:
+				filter.messageTypes[events.LogMessage_ERR] = true
+			default:
+				return nil, fmt.Errorf("Unknown output %s - must be %s or %s", output, stdoutLogOutput, stderrLogOutput)
+			}
+		}
+	}
+
+	if len(f.Match) > 0 {
+		if f.Regex {
+			regex, err := regexp.Compile(f.Match)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid regular expression %s: %v", f.Match, err)
+			}
+			filter.regex = regex
+		} else {
+			filter.match = []byte(f.Match)
+		}
+	}
+
+	return filter, nil
+}
+
+// parseLogMessageFilter parses a filter sent by the client
+func parseLogMessageFilter(data []byte) (*logMessageFilter, error) {
+	f := LogStreamFilter{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Unable to parse log stream filter: %v", err)
+	}
+	return newLogMessageFilter(f)
+}
+
+// Matches returns whether the log message passes the filter
+func (f *logMessageFilter) Matches(msg *events.LogMessage) bool {
+	if len(f.sourceTypes) > 0 && !matchesSourceType(f.sourceTypes, msg.GetSourceType()) {
+		return false
+	}
+
+	if f.instances != nil && !f.instances[msg.GetSourceInstance()] {
+		return false
+	}
+
+	if f.messageTypes != nil && !f.messageTypes[msg.GetMessageType()] {
+		return false
+	}
+
+	if f.regex != nil {
+		return f.regex.Match(msg.GetMessage())
+	}
+	if f.match != nil {
+		return bytes.Contains(msg.GetMessage(), f.match)
+	}
+	return true
+}
+
+func matchesSourceType(sourceTypes []string, sourceType string) bool {
+	sourceType = strings.ToUpper(sourceType)
+	for _, t := range sourceTypes {
+		if sourceType == t || strings.HasPrefix(sourceType, t+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// logStreamFilter holds the filter of an app log stream, which the client can change while messages are relayed
+type logStreamFilter struct {
+	lock   sync.RWMutex
+	filter *logMessageFilter
+}
+
+// Set replaces the filter. A nil filter lets every message through
+func (s *logStreamFilter) Set(filter *logMessageFilter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.filter = filter
+}
+
+// Matches returns whether the log message passes the current filter
+func (s *logStreamFilter) Matches(msg *events.LogMessage) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.filter == nil || s.filter.Matches(msg)
+}
@@ -0,0 +1,130 @@
+package cloudfoundry
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestLogMessage(sourceType, sourceInstance string, messageType events.LogMessage_MessageType, message string) *events.LogMessage {
+	appID := "app-guid"
+	timestamp := int64(1500000000000000000)
+	return &events.LogMessage{
+		Message:        []byte(message),
+		MessageType:    &messageType,
+		Timestamp:      &timestamp,
+		AppId:          &appID,
+		SourceType:     &sourceType,
+		SourceInstance: &sourceInstance,
+	}
+}
+
+func TestLogMessageFilter(t *testing.T) {
+	t.Parallel()
+
+	appOut := newTestLogMessage("APP/PROC/WEB", "0", events.LogMessage_OUT, "GET /health 200")
+	appErr := newTestLogMessage("APP/PROC/WEB", "1", events.LogMessage_ERR, "panic: something went wrong")
+	router := newTestLogMessage("RTR", "0", events.LogMessage_OUT, "GET /health 200 0.002s")
+
+	Convey("Log message filter", t, func() {
+		Convey("An empty filter should let every message through", func() {
+			filter, err := newLogMessageFilter(LogStreamFilter{})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeTrue)
+			So(filter.Matches(appErr), ShouldBeTrue)
+			So(filter.Matches(router), ShouldBeTrue)
+		})
+
+		Convey("Should match source types, including the process source types of APP", func() {
+			filter, err := newLogMessageFilter(LogStreamFilter{SourceTypes: []string{"app"}})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeTrue)
+			So(filter.Matches(router), ShouldBeFalse)
+
+			filter, err = newLogMessageFilter(LogStreamFilter{SourceTypes: []string{"AP"}})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeFalse)
+		})
+
+		Convey("Should match instances", func() {
+			filter, err := newLogMessageFilter(LogStreamFilter{Instances: []string{"1"}})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeFalse)
+			So(filter.Matches(appErr), ShouldBeTrue)
+		})
+
+		Convey("Should match outputs", func() {
+			filter, err := newLogMessageFilter(LogStreamFilter{Outputs: []string{"STDERR"}})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeFalse)
+			So(filter.Matches(appErr), ShouldBeTrue)
+		})
+
+		Convey("Should reject unknown outputs", func() {
+			_, err := newLogMessageFilter(LogStreamFilter{Outputs: []string{"stdin"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should match text in the message", func() {
+			filter, err := newLogMessageFilter(LogStreamFilter{Match: "0.002s"})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeFalse)
+			So(filter.Matches(router), ShouldBeTrue)
+		})
+
+		Convey("Should match a regular expression, rather than text, if asked to", func() {
+			filter, err := newLogMessageFilter(LogStreamFilter{Match: "^panic:", Regex: true})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeFalse)
+			So(filter.Matches(appErr), ShouldBeTrue)
+
+			filter, err = newLogMessageFilter(LogStreamFilter{Match: "^panic:"})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appErr), ShouldBeFalse)
+		})
+
+		Convey("Should reject invalid regular expressions", func() {
+			_, err := newLogMessageFilter(LogStreamFilter{Match: "(", Regex: true})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should only let through messages that match every field", func() {
+			filter, err := newLogMessageFilter(LogStreamFilter{SourceTypes: []string{"APP"}, Outputs: []string{"stdout"}, Match: "health"})
+			So(err, ShouldBeNil)
+			So(filter.Matches(appOut), ShouldBeTrue)
+			So(filter.Matches(appErr), ShouldBeFalse)
+			So(filter.Matches(router), ShouldBeFalse)
+		})
+	})
+
+	Convey("Log message filter sent by the client", t, func() {
+		Convey("Should be parsed from JSON", func() {
+			filter, err := parseLogMessageFilter([]byte(`{"sourceTypes":["RTR"]}`))
+			So(err, ShouldBeNil)
+			So(filter.Matches(router), ShouldBeTrue)
+			So(filter.Matches(appOut), ShouldBeFalse)
+		})
+
+		Convey("Should be rejected if it is not valid JSON", func() {
+			_, err := parseLogMessageFilter([]byte(`{"sourceTypes":`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Log stream filter", t, func() {
+		stream := &logStreamFilter{}
+
+		Convey("Should let every message through until a filter is set", func() {
+			So(stream.Matches(router), ShouldBeTrue)
+
+			filter, _ := newLogMessageFilter(LogStreamFilter{SourceTypes: []string{"APP"}})
+			stream.Set(filter)
+			So(stream.Matches(router), ShouldBeFalse)
+			So(stream.Matches(appOut), ShouldBeTrue)
+
+			stream.Set(nil)
+			So(stream.Matches(router), ShouldBeTrue)
+		})
+	})
+}
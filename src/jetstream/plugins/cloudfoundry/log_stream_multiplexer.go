@@ -0,0 +1,355 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Actions that the client can send to change the subscriptions of a multiplexed log stream
+const (
+	subscribeAction   = "subscribe"
+	unsubscribeAction = "unsubscribe"
+)
+
+// LogStreamSubscription is an app whose log messages are sent on a multiplexed log stream
+type LogStreamSubscription struct {
+	CNSIGUID string `json:"cnsiGuid"`
+	AppGUID  string `json:"appGuid"`
+}
+
+// LogStreamSubscriptionRequest is sent by the client to add or remove subscriptions
+type LogStreamSubscriptionRequest struct {
+	Action        string                  `json:"action"`
+	Subscriptions []LogStreamSubscription `json:"subscriptions"`
+}
+
+// Most apps that a multiplexed log stream can be subscribed to at once
+const maxLogStreamSubscriptions = 50
+
+// MultiplexedLogMessage is a log message of one of the subscribed apps, a change in the status of its stream, or an
+// error for a subscription. Errors have an HTTP style status code when there is one, e.g. 429 when the stream has too
+// many subscriptions
+type MultiplexedLogMessage struct {
+	CNSIGUID string             `json:"cnsiGuid"`
+	AppGUID  string             `json:"appGuid"`
+	Message  *events.LogMessage `json:"message,omitempty"`
+	Status   *StreamStatus      `json:"status,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Code     int                `json:"code,omitempty"`
+}
+
+// multiplexedEndpoint is a Cloud Foundry endpoint that has subscribed apps. The endpoint and the user's token for it
+// are looked up once, by the endpoint's consumer, and the token is shared by the consumers of its apps. It is released
+// when its last app is unsubscribed
+type multiplexedEndpoint struct {
+	cnsiGUID  string
+	ac        *AuthorizedConsumer
+	tokenLock sync.Mutex
+	apps      map[string]*multiplexedSubscription
+}
+
+// logStreamMultiplexer interleaves the log messages of the subscribed apps. Each app is streamed through its own Noaa
+// consumer, so that it can be closed when the app is unsubscribed, which reconnects to Doppler if the connection is
+// lost
+type logStreamMultiplexer struct {
+	c         CloudFoundrySpecification
+	userGUID  string
+	lock      sync.Mutex
+	endpoints map[string]*multiplexedEndpoint
+	stream    *clientStream
+}
+
+// multiplexedSubscription reports the status of the stream of a subscribed app to the client
+type multiplexedSubscription struct {
+	m        *logStreamMultiplexer
+	sub      LogStreamSubscription
+	endpoint *multiplexedEndpoint
+	ac       *AuthorizedConsumer
+}
+
+// multiplexedAppStream streams the log messages of any number of apps, across any number of Cloud Foundry
+// endpoints, over a single WebSocket. The client sends LogStreamSubscriptionRequests to choose the apps, and each
+// message is tagged with its app and endpoint
+func (c CloudFoundrySpecification) multiplexedAppStream(echoContext echo.Context) error {
+	log.Debug("multiplexedAppStream")
+	userGUID := echoContext.Get("user_id").(string)
+
+	overflowPolicy, err := getOverflowPolicy(echoContext)
+	if err != nil {
+		return err
+	}
+
+	clientWebSocket, pingTicker, err := interfaces.UpgradeToWebSocket(echoContext)
+	if err != nil {
+		return err
+	}
+	defer clientWebSocket.Close()
+	defer pingTicker.Stop()
+
+	m := &logStreamMultiplexer{
+		c:         c,
+		userGUID:  userGUID,
+		endpoints: make(map[string]*multiplexedEndpoint),
+		stream:    newWebSocketStream(clientWebSocket, overflowPolicy),
+	}
+	defer m.close()
+
+	log.Infof("Now streaming multiplexed logs for user: %s", userGUID)
+
+	// This blocks until the WebSocket is closed
+	readClientMessages(clientWebSocket, m.onClientMessage)
+	return nil
+}
+
+func (m *logStreamMultiplexer) send(msg MultiplexedLogMessage) {
+	if jsonMsg, err := json.Marshal(msg); err != nil {
+		log.Errorf("Received unparsable message from Doppler %v, %v", jsonMsg, err)
+	} else {
+		err := m.stream.write(jsonMsg)
+		if err != nil {
+			log.Errorf("Error writing data to WebSocket, %v", err)
+		}
+	}
+}
+
+// sendError tells the client that a subscription failed, with the error's status code if it has one
+func (m *logStreamMultiplexer) sendError(sub LogStreamSubscription, err error) {
+	msg := MultiplexedLogMessage{CNSIGUID: sub.CNSIGUID, AppGUID: sub.AppGUID, Error: err.Error()}
+	if httpErr, ok := err.(*echo.HTTPError); ok {
+		msg.Error = httpErr.Message
+		msg.Code = httpErr.Code
+	}
+	m.send(msg)
+}
+
+func (m *logStreamMultiplexer) onClientMessage(data []byte) {
+	request := LogStreamSubscriptionRequest{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		log.Warnf("Ignoring unparsable log stream subscription request: %v", err)
+		return
+	}
+
+	for _, sub := range request.Subscriptions {
+		var err error
+		switch request.Action {
+		case subscribeAction:
+			err = m.subscribe(sub)
+		case unsubscribeAction:
+			m.unsubscribe(sub)
+		default:
+			err = echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown action %s - must be %s or %s", request.Action, subscribeAction, unsubscribeAction))
+		}
+		if err != nil {
+			log.Warnf("Unable to %s to logs of App ID: %s - on CNSI: %s - %v", request.Action, sub.AppGUID, sub.CNSIGUID, err)
+			m.sendError(sub, err)
+		}
+	}
+}
+
+// subscribe starts streaming the log messages of an app
+func (m *logStreamMultiplexer) subscribe(sub LogStreamSubscription) error {
+	m.lock.Lock()
+	subscribed, err := m.checkSubscription(sub)
+	m.lock.Unlock()
+	if subscribed || err != nil {
+		return err
+	}
+
+	for {
+		endpoint, err := m.getEndpoint(sub.CNSIGUID)
+		if err != nil {
+			return err
+		}
+
+		m.lock.Lock()
+		// The endpoint is released if its last app stops streaming while the lock is not held
+		if m.endpoints[sub.CNSIGUID] == endpoint {
+			defer m.lock.Unlock()
+			return m.addSubscription(endpoint, sub)
+		}
+		m.lock.Unlock()
+	}
+}
+
+// checkSubscription checks whether the app is already subscribed to, and that another app can be. The lock must be
+// held
+func (m *logStreamMultiplexer) checkSubscription(sub LogStreamSubscription) (bool, error) {
+	if endpoint, ok := m.endpoints[sub.CNSIGUID]; ok && endpoint.apps[sub.AppGUID] != nil {
+		return true, nil
+	}
+
+	count := 0
+	for _, endpoint := range m.endpoints {
+		count += len(endpoint.apps)
+	}
+	if count >= maxLogStreamSubscriptions {
+		return false, echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("A log stream can be subscribed to at most %d apps", maxLogStreamSubscriptions))
+	}
+	return false, nil
+}
+
+// addSubscription starts streaming an app of an endpoint. The lock must be held
+func (m *logStreamMultiplexer) addSubscription(endpoint *multiplexedEndpoint, sub LogStreamSubscription) error {
+	subscribed, err := m.checkSubscription(sub)
+	if subscribed || err != nil {
+		m.releaseEndpoint(endpoint)
+		return err
+	}
+
+	s := &multiplexedSubscription{m: m, sub: sub, endpoint: endpoint, ac: endpoint.newAppConsumer()}
+	endpoint.apps[sub.AppGUID] = s
+	m.tail(s)
+
+	log.Debugf("Subscribed to logs of App ID: %s - on CNSI: %s", sub.AppGUID, sub.CNSIGUID)
+	return nil
+}
+
+// getEndpoint gets an endpoint that has subscribed apps, or opens a consumer for it if it has none. The consumer is
+// opened without holding the lock, as it looks up the endpoint and the user's token and may refresh the token
+func (m *logStreamMultiplexer) getEndpoint(cnsiGUID string) (*multiplexedEndpoint, error) {
+	m.lock.Lock()
+	endpoint, ok := m.endpoints[cnsiGUID]
+	m.lock.Unlock()
+	if ok {
+		return endpoint, nil
+	}
+
+	ac, err := m.openConsumer(cnsiGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if endpoint, ok := m.endpoints[cnsiGUID]; ok {
+		ac.Close()
+		return endpoint, nil
+	}
+	endpoint = &multiplexedEndpoint{cnsiGUID: cnsiGUID, ac: ac, apps: make(map[string]*multiplexedSubscription)}
+	m.endpoints[cnsiGUID] = endpoint
+	return endpoint, nil
+}
+
+// releaseEndpoint closes the endpoint's consumer once it has no subscribed apps. The lock must be held
+func (m *logStreamMultiplexer) releaseEndpoint(endpoint *multiplexedEndpoint) {
+	if len(endpoint.apps) > 0 {
+		return
+	}
+	if m.endpoints[endpoint.cnsiGUID] == endpoint {
+		delete(m.endpoints, endpoint.cnsiGUID)
+	}
+	endpoint.ac.Close()
+}
+
+// unsubscribe stops streaming the log messages of an app
+func (m *logStreamMultiplexer) unsubscribe(sub LogStreamSubscription) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	endpoint, ok := m.endpoints[sub.CNSIGUID]
+	if !ok {
+		return
+	}
+	if s, ok := endpoint.apps[sub.AppGUID]; ok {
+		delete(endpoint.apps, sub.AppGUID)
+		s.ac.Close()
+		m.releaseEndpoint(endpoint)
+		log.Debugf("Unsubscribed from logs of App ID: %s - on CNSI: %s", sub.AppGUID, sub.CNSIGUID)
+	}
+}
+
+func (m *logStreamMultiplexer) openConsumer(cnsiGUID string) (*AuthorizedConsumer, error) {
+	cnsiRecord, err := m.c.portalProxy.GetCNSIRecord(cnsiGUID)
+	if err != nil || cnsiRecord.CNSIType != EndpointType {
+		return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("No Cloud Foundry endpoint registered with GUID %s", cnsiGUID))
+	}
+	return m.c.newAuthorizedConsumer(cnsiGUID, m.userGUID)
+}
+
+// newAppConsumer creates the consumer of one of the endpoint's apps. Refreshing its token refreshes the endpoint's
+// token, which the endpoint's other apps then reconnect with
+func (e *multiplexedEndpoint) newAppConsumer() *AuthorizedConsumer {
+	e.tokenLock.Lock()
+	defer e.tokenLock.Unlock()
+
+	ac := &AuthorizedConsumer{
+		consumer:       newNoaaConsumer(e.ac.dopplerAddress),
+		dopplerAddress: e.ac.dopplerAddress,
+		authToken:      e.ac.authToken,
+		logCache:       e.ac.logCache,
+		done:           make(chan struct{}),
+	}
+	ac.refreshToken = func() error {
+		authToken, err := e.refreshToken()
+		if err != nil {
+			return err
+		}
+		ac.authToken = authToken
+		return nil
+	}
+	return ac
+}
+
+func (e *multiplexedEndpoint) refreshToken() (string, error) {
+	e.tokenLock.Lock()
+	defer e.tokenLock.Unlock()
+
+	if err := e.ac.refreshToken(); err != nil {
+		return "", err
+	}
+	return e.ac.authToken, nil
+}
+
+// tail relays the app's new log messages, reconnecting if the connection to Doppler is lost, until the subscription's
+// consumer is closed
+func (m *logStreamMultiplexer) tail(s *multiplexedSubscription) {
+	relay := func(msg *events.LogMessage) {
+		m.send(MultiplexedLogMessage{CNSIGUID: s.sub.CNSIGUID, AppGUID: s.sub.AppGUID, Message: msg})
+	}
+
+	go s.ac.keepStreaming(s, "logs of App ID: "+s.sub.AppGUID, func(authToken string) <-chan error {
+		msgChan, errorChan := s.ac.consumer.TailingLogsWithoutReconnect(s.sub.AppGUID, authToken)
+		go drainLogMessages(msgChan, relay)
+		return errorChan
+	})
+}
+
+func (s *multiplexedSubscription) sendStatus(status StreamStatus) {
+	s.m.send(MultiplexedLogMessage{CNSIGUID: s.sub.CNSIGUID, AppGUID: s.sub.AppGUID, Status: &status})
+}
+
+// close ends the subscription when its stream fails, leaving the other subscriptions streaming
+func (s *multiplexedSubscription) close(reason string) {
+	s.m.lock.Lock()
+	if s.endpoint.apps[s.sub.AppGUID] == s {
+		delete(s.endpoint.apps, s.sub.AppGUID)
+		s.m.releaseEndpoint(s.endpoint)
+	}
+	s.m.lock.Unlock()
+
+	s.ac.Close()
+	s.m.send(MultiplexedLogMessage{CNSIGUID: s.sub.CNSIGUID, AppGUID: s.sub.AppGUID, Error: reason})
+}
+
+// close stops streaming the log messages of all apps
+func (m *logStreamMultiplexer) close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.stream.stop()
+	for _, endpoint := range m.endpoints {
+		for _, s := range endpoint.apps {
+			s.ac.Close()
+		}
+		endpoint.ac.Close()
+	}
+	m.endpoints = nil
+}
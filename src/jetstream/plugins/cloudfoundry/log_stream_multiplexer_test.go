@@ -0,0 +1,235 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/noaa/consumer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockStreamTransport records what is written to a stream's client. Writes block while it is paused
+type mockStreamTransport struct {
+	lock     sync.Mutex
+	messages []string
+	closed   string
+	aborted  bool
+	paused   chan struct{}
+	onAbort  func()
+}
+
+func (t *mockStreamTransport) writeMessage(id string, data []byte) error {
+	if t.paused != nil {
+		<-t.paused
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.messages = append(t.messages, string(data))
+	return nil
+}
+
+func (t *mockStreamTransport) writeClose(code int, reason string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.closed = reason
+	return nil
+}
+
+func (t *mockStreamTransport) keepAlive() error {
+	return nil
+}
+
+func (t *mockStreamTransport) abort(code int, reason string) {
+	t.lock.Lock()
+	t.aborted = true
+	t.lock.Unlock()
+
+	if t.onAbort != nil {
+		t.onAbort()
+	}
+}
+
+func (t *mockStreamTransport) written() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]string(nil), t.messages...)
+}
+
+// waitForMessages waits for the transport to have been written the given number of messages
+func (t *mockStreamTransport) waitForMessages(count int) []string {
+	deadline := time.Now().Add(time.Second)
+	for len(t.written()) < count && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return t.written()
+}
+
+func newTestAuthorizedConsumer() *AuthorizedConsumer {
+	return &AuthorizedConsumer{
+		consumer: consumer.New("wss://doppler.127.0.0.1", nil, nil),
+		done:     make(chan struct{}),
+	}
+}
+
+// addTestSubscription subscribes the multiplexer to an app without streaming it
+func addTestSubscription(m *logStreamMultiplexer, sub LogStreamSubscription) *multiplexedSubscription {
+	endpoint, ok := m.endpoints[sub.CNSIGUID]
+	if !ok {
+		endpoint = &multiplexedEndpoint{cnsiGUID: sub.CNSIGUID, ac: newTestAuthorizedConsumer(), apps: make(map[string]*multiplexedSubscription)}
+		m.endpoints[sub.CNSIGUID] = endpoint
+	}
+	s := &multiplexedSubscription{m: m, sub: sub, endpoint: endpoint, ac: newTestAuthorizedConsumer()}
+	endpoint.apps[sub.AppGUID] = s
+	return s
+}
+
+func TestLogStreamMultiplexer(t *testing.T) {
+	t.Parallel()
+
+	Convey("Multiplexed log stream", t, func() {
+		transport := &mockStreamTransport{}
+		m := &logStreamMultiplexer{
+			endpoints: make(map[string]*multiplexedEndpoint),
+			stream:    newClientStream(transport, dropOldestOverflowPolicy, 0),
+		}
+		defer m.stream.stop()
+
+		readError := func(data string) MultiplexedLogMessage {
+			msg := MultiplexedLogMessage{}
+			So(json.Unmarshal([]byte(data), &msg), ShouldBeNil)
+			return msg
+		}
+
+		Convey("Should send an error frame with a 429 code when there are too many subscriptions", func() {
+			for i := 0; i < maxLogStreamSubscriptions; i++ {
+				addTestSubscription(m, LogStreamSubscription{CNSIGUID: fmt.Sprintf("cf-%d", i%2), AppGUID: fmt.Sprintf("app-%d", i)})
+			}
+
+			m.onClientMessage([]byte(`{"action":"subscribe","subscriptions":[{"cnsiGuid":"cf-0","appGuid":"one-too-many"}]}`))
+
+			messages := transport.waitForMessages(1)
+			So(messages, ShouldHaveLength, 1)
+			msg := readError(messages[0])
+			So(msg.AppGUID, ShouldEqual, "one-too-many")
+			So(msg.Code, ShouldEqual, http.StatusTooManyRequests)
+			So(msg.Error, ShouldNotBeEmpty)
+			So(m.endpoints["cf-0"].apps, ShouldHaveLength, maxLogStreamSubscriptions/2)
+		})
+
+		Convey("Should not resubscribe to an app it is already subscribed to", func() {
+			sub := LogStreamSubscription{CNSIGUID: "cf", AppGUID: "app"}
+			s := addTestSubscription(m, sub)
+
+			So(m.subscribe(sub), ShouldBeNil)
+			So(m.endpoints["cf"].apps["app"], ShouldEqual, s)
+		})
+
+		Convey("Should use the consumer of an endpoint that already has subscribed apps", func() {
+			endpoint := addTestSubscription(m, LogStreamSubscription{CNSIGUID: "cf", AppGUID: "app"}).endpoint
+
+			// The multiplexer has no portal proxy, so it would fail to open another consumer
+			found, err := m.getEndpoint("cf")
+			So(err, ShouldBeNil)
+			So(found, ShouldEqual, endpoint)
+		})
+
+		Convey("Should send an error frame with a 400 code for an unknown action", func() {
+			m.onClientMessage([]byte(`{"action":"resubscribe","subscriptions":[{"cnsiGuid":"cf","appGuid":"app"}]}`))
+
+			messages := transport.waitForMessages(1)
+			So(messages, ShouldHaveLength, 1)
+			So(readError(messages[0]).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Should close the consumer of an app when it is unsubscribed", func() {
+			s := addTestSubscription(m, LogStreamSubscription{CNSIGUID: "cf", AppGUID: "app"})
+			other := addTestSubscription(m, LogStreamSubscription{CNSIGUID: "cf", AppGUID: "other"})
+
+			m.onClientMessage([]byte(`{"action":"unsubscribe","subscriptions":[{"cnsiGuid":"cf","appGuid":"app"}]}`))
+			So(s.ac.isClosed(), ShouldBeTrue)
+			So(other.ac.isClosed(), ShouldBeFalse)
+			So(m.endpoints["cf"].apps, ShouldHaveLength, 1)
+			So(s.endpoint.ac.isClosed(), ShouldBeFalse)
+
+			Convey("and release the endpoint when its last app is unsubscribed", func() {
+				m.onClientMessage([]byte(`{"action":"unsubscribe","subscriptions":[{"cnsiGuid":"cf","appGuid":"other"}]}`))
+				So(other.ac.isClosed(), ShouldBeTrue)
+				So(m.endpoints, ShouldBeEmpty)
+				So(s.endpoint.ac.isClosed(), ShouldBeTrue)
+			})
+		})
+
+		Convey("Should end only the subscription whose stream failed", func() {
+			s := addTestSubscription(m, LogStreamSubscription{CNSIGUID: "cf", AppGUID: "failed"})
+			other := addTestSubscription(m, LogStreamSubscription{CNSIGUID: "cf", AppGUID: "other"})
+
+			s.sendStatus(StreamStatus{Status: streamFailed})
+			s.close("Unable to reconnect to Doppler")
+
+			So(m.endpoints["cf"].apps, ShouldHaveLength, 1)
+			So(m.endpoints["cf"].apps["other"], ShouldEqual, other)
+			So(s.ac.isClosed(), ShouldBeTrue)
+			So(s.endpoint.ac.isClosed(), ShouldBeFalse)
+
+			messages := transport.waitForMessages(2)
+			So(messages, ShouldHaveLength, 2)
+			So(readError(messages[0]).Status.Status, ShouldEqual, streamFailed)
+			So(readError(messages[1]).Error, ShouldEqual, "Unable to reconnect to Doppler")
+			So(transport.closed, ShouldBeEmpty)
+		})
+
+		Convey("Should not end a newer subscription to the same app when an old stream fails", func() {
+			sub := LogStreamSubscription{CNSIGUID: "cf", AppGUID: "app"}
+			old := addTestSubscription(m, sub)
+			newer := addTestSubscription(m, sub)
+
+			old.close("Unable to refresh token")
+			So(m.endpoints["cf"].apps["app"], ShouldEqual, newer)
+		})
+
+		Convey("Should close the consumers of all apps and endpoints when the stream ends", func() {
+			s := addTestSubscription(m, LogStreamSubscription{CNSIGUID: "cf", AppGUID: "app"})
+			m.close()
+			So(s.ac.isClosed(), ShouldBeTrue)
+			So(s.endpoint.ac.isClosed(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestMultiplexedEndpointToken(t *testing.T) {
+	t.Parallel()
+
+	Convey("Token shared by the apps of a multiplexed endpoint", t, func() {
+		refreshes := 0
+		endpointAC := newTestAuthorizedConsumer()
+		endpointAC.authToken = "bearer old"
+		endpointAC.refreshToken = func() error {
+			refreshes++
+			endpointAC.authToken = fmt.Sprintf("bearer new-%d", refreshes)
+			return nil
+		}
+		endpoint := &multiplexedEndpoint{cnsiGUID: "cf", ac: endpointAC, apps: make(map[string]*multiplexedSubscription)}
+
+		Convey("Should give each app its own consumer with the endpoint's token", func() {
+			first := endpoint.newAppConsumer()
+			second := endpoint.newAppConsumer()
+			So(first, ShouldNotPointTo, second)
+			So(first.authToken, ShouldEqual, "bearer old")
+			So(second.authToken, ShouldEqual, "bearer old")
+
+			Convey("and refresh the endpoint's token when an app's token is refreshed", func() {
+				So(first.refreshToken(), ShouldBeNil)
+				So(refreshes, ShouldEqual, 1)
+				So(first.authToken, ShouldEqual, "bearer new-1")
+				So(endpoint.newAppConsumer().authToken, ShouldEqual, "bearer new-1")
+
+				first.Close()
+				So(second.isClosed(), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,352 @@
+package cloudfoundry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/labstack/echo"
+	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Default and longest times that a log tap forwards messages for before it expires
+	defaultLogTapDuration = 15 * time.Minute
+	maxLogTapDuration     = 2 * time.Hour
+
+	// Number of messages that can be waiting to be forwarded before new messages are dropped
+	logTapQueueSize = 1024
+
+	// Messages are forwarded in batches of up to this size, at least this often
+	logTapBatchSize     = 100
+	logTapBatchInterval = 1 * time.Second
+
+	// Most log taps that can be running at once
+	maxLogTaps = 20
+)
+
+// Statuses of a log tap, in addition to the StreamStatus statuses
+const (
+	logTapStreaming = "streaming"
+	logTapStopped   = "stopped"
+)
+
+// LogTapRequest is sent by an admin to start forwarding the log messages of an app to an external sink. The sink is
+// a URL in the same form as a Cloud Foundry syslog drain: syslog://host:port, syslog-tls://host:port or an http(s)
+// webhook URL
+type LogTapRequest struct {
+	CNSIGUID          string          `json:"cnsiGuid"`
+	AppGUID           string          `json:"appGuid"`
+	Sink              string          `json:"sink"`
+	SkipSSLValidation bool            `json:"skipSSLValidation,omitempty"`
+	Filter            LogStreamFilter `json:"filter"`
+	// Number of seconds to forward messages for
+	Duration int `json:"duration,omitempty"`
+}
+
+// LogTap is a log tap that is forwarding the log messages of an app
+type LogTap struct {
+	ID       string `json:"id"`
+	CNSIGUID string `json:"cnsiGuid"`
+	AppGUID  string `json:"appGuid"`
+	// The sink without any credentials in its URL
+	Sink      string          `json:"sink"`
+	Filter    LogStreamFilter `json:"filter"`
+	CreatedBy string          `json:"createdBy"`
+	Started   time.Time       `json:"started"`
+	Expires   time.Time       `json:"expires"`
+	Status    string          `json:"status"`
+	Forwarded int64           `json:"forwarded"`
+	Dropped   int64           `json:"dropped"`
+}
+
+// logTap forwards the log messages of an app that match its filter to a sink, until it is stopped or expires. It
+// streams the app's logs with the token of the admin that started it
+type logTap struct {
+	lock sync.Mutex
+	info LogTap
+
+	ac       *AuthorizedConsumer
+	filter   *logStreamFilter
+	sink     logTapSink
+	queue    chan *events.LogMessage
+	expiry   *time.Timer
+	onStop   func()
+	stopOnce sync.Once
+}
+
+// logTapManager keeps track of the log taps that are running
+type logTapManager struct {
+	lock sync.Mutex
+	taps map[string]*logTap
+}
+
+func newLogTapManager() *logTapManager {
+	return &logTapManager{taps: make(map[string]*logTap)}
+}
+
+// add adds a tap, unless as many taps as are allowed are already running
+func (m *logTapManager) add(tap *logTap) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.taps) >= maxLogTaps {
+		return echo.NewHTTPError(http.StatusTooManyRequests, fmt.Sprintf("At most %d log taps can be running at once", maxLogTaps))
+	}
+	m.taps[tap.info.ID] = tap
+	return nil
+}
+
+func (m *logTapManager) remove(tapID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.taps, tapID)
+}
+
+func (m *logTapManager) get(tapID string) (*logTap, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	tap, ok := m.taps[tapID]
+	return tap, ok
+}
+
+func (m *logTapManager) list() []*logTap {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	taps := make([]*logTap, 0, len(m.taps))
+	for _, tap := range m.taps {
+		taps = append(taps, tap)
+	}
+	return taps
+}
+
+// stopEndpoint stops the log taps of an endpoint
+func (m *logTapManager) stopEndpoint(cnsiGUID string) {
+	for _, tap := range m.list() {
+		if tap.info.CNSIGUID == cnsiGUID {
+			tap.stop("Endpoint unregistered")
+		}
+	}
+}
+
+// listLogTaps lists the log taps that are running
+func (c CloudFoundrySpecification) listLogTaps(echoContext echo.Context) error {
+	log.Debug("listLogTaps")
+	taps := c.logTaps.list()
+	infos := make([]LogTap, 0, len(taps))
+	for _, tap := range taps {
+		infos = append(infos, tap.getInfo())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return echoContext.JSON(http.StatusOK, infos)
+}
+
+// startLogTap starts forwarding the log messages of an app to the sink given in a LogTapRequest
+func (c CloudFoundrySpecification) startLogTap(echoContext echo.Context) error {
+	log.Debug("startLogTap")
+	userGUID := echoContext.Get("user_id").(string)
+
+	data, err := ioutil.ReadAll(echoContext.Request().Body())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Unable to read log tap request")
+	}
+
+	var request LogTapRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unable to parse log tap request: %v", err))
+	}
+	if len(request.CNSIGUID) == 0 || len(request.AppGUID) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "A log tap needs a cnsiGuid and an appGuid")
+	}
+
+	duration := defaultLogTapDuration
+	if request.Duration != 0 {
+		duration = time.Duration(request.Duration) * time.Second
+		if request.Duration < 0 || duration > maxLogTapDuration {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Duration must be a number of seconds, up to %d", int(maxLogTapDuration.Seconds())))
+		}
+	}
+
+	messageFilter, err := newLogMessageFilter(request.Filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	filter := &logStreamFilter{}
+	filter.Set(messageFilter)
+
+	sink, err := c.newLogTapSink(request.Sink, request.SkipSSLValidation)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	cnsiRecord, err := c.portalProxy.GetCNSIRecord(request.CNSIGUID)
+	if err != nil || cnsiRecord.CNSIType != EndpointType {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("No Cloud Foundry endpoint registered with GUID %s", request.CNSIGUID))
+	}
+
+	ac, err := c.newAuthorizedConsumer(request.CNSIGUID, userGUID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tap := &logTap{
+		info: LogTap{
+			ID:        uuid.NewV4().String(),
+			CNSIGUID:  request.CNSIGUID,
+			AppGUID:   request.AppGUID,
+			Sink:      sink.name(),
+			Filter:    request.Filter,
+			CreatedBy: userGUID,
+			Started:   now,
+			Expires:   now.Add(duration),
+			Status:    logTapStreaming,
+		},
+		ac:     ac,
+		filter: filter,
+		sink:   sink,
+		queue:  make(chan *events.LogMessage, logTapQueueSize),
+	}
+	tap.onStop = func() { c.logTaps.remove(tap.info.ID) }
+
+	if err = c.logTaps.add(tap); err != nil {
+		ac.Close()
+		return err
+	}
+	tap.start(duration)
+
+	return echoContext.JSON(http.StatusCreated, tap.getInfo())
+}
+
+// stopLogTap stops a log tap before it expires
+func (c CloudFoundrySpecification) stopLogTap(echoContext echo.Context) error {
+	log.Debug("stopLogTap")
+	tapID := echoContext.Param("tapId")
+
+	tap, ok := c.logTaps.get(tapID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("No log tap with ID %s", tapID))
+	}
+
+	tap.stop("Stopped by an admin")
+	return echoContext.JSON(http.StatusOK, tap.getInfo())
+}
+
+// start streams the app's logs, in the same way as the app's log stream, and forwards them until the tap expires
+func (t *logTap) start(duration time.Duration) {
+	log.Infof("Starting log tap %s for App ID: %s - on CNSI: %s - to %s", t.info.ID, t.info.AppGUID, t.info.CNSIGUID, t.sink.name())
+
+	t.expiry = time.AfterFunc(duration, func() {
+		t.stop("Expired")
+	})
+
+	go t.forward()
+	go t.ac.keepStreaming(t, "log tap for App ID: "+t.info.AppGUID, func(authToken string) <-chan error {
+		msgChan, errorChan := t.ac.consumer.TailingLogsWithoutReconnect(t.info.AppGUID, authToken)
+		go drainLogMessages(msgChan, t.relay)
+		return errorChan
+	})
+}
+
+// relay queues a message to be forwarded if it matches the tap's filter. Messages are dropped if the sink cannot
+// keep up with them
+func (t *logTap) relay(msg *events.LogMessage) {
+	if !t.filter.Matches(msg) {
+		return
+	}
+
+	select {
+	case t.queue <- msg:
+	default:
+		t.count(0, 1)
+	}
+}
+
+// forward sends the queued messages to the sink in batches, until the tap is stopped
+func (t *logTap) forward() {
+	defer t.sink.close()
+
+	ticker := time.NewTicker(logTapBatchInterval)
+	defer ticker.Stop()
+
+	batch := make([]*events.LogMessage, 0, logTapBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.sink.send(batch); err != nil {
+			log.Warnf("Log tap %s failed to forward %d messages: %v", t.info.ID, len(batch), err)
+			t.count(0, len(batch))
+		} else {
+			t.count(len(batch), 0)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-t.ac.done:
+			flush()
+			return
+		case msg := <-t.queue:
+			batch = append(batch, msg)
+			if len(batch) >= logTapBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (t *logTap) count(forwarded, dropped int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.info.Forwarded += int64(forwarded)
+	t.info.Dropped += int64(dropped)
+}
+
+func (t *logTap) getInfo() LogTap {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.info
+}
+
+// sendStatus records the status of the tap's stream, as there is no client to send it to
+func (t *logTap) sendStatus(status StreamStatus) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if status.Status == streamResumed {
+		t.info.Status = logTapStreaming
+	} else {
+		t.info.Status = status.Status
+	}
+}
+
+// close stops the tap when its stream fails
+func (t *logTap) close(reason string) {
+	t.stop(reason)
+}
+
+// stop stops streaming and forwarding the app's logs
+func (t *logTap) stop(reason string) {
+	t.stopOnce.Do(func() {
+		t.lock.Lock()
+		t.info.Status = logTapStopped
+		info := t.info
+		t.lock.Unlock()
+
+		log.Infof("Stopping log tap %s for App ID: %s - %s. Forwarded %d messages, dropped %d", info.ID, info.AppGUID, reason, info.Forwarded, info.Dropped)
+
+		if t.expiry != nil {
+			t.expiry.Stop()
+		}
+		t.ac.Close()
+		t.onStop()
+	})
+}
@@ -0,0 +1,183 @@
+package cloudfoundry
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/sonde-go/events"
+)
+
+// Schemes of the URLs of log tap sinks, which are the same as those of Cloud Foundry syslog drains
+const (
+	syslogSinkScheme    = "syslog"
+	syslogTLSSinkScheme = "syslog-tls"
+	httpSinkScheme      = "http"
+	httpsSinkScheme     = "https"
+)
+
+const (
+	// Time allowed to connect to a syslog sink, and to write a batch of messages to it
+	syslogSinkTimeout = 10 * time.Second
+
+	// RFC5424 priorities of stdout and stderr messages, which are sent with the user-level facility
+	syslogInfoPriority  = 14
+	syslogErrorPriority = 11
+)
+
+// logTapSink is somewhere that a log tap forwards messages to. Only one goroutine uses a sink at a time
+type logTapSink interface {
+	// name describes the sink without any credentials in its URL, for logging
+	name() string
+	send(messages []*events.LogMessage) error
+	close()
+}
+
+// newLogTapSink creates the sink for a URL
+func (c CloudFoundrySpecification) newLogTapSink(sinkURL string, skipSSLValidation bool) (logTapSink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Invalid sink URL: %s", sinkURL)
+	}
+
+	switch u.Scheme {
+	case syslogSinkScheme:
+		return &syslogSink{scheme: u.Scheme, address: u.Host}, nil
+	case syslogTLSSinkScheme:
+		return &syslogSink{scheme: u.Scheme, address: u.Host, tlsConfig: &tls.Config{InsecureSkipVerify: skipSSLValidation}}, nil
+	case httpSinkScheme, httpsSinkScheme:
+		return &webhookSink{url: sinkURL, host: u.Scheme + "://" + u.Host, client: c.portalProxy.GetHttpClient(skipSSLValidation)}, nil
+	default:
+		return nil, fmt.Errorf("Sink URL must be %s, %s, %s or %s", syslogSinkScheme, syslogTLSSinkScheme, httpSinkScheme, httpsSinkScheme)
+	}
+}
+
+// syslogSink sends messages to a syslog server over TCP, or TLS if it has a TLS config. Messages are formatted as in
+// RFC5424 and framed with octet counting, as Cloud Foundry syslog drains are
+type syslogSink struct {
+	scheme    string
+	address   string
+	tlsConfig *tls.Config
+	conn      net.Conn
+}
+
+func (s *syslogSink) name() string {
+	return s.scheme + "://" + s.address
+}
+
+func (s *syslogSink) connect() error {
+	dialer := &net.Dialer{Timeout: syslogSinkTimeout}
+
+	var err error
+	if s.tlsConfig != nil {
+		s.conn, err = tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+	} else {
+		s.conn, err = dialer.Dial("tcp", s.address)
+	}
+	return err
+}
+
+// send writes the messages to the syslog server, reconnecting if the last write failed
+func (s *syslogSink) send(messages []*events.LogMessage) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return fmt.Errorf("Unable to connect to %s: %v", s.name(), err)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		line := formatSyslogMessage(msg)
+		fmt.Fprintf(&buf, "%d %s", len(line), line)
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(syslogSinkTimeout))
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.close()
+		return err
+	}
+	return nil
+}
+
+func (s *syslogSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// formatSyslogMessage formats a log message as RFC5424 syslog, with the app GUID as the app name and the source of
+// the message, e.g. [APP/PROC/WEB/0], as the process ID
+func formatSyslogMessage(msg *events.LogMessage) string {
+	priority := syslogInfoPriority
+	if msg.GetMessageType() == events.LogMessage_ERR {
+		priority = syslogErrorPriority
+	}
+
+	source := msg.GetSourceType()
+	if len(msg.GetSourceInstance()) > 0 {
+		source = source + "/" + msg.GetSourceInstance()
+	}
+	source = strings.Replace(source, " ", "", -1)
+
+	timestamp := time.Unix(0, msg.GetTimestamp()).UTC().Format(time.RFC3339Nano)
+	message := strings.TrimRight(string(msg.GetMessage()), "\r\n")
+	return fmt.Sprintf("<%d>1 %s - %s [%s] - - %s\n", priority, timestamp, msg.GetAppId(), source, message)
+}
+
+// webhookSink posts batches of messages to a URL as JSON
+type webhookSink struct {
+	url    string
+	host   string
+	client http.Client
+}
+
+// LogTapWebhookBatch is posted to a webhook sink with a batch of an app's log messages
+type LogTapWebhookBatch struct {
+	AppGUID  string            `json:"appGuid"`
+	Messages []logArchiveEntry `json:"messages"`
+}
+
+func (w *webhookSink) name() string {
+	return w.host
+}
+
+func (w *webhookSink) send(messages []*events.LogMessage) error {
+	batch := LogTapWebhookBatch{Messages: make([]logArchiveEntry, 0, len(messages))}
+	for _, msg := range messages {
+		batch.AppGUID = msg.GetAppId()
+		batch.Messages = append(batch.Messages, formatLogArchiveEntry(msg))
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	res, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// Leave out the URL, which may contain credentials
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("Unable to post to %s: %v", w.host, err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", w.host, res.StatusCode)
+	}
+	return nil
+}
+
+func (w *webhookSink) close() {
+}
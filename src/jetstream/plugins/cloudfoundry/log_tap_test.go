@@ -0,0 +1,35 @@
+package cloudfoundry
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogTapManager(t *testing.T) {
+	t.Parallel()
+
+	Convey("Log tap manager", t, func() {
+		m := newLogTapManager()
+		for i := 0; i < maxLogTaps; i++ {
+			So(m.add(&logTap{info: LogTap{ID: strconv.Itoa(i)}}), ShouldBeNil)
+		}
+
+		Convey("Should not run more than the most log taps allowed", func() {
+			err := m.add(&logTap{info: LogTap{ID: "one-too-many"}})
+			So(err, ShouldNotBeNil)
+			So(err.(*echo.HTTPError).Code, ShouldEqual, http.StatusTooManyRequests)
+			_, ok := m.get("one-too-many")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Should allow another log tap once one has stopped", func() {
+			m.remove("0")
+			So(m.add(&logTap{info: LogTap{ID: "another"}}), ShouldBeNil)
+			So(m.list(), ShouldHaveLength, maxLogTaps)
+		})
+	})
+}
@@ -19,6 +19,8 @@ import (
 type CloudFoundrySpecification struct {
 	portalProxy  interfaces.PortalProxy
 	endpointType string
+	logCache     *logCacheDetector
+	logTaps      *logTapManager
 }
 
 const (
@@ -28,7 +30,7 @@ const (
 
 // Init creates a new CloudFoundrySpecification
 func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
-	return &CloudFoundrySpecification{portalProxy: portalProxy, endpointType: EndpointType}, nil
+	return &CloudFoundrySpecification{portalProxy: portalProxy, endpointType: EndpointType, logCache: newLogCacheDetector(), logTaps: newLogTapManager()}, nil
 }
 
 // GetEndpointPlugin gets the endpoint plugin for this plugin
@@ -63,15 +65,24 @@ func (c *CloudFoundrySpecification) Connect(ec echo.Context, cnsiRecord interfac
 		connectType = interfaces.AuthConnectTypeCreds
 	}
 
-	if connectType != interfaces.AuthConnectTypeCreds {
-		return nil, false, errors.New("Only username/password accepted for Cloud Foundry endpoints")
+	var tokenRecord *interfaces.TokenRecord
+	var err error
+	switch connectType {
+	case interfaces.AuthConnectTypeCreds:
+		tokenRecord, err = c.portalProxy.ConnectOAuth2(ec, cnsiRecord)
+	case interfaces.AuthConnectTypeClientCredentials:
+		tokenRecord, err = c.portalProxy.ConnectOAuth2ClientCredentials(ec, cnsiRecord)
+	case interfaces.AuthConnectTypeBearer:
+		tokenRecord, err = c.portalProxy.ConnectBearer(ec, cnsiRecord)
+	default:
+		return nil, false, errors.New("Only username/password, client credentials or bearer token accepted for Cloud Foundry endpoints")
 	}
-	cfAdmin := false
-	tokenRecord, err := c.portalProxy.ConnectOAuth2(ec, cnsiRecord)
 	if err != nil {
 		return nil, false, err
 	}
 
+	cfAdmin := false
+
 	userTokenInfo, err := c.portalProxy.GetUserTokenInfo(tokenRecord.AuthToken)
 	if err == nil {
 		cfAdmin = strings.Contains(strings.Join(userTokenInfo.Scope, ""), c.portalProxy.GetConfig().CFAdminIdentifier)
@@ -170,19 +181,37 @@ func (c *CloudFoundrySpecification) fetchAutoRegisterEndpoint() (string, interfa
 
 // AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
 func (c *CloudFoundrySpecification) AddAdminGroupRoutes(echoGroup *echo.Group) {
-	// no-op
+	// Log Taps, which forward Application Logs to external sinks
+	echoGroup.GET("/logtaps", c.listLogTaps)
+	echoGroup.POST("/logtaps", c.startLogTap)
+	echoGroup.DELETE("/logtaps/:tapId", c.stopLogTap)
 }
 
 // AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
 func (c *CloudFoundrySpecification) AddSessionGroupRoutes(echoGroup *echo.Group) {
 	// Firehose Stream
 	echoGroup.GET("/:cnsiGuid/firehose", c.firehose)
+	echoGroup.GET("/:cnsiGuid/firehose/sse", c.firehoseSSE)
 
 	// Applications Log Streams
 	echoGroup.GET("/:cnsiGuid/apps/:appGuid/stream", c.appStream)
+	echoGroup.GET("/:cnsiGuid/apps/:appGuid/stream/sse", c.appStreamSSE)
+
+	// Multiplexed Log Stream of any number of Applications
+	echoGroup.GET("/apps/stream", c.multiplexedAppStream)
+
+	// Applications Log Archives
+	echoGroup.GET("/:cnsiGuid/apps/:appGuid/logs", c.appLogArchive)
 
 	// Application Stream
 	echoGroup.GET("/:cnsiGuid/apps/:appGuid/appFirehose", c.appFirehose)
+	echoGroup.GET("/:cnsiGuid/apps/:appGuid/appFirehose/sse", c.appFirehoseSSE)
+
+	// Application Metrics
+	echoGroup.GET("/:cnsiGuid/apps/:appGuid/metrics", c.appMetrics)
+	echoGroup.GET("/:cnsiGuid/apps/:appGuid/containerMetrics", c.appContainerMetrics)
+	echoGroup.GET("/:cnsiGuid/apps/:appGuid/containerMetrics/stream", c.appContainerMetricsStream)
+	echoGroup.GET("/:cnsiGuid/apps/:appGuid/containerMetrics/stream/sse", c.appContainerMetricsStreamSSE)
 }
 
 func (c *CloudFoundrySpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
@@ -227,3 +256,14 @@ func (c *CloudFoundrySpecification) Info(apiEndpoint string, skipSSLValidation b
 
 func (c *CloudFoundrySpecification) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
 }
+
+// OnEndpointNotification forgets whether a Cloud Foundry has a log-cache, and stops its log taps, when it is
+// unregistered
+func (c *CloudFoundrySpecification) OnEndpointNotification(action interfaces.EndpointAction, endpoint *interfaces.CNSIRecord) {
+	if endpoint.CNSIType != EndpointType || action != interfaces.EndpointUnregisterAction {
+		return
+	}
+
+	c.logCache.remove(endpoint.GUID)
+	c.logTaps.stopEndpoint(endpoint.GUID)
+}
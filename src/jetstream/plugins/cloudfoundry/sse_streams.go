@@ -0,0 +1,210 @@
+package cloudfoundry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	log "github.com/sirupsen/logrus"
+)
+
+// How often a comment is sent on an idle stream of server-sent events, so that proxies do not close it
+const sseKeepAliveInterval = 15 * time.Second
+
+// The server-sent events variants of the streams are for clients that cannot use WebSockets, such as those behind
+// proxies that break WebSocket upgrades. Clients cannot send messages, so filters can only be given in the filter
+// query parameter
+
+func (c CloudFoundrySpecification) appStreamSSE(echoContext echo.Context) error {
+	return c.commonSSEStreamHandler(echoContext, appStreamHandler)
+}
+
+func (c CloudFoundrySpecification) firehoseSSE(echoContext echo.Context) error {
+	return c.commonSSEStreamHandler(echoContext, firehoseStreamHandler)
+}
+
+func (c CloudFoundrySpecification) appFirehoseSSE(echoContext echo.Context) error {
+	return c.commonSSEStreamHandler(echoContext, appFirehoseStreamHandler)
+}
+
+// commonSSEStreamHandler relays a stream to the client as server-sent events, until the client disconnects
+func (c CloudFoundrySpecification) commonSSEStreamHandler(echoContext echo.Context, bespokeStreamHandler func(echo.Context, *AuthorizedConsumer, *clientStream) (clientMessageHandler, error)) error {
+	overflowPolicy, err := getOverflowPolicy(echoContext)
+	if err != nil {
+		return err
+	}
+
+	ac, err := c.openNoaaConsumer(echoContext)
+	if err != nil {
+		return err
+	}
+
+	transport, err := startSSEResponse(echoContext)
+	if err != nil {
+		ac.Close()
+		return err
+	}
+	defer transport.close()
+
+	stream := newClientStream(transport, overflowPolicy, sseKeepAliveInterval)
+	// The consumer is closed before waiting for the last write to the client, so that it is not held open by a
+	// client that has stopped reading
+	defer stream.wait()
+	defer ac.Close()
+	defer stream.stop()
+
+	if _, err := bespokeStreamHandler(echoContext, ac, stream); err != nil {
+		// The response has started, so the error can only be reported in the stream
+		log.Errorf("Unable to start stream: %v", err)
+		stream.close(err.Error())
+	}
+
+	// This blocks until the client disconnects or the stream ends
+	select {
+	case <-transport.disconnected:
+	case <-stream.done:
+	}
+	return nil
+}
+
+// startSSEResponse starts the response to the client. HTTP/1 connections are hijacked, so that writes to a client
+// that has stopped reading time out and the client can be disconnected. HTTP/2 connections can not be hijacked, so
+// the response is written through the server
+func startSSEResponse(echoContext echo.Context) (*sseTransport, error) {
+	response := echoContext.Response().(*standard.Response).ResponseWriter
+	request := echoContext.Request().(*standard.Request).Request
+
+	header := response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream
+	header.Set("X-Accel-Buffering", "no")
+
+	hijacker, ok := response.(http.Hijacker)
+	if !ok {
+		flusher, ok := response.(http.Flusher)
+		if !ok {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "Streaming is not supported")
+		}
+		response.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		return &sseTransport{
+			writer:       response,
+			flush:        func() error { flusher.Flush(); return nil },
+			disconnected: request.Context().Done(),
+		}, nil
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Unable to start stream: %v", err))
+	}
+
+	// The end of the stream is marked by closing the connection
+	header.Set("Connection", "close")
+	conn.SetWriteDeadline(time.Now().Add(clientStreamWriteTimeout))
+	fmt.Fprintf(rw, "HTTP/1.1 %d %s\r\n", http.StatusOK, http.StatusText(http.StatusOK))
+	header.Write(rw)
+	rw.WriteString("\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The client does not send anything once it has made its request, so the connection has been closed when
+	// reading from it ends
+	disconnected := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, rw.Reader)
+		close(disconnected)
+	}()
+
+	return &sseTransport{writer: rw, flush: rw.Flush, conn: conn, disconnected: disconnected}, nil
+}
+
+// getLastEventID gets the ID of the last server-sent event that the client received, which it sends when it
+// reconnects to a stream. Log messages are identified by their timestamps
+func getLastEventID(echoContext echo.Context) int64 {
+	lastEventID := echoContext.Request().Header().Get("Last-Event-ID")
+	if len(lastEventID) == 0 {
+		return 0
+	}
+
+	timestamp, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil {
+		log.Warnf("Ignoring invalid Last-Event-ID: %s", lastEventID)
+		return 0
+	}
+	return timestamp
+}
+
+// sseTransport carries a stream as server-sent events. When the connection has been hijacked writes have
+// deadlines, and the client can be disconnected by closing the connection
+type sseTransport struct {
+	writer       io.Writer
+	flush        func() error
+	conn         net.Conn
+	disconnected <-chan struct{}
+}
+
+func (t *sseTransport) write(data []byte, timeout time.Duration) error {
+	if t.conn != nil {
+		t.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	if _, err := t.writer.Write(data); err != nil {
+		return err
+	}
+	return t.flush()
+}
+
+func (t *sseTransport) writeEvent(event string, id string, data []byte, timeout time.Duration) error {
+	var buf bytes.Buffer
+	if len(event) > 0 {
+		fmt.Fprintf(&buf, "event: %s\n", event)
+	}
+	if len(id) > 0 {
+		fmt.Fprintf(&buf, "id: %s\n", id)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+
+	return t.write(buf.Bytes(), timeout)
+}
+
+func (t *sseTransport) writeMessage(id string, data []byte) error {
+	return t.writeEvent("", id, data, clientStreamWriteTimeout)
+}
+
+func (t *sseTransport) writeClose(code int, reason string) error {
+	return t.writeEvent("close", "", []byte(reason), closeWriteTimeout)
+}
+
+func (t *sseTransport) keepAlive() error {
+	return t.write([]byte(": keep-alive\n\n"), clientStreamWriteTimeout)
+}
+
+// abort closes a hijacked connection, which interrupts any write that is in progress. Over HTTP/2 a write can not be
+// interrupted, so the stream is ended by the handler returning once the stream has stopped
+func (t *sseTransport) abort(code int, reason string) {
+	if t.conn != nil {
+		t.conn.Close()
+	}
+}
+
+// close closes a hijacked connection once the stream has ended
+func (t *sseTransport) close() {
+	if t.conn != nil {
+		t.conn.Close()
+	}
+}
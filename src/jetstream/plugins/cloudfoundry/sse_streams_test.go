@@ -0,0 +1,84 @@
+package cloudfoundry
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// startTestSSEServer starts a server that starts a stream of server-sent events for each request and hands its
+// transport to the test. The handler returns once the transport has been closed
+func startTestSSEServer() (*httptest.Server, chan *sseTransport) {
+	transports := make(chan *sseTransport, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echoContext := echo.New().NewContext(standard.NewRequest(r, nil), standard.NewResponse(w, nil))
+		transport, err := startSSEResponse(echoContext)
+		if err != nil {
+			panic(err)
+		}
+		transports <- transport
+		<-transport.disconnected
+		transport.close()
+	}))
+	return server, transports
+}
+
+func TestSSETransport(t *testing.T) {
+	t.Parallel()
+
+	Convey("Server-sent events transport", t, func() {
+		server, transports := startTestSSEServer()
+		defer server.Close()
+
+		res, err := http.Get(server.URL)
+		So(err, ShouldBeNil)
+		defer res.Body.Close()
+		transport := <-transports
+
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		So(res.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+		So(transport.conn, ShouldNotBeNil)
+
+		Convey("Should write messages as events", func() {
+			So(transport.writeMessage("42", []byte("first\nsecond")), ShouldBeNil)
+			So(transport.writeClose(0, "done"), ShouldBeNil)
+
+			reader := bufio.NewReader(res.Body)
+			var lines []string
+			for len(lines) < 7 {
+				line, err := reader.ReadString('\n')
+				So(err, ShouldBeNil)
+				lines = append(lines, line)
+			}
+			So(lines, ShouldResemble, []string{
+				"id: 42\n", "data: first\n", "data: second\n", "\n",
+				"event: close\n", "data: done\n", "\n",
+			})
+		})
+
+		Convey("Should disconnect the client when aborted", func() {
+			transport.abort(0, "Client is too slow")
+
+			_, err := ioutil.ReadAll(res.Body)
+			So(err, ShouldBeNil)
+			So(transport.writeMessage("", []byte("late")), ShouldNotBeNil)
+		})
+
+		Convey("Should notice when the client disconnects", func() {
+			res.Body.Close()
+
+			select {
+			case <-transport.disconnected:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Client disconnect was not noticed")
+			}
+		})
+	})
+}
@@ -0,0 +1,129 @@
+package cloudfoundry
+
+import (
+	"time"
+
+	noaa_errors "github.com/cloudfoundry/noaa/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Statuses of a stream that are sent to the client
+const (
+	streamReconnecting = "reconnecting"
+	streamResumed      = "resumed"
+	streamFailed       = "failed"
+	// Messages were dropped because the client could not keep up with them
+	streamDropped = "dropped"
+)
+
+const (
+	// Number of times in a row that reconnecting to Doppler can fail before the stream fails
+	maxStreamReconnectAttempts = 8
+
+	// Delays between attempts to reconnect, which double after each failed attempt
+	minStreamReconnectDelay = 1 * time.Second
+	maxStreamReconnectDelay = 30 * time.Second
+
+	// Time that a stream must stay connected for before it is considered to have resumed
+	streamResumeTime = 5 * time.Second
+)
+
+// StreamStatus is sent to the client when the connection to Doppler is lost and when it has been restored, and when
+// messages have been dropped
+type StreamStatus struct {
+	Status  string `json:"streamStatus"`
+	Attempt int    `json:"attempt,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Number of messages dropped since the last dropped status
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// streamReporter is told the status of a stream that is kept open by keepStreaming, and is closed if it fails
+type streamReporter interface {
+	sendStatus(status StreamStatus)
+	close(reason string)
+}
+
+// keepStreaming opens a Doppler stream and reopens it whenever it fails, until the consumer is closed. The token is
+// refreshed if Doppler rejects it, and the client is told when the stream is reconnecting, has resumed or has
+// failed. open must start relaying the stream's messages and return its error channel
+func (ac *AuthorizedConsumer) keepStreaming(stream streamReporter, description string, open func(authToken string) <-chan error) {
+	attempt := 0
+	delay := minStreamReconnectDelay
+	for {
+		err := waitForStreamError(open(ac.authToken), func() {
+			if attempt > 0 {
+				log.Infof("Resumed streaming %s", description)
+				stream.sendStatus(StreamStatus{Status: streamResumed})
+			}
+			attempt = 0
+			delay = minStreamReconnectDelay
+		})
+
+		if ac.isClosed() {
+			return
+		}
+
+		log.Warnf("Lost connection to Doppler while streaming %s: %v", description, err)
+		if _, ok := err.(*noaa_errors.UnauthorizedError); ok {
+			if err = ac.refreshToken(); err != nil {
+				log.Errorf("Unable to refresh token while streaming %s: %v", description, err)
+				stream.sendStatus(StreamStatus{Status: streamFailed, Error: err.Error()})
+				stream.close("Unable to refresh token")
+				return
+			}
+		}
+
+		attempt++
+		if attempt > maxStreamReconnectAttempts {
+			log.Errorf("Giving up streaming %s after %d attempts to reconnect", description, maxStreamReconnectAttempts)
+			status := StreamStatus{Status: streamFailed}
+			if err != nil {
+				status.Error = err.Error()
+			}
+			stream.sendStatus(status)
+			stream.close("Unable to reconnect to Doppler")
+			return
+		}
+
+		status := StreamStatus{Status: streamReconnecting, Attempt: attempt}
+		if err != nil {
+			status.Error = err.Error()
+		}
+		stream.sendStatus(status)
+
+		select {
+		case <-ac.done:
+			return
+		case <-time.After(delay):
+		}
+
+		delay = delay * 2
+		if delay > maxStreamReconnectDelay {
+			delay = maxStreamReconnectDelay
+		}
+	}
+}
+
+// waitForStreamError waits for a stream to end, and returns the last error that it reported. onStable is called
+// once the stream has stayed connected for long enough to be considered resumed
+func waitForStreamError(errorChan <-chan error, onStable func()) error {
+	stable := time.NewTimer(streamResumeTime)
+	defer stable.Stop()
+
+	var streamErr error
+	for {
+		select {
+		case err, ok := <-errorChan:
+			if !ok {
+				return streamErr
+			}
+			// Note: we receive a nil error before the channel is closed so check here...
+			if err != nil {
+				streamErr = err
+			}
+		case <-stable.C:
+			onStable()
+		}
+	}
+}
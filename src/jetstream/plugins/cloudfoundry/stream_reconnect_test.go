@@ -0,0 +1,156 @@
+package cloudfoundry
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	noaa_errors "github.com/cloudfoundry/noaa/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// mockStreamReporter records the statuses of a stream kept open by keepStreaming
+type mockStreamReporter struct {
+	lock     sync.Mutex
+	statuses []StreamStatus
+	closed   string
+}
+
+func (r *mockStreamReporter) sendStatus(status StreamStatus) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.statuses = append(r.statuses, status)
+}
+
+func (r *mockStreamReporter) close(reason string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = reason
+}
+
+func (r *mockStreamReporter) getStatuses() []StreamStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]StreamStatus(nil), r.statuses...)
+}
+
+// failedStream returns the error channel of a stream that has failed with the given error
+func failedStream(err error) <-chan error {
+	errorChan := make(chan error, 1)
+	errorChan <- err
+	close(errorChan)
+	return errorChan
+}
+
+func TestKeepStreaming(t *testing.T) {
+	t.Parallel()
+
+	Convey("Keep a Doppler stream open", t, func() {
+		ac := newTestAuthorizedConsumer()
+		ac.authToken = "bearer old"
+		reporter := &mockStreamReporter{}
+
+		var lock sync.Mutex
+		var tokens []string
+		opened := make(chan struct{}, 10)
+		streamErrors := make(chan error, 10)
+		open := func(authToken string) <-chan error {
+			lock.Lock()
+			tokens = append(tokens, authToken)
+			lock.Unlock()
+			opened <- struct{}{}
+			return failedStream(<-streamErrors)
+		}
+
+		finished := make(chan struct{})
+		start := func() {
+			go func() {
+				ac.keepStreaming(reporter, "test stream", open)
+				close(finished)
+			}()
+		}
+
+		waitForOpen := func() {
+			select {
+			case <-opened:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Stream was not opened")
+			}
+		}
+
+		waitForFinish := func() {
+			select {
+			case <-finished:
+			case <-time.After(5 * time.Second):
+				t.Fatal("keepStreaming did not return")
+			}
+		}
+
+		Convey("Should tell the client it is reconnecting and reconnect after a delay", func() {
+			start()
+			waitForOpen()
+			streamErrors <- errors.New("connection reset")
+
+			waitForOpen()
+			statuses := reporter.getStatuses()
+			So(statuses, ShouldHaveLength, 1)
+			So(statuses[0].Status, ShouldEqual, streamReconnecting)
+			So(statuses[0].Attempt, ShouldEqual, 1)
+			So(statuses[0].Error, ShouldEqual, "connection reset")
+
+			ac.Close()
+			streamErrors <- nil
+			waitForFinish()
+			So(reporter.closed, ShouldBeEmpty)
+		})
+
+		Convey("Should reconnect with a refreshed token if Doppler rejects the token", func() {
+			ac.refreshToken = func() error {
+				ac.authToken = "bearer new"
+				return nil
+			}
+
+			start()
+			waitForOpen()
+			streamErrors <- noaa_errors.NewUnauthorizedError("token expired")
+
+			waitForOpen()
+			lock.Lock()
+			So(tokens, ShouldResemble, []string{"bearer old", "bearer new"})
+			lock.Unlock()
+
+			ac.Close()
+			streamErrors <- nil
+			waitForFinish()
+		})
+
+		Convey("Should fail the stream if the token can not be refreshed", func() {
+			ac.refreshToken = func() error {
+				return errors.New("refresh token revoked")
+			}
+
+			start()
+			waitForOpen()
+			streamErrors <- noaa_errors.NewUnauthorizedError("token expired")
+			waitForFinish()
+
+			statuses := reporter.getStatuses()
+			So(statuses, ShouldHaveLength, 1)
+			So(statuses[0].Status, ShouldEqual, streamFailed)
+			So(statuses[0].Error, ShouldEqual, "refresh token revoked")
+			So(reporter.closed, ShouldEqual, "Unable to refresh token")
+		})
+
+		Convey("Should stop without reporting anything when the consumer is closed", func() {
+			start()
+			waitForOpen()
+			ac.Close()
+			streamErrors <- errors.New("connection closed")
+			waitForFinish()
+
+			So(reporter.getStatuses(), ShouldBeEmpty)
+			So(reporter.closed, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,154 @@
+package concourse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// concourseEndpoint is a Concourse endpoint that the user is connected to
+type concourseEndpoint struct {
+	cnsiRecord interfaces.CNSIRecord
+	token      string
+}
+
+func (c *ConcourseSpecification) getConcourseEndpoint(ec echo.Context) (*concourseEndpoint, error) {
+	cnsiGUID := ec.Param("cnsiGuid")
+	userGUID := ec.Get("user_id").(string)
+
+	cnsiRecord, err := c.portalProxy.GetCNSIRecord(cnsiGUID)
+	if err != nil || cnsiRecord.CNSIType != EndpointType {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested Concourse endpoint not registered",
+			"No Concourse endpoint registered with GUID %s: %v", cnsiGUID, err)
+	}
+
+	tokenRecord, ok := c.portalProxy.GetCNSITokenRecord(cnsiGUID, userGUID)
+	if !ok || tokenRecord.Disconnected {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"User has not connected to the Concourse endpoint",
+			"User %s has not connected to Concourse endpoint %s", userGUID, cnsiGUID)
+	}
+
+	return &concourseEndpoint{cnsiRecord: cnsiRecord, token: tokenRecord.AuthToken}, nil
+}
+
+// newRequest creates a request to the Concourse API
+func newRequest(cnsiRecord *interfaces.CNSIRecord, token, path string) (*http.Request, error) {
+	apiURL := strings.TrimRight(cnsiRecord.APIEndpoint.String(), "/") + path
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request for %s: %v", apiURL, err)
+	}
+
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "bearer "+token)
+	}
+	return req, nil
+}
+
+// get makes a request to the Concourse API
+func (c *ConcourseSpecification) get(cnsiRecord *interfaces.CNSIRecord, token, path string) ([]byte, error) {
+	req, err := newRequest(cnsiRecord, token, path)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := c.portalProxy.GetHttpClientForRequest(req, cnsiRecord.SkipSSLValidation)
+	res, err := client.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error performing Concourse request - response: %v, error: %v", res, err)
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// relay makes a request to the Concourse API on behalf of the user and sends them the response
+func (c *ConcourseSpecification) relay(ec echo.Context, userMsg string, pathFormat string, params ...string) error {
+	endpoint, err := c.getConcourseEndpoint(ec)
+	if err != nil {
+		return err
+	}
+
+	args := make([]interface{}, len(params))
+	for i, param := range params {
+		args[i] = url.PathEscape(param)
+	}
+
+	body, err := c.get(&endpoint.cnsiRecord, endpoint.token, fmt.Sprintf(pathFormat, args...))
+	if err != nil {
+		// Pass on client errors, such as an unknown pipeline or an expired token
+		status := http.StatusBadGateway
+		if httpErr, ok := err.(interfaces.ErrHTTPRequest); ok && httpErr.Status >= 400 && httpErr.Status < 500 {
+			status = httpErr.Status
+		}
+		return interfaces.NewHTTPShadowError(status, userMsg, "%s for Concourse endpoint %s: %v", userMsg, endpoint.cnsiRecord.GUID, err)
+	}
+
+	return ec.JSONBlob(http.StatusOK, body)
+}
+
+func getBuildIDParam(ec echo.Context) (string, error) {
+	buildID := ec.Param("buildId")
+	if _, err := strconv.Atoi(buildID); err != nil {
+		return "", interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Invalid build ID",
+			"Invalid build ID: %s", buildID)
+	}
+	return buildID, nil
+}
+
+// listTeams lists the teams that the user can see
+func (c *ConcourseSpecification) listTeams(ec echo.Context) error {
+	log.Debug("listTeams")
+	return c.relay(ec, "Unable to list teams", "/api/v1/teams")
+}
+
+// listPipelines lists the pipelines of a team
+func (c *ConcourseSpecification) listPipelines(ec echo.Context) error {
+	log.Debug("listPipelines")
+	return c.relay(ec, "Unable to list pipelines", "/api/v1/teams/%s/pipelines", ec.Param("team"))
+}
+
+// listJobs lists the jobs of a pipeline, along with the status of their latest builds
+func (c *ConcourseSpecification) listJobs(ec echo.Context) error {
+	log.Debug("listJobs")
+	return c.relay(ec, "Unable to list jobs", "/api/v1/teams/%s/pipelines/%s/jobs", ec.Param("team"), ec.Param("pipeline"))
+}
+
+// listJobBuilds lists the builds of a job, newest first
+func (c *ConcourseSpecification) listJobBuilds(ec echo.Context) error {
+	log.Debug("listJobBuilds")
+	path := "/api/v1/teams/%s/pipelines/%s/jobs/%s/builds"
+	if limit := ec.QueryParam("limit"); len(limit) > 0 {
+		if _, err := strconv.Atoi(limit); err != nil {
+			return interfaces.NewHTTPShadowError(http.StatusBadRequest, "Invalid limit", "Invalid limit: %s", limit)
+		}
+		path = path + "?limit=" + limit
+	}
+	return c.relay(ec, "Unable to list builds", path, ec.Param("team"), ec.Param("pipeline"), ec.Param("job"))
+}
+
+// getBuild gets a build
+func (c *ConcourseSpecification) getBuild(ec echo.Context) error {
+	log.Debug("getBuild")
+	buildID, err := getBuildIDParam(ec)
+	if err != nil {
+		return err
+	}
+	return c.relay(ec, "Unable to get build", "/api/v1/builds/%s", buildID)
+}
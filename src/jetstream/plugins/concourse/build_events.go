@@ -0,0 +1,158 @@
+package concourse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	// Concourse sends the events of a build as server-sent events, ending with an end event
+	buildEventType    = "event"
+	buildEndEventType = "end"
+
+	// Largest build event that will be relayed
+	maxBuildEventSize = 1024 * 1024
+
+	closeWriteTimeout = 5 * time.Second
+)
+
+// buildEvents relays the events of a build (its output and status changes) to the client over a WebSocket. The
+// WebSocket is closed once the build has finished and all of its events have been sent
+func (c *ConcourseSpecification) buildEvents(ec echo.Context) error {
+	log.Debug("buildEvents")
+	endpoint, err := c.getConcourseEndpoint(ec)
+	if err != nil {
+		return err
+	}
+
+	buildID, err := getBuildIDParam(ec)
+	if err != nil {
+		return err
+	}
+
+	events, err := c.openBuildEvents(endpoint, buildID)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	clientWebSocket, pingTicker, err := interfaces.UpgradeToWebSocket(ec)
+	if err != nil {
+		return err
+	}
+	defer clientWebSocket.Close()
+	defer pingTicker.Stop()
+
+	log.Infof("Now streaming events for build %s - on Concourse endpoint: %s", buildID, endpoint.cnsiRecord.GUID)
+	go relayBuildEvents(events, clientWebSocket)
+
+	// This blocks until the WebSocket is closed
+	drainClientMessages(clientWebSocket)
+	return nil
+}
+
+// openBuildEvents opens the event stream of a build
+func (c *ConcourseSpecification) openBuildEvents(endpoint *concourseEndpoint, buildID string) (io.ReadCloser, error) {
+	req, err := newRequest(&endpoint.cnsiRecord, endpoint.token, fmt.Sprintf("/api/v1/builds/%s/events", buildID))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream stays open for as long as the build runs, so must not time out
+	client := c.portalProxy.GetHttpClient(endpoint.cnsiRecord.SkipSSLValidation)
+	client.Timeout = 0
+
+	res, err := client.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error opening Concourse build events - response: %v, error: %v", res, err)
+		status := http.StatusBadGateway
+		if res != nil && res.StatusCode >= 400 && res.StatusCode < 500 {
+			status = res.StatusCode
+		}
+		return nil, interfaces.NewHTTPShadowError(
+			status,
+			"Unable to get build events",
+			"Unable to get events of build %s: %v", buildID, interfaces.LogHTTPError(res, err))
+	}
+
+	return res.Body, nil
+}
+
+// relayBuildEvents sends the data of each build event to the client as a JSON message
+func relayBuildEvents(events io.ReadCloser, clientWebSocket *websocket.Conn) {
+	scanner := bufio.NewScanner(events)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBuildEventSize)
+
+	var eventType string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A blank line ends an event
+		if len(line) == 0 {
+			switch eventType {
+			case buildEventType:
+				err := clientWebSocket.WriteMessage(websocket.TextMessage, []byte(strings.Join(data, "\n")))
+				if err != nil {
+					log.Errorf("Error writing data to WebSocket, %v", err)
+					return
+				}
+			case buildEndEventType:
+				closeWebSocket(clientWebSocket, "Build finished")
+				return
+			}
+			eventType = ""
+			data = nil
+			continue
+		}
+
+		field, value := parseEventLine(line)
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Warnf("Error reading Concourse build events: %v", err)
+	}
+	closeWebSocket(clientWebSocket, "Build event stream closed")
+}
+
+// parseEventLine splits a line of a server-sent event into its field and value
+func parseEventLine(line string) (string, string) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimPrefix(parts[1], " ")
+}
+
+func closeWebSocket(clientWebSocket *websocket.Conn, reason string) {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	clientWebSocket.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWriteTimeout))
+}
+
+// Drain and discard incoming messages from the WebSocket client, effectively making our WebSocket read-only
+func drainClientMessages(clientWebSocket *websocket.Conn) {
+	for {
+		_, _, err := clientWebSocket.ReadMessage()
+		if err != nil {
+			// We get here when the client (browser) disconnects
+			break
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package concourse
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseEventLine(t *testing.T) {
+	t.Parallel()
+
+	Convey("Parsing lines of server-sent events", t, func() {
+		Convey("Should split the field from the value", func() {
+			field, value := parseEventLine("event: end")
+			So(field, ShouldEqual, "event")
+			So(value, ShouldEqual, "end")
+		})
+
+		Convey("Should only remove a single leading space and keep any further colons", func() {
+			field, value := parseEventLine(`data:  {"time":"10:00"}`)
+			So(field, ShouldEqual, "data")
+			So(value, ShouldEqual, ` {"time":"10:00"}`)
+		})
+
+		Convey("Should treat a line without a colon as a field with no value", func() {
+			field, value := parseEventLine("data")
+			So(field, ShouldEqual, "data")
+			So(value, ShouldEqual, "")
+		})
+
+		Convey("Should treat a comment as a field with no name", func() {
+			field, _ := parseEventLine(": keep-alive")
+			So(field, ShouldEqual, "")
+		})
+	})
+}
+
+// relayTestBuildEvents relays the events sent by a Concourse stand-in to a WebSocket and returns the messages and the
+// close reason received by the WebSocket client
+func relayTestBuildEvents(events string) ([]string, string, error) {
+	concourse := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, events)
+	}))
+	defer concourse.Close()
+
+	upgrader := websocket.Upgrader{}
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientWebSocket, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer clientWebSocket.Close()
+
+		res, err := http.Get(concourse.URL)
+		if err != nil {
+			return
+		}
+		defer res.Body.Close()
+
+		relayBuildEvents(res.Body, clientWebSocket)
+		drainClientMessages(clientWebSocket)
+	}))
+	defer relay.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(relay.URL, "http"), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer conn.Close()
+
+	var messages []string
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				return messages, closeErr.Text, nil
+			}
+			return messages, "", err
+		}
+		messages = append(messages, string(data))
+	}
+}
+
+func TestRelayBuildEvents(t *testing.T) {
+	t.Parallel()
+
+	Convey("Relaying build events", t, func() {
+		Convey("Should send the data of each build event until the build ends", func() {
+			messages, reason, err := relayTestBuildEvents(
+				"id: 1\nevent: event\ndata: {\"event\":\"log\",\ndata: \"payload\":\"hello\"}\n\n" +
+					": keep-alive\n\n" +
+					"event: event\ndata: {\"event\":\"status\"}\n\n" +
+					"event: end\ndata\n\n" +
+					"event: event\ndata: {\"event\":\"ignored\"}\n\n")
+			So(err, ShouldBeNil)
+			So(messages, ShouldResemble, []string{"{\"event\":\"log\",\n\"payload\":\"hello\"}", "{\"event\":\"status\"}"})
+			So(reason, ShouldEqual, "Build finished")
+		})
+
+		Convey("Should close the WebSocket when the event stream closes before the build ends", func() {
+			messages, reason, err := relayTestBuildEvents("event: event\ndata: {\"event\":\"log\"}\n\n")
+			So(err, ShouldBeNil)
+			So(messages, ShouldResemble, []string{"{\"event\":\"log\"}"})
+			So(reason, ShouldEqual, "Build event stream closed")
+		})
+
+		Convey("Should not send an event that has not been ended by a blank line", func() {
+			messages, reason, err := relayTestBuildEvents("event: event\ndata: {\"event\":\"partial\"}\n")
+			So(err, ShouldBeNil)
+			So(messages, ShouldBeEmpty)
+			So(reason, ShouldEqual, "Build event stream closed")
+		})
+	})
+}
@@ -0,0 +1,241 @@
+package concourse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConcourseSpecification is a plugin to support the Concourse CI endpoint type
+type ConcourseSpecification struct {
+	portalProxy  interfaces.PortalProxy
+	endpointType string
+}
+
+const (
+	EndpointType = "concourse"
+
+	infoPath = "/api/v1/info"
+	userPath = "/api/v1/user"
+
+	// Concourse 7 serves the token endpoint of its OIDC issuer, older versions serve their own
+	issuerTokenPath = "/sky/issuer/token"
+	skyTokenPath    = "/sky/token"
+	skyLoginPath    = "/sky/login"
+
+	// Concourse only issues tokens to its own client, which is the one used by fly
+	flyClientID     = "fly"
+	flyClientSecret = "Zmx5"
+	tokenScope      = "openid profile email federated:id groups"
+)
+
+// ConcourseInfo is the response to the Concourse info request
+type ConcourseInfo struct {
+	Version       string `json:"version"`
+	WorkerVersion string `json:"worker_version"`
+	ExternalURL   string `json:"external_url,omitempty"`
+	ClusterName   string `json:"cluster_name,omitempty"`
+}
+
+// Init creates a new ConcourseSpecification
+func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
+	return &ConcourseSpecification{portalProxy: portalProxy, endpointType: EndpointType}, nil
+}
+
+// GetEndpointPlugin gets the endpoint plugin for this plugin
+func (c *ConcourseSpecification) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return c, nil
+}
+
+// GetRoutePlugin gets the route plugin for this plugin
+func (c *ConcourseSpecification) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return c, nil
+}
+
+// GetMiddlewarePlugin gets the middleware plugin for this plugin
+func (c *ConcourseSpecification) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
+func (c *ConcourseSpecification) AddAdminGroupRoutes(echoGroup *echo.Group) {
+	// no-op
+}
+
+// AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
+func (c *ConcourseSpecification) AddSessionGroupRoutes(echoGroup *echo.Group) {
+	echoGroup.GET("/concourse/:cnsiGuid/teams", c.listTeams)
+	echoGroup.GET("/concourse/:cnsiGuid/teams/:team/pipelines", c.listPipelines)
+	echoGroup.GET("/concourse/:cnsiGuid/teams/:team/pipelines/:pipeline/jobs", c.listJobs)
+	echoGroup.GET("/concourse/:cnsiGuid/teams/:team/pipelines/:pipeline/jobs/:job/builds", c.listJobBuilds)
+	echoGroup.GET("/concourse/:cnsiGuid/builds/:buildId", c.getBuild)
+	echoGroup.GET("/concourse/:cnsiGuid/builds/:buildId/events", c.buildEvents)
+}
+
+// Init performs plugin initialization
+func (c *ConcourseSpecification) Init() error {
+	return nil
+}
+
+func (c *ConcourseSpecification) GetType() string {
+	return EndpointType
+}
+
+func (c *ConcourseSpecification) Register(echoContext echo.Context) error {
+	log.Debug("Concourse Register...")
+	return c.portalProxy.RegisterEndpoint(echoContext, c.Info)
+}
+
+// Connect connects to Concourse as a local (or LDAP) user, or with a bearer token.
+//
+// There is no UAA-backed (or other OAuth provider) connect flow. Concourse only issues tokens for those users through
+// its browser login, which hands the token to fly on a localhost port and does not redirect to any other client, so
+// Stratos has no way to receive it. Those users connect with the token from `fly login` (the `token` in ~/.flyrc)
+func (c *ConcourseSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
+	log.Debug("Concourse Connect...")
+
+	var tr *interfaces.TokenRecord
+	var err error
+
+	connectType := ec.FormValue("connect_type")
+	switch connectType {
+	case interfaces.AuthConnectTypeCreds:
+		tr, err = c.connectWithCreds(ec, cnsiRecord)
+	case interfaces.AuthConnectTypeBearer:
+		tr, err = c.portalProxy.ConnectBearer(ec, cnsiRecord)
+	default:
+		return nil, false, errors.New("Only username/password or bearer token is accepted for Concourse endpoints - users that log in through UAA can connect with the token from fly login")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Check that Concourse accepts the token
+	if _, err = c.get(&cnsiRecord, tr.AuthToken, userPath); err != nil {
+		return nil, false, err
+	}
+
+	return tr, false, nil
+}
+
+// tokenResponse is the response to a Concourse token request
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// connectWithCreds gets a token for the user with Concourse's password grant. The token can not be refreshed, so the
+// user will need to reconnect once it expires
+func (c *ConcourseSpecification) connectWithCreds(ec echo.Context, cnsiRecord interfaces.CNSIRecord) (*interfaces.TokenRecord, error) {
+	username := ec.FormValue("username")
+	password := ec.FormValue("password")
+
+	if len(username) == 0 || len(password) == 0 {
+		return nil, errors.New("Need username and password")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "password")
+	form.Set("username", username)
+	form.Set("password", password)
+	form.Set("scope", tokenScope)
+
+	req, err := http.NewRequest("POST", cnsiRecord.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Concourse token request: %v", err)
+	}
+	req.SetBasicAuth(flyClientID, flyClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	h := c.portalProxy.GetHttpClientForRequest(req, cnsiRecord.SkipSSLValidation)
+	res, err := h.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error performing Concourse token request - response: %v, error: %v", res, err)
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	tokenRes := &tokenResponse{}
+	if err = json.NewDecoder(res.Body).Decode(tokenRes); err != nil {
+		return nil, fmt.Errorf("Unable to parse Concourse token response: %v", err)
+	}
+
+	// Concourse 7 authenticates requests with the ID token issued by its OIDC issuer
+	token := tokenRes.AccessToken
+	if strings.HasSuffix(cnsiRecord.TokenEndpoint, issuerTokenPath) && len(tokenRes.IDToken) > 0 {
+		token = tokenRes.IDToken
+	}
+	if len(token) == 0 {
+		return nil, errors.New("Concourse did not return a token")
+	}
+
+	var expiry int64
+	if tokenRes.ExpiresIn > 0 {
+		expiry = time.Now().Unix() + tokenRes.ExpiresIn
+	} else if u, err := c.portalProxy.GetUserTokenInfo(token); err == nil {
+		expiry = u.TokenExpiry
+	}
+
+	return &interfaces.TokenRecord{
+		AuthToken:   token,
+		TokenExpiry: expiry,
+		AuthType:    interfaces.AuthTypeBearer,
+	}, nil
+}
+
+// Info checks that the endpoint is Concourse and determines which token endpoint its version uses
+func (c *ConcourseSpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	log.Debug("Concourse Info")
+	var newCNSI interfaces.CNSIRecord
+
+	newCNSI.CNSIType = EndpointType
+
+	endpointURL, err := url.Parse(apiEndpoint)
+	if err != nil {
+		return newCNSI, nil, err
+	}
+
+	newCNSI.APIEndpoint = endpointURL
+	newCNSI.SkipSSLValidation = skipSSLValidation
+	body, err := c.get(&newCNSI, "", infoPath)
+	if err != nil {
+		return newCNSI, nil, err
+	}
+
+	info := ConcourseInfo{}
+	if err = json.Unmarshal(body, &info); err != nil || len(info.Version) == 0 {
+		return newCNSI, nil, fmt.Errorf("Endpoint %s does not appear to be Concourse - unexpected response to %s", apiEndpoint, infoPath)
+	}
+
+	baseURL := strings.TrimRight(apiEndpoint, "/")
+	newCNSI.AuthorizationEndpoint = baseURL + skyLoginPath
+	if majorVersion(info.Version) >= 7 {
+		newCNSI.TokenEndpoint = baseURL + issuerTokenPath
+	} else {
+		newCNSI.TokenEndpoint = baseURL + skyTokenPath
+	}
+
+	return newCNSI, info, nil
+}
+
+func majorVersion(version string) int {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return 0
+	}
+	return major
+}
+
+func (c *ConcourseSpecification) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
+}
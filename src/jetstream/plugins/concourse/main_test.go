@@ -0,0 +1,94 @@
+package concourse
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// mockPortalProxy provides the HTTP clients used by the plugin. Any other use of the portal proxy is unexpected
+type mockPortalProxy struct {
+	interfaces.PortalProxy
+}
+
+func (p *mockPortalProxy) GetHttpClient(skipSSLValidation bool) http.Client {
+	return http.Client{}
+}
+
+func (p *mockPortalProxy) GetHttpClientForRequest(req *http.Request, skipSSLValidation bool) http.Client {
+	return http.Client{}
+}
+
+// startTestConcourse starts a server that responds to the info request with the given version
+func startTestConcourse(version string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != infoPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"version":"%s","worker_version":"2.3"}`, version)
+	}))
+}
+
+func TestInfo(t *testing.T) {
+	t.Parallel()
+
+	Convey("Concourse info", t, func() {
+		c := &ConcourseSpecification{portalProxy: &mockPortalProxy{}, endpointType: EndpointType}
+
+		Convey("Should use the token endpoint of the OIDC issuer from Concourse 7", func() {
+			server := startTestConcourse("7.4.0")
+			defer server.Close()
+
+			cnsi, info, err := c.Info(server.URL+"/", false)
+			So(err, ShouldBeNil)
+			So(cnsi.CNSIType, ShouldEqual, EndpointType)
+			So(cnsi.AuthorizationEndpoint, ShouldEqual, server.URL+skyLoginPath)
+			So(cnsi.TokenEndpoint, ShouldEqual, server.URL+issuerTokenPath)
+			So(info.(ConcourseInfo).Version, ShouldEqual, "7.4.0")
+		})
+
+		Convey("Should use the sky token endpoint before Concourse 7", func() {
+			server := startTestConcourse("6.7.1")
+			defer server.Close()
+
+			cnsi, _, err := c.Info(server.URL, false)
+			So(err, ShouldBeNil)
+			So(cnsi.TokenEndpoint, ShouldEqual, server.URL+skyTokenPath)
+		})
+
+		Convey("Should reject an endpoint that is not Concourse", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"name":"something else"}`)
+			}))
+			defer server.Close()
+
+			_, _, err := c.Info(server.URL, false)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should fail if the info can not be fetched", func() {
+			server := httptest.NewServer(http.NotFoundHandler())
+			defer server.Close()
+
+			_, _, err := c.Info(server.URL, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMajorVersion(t *testing.T) {
+	t.Parallel()
+
+	Convey("Concourse major version", t, func() {
+		So(majorVersion("7.4.0"), ShouldEqual, 7)
+		So(majorVersion("6"), ShouldEqual, 6)
+		So(majorVersion("v7.0.0"), ShouldEqual, 0)
+		So(majorVersion(""), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,218 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// GitSpecification is a plugin to support git hosting endpoints (GitHub and GitLab)
+type GitSpecification struct {
+	portalProxy  interfaces.PortalProxy
+	endpointType string
+}
+
+const (
+	EndpointType = "git"
+
+	// Connect type for tokens obtained through the git host's OAuth authorization code flow
+	connectTypeOAuthCode = "oauth_code"
+
+	// Key of the endpoint metadata that reports the git host's type
+	gitTypeMetadataKey = "git_type"
+)
+
+// Init creates a new GitSpecification
+func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
+	return &GitSpecification{portalProxy: portalProxy, endpointType: EndpointType}, nil
+}
+
+// GetEndpointPlugin gets the endpoint plugin for this plugin
+func (g *GitSpecification) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return g, nil
+}
+
+// GetRoutePlugin gets the route plugin for this plugin
+func (g *GitSpecification) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return g, nil
+}
+
+// GetMiddlewarePlugin gets the middleware plugin for this plugin
+func (g *GitSpecification) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
+func (g *GitSpecification) AddAdminGroupRoutes(echoGroup *echo.Group) {
+	// no-op
+}
+
+// AddSessionGroupRoutes adds the session routes for this plugin to the Echo server.
+// Repositories are identified by their full name (e.g. owner/repo), which is passed as the repo query parameter
+func (g *GitSpecification) AddSessionGroupRoutes(echoGroup *echo.Group) {
+	echoGroup.GET("/git/:cnsiGuid/repos", g.listRepositories)
+	echoGroup.GET("/git/:cnsiGuid/branches", g.listBranches)
+	echoGroup.GET("/git/:cnsiGuid/commits", g.listCommits)
+}
+
+// Init performs plugin initialization
+func (g *GitSpecification) Init() error {
+	return nil
+}
+
+func (g *GitSpecification) GetType() string {
+	return EndpointType
+}
+
+// Register registers a git host. The endpoint is the web URL of the host, e.g. https://github.com or the URL of a
+// GitHub Enterprise or self-managed GitLab server. The type of the host (git_type) must be supplied unless it can be
+// determined from the URL
+func (g *GitSpecification) Register(echoContext echo.Context) error {
+	log.Debug("Git Register...")
+
+	gitType := echoContext.FormValue("git_type")
+	info := func(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+		return g.info(apiEndpoint, gitType)
+	}
+
+	return g.portalProxy.RegisterEndpoint(echoContext, info)
+}
+
+// Connect connects to the git host with a personal access token, or with a code from the host's OAuth flow
+func (g *GitSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
+	log.Debug("Git Connect...")
+
+	host, err := getGitProvider(&cnsiRecord)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var tr *interfaces.TokenRecord
+	connectType := ec.FormValue("connect_type")
+	switch connectType {
+	case interfaces.AuthConnectTypeBearer:
+		tr, err = g.portalProxy.ConnectBearer(ec, cnsiRecord)
+	case connectTypeOAuthCode:
+		tr, err = g.connectOAuthCode(ec, cnsiRecord)
+	default:
+		return nil, false, errors.New("Only personal access tokens or OAuth authorization codes are accepted for git endpoints")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Check that the token can be used with the host's API
+	if _, err = g.get(&cnsiRecord, host, tr.AuthToken, host.userPath()); err != nil {
+		return nil, false, err
+	}
+
+	return tr, false, nil
+}
+
+// oauthTokenResponse is the response to an OAuth token request. GitHub reports errors with a 200 status
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// connectOAuthCode exchanges an authorization code for a token, using the OAuth application that the endpoint was
+// registered with. The tokens are passed through as bearer tokens, so the user will need to reconnect once they expire
+func (g *GitSpecification) connectOAuthCode(ec echo.Context, cnsiRecord interfaces.CNSIRecord) (*interfaces.TokenRecord, error) {
+	code := ec.FormValue("code")
+	if len(code) == 0 {
+		return nil, errors.New("Needs OAuth authorization code")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", cnsiRecord.ClientId)
+	form.Set("client_secret", cnsiRecord.ClientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", ec.FormValue("redirect_uri"))
+
+	req, err := http.NewRequest("POST", cnsiRecord.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create OAuth token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	h := g.portalProxy.GetHttpClientForRequest(req, cnsiRecord.SkipSSLValidation)
+	res, err := h.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error performing OAuth token request - response: %v, error: %v", res, err)
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	tokenRes := &oauthTokenResponse{}
+	if err = json.NewDecoder(res.Body).Decode(tokenRes); err != nil {
+		return nil, fmt.Errorf("Unable to parse OAuth token response: %v", err)
+	}
+	if len(tokenRes.Error) > 0 || len(tokenRes.AccessToken) == 0 {
+		return nil, fmt.Errorf("OAuth token request failed: %s %s", tokenRes.Error, tokenRes.ErrorDescription)
+	}
+
+	var expiry int64
+	if tokenRes.ExpiresIn > 0 {
+		expiry = time.Now().Unix() + tokenRes.ExpiresIn
+	}
+
+	return &interfaces.TokenRecord{
+		AuthToken:   tokenRes.AccessToken,
+		TokenExpiry: expiry,
+		AuthType:    interfaces.AuthTypeBearer,
+	}, nil
+}
+
+// Info determines the type of the git host from its URL
+func (g *GitSpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	return g.info(apiEndpoint, "")
+}
+
+func (g *GitSpecification) info(apiEndpoint, gitType string) (interfaces.CNSIRecord, interface{}, error) {
+	log.Debug("Git Info")
+	var newCNSI interfaces.CNSIRecord
+
+	newCNSI.CNSIType = EndpointType
+
+	endpointURL, err := url.Parse(apiEndpoint)
+	if err != nil {
+		return newCNSI, nil, err
+	}
+
+	host, err := findGitProvider(endpointURL, gitType)
+	if err != nil {
+		return newCNSI, nil, err
+	}
+
+	// The endpoints of the host's OAuth flow - these also identify the type of host
+	newCNSI.AuthorizationEndpoint = host.authorizationURL(endpointURL)
+	newCNSI.TokenEndpoint = host.tokenURL(endpointURL)
+
+	return newCNSI, nil, nil
+}
+
+// UpdateMetadata adds the type of each git host to its metadata
+func (g *GitSpecification) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
+	endpoints, ok := info.Endpoints[EndpointType]
+	if !ok {
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		if host, err := getGitProvider(endpoint.CNSIRecord); err == nil {
+			endpoint.Metadata[gitTypeMetadataKey] = host.name()
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// Types of git host
+const (
+	gitHubType = "github"
+	gitLabType = "gitlab"
+)
+
+// Repository is a repository on a git host
+type Repository struct {
+	Name          string    `json:"name"`
+	FullName      string    `json:"full_name"`
+	Description   string    `json:"description"`
+	Private       bool      `json:"private"`
+	DefaultBranch string    `json:"default_branch"`
+	CloneURL      string    `json:"clone_url"`
+	WebURL        string    `json:"web_url"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Branch is a branch of a repository
+type Branch struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit"`
+}
+
+// Commit is a commit on a branch of a repository
+type Commit struct {
+	SHA     string    `json:"sha"`
+	Message string    `json:"message"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	WebURL  string    `json:"web_url"`
+}
+
+// gitProvider knows how to use the API of a type of git host
+type gitProvider interface {
+	name() string
+	apiURL(endpoint *url.URL) string
+	authorizationURL(endpoint *url.URL) string
+	tokenURL(endpoint *url.URL) string
+	// Username to use alongside the token when cloning over https
+	cloneUsername() string
+
+	userPath() string
+	repositoriesPath(page, perPage int) string
+	branchesPath(repo string) string
+	commitsPath(repo, branch string, page, perPage int) string
+
+	parseRepositories(body []byte) ([]*Repository, error)
+	parseBranches(body []byte) ([]*Branch, error)
+	parseCommits(body []byte) ([]*Commit, error)
+}
+
+var gitProviders = map[string]gitProvider{
+	gitHubType: &gitHub{},
+	gitLabType: &gitLab{},
+}
+
+// findGitProvider gets the provider for the given type of git host, or determines it from the host's URL
+func findGitProvider(endpoint *url.URL, gitType string) (gitProvider, error) {
+	if len(gitType) == 0 {
+		host := strings.ToLower(endpoint.Hostname())
+		switch {
+		case host == "github.com" || strings.HasPrefix(host, "github."):
+			gitType = gitHubType
+		case host == "gitlab.com" || strings.HasPrefix(host, "gitlab."):
+			gitType = gitLabType
+		default:
+			return nil, fmt.Errorf("Unable to determine the type of git host %s - type must be one of %s or %s", endpoint.Host, gitHubType, gitLabType)
+		}
+	}
+
+	provider, ok := gitProviders[gitType]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported type of git host: %s", gitType)
+	}
+	return provider, nil
+}
+
+// getGitProvider gets the provider for a registered git host. The type of host is identified by its token endpoint
+func getGitProvider(cnsiRecord *interfaces.CNSIRecord) (gitProvider, error) {
+	for _, provider := range gitProviders {
+		if cnsiRecord.TokenEndpoint == provider.tokenURL(cnsiRecord.APIEndpoint) {
+			return provider, nil
+		}
+	}
+	return nil, fmt.Errorf("Unable to determine the type of git endpoint %s", cnsiRecord.Name)
+}
+
+func trimmedURL(endpoint *url.URL) string {
+	return strings.TrimRight(endpoint.String(), "/")
+}
+
+func pageQuery(page, perPage int) string {
+	return fmt.Sprintf("page=%d&per_page=%d", page, perPage)
+}
+
+type gitHub struct{}
+
+func (h *gitHub) name() string {
+	return gitHubType
+}
+
+// apiURL gets the URL of the API - github.com has a separate API host, GitHub Enterprise serves it under /api/v3
+func (h *gitHub) apiURL(endpoint *url.URL) string {
+	if strings.ToLower(endpoint.Hostname()) == "github.com" {
+		return "https://api.github.com"
+	}
+	return trimmedURL(endpoint) + "/api/v3"
+}
+
+func (h *gitHub) authorizationURL(endpoint *url.URL) string {
+	return trimmedURL(endpoint) + "/login/oauth/authorize"
+}
+
+func (h *gitHub) tokenURL(endpoint *url.URL) string {
+	return trimmedURL(endpoint) + "/login/oauth/access_token"
+}
+
+func (h *gitHub) cloneUsername() string {
+	return "x-access-token"
+}
+
+func (h *gitHub) userPath() string {
+	return "/user"
+}
+
+func (h *gitHub) repositoriesPath(page, perPage int) string {
+	return "/user/repos?sort=updated&" + pageQuery(page, perPage)
+}
+
+func (h *gitHub) branchesPath(repo string) string {
+	return fmt.Sprintf("/repos/%s/branches?per_page=100", repo)
+}
+
+func (h *gitHub) commitsPath(repo, branch string, page, perPage int) string {
+	return fmt.Sprintf("/repos/%s/commits?sha=%s&%s", repo, url.QueryEscape(branch), pageQuery(page, perPage))
+}
+
+type gitHubRepository struct {
+	Name          string    `json:"name"`
+	FullName      string    `json:"full_name"`
+	Description   string    `json:"description"`
+	Private       bool      `json:"private"`
+	DefaultBranch string    `json:"default_branch"`
+	CloneURL      string    `json:"clone_url"`
+	HTMLURL       string    `json:"html_url"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (h *gitHub) parseRepositories(body []byte) ([]*Repository, error) {
+	var gitHubRepos []*gitHubRepository
+	if err := json.Unmarshal(body, &gitHubRepos); err != nil {
+		return nil, err
+	}
+
+	repos := make([]*Repository, 0, len(gitHubRepos))
+	for _, r := range gitHubRepos {
+		repos = append(repos, &Repository{
+			Name:          r.Name,
+			FullName:      r.FullName,
+			Description:   r.Description,
+			Private:       r.Private,
+			DefaultBranch: r.DefaultBranch,
+			CloneURL:      r.CloneURL,
+			WebURL:        r.HTMLURL,
+			UpdatedAt:     r.UpdatedAt,
+		})
+	}
+	return repos, nil
+}
+
+type gitHubBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+func (h *gitHub) parseBranches(body []byte) ([]*Branch, error) {
+	var gitHubBranches []*gitHubBranch
+	if err := json.Unmarshal(body, &gitHubBranches); err != nil {
+		return nil, err
+	}
+
+	branches := make([]*Branch, 0, len(gitHubBranches))
+	for _, b := range gitHubBranches {
+		branches = append(branches, &Branch{Name: b.Name, Commit: b.Commit.SHA})
+	}
+	return branches, nil
+}
+
+type gitHubCommit struct {
+	SHA     string `json:"sha"`
+	HTMLURL string `json:"html_url"`
+	Commit  struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name string    `json:"name"`
+			Date time.Time `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+}
+
+func (h *gitHub) parseCommits(body []byte) ([]*Commit, error) {
+	var gitHubCommits []*gitHubCommit
+	if err := json.Unmarshal(body, &gitHubCommits); err != nil {
+		return nil, err
+	}
+
+	commits := make([]*Commit, 0, len(gitHubCommits))
+	for _, c := range gitHubCommits {
+		commits = append(commits, &Commit{
+			SHA:     c.SHA,
+			Message: c.Commit.Message,
+			Author:  c.Commit.Author.Name,
+			Date:    c.Commit.Author.Date,
+			WebURL:  c.HTMLURL,
+		})
+	}
+	return commits, nil
+}
+
+type gitLab struct{}
+
+func (l *gitLab) name() string {
+	return gitLabType
+}
+
+func (l *gitLab) apiURL(endpoint *url.URL) string {
+	return trimmedURL(endpoint) + "/api/v4"
+}
+
+func (l *gitLab) authorizationURL(endpoint *url.URL) string {
+	return trimmedURL(endpoint) + "/oauth/authorize"
+}
+
+func (l *gitLab) tokenURL(endpoint *url.URL) string {
+	return trimmedURL(endpoint) + "/oauth/token"
+}
+
+func (l *gitLab) cloneUsername() string {
+	return "oauth2"
+}
+
+func (l *gitLab) userPath() string {
+	return "/user"
+}
+
+func (l *gitLab) repositoriesPath(page, perPage int) string {
+	return "/projects?membership=true&order_by=last_activity_at&" + pageQuery(page, perPage)
+}
+
+// Projects are identified by their URL encoded path
+func (l *gitLab) branchesPath(repo string) string {
+	return fmt.Sprintf("/projects/%s/repository/branches?per_page=100", url.PathEscape(repo))
+}
+
+func (l *gitLab) commitsPath(repo, branch string, page, perPage int) string {
+	return fmt.Sprintf("/projects/%s/repository/commits?ref_name=%s&%s", url.PathEscape(repo), url.QueryEscape(branch), pageQuery(page, perPage))
+}
+
+type gitLabProject struct {
+	Name              string    `json:"name"`
+	PathWithNamespace string    `json:"path_with_namespace"`
+	Description       string    `json:"description"`
+	Visibility        string    `json:"visibility"`
+	DefaultBranch     string    `json:"default_branch"`
+	HTTPURLToRepo     string    `json:"http_url_to_repo"`
+	WebURL            string    `json:"web_url"`
+	LastActivityAt    time.Time `json:"last_activity_at"`
+}
+
+func (l *gitLab) parseRepositories(body []byte) ([]*Repository, error) {
+	var projects []*gitLabProject
+	if err := json.Unmarshal(body, &projects); err != nil {
+		return nil, err
+	}
+
+	repos := make([]*Repository, 0, len(projects))
+	for _, p := range projects {
+		repos = append(repos, &Repository{
+			Name:          p.Name,
+			FullName:      p.PathWithNamespace,
+			Description:   p.Description,
+			Private:       p.Visibility != "public",
+			DefaultBranch: p.DefaultBranch,
+			CloneURL:      p.HTTPURLToRepo,
+			WebURL:        p.WebURL,
+			UpdatedAt:     p.LastActivityAt,
+		})
+	}
+	return repos, nil
+}
+
+type gitLabBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+func (l *gitLab) parseBranches(body []byte) ([]*Branch, error) {
+	var gitLabBranches []*gitLabBranch
+	if err := json.Unmarshal(body, &gitLabBranches); err != nil {
+		return nil, err
+	}
+
+	branches := make([]*Branch, 0, len(gitLabBranches))
+	for _, b := range gitLabBranches {
+		branches = append(branches, &Branch{Name: b.Name, Commit: b.Commit.ID})
+	}
+	return branches, nil
+}
+
+type gitLabCommit struct {
+	ID         string    `json:"id"`
+	Message    string    `json:"message"`
+	AuthorName string    `json:"author_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	WebURL     string    `json:"web_url"`
+}
+
+func (l *gitLab) parseCommits(body []byte) ([]*Commit, error) {
+	var gitLabCommits []*gitLabCommit
+	if err := json.Unmarshal(body, &gitLabCommits); err != nil {
+		return nil, err
+	}
+
+	commits := make([]*Commit, 0, len(gitLabCommits))
+	for _, c := range gitLabCommits {
+		commits = append(commits, &Commit{
+			SHA:     c.ID,
+			Message: c.Message,
+			Author:  c.AuthorName,
+			Date:    c.CreatedAt,
+			WebURL:  c.WebURL,
+		})
+	}
+	return commits, nil
+}
@@ -0,0 +1,196 @@
+package git
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func mustParseURL(rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestFindGitProvider(t *testing.T) {
+	t.Parallel()
+
+	Convey("Finding the type of a git host", t, func() {
+		Convey("Should detect github.com and use its separate API host", func() {
+			provider, err := findGitProvider(mustParseURL("https://github.com"), "")
+			So(err, ShouldBeNil)
+			So(provider.name(), ShouldEqual, gitHubType)
+			So(provider.apiURL(mustParseURL("https://github.com/")), ShouldEqual, "https://api.github.com")
+		})
+
+		Convey("Should detect GitHub Enterprise and use the API under its own host", func() {
+			endpoint := mustParseURL("https://GitHub.example.com/")
+			provider, err := findGitProvider(endpoint, "")
+			So(err, ShouldBeNil)
+			So(provider.name(), ShouldEqual, gitHubType)
+			So(provider.apiURL(endpoint), ShouldEqual, "https://GitHub.example.com/api/v3")
+		})
+
+		Convey("Should detect GitLab", func() {
+			for _, endpoint := range []string{"https://gitlab.com", "https://gitlab.example.com:8443"} {
+				provider, err := findGitProvider(mustParseURL(endpoint), "")
+				So(err, ShouldBeNil)
+				So(provider.name(), ShouldEqual, gitLabType)
+				So(provider.apiURL(mustParseURL(endpoint)), ShouldEqual, endpoint+"/api/v4")
+			}
+		})
+
+		Convey("Should use the given type of a host that can not be detected", func() {
+			provider, err := findGitProvider(mustParseURL("https://code.example.com"), gitLabType)
+			So(err, ShouldBeNil)
+			So(provider.name(), ShouldEqual, gitLabType)
+		})
+
+		Convey("Should fail for a host that can not be detected without a type", func() {
+			_, err := findGitProvider(mustParseURL("https://code.example.com"), "")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should fail for an unsupported type", func() {
+			_, err := findGitProvider(mustParseURL("https://code.example.com"), "bitbucket")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGetGitProvider(t *testing.T) {
+	t.Parallel()
+
+	Convey("Getting the type of a registered git host", t, func() {
+		g := &GitSpecification{}
+
+		for _, test := range []struct{ endpoint, gitType, expected string }{
+			{"https://github.com", "", gitHubType},
+			{"https://github.example.com", "", gitHubType},
+			{"https://gitlab.com", "", gitLabType},
+			{"https://code.example.com", gitLabType, gitLabType},
+		} {
+			cnsiRecord, _, err := g.info(test.endpoint, test.gitType)
+			So(err, ShouldBeNil)
+			cnsiRecord.APIEndpoint = mustParseURL(test.endpoint)
+
+			provider, err := getGitProvider(&cnsiRecord)
+			So(err, ShouldBeNil)
+			So(provider.name(), ShouldEqual, test.expected)
+		}
+
+		Convey("Should fail for an endpoint with an unknown token endpoint", func() {
+			_, err := getGitProvider(&interfaces.CNSIRecord{
+				APIEndpoint:   mustParseURL("https://github.com"),
+				TokenEndpoint: "https://github.com/token",
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGitHubParsers(t *testing.T) {
+	t.Parallel()
+
+	Convey("Parsing GitHub responses", t, func() {
+		h := &gitHub{}
+
+		Convey("Should parse repositories", func() {
+			repos, err := h.parseRepositories([]byte(`[{"name":"repo","full_name":"owner/repo","description":"A repo","private":true,
+				"default_branch":"main","clone_url":"https://github.com/owner/repo.git","html_url":"https://github.com/owner/repo",
+				"updated_at":"2020-01-02T03:04:05Z"}]`))
+			So(err, ShouldBeNil)
+			So(repos, ShouldResemble, []*Repository{{
+				Name:          "repo",
+				FullName:      "owner/repo",
+				Description:   "A repo",
+				Private:       true,
+				DefaultBranch: "main",
+				CloneURL:      "https://github.com/owner/repo.git",
+				WebURL:        "https://github.com/owner/repo",
+				UpdatedAt:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			}})
+		})
+
+		Convey("Should parse branches", func() {
+			branches, err := h.parseBranches([]byte(`[{"name":"main","commit":{"sha":"abc123"}}]`))
+			So(err, ShouldBeNil)
+			So(branches, ShouldResemble, []*Branch{{Name: "main", Commit: "abc123"}})
+		})
+
+		Convey("Should parse commits", func() {
+			commits, err := h.parseCommits([]byte(`[{"sha":"abc123","html_url":"https://github.com/owner/repo/commit/abc123",
+				"commit":{"message":"Fix it","author":{"name":"Someone","date":"2020-01-02T03:04:05Z"}}}]`))
+			So(err, ShouldBeNil)
+			So(commits, ShouldResemble, []*Commit{{
+				SHA:     "abc123",
+				Message: "Fix it",
+				Author:  "Someone",
+				Date:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+				WebURL:  "https://github.com/owner/repo/commit/abc123",
+			}})
+		})
+
+		Convey("Should fail for an unexpected response", func() {
+			_, err := h.parseRepositories([]byte(`{"message":"Bad credentials"}`))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestGitLabParsers(t *testing.T) {
+	t.Parallel()
+
+	Convey("Parsing GitLab responses", t, func() {
+		l := &gitLab{}
+
+		Convey("Should parse projects as repositories", func() {
+			repos, err := l.parseRepositories([]byte(`[
+				{"name":"repo","path_with_namespace":"group/sub/repo","description":"A repo","visibility":"internal","default_branch":"master",
+				"http_url_to_repo":"https://gitlab.com/group/sub/repo.git","web_url":"https://gitlab.com/group/sub/repo","last_activity_at":"2020-01-02T03:04:05Z"},
+				{"name":"public","path_with_namespace":"group/public","visibility":"public"}]`))
+			So(err, ShouldBeNil)
+			So(repos, ShouldHaveLength, 2)
+			So(repos[0], ShouldResemble, &Repository{
+				Name:          "repo",
+				FullName:      "group/sub/repo",
+				Description:   "A repo",
+				Private:       true,
+				DefaultBranch: "master",
+				CloneURL:      "https://gitlab.com/group/sub/repo.git",
+				WebURL:        "https://gitlab.com/group/sub/repo",
+				UpdatedAt:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			})
+			So(repos[1].Private, ShouldBeFalse)
+		})
+
+		Convey("Should parse branches", func() {
+			branches, err := l.parseBranches([]byte(`[{"name":"master","commit":{"id":"abc123"}}]`))
+			So(err, ShouldBeNil)
+			So(branches, ShouldResemble, []*Branch{{Name: "master", Commit: "abc123"}})
+		})
+
+		Convey("Should parse commits", func() {
+			commits, err := l.parseCommits([]byte(`[{"id":"abc123","message":"Fix it","author_name":"Someone",
+				"created_at":"2020-01-02T03:04:05Z","web_url":"https://gitlab.com/group/repo/-/commit/abc123"}]`))
+			So(err, ShouldBeNil)
+			So(commits, ShouldResemble, []*Commit{{
+				SHA:     "abc123",
+				Message: "Fix it",
+				Author:  "Someone",
+				Date:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+				WebURL:  "https://gitlab.com/group/repo/-/commit/abc123",
+			}})
+		})
+
+		Convey("Should escape the path of a nested project", func() {
+			So(l.branchesPath("group/sub/repo"), ShouldEqual, "/projects/group%2Fsub%2Frepo/repository/branches?per_page=100")
+		})
+	})
+}
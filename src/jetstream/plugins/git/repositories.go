@@ -0,0 +1,262 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	defaultPerPage = 30
+	maxPerPage     = 100
+)
+
+// Full name of a repository, e.g. owner/repo - GitLab projects can be nested in several groups
+var repositoryNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)+$`)
+
+// CloneCredentials are the credentials a user has connected to a git endpoint with, for cloning its repositories
+type CloneCredentials struct {
+	// Web URL of the git host
+	URL      *url.URL
+	Username string
+	Token    string
+}
+
+// GetCloneCredentials gets the credentials of the user for the git endpoint
+func GetCloneCredentials(portalProxy interfaces.PortalProxy, cnsiGUID, userGUID string) (*CloneCredentials, error) {
+	cnsiRecord, err := portalProxy.GetCNSIRecord(cnsiGUID)
+	if err != nil || cnsiRecord.CNSIType != EndpointType {
+		return nil, fmt.Errorf("No git endpoint registered with GUID %s", cnsiGUID)
+	}
+
+	host, err := getGitProvider(&cnsiRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenRecord, ok := portalProxy.GetCNSITokenRecord(cnsiGUID, userGUID)
+	if !ok || tokenRecord.Disconnected {
+		return nil, fmt.Errorf("User has not connected to git endpoint %s", cnsiRecord.Name)
+	}
+
+	return &CloneCredentials{
+		URL:      cnsiRecord.APIEndpoint,
+		Username: host.cloneUsername(),
+		Token:    tokenRecord.AuthToken,
+	}, nil
+}
+
+// Matches determines if a repository is hosted by the git host that the credentials are for. Credentials must only
+// be sent to their own host
+func (c *CloneCredentials) Matches(repoURL string) bool {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == c.URL.Scheme && strings.EqualFold(u.Host, c.URL.Host)
+}
+
+// gitEndpoint is a git endpoint that the user is connected to
+type gitEndpoint struct {
+	cnsiRecord interfaces.CNSIRecord
+	host       gitProvider
+	token      string
+}
+
+func (g *GitSpecification) getGitEndpoint(c echo.Context) (*gitEndpoint, error) {
+	cnsiGUID := c.Param("cnsiGuid")
+	userGUID := c.Get("user_id").(string)
+
+	cnsiRecord, err := g.portalProxy.GetCNSIRecord(cnsiGUID)
+	if err != nil || cnsiRecord.CNSIType != EndpointType {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested git endpoint not registered",
+			"No git endpoint registered with GUID %s: %v", cnsiGUID, err)
+	}
+
+	host, err := getGitProvider(&cnsiRecord)
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Unable to determine the type of git endpoint",
+			"%v", err)
+	}
+
+	tokenRecord, ok := g.portalProxy.GetCNSITokenRecord(cnsiGUID, userGUID)
+	if !ok || tokenRecord.Disconnected {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"User has not connected to the git endpoint",
+			"User %s has not connected to git endpoint %s", userGUID, cnsiGUID)
+	}
+
+	return &gitEndpoint{cnsiRecord: cnsiRecord, host: host, token: tokenRecord.AuthToken}, nil
+}
+
+// get makes a request to the API of the git host
+func (g *GitSpecification) get(cnsiRecord *interfaces.CNSIRecord, host gitProvider, token, path string) ([]byte, error) {
+	apiURL := host.apiURL(cnsiRecord.APIEndpoint) + path
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request for %s: %v", apiURL, err)
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	client := g.portalProxy.GetHttpClientForRequest(req, cnsiRecord.SkipSSLValidation)
+	res, err := client.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error performing git API request - response: %v, error: %v", res, err)
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// apiError reports a failed request to the git host. The host's status is passed on for client errors, such as
+// an unknown repository or a token that has been revoked
+func apiError(err error, userMsg string, cnsiGUID string) error {
+	status := http.StatusBadGateway
+	if httpErr, ok := err.(interfaces.ErrHTTPRequest); ok && httpErr.Status >= 400 && httpErr.Status < 500 {
+		status = httpErr.Status
+	}
+	return interfaces.NewHTTPShadowError(status, userMsg, "%s for git endpoint %s: %v", userMsg, cnsiGUID, err)
+}
+
+func getPageParams(c echo.Context) (int, int, error) {
+	page, perPage := 1, defaultPerPage
+
+	if value := c.QueryParam("page"); len(value) > 0 {
+		p, err := strconv.Atoi(value)
+		if err != nil || p < 1 {
+			return 0, 0, interfaces.NewHTTPShadowError(http.StatusBadRequest, "Invalid page", "Invalid page: %s", value)
+		}
+		page = p
+	}
+
+	if value := c.QueryParam("per_page"); len(value) > 0 {
+		p, err := strconv.Atoi(value)
+		if err != nil || p < 1 || p > maxPerPage {
+			return 0, 0, interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				fmt.Sprintf("Page size must be between 1 and %d", maxPerPage),
+				"Invalid page size: %s", value)
+		}
+		perPage = p
+	}
+
+	return page, perPage, nil
+}
+
+func getRepositoryParam(c echo.Context) (string, error) {
+	repo := c.QueryParam("repo")
+	if !repositoryNameRegexp.MatchString(repo) || strings.Contains(repo, "..") {
+		return "", interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Repository must be given by its full name, e.g. owner/repo",
+			"Invalid repository name: %s", repo)
+	}
+	return repo, nil
+}
+
+// listRepositories lists the repositories that the user can access, most recently updated first
+func (g *GitSpecification) listRepositories(c echo.Context) error {
+	log.Debug("listRepositories")
+	endpoint, err := g.getGitEndpoint(c)
+	if err != nil {
+		return err
+	}
+
+	page, perPage, err := getPageParams(c)
+	if err != nil {
+		return err
+	}
+
+	body, err := g.get(&endpoint.cnsiRecord, endpoint.host, endpoint.token, endpoint.host.repositoriesPath(page, perPage))
+	if err != nil {
+		return apiError(err, "Unable to list repositories", endpoint.cnsiRecord.GUID)
+	}
+
+	repos, err := endpoint.host.parseRepositories(body)
+	if err != nil {
+		return apiError(err, "Unable to list repositories", endpoint.cnsiRecord.GUID)
+	}
+
+	return c.JSON(http.StatusOK, repos)
+}
+
+// listBranches lists the branches of a repository
+func (g *GitSpecification) listBranches(c echo.Context) error {
+	log.Debug("listBranches")
+	endpoint, err := g.getGitEndpoint(c)
+	if err != nil {
+		return err
+	}
+
+	repo, err := getRepositoryParam(c)
+	if err != nil {
+		return err
+	}
+
+	body, err := g.get(&endpoint.cnsiRecord, endpoint.host, endpoint.token, endpoint.host.branchesPath(repo))
+	if err != nil {
+		return apiError(err, "Unable to list branches", endpoint.cnsiRecord.GUID)
+	}
+
+	branches, err := endpoint.host.parseBranches(body)
+	if err != nil {
+		return apiError(err, "Unable to list branches", endpoint.cnsiRecord.GUID)
+	}
+
+	return c.JSON(http.StatusOK, branches)
+}
+
+// listCommits lists the commits on a branch of a repository, newest first
+func (g *GitSpecification) listCommits(c echo.Context) error {
+	log.Debug("listCommits")
+	endpoint, err := g.getGitEndpoint(c)
+	if err != nil {
+		return err
+	}
+
+	repo, err := getRepositoryParam(c)
+	if err != nil {
+		return err
+	}
+
+	branch := c.QueryParam("branch")
+	if len(branch) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Missing branch",
+			"Need branch passed as query param")
+	}
+
+	page, perPage, err := getPageParams(c)
+	if err != nil {
+		return err
+	}
+
+	body, err := g.get(&endpoint.cnsiRecord, endpoint.host, endpoint.token, endpoint.host.commitsPath(repo, branch, page, perPage))
+	if err != nil {
+		return apiError(err, "Unable to list commits", endpoint.cnsiRecord.GUID)
+	}
+
+	commits, err := endpoint.host.parseCommits(body)
+	if err != nil {
+		return apiError(err, "Unable to list commits", endpoint.cnsiRecord.GUID)
+	}
+
+	return c.JSON(http.StatusOK, commits)
+}
@@ -0,0 +1,69 @@
+package git
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+func TestCloneCredentialsMatches(t *testing.T) {
+	t.Parallel()
+
+	Convey("Matching repositories to clone credentials", t, func() {
+		creds := &CloneCredentials{URL: mustParseURL("https://github.example.com"), Username: "x-access-token", Token: "token"}
+
+		Convey("Should match a repository on the same host", func() {
+			So(creds.Matches("https://github.example.com/owner/repo.git"), ShouldBeTrue)
+			So(creds.Matches("https://GITHUB.example.com/owner/repo"), ShouldBeTrue)
+		})
+
+		Convey("Should not match a repository on another host", func() {
+			So(creds.Matches("https://github.com/owner/repo.git"), ShouldBeFalse)
+			So(creds.Matches("https://github.example.com.evil.com/owner/repo.git"), ShouldBeFalse)
+			So(creds.Matches("https://github.example.com:8443/owner/repo.git"), ShouldBeFalse)
+		})
+
+		Convey("Should not match a repository over another scheme", func() {
+			So(creds.Matches("http://github.example.com/owner/repo.git"), ShouldBeFalse)
+			So(creds.Matches("git@github.example.com:owner/repo.git"), ShouldBeFalse)
+		})
+
+		Convey("Should not match an invalid URL", func() {
+			So(creds.Matches("https://github.example.com/%zz"), ShouldBeFalse)
+		})
+	})
+}
+
+func newRepositoryParamContext(repo string) echo.Context {
+	req := httptest.NewRequest("GET", "/?repo="+url.QueryEscape(repo), nil)
+	return echo.New().NewContext(standard.NewRequest(req, nil), standard.NewResponse(httptest.NewRecorder(), nil))
+}
+
+func TestGetRepositoryParam(t *testing.T) {
+	t.Parallel()
+
+	Convey("Validating the repository parameter", t, func() {
+		Convey("Should accept the full name of a repository", func() {
+			for _, repo := range []string{"owner/repo", "group/sub-group/my_repo.js"} {
+				value, err := getRepositoryParam(newRepositoryParamContext(repo))
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, repo)
+			}
+		})
+
+		Convey("Should reject a repository that is not given by its full name", func() {
+			for _, repo := range []string{"", "repo", "/owner/repo", "owner/repo/", "owner/../repo", "owner/repo?x=1", "owner/re po"} {
+				_, err := getRepositoryParam(newRepositoryParamContext(repo))
+				So(err, ShouldNotBeNil)
+				So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
@@ -0,0 +1,262 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	chartMetadataFile = "Chart.yaml"
+	chartValuesFile   = "values.yaml"
+
+	// Limit on the size of each of the files read from a chart archive
+	maxChartFileSize = 4 * 1024 * 1024
+)
+
+// ChartSummary describes a chart in the repository by its latest version
+type ChartSummary struct {
+	Name         string        `json:"name"`
+	Latest       *ChartVersion `json:"latest"`
+	VersionCount int           `json:"version_count"`
+}
+
+// Chart is a version of a chart along with the metadata and default values from its archive
+type Chart struct {
+	*ChartVersion `json:"chart"`
+	Metadata      *ChartMetadata `json:"metadata"`
+	Values        string         `json:"values"`
+}
+
+// repository is a Helm repository endpoint that the user is connected to
+type repository struct {
+	cnsiRecord  interfaces.CNSIRecord
+	tokenRecord interfaces.TokenRecord
+	index       *Index
+}
+
+// getRepository gets the repository of the request and its index
+func (h *HelmSpecification) getRepository(c echo.Context) (*repository, error) {
+	cnsiGUID := c.Param("cnsiGuid")
+	userGUID := c.Get("user_id").(string)
+
+	cnsiRecord, err := h.portalProxy.GetCNSIRecord(cnsiGUID)
+	if err != nil || cnsiRecord.CNSIType != EndpointType {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested Helm repository not registered",
+			"No Helm repository registered with GUID %s: %v", cnsiGUID, err)
+	}
+
+	tokenRecord, ok := h.portalProxy.GetCNSITokenRecord(cnsiGUID, userGUID)
+	if !ok || tokenRecord.Disconnected {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusUnauthorized,
+			"User has not connected to the Helm repository",
+			"User %s has not connected to Helm repository %s", userGUID, cnsiGUID)
+	}
+
+	repoURL := cnsiRecord.APIEndpoint.String()
+	index, err := h.indexes.get(cnsiGUID, func() (*Index, error) {
+		return h.fetchIndex(repoURL, cnsiRecord.SkipSSLValidation, tokenRecord.AuthToken)
+	})
+	if err != nil {
+		return nil, interfaces.NewHTTPShadowError(
+			http.StatusBadGateway,
+			"Unable to fetch the Helm repository index",
+			"Unable to fetch index of Helm repository %s: %v", cnsiGUID, err)
+	}
+
+	return &repository{cnsiRecord: cnsiRecord, tokenRecord: tokenRecord, index: index}, nil
+}
+
+// listCharts lists the charts in the repository
+func (h *HelmSpecification) listCharts(c echo.Context) error {
+	log.Debug("listCharts")
+	repo, err := h.getRepository(c)
+	if err != nil {
+		return err
+	}
+
+	// The versions of each chart are sorted newest first when the index is fetched
+	charts := make([]*ChartSummary, 0, len(repo.index.Entries))
+	for name, versions := range repo.index.Entries {
+		if len(versions) == 0 {
+			continue
+		}
+		charts = append(charts, &ChartSummary{
+			Name:         name,
+			Latest:       versions[0],
+			VersionCount: len(versions),
+		})
+	}
+
+	sort.Slice(charts, func(i, j int) bool {
+		return charts[i].Name < charts[j].Name
+	})
+
+	return c.JSON(http.StatusOK, charts)
+}
+
+// listChartVersions lists the versions of a chart, newest first
+func (h *HelmSpecification) listChartVersions(c echo.Context) error {
+	log.Debug("listChartVersions")
+	repo, err := h.getRepository(c)
+	if err != nil {
+		return err
+	}
+
+	name := c.Param("name")
+	versions, ok := repo.index.Entries[name]
+	if !ok {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Chart not found",
+			"No chart %s in Helm repository %s", name, repo.cnsiRecord.GUID)
+	}
+
+	return c.JSON(http.StatusOK, versions)
+}
+
+// getChart gets a version of a chart, with the metadata and default values from its archive
+func (h *HelmSpecification) getChart(c echo.Context) error {
+	log.Debug("getChart")
+	repo, err := h.getRepository(c)
+	if err != nil {
+		return err
+	}
+
+	name := c.Param("name")
+	version := c.Param("version")
+	chartVersion, ok := repo.index.Find(name, version)
+	if !ok || len(chartVersion.URLs) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Chart version not found",
+			"No version %s of chart %s in Helm repository %s", version, name, repo.cnsiRecord.GUID)
+	}
+
+	chart, err := h.fetchChart(repo, chartVersion)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadGateway,
+			"Unable to fetch the chart",
+			"Unable to fetch version %s of chart %s: %v", version, name, err)
+	}
+
+	return c.JSON(http.StatusOK, chart)
+}
+
+// fetchChart downloads the archive of a chart version and reads its metadata and default values
+func (h *HelmSpecification) fetchChart(repo *repository, chartVersion *ChartVersion) (*Chart, error) {
+	chartURL, err := resolveChartURL(repo.cnsiRecord.APIEndpoint.String(), chartVersion.URLs[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid chart URL %s: %v", chartVersion.URLs[0], err)
+	}
+
+	// Only send the user's credentials to the repository itself
+	authToken := ""
+	if strings.HasPrefix(chartURL, strings.TrimRight(repo.cnsiRecord.APIEndpoint.String(), "/")+"/") {
+		authToken = repo.tokenRecord.AuthToken
+	}
+
+	archive, err := h.fetch(chartURL, repo.cnsiRecord.SkipSSLValidation, authToken, maxChartSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chartVersion.Digest) > 0 {
+		digest := sha256.Sum256(archive)
+		if hex.EncodeToString(digest[:]) != chartVersion.Digest {
+			return nil, fmt.Errorf("Digest of %s does not match the repository index", chartURL)
+		}
+	}
+
+	files, err := readChartFiles(archive, chartMetadataFile, chartValuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read chart archive %s: %v", chartURL, err)
+	}
+
+	metadataFile, ok := files[chartMetadataFile]
+	if !ok {
+		return nil, fmt.Errorf("Chart archive %s does not contain %s", chartURL, chartMetadataFile)
+	}
+
+	metadata := &ChartMetadata{}
+	if err = yaml.Unmarshal(metadataFile, metadata); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s of chart %s: %v", chartMetadataFile, chartURL, err)
+	}
+
+	return &Chart{
+		ChartVersion: chartVersion,
+		Metadata:     metadata,
+		Values:       string(files[chartValuesFile]),
+	}, nil
+}
+
+// readChartFiles reads the given files from the top level folder of a chart archive
+func readChartFiles(archive []byte, names ...string) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Files are in a folder named after the chart, e.g. mychart/Chart.yaml
+		filePath := path.Clean(header.Name)
+		name := path.Base(filePath)
+		if !wanted[name] || strings.Count(filePath, "/") != 1 {
+			continue
+		}
+
+		if header.Size > maxChartFileSize {
+			return nil, fmt.Errorf("%s is larger than %d bytes", filePath, maxChartFileSize)
+		}
+
+		file, err := ioutil.ReadAll(io.LimitReader(tr, maxChartFileSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(file) > maxChartFileSize {
+			return nil, fmt.Errorf("%s is larger than %d bytes", filePath, maxChartFileSize)
+		}
+		files[name] = file
+	}
+
+	return files, nil
+}
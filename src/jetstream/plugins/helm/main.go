@@ -0,0 +1,165 @@
+package helm
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/config"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// HelmSpecification is a plugin to support Helm chart repository endpoints
+type HelmSpecification struct {
+	portalProxy  interfaces.PortalProxy
+	endpointType string
+	indexes      *indexCache
+}
+
+const (
+	EndpointType = "helm"
+
+	// Connect type for repositories that do not require authentication
+	connectTypeNone = "none"
+
+	// Config value for how often (in seconds) the cached repository indexes are refreshed
+	indexRefreshIntervalKey     = "HELM_INDEX_REFRESH_INTERVAL"
+	defaultIndexRefreshInterval = 5 * time.Minute
+)
+
+// Init creates a new HelmSpecification
+func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
+	return &HelmSpecification{portalProxy: portalProxy, endpointType: EndpointType}, nil
+}
+
+// GetEndpointPlugin gets the endpoint plugin for this plugin
+func (h *HelmSpecification) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return h, nil
+}
+
+// GetRoutePlugin gets the route plugin for this plugin
+func (h *HelmSpecification) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return h, nil
+}
+
+// GetMiddlewarePlugin gets the middleware plugin for this plugin
+func (h *HelmSpecification) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// AddAdminGroupRoutes adds the admin routes for this plugin to the Echo server
+func (h *HelmSpecification) AddAdminGroupRoutes(echoGroup *echo.Group) {
+	// no-op
+}
+
+// AddSessionGroupRoutes adds the session routes for this plugin to the Echo server
+func (h *HelmSpecification) AddSessionGroupRoutes(echoGroup *echo.Group) {
+	echoGroup.GET("/helm/:cnsiGuid/charts", h.listCharts)
+	echoGroup.GET("/helm/:cnsiGuid/charts/:name", h.listChartVersions)
+	echoGroup.GET("/helm/:cnsiGuid/charts/:name/:version", h.getChart)
+}
+
+// Init performs plugin initialization
+func (h *HelmSpecification) Init() error {
+	refreshInterval := defaultIndexRefreshInterval
+	if value, err := config.GetValue(indexRefreshIntervalKey); err == nil {
+		secs, err := strconv.Atoi(value)
+		if err != nil || secs <= 0 {
+			return fmt.Errorf("Invalid value for %s: %s", indexRefreshIntervalKey, value)
+		}
+		refreshInterval = time.Duration(secs) * time.Second
+	}
+
+	h.indexes = newIndexCache(refreshInterval)
+	go h.indexes.run()
+	return nil
+}
+
+func (h *HelmSpecification) GetType() string {
+	return EndpointType
+}
+
+func (h *HelmSpecification) Register(echoContext echo.Context) error {
+	log.Debug("Helm Register...")
+	return h.portalProxy.RegisterEndpoint(echoContext, h.Info)
+}
+
+// Connect connects to the repository, either anonymously or with a username and password
+func (h *HelmSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
+	log.Debug("Helm Connect...")
+
+	tr := &interfaces.TokenRecord{
+		AuthType: interfaces.AuthTypeHttpBasic,
+	}
+
+	connectType := ec.FormValue("connect_type")
+	switch connectType {
+	case interfaces.AuthConnectTypeCreds:
+		username := ec.FormValue("username")
+		password := ec.FormValue("password")
+
+		if len(username) == 0 || len(password) == 0 {
+			return nil, false, errors.New("Need username and password")
+		}
+
+		authString := fmt.Sprintf("%s:%s", username, password)
+		tr.AuthToken = base64.StdEncoding.EncodeToString([]byte(authString))
+		tr.RefreshToken = username
+	case connectTypeNone:
+		// Anonymous access - there are no credentials to store
+	default:
+		return nil, false, errors.New("Only username/password or anonymous access is accepted for Helm repositories")
+	}
+
+	// Check that the repository index can be read with the credentials
+	if _, err := h.fetchIndex(cnsiRecord.APIEndpoint.String(), cnsiRecord.SkipSSLValidation, tr.AuthToken); err != nil {
+		return nil, false, err
+	}
+
+	return tr, false, nil
+}
+
+// Info checks that the endpoint is a Helm repository by fetching its index
+func (h *HelmSpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	log.Debug("Helm Info")
+	var newCNSI interfaces.CNSIRecord
+
+	newCNSI.CNSIType = EndpointType
+
+	if _, err := url.Parse(apiEndpoint); err != nil {
+		return newCNSI, nil, err
+	}
+
+	newCNSI.TokenEndpoint = apiEndpoint
+	newCNSI.AuthorizationEndpoint = apiEndpoint
+
+	index, err := h.fetchIndex(apiEndpoint, skipSSLValidation, "")
+	if err != nil {
+		// Repositories that need credentials can only be validated when the user connects
+		if httpErr, ok := err.(interfaces.ErrHTTPRequest); ok && httpErr.Status == http.StatusUnauthorized {
+			log.Infof("Helm repository %s requires authentication", apiEndpoint)
+			return newCNSI, nil, nil
+		}
+		return newCNSI, nil, err
+	}
+
+	return newCNSI, index, nil
+}
+
+func (h *HelmSpecification) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
+}
+
+// OnEndpointNotification drops the cached indexes of a repository when it is unregistered
+func (h *HelmSpecification) OnEndpointNotification(action interfaces.EndpointAction, endpoint *interfaces.CNSIRecord) {
+	if endpoint.CNSIType != EndpointType || action != interfaces.EndpointUnregisterAction {
+		return
+	}
+
+	h.indexes.remove(endpoint.GUID)
+}
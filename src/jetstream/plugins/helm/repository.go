@@ -0,0 +1,356 @@
+package helm
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+const (
+	indexPath = "index.yaml"
+
+	// Number of refresh intervals that a cached index is kept for without being used
+	indexIdleIntervals = 3
+
+	// Limits on the size of the files fetched from a repository
+	maxIndexSize = 64 * 1024 * 1024
+	maxChartSize = 16 * 1024 * 1024
+)
+
+// Maintainer is a maintainer of a chart
+type Maintainer struct {
+	Name  string `yaml:"name" json:"name"`
+	Email string `yaml:"email,omitempty" json:"email,omitempty"`
+	URL   string `yaml:"url,omitempty" json:"url,omitempty"`
+}
+
+// ChartMetadata is the metadata of a chart, as found in its Chart.yaml
+type ChartMetadata struct {
+	Name        string        `yaml:"name" json:"name"`
+	Version     string        `yaml:"version" json:"version"`
+	AppVersion  string        `yaml:"appVersion,omitempty" json:"appVersion,omitempty"`
+	APIVersion  string        `yaml:"apiVersion,omitempty" json:"apiVersion,omitempty"`
+	KubeVersion string        `yaml:"kubeVersion,omitempty" json:"kubeVersion,omitempty"`
+	Description string        `yaml:"description,omitempty" json:"description,omitempty"`
+	Home        string        `yaml:"home,omitempty" json:"home,omitempty"`
+	Icon        string        `yaml:"icon,omitempty" json:"icon,omitempty"`
+	Keywords    []string      `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	Sources     []string      `yaml:"sources,omitempty" json:"sources,omitempty"`
+	Maintainers []*Maintainer `yaml:"maintainers,omitempty" json:"maintainers,omitempty"`
+	Deprecated  bool          `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+}
+
+// ChartVersion is the index entry for a version of a chart
+type ChartVersion struct {
+	ChartMetadata `yaml:",inline"`
+	URLs          []string  `yaml:"urls" json:"urls"`
+	Created       time.Time `yaml:"created,omitempty" json:"created,omitempty"`
+	Digest        string    `yaml:"digest,omitempty" json:"digest,omitempty"`
+}
+
+// Index is the index.yaml of a chart repository
+type Index struct {
+	APIVersion string                     `yaml:"apiVersion" json:"apiVersion"`
+	Generated  time.Time                  `yaml:"generated" json:"generated"`
+	Entries    map[string][]*ChartVersion `yaml:"entries" json:"entries"`
+}
+
+// fetchIndex fetches and validates the index of the repository
+func (h *HelmSpecification) fetchIndex(repoURL string, skipSSLValidation bool, authToken string) (*Index, error) {
+	indexURL := strings.TrimRight(repoURL, "/") + "/" + indexPath
+	body, err := h.fetch(indexURL, skipSSLValidation, authToken, maxIndexSize)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Index{}
+	if err = yaml.Unmarshal(body, index); err != nil {
+		return nil, fmt.Errorf("Unable to parse Helm repository index %s: %v", indexURL, err)
+	}
+
+	if len(index.APIVersion) == 0 {
+		return nil, fmt.Errorf("Helm repository index %s does not have an API version", indexURL)
+	}
+	if index.Entries == nil {
+		index.Entries = make(map[string][]*ChartVersion)
+	}
+	for _, versions := range index.Entries {
+		sortChartVersions(versions)
+	}
+
+	return index, nil
+}
+
+// fetch gets a file from the repository. The file must be smaller than the given limit
+func (h *HelmSpecification) fetch(fileURL string, skipSSLValidation bool, authToken string, limit int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request for %s: %v", fileURL, err)
+	}
+
+	if len(authToken) > 0 {
+		req.Header.Set("Authorization", "basic "+authToken)
+	}
+
+	client := h.portalProxy.GetHttpClientForRequest(req, skipSSLValidation)
+	res, err := client.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error fetching %s - response: %v, error: %v", fileURL, res, err)
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %v", fileURL, err)
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", fileURL, limit)
+	}
+
+	return body, nil
+}
+
+// resolveChartURL resolves the URL of a chart archive, which can be relative to the repository
+func resolveChartURL(repoURL, chartURL string) (string, error) {
+	base, err := url.Parse(strings.TrimRight(repoURL, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(chartURL)
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Find gets a version of a chart from the index
+func (i *Index) Find(name, version string) (*ChartVersion, bool) {
+	for _, chartVersion := range i.Entries[name] {
+		if chartVersion.Version == version {
+			return chartVersion, true
+		}
+	}
+	return nil, false
+}
+
+// sortChartVersions sorts the versions of a chart newest first. Repositories do not have to list them in order.
+// Versions are compared as semantic versions, then by when they were created. Versions that are not semantic
+// versions come after those that are
+func sortChartVersions(versions []*ChartVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, iok := parseSemVer(versions[i].Version)
+		vj, jok := parseSemVer(versions[j].Version)
+		if iok != jok {
+			return iok
+		}
+		if iok {
+			if cmp := vi.compare(vj); cmp != 0 {
+				return cmp > 0
+			}
+		}
+		return versions[i].Created.After(versions[j].Created)
+	})
+}
+
+type semVer struct {
+	release    [3]int
+	prerelease []string
+}
+
+// parseSemVer parses a semantic version, e.g. 1.2.3-beta.1+build, which may have a leading v
+func parseSemVer(version string) (semVer, bool) {
+	var v semVer
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.Index(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+	if i := strings.Index(version, "-"); i >= 0 {
+		v.prerelease = strings.Split(version[i+1:], ".")
+		version = version[:i]
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) != len(v.release) {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.release[i] = n
+	}
+	return v, true
+}
+
+// compare returns a positive number if v is newer than o, a negative number if it is older, and 0 if they are equal
+func (v semVer) compare(o semVer) int {
+	for i := range v.release {
+		if v.release[i] != o.release[i] {
+			return v.release[i] - o.release[i]
+		}
+	}
+
+	// A pre-release is older than its release
+	if len(v.prerelease) == 0 || len(o.prerelease) == 0 {
+		return len(o.prerelease) - len(v.prerelease)
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(o.prerelease); i++ {
+		if cmp := comparePrerelease(v.prerelease[i], o.prerelease[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(v.prerelease) - len(o.prerelease)
+}
+
+// comparePrerelease compares pre-release identifiers. Numeric identifiers are compared as numbers and are older than
+// alphanumeric ones
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return an - bn
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+type cachedIndex struct {
+	index      *Index
+	fetchIndex func() (*Index, error)
+	lastUsed   time.Time
+
+	// Held while the index is first fetched, so that requests made in the meantime wait for it rather than fetching
+	// it again
+	fetching sync.Mutex
+}
+
+// indexCache caches the index of each repository. Cached indexes are refreshed every refresh interval, with the
+// credentials of the last user that used them, and dropped when they have not been used for a few intervals. Users
+// must be connected to a repository before its index is used
+type indexCache struct {
+	sync.Mutex
+	refreshInterval time.Duration
+	indexes         map[string]*cachedIndex
+}
+
+func newIndexCache(refreshInterval time.Duration) *indexCache {
+	return &indexCache{
+		refreshInterval: refreshInterval,
+		indexes:         make(map[string]*cachedIndex),
+	}
+}
+
+// get gets the index of a repository from the cache, fetching it if it is not cached
+func (c *indexCache) get(cnsiGUID string, fetchIndex func() (*Index, error)) (*Index, error) {
+	c.Lock()
+	cached, ok := c.indexes[cnsiGUID]
+	if !ok {
+		cached = &cachedIndex{}
+		c.indexes[cnsiGUID] = cached
+	}
+	cached.fetchIndex = fetchIndex
+	cached.lastUsed = time.Now()
+	index := cached.index
+	c.Unlock()
+
+	if index != nil {
+		return index, nil
+	}
+
+	cached.fetching.Lock()
+	defer cached.fetching.Unlock()
+
+	c.Lock()
+	index = cached.index
+	c.Unlock()
+	if index != nil {
+		return index, nil
+	}
+
+	index, err := fetchIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	cached.index = index
+	c.Unlock()
+
+	return index, nil
+}
+
+// run refreshes the cached indexes every refresh interval
+func (c *indexCache) run() {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.refresh(time.Now())
+	}
+}
+
+// refresh drops the indexes that have not been used for a while and fetches the others again. If a refresh fails
+// the previously fetched index is kept
+func (c *indexCache) refresh(now time.Time) {
+	type pendingRefresh struct {
+		cnsiGUID   string
+		cached     *cachedIndex
+		fetchIndex func() (*Index, error)
+	}
+
+	c.Lock()
+	pending := make([]pendingRefresh, 0, len(c.indexes))
+	for cnsiGUID, cached := range c.indexes {
+		if now.Sub(cached.lastUsed) > indexIdleIntervals*c.refreshInterval {
+			delete(c.indexes, cnsiGUID)
+			continue
+		}
+		if cached.index != nil {
+			pending = append(pending, pendingRefresh{cnsiGUID: cnsiGUID, cached: cached, fetchIndex: cached.fetchIndex})
+		}
+	}
+	c.Unlock()
+
+	for _, p := range pending {
+		index, err := p.fetchIndex()
+		if err != nil {
+			log.Warnf("Unable to refresh Helm repository index for endpoint %s: %v", p.cnsiGUID, err)
+			continue
+		}
+
+		c.Lock()
+		// The repository may have been unregistered in the meantime
+		if c.indexes[p.cnsiGUID] == p.cached {
+			p.cached.index = index
+		}
+		c.Unlock()
+	}
+}
+
+// remove drops the cached index of a repository
+func (c *indexCache) remove(cnsiGUID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.indexes, cnsiGUID)
+}
@@ -0,0 +1,148 @@
+package helm
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func chartVersions(versions ...string) []*ChartVersion {
+	chartVersions := make([]*ChartVersion, 0, len(versions))
+	for _, version := range versions {
+		chartVersions = append(chartVersions, &ChartVersion{ChartMetadata: ChartMetadata{Version: version}})
+	}
+	return chartVersions
+}
+
+func versionsOf(chartVersions []*ChartVersion) []string {
+	versions := make([]string, 0, len(chartVersions))
+	for _, chartVersion := range chartVersions {
+		versions = append(versions, chartVersion.Version)
+	}
+	return versions
+}
+
+func chartArchive(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, contents := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(contents))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestSortChartVersions(t *testing.T) {
+	t.Parallel()
+
+	Convey("Sort chart versions", t, func() {
+		Convey("Should sort semantic versions newest first", func() {
+			versions := chartVersions("1.9.0", "1.10.0", "v2.0.0", "1.10.0-rc.2", "1.10.0-rc.10", "1.10.0-beta", "0.1.0")
+			sortChartVersions(versions)
+			So(versionsOf(versions), ShouldResemble, []string{"v2.0.0", "1.10.0", "1.10.0-rc.10", "1.10.0-rc.2", "1.10.0-beta", "1.9.0", "0.1.0"})
+		})
+
+		Convey("Should put versions that are not semantic versions last, newest first", func() {
+			now := time.Now()
+			versions := chartVersions("latest", "1.0.0", "nightly")
+			versions[0].Created = now.Add(-time.Hour)
+			versions[2].Created = now
+			sortChartVersions(versions)
+			So(versionsOf(versions), ShouldResemble, []string{"1.0.0", "nightly", "latest"})
+		})
+	})
+}
+
+func TestReadChartFiles(t *testing.T) {
+	t.Parallel()
+
+	Convey("Read files from a chart archive", t, func() {
+		Convey("Should only read the wanted files from the chart folder", func() {
+			archive := chartArchive(map[string]string{
+				"mychart/Chart.yaml":             "name: mychart",
+				"mychart/values.yaml":            "replicas: 1",
+				"mychart/charts/dep/values.yaml": "replicas: 2",
+				"mychart/templates/service.yaml": "kind: Service",
+			})
+			files, err := readChartFiles(archive, chartMetadataFile, chartValuesFile)
+			So(err, ShouldBeNil)
+			So(files, ShouldHaveLength, 2)
+			So(string(files[chartMetadataFile]), ShouldEqual, "name: mychart")
+			So(string(files[chartValuesFile]), ShouldEqual, "replicas: 1")
+		})
+
+		Convey("Should reject files that are too large", func() {
+			archive := chartArchive(map[string]string{
+				"mychart/values.yaml": string(make([]byte, maxChartFileSize+1)),
+			})
+			_, err := readChartFiles(archive, chartValuesFile)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestIndexCache(t *testing.T) {
+	t.Parallel()
+
+	Convey("Helm repository index cache", t, func() {
+		cache := newIndexCache(time.Minute)
+		fetches := 0
+		fetchIndex := func() (*Index, error) {
+			fetches++
+			return &Index{APIVersion: "v1"}, nil
+		}
+
+		Convey("Should fetch an index once for all users", func() {
+			index, err := cache.get("repo", fetchIndex)
+			So(err, ShouldBeNil)
+			So(index.APIVersion, ShouldEqual, "v1")
+
+			_, err = cache.get("repo", fetchIndex)
+			So(err, ShouldBeNil)
+			So(fetches, ShouldEqual, 1)
+		})
+
+		Convey("Should not cache an index that can not be fetched", func() {
+			_, err := cache.get("repo", func() (*Index, error) { return nil, errors.New("unavailable") })
+			So(err, ShouldNotBeNil)
+
+			_, err = cache.get("repo", fetchIndex)
+			So(err, ShouldBeNil)
+			So(fetches, ShouldEqual, 1)
+		})
+
+		Convey("Should refresh used indexes and keep the old index if the refresh fails", func() {
+			cache.get("repo", fetchIndex)
+			cache.refresh(time.Now())
+			So(fetches, ShouldEqual, 2)
+
+			cache.get("repo", func() (*Index, error) { return nil, errors.New("unavailable") })
+			cache.refresh(time.Now())
+			index, err := cache.get("repo", fetchIndex)
+			So(err, ShouldBeNil)
+			So(index, ShouldNotBeNil)
+			So(fetches, ShouldEqual, 2)
+		})
+
+		Convey("Should drop indexes that have not been used for a while", func() {
+			cache.get("repo", fetchIndex)
+			cache.refresh(time.Now().Add((indexIdleIntervals + 1) * time.Minute))
+			So(cache.indexes, ShouldBeEmpty)
+			So(fetches, ShouldEqual, 1)
+		})
+
+		Convey("Should drop the index of an unregistered repository", func() {
+			cache.get("repo", fetchIndex)
+			cache.remove("repo")
+			So(cache.indexes, ShouldBeEmpty)
+		})
+	})
+}
@@ -94,25 +94,43 @@ func (m *MetricsSpecification) Register(echoContext echo.Context) error {
 func (m *MetricsSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
 	log.Debug("Metrics Connect...")
 
-	connectType := ec.FormValue("connect_type")
-	if connectType != interfaces.AuthConnectTypeCreds {
-		return nil, false, errors.New("Only username/password is accepted for Metrics endpoints")
-	}
+	var tr *interfaces.TokenRecord
+	var authHeader string
 
-	username := ec.FormValue("username")
-	password := ec.FormValue("password")
+	connectType := ec.FormValue("connect_type")
+	switch connectType {
+	case interfaces.AuthConnectTypeCreds:
+		username := ec.FormValue("username")
+		password := ec.FormValue("password")
 
-	if len(username) == 0 || len(password) == 0 {
-		return nil, false, errors.New("Need username and password")
-	}
+		if len(username) == 0 || len(password) == 0 {
+			return nil, false, errors.New("Need username and password")
+		}
 
-	authString := fmt.Sprintf("%s:%s", username, password)
-	base64EncodedAuthString := base64.StdEncoding.EncodeToString([]byte(authString))
+		authString := fmt.Sprintf("%s:%s", username, password)
+		base64EncodedAuthString := base64.StdEncoding.EncodeToString([]byte(authString))
 
-	tr := &interfaces.TokenRecord{
-		AuthType:     interfaces.AuthTypeHttpBasic,
-		AuthToken:    base64EncodedAuthString,
-		RefreshToken: username,
+		tr = &interfaces.TokenRecord{
+			AuthType:     interfaces.AuthTypeHttpBasic,
+			AuthToken:    base64EncodedAuthString,
+			RefreshToken: username,
+		}
+		authHeader = "basic " + base64EncodedAuthString
+	case interfaces.AuthConnectTypeBearer:
+		var err error
+		tr, err = m.portalProxy.ConnectBearer(ec, cnsiRecord)
+		if err != nil {
+			return nil, false, err
+		}
+		authHeader = "bearer " + tr.AuthToken
+	case interfaces.AuthConnectTypeCertificate:
+		var err error
+		tr, err = m.portalProxy.ConnectCertificate(ec, cnsiRecord)
+		if err != nil {
+			return nil, false, err
+		}
+	default:
+		return nil, false, errors.New("Only username/password, bearer token or client certificate is accepted for Metrics endpoints")
 	}
 
 	// Metadata indicates which Cloud Foundry/Kubernetes endpoints the metrics endpoint can supply data for
@@ -124,9 +142,17 @@ func (m *MetricsSpecification) Connect(ec echo.Context, cnsiRecord interfaces.CN
 		return nil, false, fmt.Errorf(msg, err)
 	}
 
-	req.SetBasicAuth(username, password)
+	var h http.Client
+	if tr.AuthType == interfaces.AuthTypeCertificate {
+		h, err = m.portalProxy.GetHttpClientForCertificate(tr, cnsiRecord.SkipSSLValidation)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		req.Header.Set("Authorization", authHeader)
+		h = m.portalProxy.GetHttpClient(cnsiRecord.SkipSSLValidation)
+	}
 
-	var h = m.portalProxy.GetHttpClient(cnsiRecord.SkipSSLValidation)
 	res, err := h.Do(req)
 	if err != nil || res.StatusCode != http.StatusOK {
 		log.Errorf("Error performing http request - response: %v, error: %v", res, err)
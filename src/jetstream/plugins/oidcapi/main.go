@@ -0,0 +1,273 @@
+package oidcapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+)
+
+// OidcAPISpecification is a plugin to support generic REST APIs that are protected by an OAuth2/OIDC provider
+type OidcAPISpecification struct {
+	portalProxy  interfaces.PortalProxy
+	endpointType string
+}
+
+const (
+	EndpointType = "api"
+
+	// Default name shown for the type of an API endpoint, if one is not set at registration
+	defaultTypeName = "API"
+
+	// Keys of the endpoint metadata set at registration
+	typeNameMetadataKey  = "type_name"
+	iconMetadataKey      = "icon"
+	issuerURLMetadataKey = "issuer_url"
+
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	maxTypeNameLength = 64
+)
+
+// Icons can either be a URL or the name of one of the console's icons
+var iconNameRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// Metadata that is set at registration - anything else in an endpoint's metadata is not shown to users
+var registrationMetadataKeys = []string{typeNameMetadataKey, iconMetadataKey, issuerURLMetadataKey}
+
+// OidcConfiguration is the subset of the OIDC discovery document that is needed to connect to the provider
+type OidcConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// Init creates a new OidcAPISpecification
+func Init(portalProxy interfaces.PortalProxy) (interfaces.StratosPlugin, error) {
+	return &OidcAPISpecification{portalProxy: portalProxy, endpointType: EndpointType}, nil
+}
+
+// GetEndpointPlugin gets the endpoint plugin for this plugin
+func (o *OidcAPISpecification) GetEndpointPlugin() (interfaces.EndpointPlugin, error) {
+	return o, nil
+}
+
+// GetRoutePlugin gets the route plugin for this plugin
+func (o *OidcAPISpecification) GetRoutePlugin() (interfaces.RoutePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// GetMiddlewarePlugin gets the middleware plugin for this plugin
+func (o *OidcAPISpecification) GetMiddlewarePlugin() (interfaces.MiddlewarePlugin, error) {
+	return nil, errors.New("Not implemented!")
+}
+
+// Init performs plugin initialization
+func (o *OidcAPISpecification) Init() error {
+	return nil
+}
+
+func (o *OidcAPISpecification) GetType() string {
+	return EndpointType
+}
+
+// Register registers an API endpoint. As well as the usual registration form values, the URL of the OIDC provider
+// (issuer_url, defaults to the API endpoint), the name of the type of API (type_name) and the URL or name of the
+// icon to show for the endpoint (icon) can be supplied. These are kept in the endpoint's metadata
+func (o *OidcAPISpecification) Register(echoContext echo.Context) error {
+	log.Debug("OIDC API Register...")
+
+	metadata := getRegistrationMetadata(echoContext)
+	if err := validateRegistrationMetadata(metadata); err != nil {
+		return err
+	}
+
+	if err := o.portalProxy.RegisterEndpoint(echoContext, o.InfoWithMetadata(metadata)); err != nil {
+		return err
+	}
+
+	// The endpoint has been registered, so add the metadata used to display it
+	apiEndpoint := strings.TrimRight(echoContext.FormValue("api_endpoint"), "/")
+	cnsiRecord, err := o.portalProxy.GetCNSIRecordByEndpoint(apiEndpoint)
+	if err != nil {
+		log.Warnf("Unable to find registered API endpoint %s: %v", apiEndpoint, err)
+		return nil
+	}
+
+	if err = o.portalProxy.SetCNSIMetadata(cnsiRecord.GUID, metadata); err != nil {
+		log.Warnf("Unable to save metadata of API endpoint %s: %v", cnsiRecord.Name, err)
+	}
+
+	return nil
+}
+
+func getRegistrationMetadata(ec echo.Context) map[string]string {
+	metadata := map[string]string{typeNameMetadataKey: defaultTypeName}
+	for _, key := range registrationMetadataKeys {
+		if value := strings.TrimSpace(ec.FormValue(key)); len(value) > 0 {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+func validateRegistrationMetadata(metadata map[string]string) error {
+	if len(metadata[typeNameMetadataKey]) > maxTypeNameLength {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			fmt.Sprintf("Type name must be at most %d characters", maxTypeNameLength),
+			"API endpoint type name is too long")
+	}
+
+	if icon := metadata[iconMetadataKey]; len(icon) > 0 && !isValidIcon(icon) {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Icon must be an http(s) URL or an icon name",
+			"Invalid API endpoint icon: %s", icon)
+	}
+
+	if issuerURL := metadata[issuerURLMetadataKey]; len(issuerURL) > 0 {
+		if u, err := url.Parse(issuerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return interfaces.NewHTTPShadowError(
+				http.StatusBadRequest,
+				"Issuer URL must be an http(s) URL",
+				"Invalid API endpoint issuer URL: %s", issuerURL)
+		}
+	}
+
+	return nil
+}
+
+func isValidIcon(icon string) bool {
+	if iconNameRegexp.MatchString(icon) {
+		return true
+	}
+
+	iconURL, err := url.Parse(icon)
+	return err == nil && (iconURL.Scheme == "http" || iconURL.Scheme == "https") && len(iconURL.Host) > 0
+}
+
+// Connect connects to the API with the user's OIDC credentials or with a bearer token
+func (o *OidcAPISpecification) Connect(ec echo.Context, cnsiRecord interfaces.CNSIRecord, userId string) (*interfaces.TokenRecord, bool, error) {
+	log.Debug("OIDC API Connect...")
+
+	connectType := ec.FormValue("connect_type")
+	if len(connectType) == 0 {
+		connectType = interfaces.AuthConnectTypeCreds
+	}
+
+	var tokenRecord *interfaces.TokenRecord
+	var err error
+	switch connectType {
+	case interfaces.AuthConnectTypeCreds:
+		tokenRecord, err = o.portalProxy.ConnectOidc(ec, cnsiRecord)
+	case interfaces.AuthConnectTypeBearer:
+		tokenRecord, err = o.portalProxy.ConnectBearer(ec, cnsiRecord)
+	default:
+		return nil, false, errors.New("Only username/password or bearer token accepted for API endpoints")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return tokenRecord, false, nil
+}
+
+// Info discovers the OIDC provider's endpoints, assuming that the issuer is the API endpoint itself
+func (o *OidcAPISpecification) Info(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	return o.info(apiEndpoint, apiEndpoint, skipSSLValidation)
+}
+
+// InfoWithMetadata gets the info of an endpoint that is registered with the given metadata, which is how the issuer
+// of imported and bootstrapped endpoints is known
+func (o *OidcAPISpecification) InfoWithMetadata(metadata map[string]string) interfaces.InfoFunc {
+	return func(apiEndpoint string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+		if err := validateRegistrationMetadata(metadata); err != nil {
+			return interfaces.CNSIRecord{CNSIType: EndpointType}, nil, err
+		}
+
+		issuerURL := metadata[issuerURLMetadataKey]
+		if len(issuerURL) == 0 {
+			issuerURL = apiEndpoint
+		}
+		return o.info(apiEndpoint, issuerURL, skipSSLValidation)
+	}
+}
+
+func (o *OidcAPISpecification) info(apiEndpoint, issuerURL string, skipSSLValidation bool) (interfaces.CNSIRecord, interface{}, error) {
+	log.Debug("OIDC API Info")
+	var newCNSI interfaces.CNSIRecord
+
+	newCNSI.CNSIType = EndpointType
+
+	if _, err := url.Parse(apiEndpoint); err != nil {
+		return newCNSI, nil, err
+	}
+
+	oidcConfig, err := o.discover(issuerURL, skipSSLValidation)
+	if err != nil {
+		return newCNSI, nil, err
+	}
+
+	newCNSI.AuthorizationEndpoint = oidcConfig.AuthorizationEndpoint
+	newCNSI.TokenEndpoint = oidcConfig.TokenEndpoint
+
+	return newCNSI, oidcConfig, nil
+}
+
+// discover fetches the OIDC discovery document of the provider
+func (o *OidcAPISpecification) discover(issuerURL string, skipSSLValidation bool) (*OidcConfiguration, error) {
+	discoveryURL := strings.TrimRight(issuerURL, "/") + oidcDiscoveryPath
+	req, err := http.NewRequest("GET", discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create OIDC discovery request: %v", err)
+	}
+
+	h := o.portalProxy.GetHttpClientForRequest(req, skipSSLValidation)
+	res, err := h.Do(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		log.Errorf("Error performing OIDC discovery - response: %v, error: %v", res, err)
+		return nil, interfaces.LogHTTPError(res, err)
+	}
+	defer res.Body.Close()
+
+	oidcConfig := &OidcConfiguration{}
+	if err = json.NewDecoder(res.Body).Decode(oidcConfig); err != nil {
+		return nil, fmt.Errorf("Unable to parse OIDC discovery document from %s: %v", discoveryURL, err)
+	}
+
+	if len(oidcConfig.TokenEndpoint) == 0 {
+		return nil, fmt.Errorf("OIDC discovery document from %s does not include a token endpoint", discoveryURL)
+	}
+
+	return oidcConfig, nil
+}
+
+// UpdateMetadata adds the metadata that each API endpoint was registered with, e.g. its type name and icon
+func (o *OidcAPISpecification) UpdateMetadata(info *interfaces.Info, userGUID string, echoContext echo.Context) {
+	endpoints, ok := info.Endpoints[EndpointType]
+	if !ok {
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		metadata, err := o.portalProxy.GetCNSIMetadata(endpoint.GUID)
+		if err != nil {
+			log.Warnf("Unable to get metadata of API endpoint %s: %v", endpoint.Name, err)
+			continue
+		}
+
+		endpoint.Metadata[typeNameMetadataKey] = defaultTypeName
+		for _, key := range registrationMetadataKeys {
+			if value, ok := metadata[key]; ok {
+				endpoint.Metadata[key] = value
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package oidcapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/engine/standard"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
+
+// mockPortalProxy provides the HTTP client used for discovery and the metadata of the endpoints. Any other use of
+// the portal proxy is unexpected
+type mockPortalProxy struct {
+	interfaces.PortalProxy
+	metadata map[string]map[string]string
+}
+
+func (p *mockPortalProxy) GetHttpClientForRequest(req *http.Request, skipSSLValidation bool) http.Client {
+	return http.Client{}
+}
+
+func (p *mockPortalProxy) GetCNSIMetadata(cnsiGUID string) (map[string]string, error) {
+	return p.metadata[cnsiGUID], nil
+}
+
+// startTestIssuer starts an OIDC provider that serves its discovery document
+func startTestIssuer() *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != oidcDiscoveryPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"issuer":"%[1]s","authorization_endpoint":"%[1]s/authorize","token_endpoint":"%[1]s/token"}`, server.URL)
+	}))
+	return server
+}
+
+func TestInfo(t *testing.T) {
+	t.Parallel()
+
+	Convey("API endpoint info", t, func() {
+		o := &OidcAPISpecification{portalProxy: &mockPortalProxy{}, endpointType: EndpointType}
+		issuer := startTestIssuer()
+		defer issuer.Close()
+
+		Convey("Should discover the endpoints of the issuer in the metadata", func() {
+			info := o.InfoWithMetadata(map[string]string{issuerURLMetadataKey: issuer.URL + "/"})
+			cnsi, oidcConfig, err := info("https://api.example.com", false)
+			So(err, ShouldBeNil)
+			So(cnsi.CNSIType, ShouldEqual, EndpointType)
+			So(cnsi.AuthorizationEndpoint, ShouldEqual, issuer.URL+"/authorize")
+			So(cnsi.TokenEndpoint, ShouldEqual, issuer.URL+"/token")
+			So(oidcConfig.(*OidcConfiguration).Issuer, ShouldEqual, issuer.URL)
+		})
+
+		Convey("Should use the API endpoint as the issuer if there is none in the metadata", func() {
+			cnsi, _, err := o.InfoWithMetadata(map[string]string{typeNameMetadataKey: "Widgets"})(issuer.URL, false)
+			So(err, ShouldBeNil)
+			So(cnsi.TokenEndpoint, ShouldEqual, issuer.URL+"/token")
+
+			cnsi, _, err = o.Info(issuer.URL, false)
+			So(err, ShouldBeNil)
+			So(cnsi.TokenEndpoint, ShouldEqual, issuer.URL+"/token")
+		})
+
+		Convey("Should fail if the API endpoint is not the issuer", func() {
+			api := httptest.NewServer(http.NotFoundHandler())
+			defer api.Close()
+
+			_, _, err := o.Info(api.URL, false)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should fail if the discovery document has no token endpoint", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"issuer":"https://issuer.example.com"}`)
+			}))
+			defer server.Close()
+
+			_, _, err := o.Info(server.URL, false)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Should reject invalid metadata", func() {
+			_, _, err := o.InfoWithMetadata(map[string]string{issuerURLMetadataKey: "ftp://issuer.example.com"})(issuer.URL, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func newRegisterContext(form url.Values) echo.Context {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return echo.New().NewContext(standard.NewRequest(req, nil), standard.NewResponse(httptest.NewRecorder(), nil))
+}
+
+func TestRegistrationMetadata(t *testing.T) {
+	t.Parallel()
+
+	Convey("API endpoint registration metadata", t, func() {
+		Convey("Should keep the issuer, type name and icon", func() {
+			metadata := getRegistrationMetadata(newRegisterContext(url.Values{
+				"cnsi_name":  {"Widgets"},
+				"issuer_url": {" https://issuer.example.com "},
+				"type_name":  {"Widget API"},
+				"icon":       {"widgets"},
+			}))
+			So(metadata, ShouldResemble, map[string]string{
+				issuerURLMetadataKey: "https://issuer.example.com",
+				typeNameMetadataKey:  "Widget API",
+				iconMetadataKey:      "widgets",
+			})
+			So(validateRegistrationMetadata(metadata), ShouldBeNil)
+		})
+
+		Convey("Should use the default type name", func() {
+			metadata := getRegistrationMetadata(newRegisterContext(url.Values{}))
+			So(metadata, ShouldResemble, map[string]string{typeNameMetadataKey: defaultTypeName})
+		})
+
+		Convey("Should reject invalid values", func() {
+			for _, metadata := range []map[string]string{
+				{typeNameMetadataKey: strings.Repeat("x", maxTypeNameLength+1)},
+				{iconMetadataKey: "javascript:alert(1)"},
+				{iconMetadataKey: "Not An Icon"},
+				{issuerURLMetadataKey: "issuer.example.com"},
+			} {
+				err := validateRegistrationMetadata(metadata)
+				So(err, ShouldNotBeNil)
+				So(err.(interfaces.ErrHTTPShadow).HTTPError.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	t.Parallel()
+
+	Convey("Adding the registration metadata of API endpoints", t, func() {
+		o := &OidcAPISpecification{portalProxy: &mockPortalProxy{metadata: map[string]map[string]string{
+			"api-guid":   {typeNameMetadataKey: "Widget API", issuerURLMetadataKey: "https://issuer.example.com", "internal": "secret"},
+			"plain-guid": {},
+		}}}
+		info := &interfaces.Info{Endpoints: map[string]map[string]*interfaces.EndpointDetail{
+			EndpointType: {
+				"api-guid":   {CNSIRecord: &interfaces.CNSIRecord{GUID: "api-guid"}, Metadata: map[string]string{}},
+				"plain-guid": {CNSIRecord: &interfaces.CNSIRecord{GUID: "plain-guid"}, Metadata: map[string]string{}},
+			},
+		}}
+
+		o.UpdateMetadata(info, "user-guid", nil)
+
+		Convey("Should only add the metadata set at registration", func() {
+			So(info.Endpoints[EndpointType]["api-guid"].Metadata, ShouldResemble, map[string]string{
+				typeNameMetadataKey:  "Widget API",
+				issuerURLMetadataKey: "https://issuer.example.com",
+			})
+			So(info.Endpoints[EndpointType]["plain-guid"].Metadata, ShouldResemble, map[string]string{typeNameMetadataKey: defaultTypeName})
+		})
+	})
+}
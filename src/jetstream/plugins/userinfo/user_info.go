@@ -46,7 +46,7 @@ func (userInfo *UserInfo) uaa(c echo.Context) error {
 	password := c.Request().Header().Get("x-stratos-password")
 	if len(password) > 0 {
 		// Need to verify the user's login
-		err := userInfo.portalProxy.RefreshUAALogin(username, password, false)
+		err := userInfo.portalProxy.RefreshUAALogin(c, username, password, false)
 		if err != nil {
 			return err
 		}
@@ -76,7 +76,7 @@ func (userInfo *UserInfo) uaa(c echo.Context) error {
 		if len(newPassword) > 0 {
 			password = newPassword
 		}
-		err := userInfo.portalProxy.RefreshUAALogin(username, password, true)
+		err := userInfo.portalProxy.RefreshUAALogin(c, username, password, true)
 		if err != nil {
 			return err
 		}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"net"
 	"regexp"
 	"time"
 
@@ -17,7 +18,10 @@ type portalProxy struct {
 	Plugins                map[string]interfaces.StratosPlugin
 	Diagnostics            *interfaces.Diagnostics
 	SessionCookieName      string
-	EmptyCookieMatcher     *regexp.Regexp // Used to detect and remove empty Cookies sent by certain browsers
+	EmptyCookieMatcher     *regexp.Regexp     // Used to detect and remove empty Cookies sent by certain browsers
+	LoginThrottle          *loginThrottle     // Tracks failed login attempts
+	TokenRefresh           *tokenRefreshGroup // Ensures only one refresh runs at a time for each endpoint token
+	TrustedProxies         []*net.IPNet       // Proxies whose X-Forwarded-For headers are trusted for login client IPs
 }
 
 // HttpSessionStore - Interface for a store that can manage HTTP Sessions
@@ -1,6 +1,8 @@
 package cnsis
 
 import (
+	"database/sql"
+
 	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
 )
 
@@ -13,6 +15,24 @@ type Repository interface {
 	Delete(guid string) error
 	Save(guid string, cnsiRecord interfaces.CNSIRecord, encryptionKey []byte) error
 	Update(guid string, ssoAllowed bool) error
+	UpdateRegistration(guid string, cnsiRecord interfaces.CNSIRecord, encryptionKey []byte) error
+	Overwrite(guid string, cnsiRecord interfaces.CNSIRecord, encryptionKey []byte) error
+
+	// Unregistered endpoints can be restored until they are purged
+	ListDeleted(encryptionKey []byte) ([]*interfaces.DeletedCNSIRecord, error)
+	SoftDelete(guid string, deletedAt int64) error
+	Restore(guid string) error
+	Purge(guid string) error
+	PurgeInTransaction(txn *sql.Tx, guid string) error
+
+	// User defined attributes (description, group and labels)
+	ListAttributes() (map[string]*interfaces.CNSIAttributes, error)
+	SaveAttributes(guid string, attributes interfaces.CNSIAttributes) error
+	DeleteAttributes(guid string) error
+
+	// Metadata set by endpoint plugins at registration
+	GetMetadata(guid string) (map[string]string, error)
+	SaveMetadata(guid string, metadata map[string]string) error
 }
 
 type Endpoint interface {
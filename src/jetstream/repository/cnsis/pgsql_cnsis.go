@@ -2,6 +2,7 @@ package cnsis
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/url"
@@ -13,28 +14,60 @@ import (
 )
 
 var listCNSIs = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed
-							FROM cnsis`
+							FROM cnsis
+							WHERE deleted_at IS NULL`
 
 var listCNSIsByUser = `SELECT c.guid, c.name, c.cnsi_type, c.api_endpoint, c.doppler_logging_endpoint, t.user_guid, t.token_expiry, c.skip_ssl_validation, t.disconnected, t.meta_data
 										FROM cnsis c, tokens t
-										WHERE c.guid = t.cnsi_guid AND t.token_type=$1 AND t.user_guid=$2 AND t.disconnected = '0'`
+										WHERE c.guid = t.cnsi_guid AND c.deleted_at IS NULL AND t.token_type=$1 AND t.user_guid=$2 AND t.disconnected = '0'`
 
 var findCNSI = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed
 						FROM cnsis
-						WHERE guid=$1`
+						WHERE guid=$1 AND deleted_at IS NULL`
 
 var findCNSIByAPIEndpoint = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed
 						FROM cnsis
-						WHERE api_endpoint=$1`
+						WHERE api_endpoint=$1 AND deleted_at IS NULL`
 
 var saveCNSI = `INSERT INTO cnsis (guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed)
 						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 
 var deleteCNSI = `DELETE FROM cnsis WHERE guid = $1`
 
+var listDeletedCNSIs = `SELECT guid, name, cnsi_type, api_endpoint, auth_endpoint, token_endpoint, doppler_logging_endpoint, skip_ssl_validation, client_id, client_secret, sso_allowed, deleted_at
+							FROM cnsis
+							WHERE deleted_at IS NOT NULL`
+
+var softDeleteCNSI = `UPDATE cnsis SET deleted_at = $1 WHERE guid = $2 AND deleted_at IS NULL`
+
+var restoreCNSI = `UPDATE cnsis SET deleted_at = NULL WHERE guid = $1 AND deleted_at IS NOT NULL`
+
 // Just update the SSO Allowed state for now
 var updateCNSI = `UPDATE cnsis SET sso_allowed = $1 WHERE guid = $2`
 
+var updateCNSIRegistration = `UPDATE cnsis SET name = $1, skip_ssl_validation = $2, client_id = $3, client_secret = $4, sso_allowed = $5 WHERE guid = $6`
+
+var overwriteCNSI = `UPDATE cnsis SET name = $1, cnsi_type = $2, api_endpoint = $3, auth_endpoint = $4, token_endpoint = $5, doppler_logging_endpoint = $6, skip_ssl_validation = $7, client_id = $8, client_secret = $9, sso_allowed = $10
+						WHERE guid = $11 AND deleted_at IS NULL`
+
+var listCNSIAttributes = `SELECT cnsi_guid, description, endpoint_group, labels
+						FROM cnsi_attributes`
+
+var updateCNSIAttributes = `UPDATE cnsi_attributes SET description = $1, endpoint_group = $2, labels = $3 WHERE cnsi_guid = $4`
+
+var insertCNSIAttributes = `INSERT INTO cnsi_attributes (cnsi_guid, description, endpoint_group, labels)
+						VALUES ($1, $2, $3, $4)`
+
+var deleteCNSIAttributes = `DELETE FROM cnsi_attributes WHERE cnsi_guid = $1`
+
+var findCNSIMetadata = `SELECT metadata FROM cnsi_metadata WHERE cnsi_guid = $1`
+
+var updateCNSIMetadata = `UPDATE cnsi_metadata SET metadata = $1 WHERE cnsi_guid = $2`
+
+var insertCNSIMetadata = `INSERT INTO cnsi_metadata (cnsi_guid, metadata) VALUES ($1, $2)`
+
+var deleteCNSIMetadata = `DELETE FROM cnsi_metadata WHERE cnsi_guid = $1`
+
 // PostgresCNSIRepository is a PostgreSQL-backed CNSI repository
 type PostgresCNSIRepository struct {
 	db *sql.DB
@@ -54,7 +87,19 @@ func InitRepositoryProvider(databaseProvider string) {
 	findCNSIByAPIEndpoint = datastore.ModifySQLStatement(findCNSIByAPIEndpoint, databaseProvider)
 	saveCNSI = datastore.ModifySQLStatement(saveCNSI, databaseProvider)
 	deleteCNSI = datastore.ModifySQLStatement(deleteCNSI, databaseProvider)
+	listDeletedCNSIs = datastore.ModifySQLStatement(listDeletedCNSIs, databaseProvider)
+	softDeleteCNSI = datastore.ModifySQLStatement(softDeleteCNSI, databaseProvider)
+	restoreCNSI = datastore.ModifySQLStatement(restoreCNSI, databaseProvider)
 	updateCNSI = datastore.ModifySQLStatement(updateCNSI, databaseProvider)
+	updateCNSIRegistration = datastore.ModifySQLStatement(updateCNSIRegistration, databaseProvider)
+	listCNSIAttributes = datastore.ModifySQLStatement(listCNSIAttributes, databaseProvider)
+	updateCNSIAttributes = datastore.ModifySQLStatement(updateCNSIAttributes, databaseProvider)
+	insertCNSIAttributes = datastore.ModifySQLStatement(insertCNSIAttributes, databaseProvider)
+	deleteCNSIAttributes = datastore.ModifySQLStatement(deleteCNSIAttributes, databaseProvider)
+	findCNSIMetadata = datastore.ModifySQLStatement(findCNSIMetadata, databaseProvider)
+	updateCNSIMetadata = datastore.ModifySQLStatement(updateCNSIMetadata, databaseProvider)
+	insertCNSIMetadata = datastore.ModifySQLStatement(insertCNSIMetadata, databaseProvider)
+	deleteCNSIMetadata = datastore.ModifySQLStatement(deleteCNSIMetadata, databaseProvider)
 }
 
 // List - Returns a list of CNSI Records
@@ -227,6 +272,135 @@ func (p *PostgresCNSIRepository) Delete(guid string) error {
 	return nil
 }
 
+// ListDeleted - Returns a list of the CNSI Records that have been unregistered but not yet purged
+func (p *PostgresCNSIRepository) ListDeleted(encryptionKey []byte) ([]*interfaces.DeletedCNSIRecord, error) {
+	log.Debug("ListDeleted")
+	rows, err := p.db.Query(listDeletedCNSIs)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve deleted CNSI records: %v", err)
+	}
+	defer rows.Close()
+
+	cnsiList := make([]*interfaces.DeletedCNSIRecord, 0)
+	for rows.Next() {
+		var (
+			pCNSIType              string
+			pURL                   string
+			cipherTextClientSecret []byte
+		)
+
+		cnsi := &interfaces.DeletedCNSIRecord{CNSIRecord: new(interfaces.CNSIRecord)}
+
+		err := rows.Scan(&cnsi.GUID, &cnsi.Name, &pCNSIType, &pURL, &cnsi.AuthorizationEndpoint, &cnsi.TokenEndpoint, &cnsi.DopplerLoggingEndpoint, &cnsi.SkipSSLValidation, &cnsi.ClientId, &cipherTextClientSecret, &cnsi.SSOAllowed, &cnsi.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan deleted CNSI records: %v", err)
+		}
+
+		cnsi.CNSIType = pCNSIType
+
+		if cnsi.APIEndpoint, err = url.Parse(pURL); err != nil {
+			return nil, fmt.Errorf("Unable to parse API Endpoint: %v", err)
+		}
+
+		plaintextClientSecret, err := crypto.DecryptToken(encryptionKey, cipherTextClientSecret)
+		if err != nil {
+			return nil, err
+		}
+		cnsi.ClientSecret = plaintextClientSecret
+
+		cnsiList = append(cnsiList, cnsi)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to List deleted CNSI records: %v", err)
+	}
+
+	return cnsiList, nil
+}
+
+// SoftDelete - Unregister a CNSI, keeping its record and tokens so that it can be restored
+func (p *PostgresCNSIRepository) SoftDelete(guid string, deletedAt int64) error {
+	log.Debug("SoftDelete")
+	result, err := p.db.Exec(softDeleteCNSI, deletedAt, guid)
+	if err != nil {
+		return fmt.Errorf("Unable to Delete CNSI record: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to Delete CNSI record: could not determine number of rows that were updated")
+	}
+
+	if rowsUpdates < 1 {
+		return errors.New("No match for that Endpoint")
+	}
+
+	return nil
+}
+
+// Restore - Restore a CNSI that has been unregistered but not yet purged
+func (p *PostgresCNSIRepository) Restore(guid string) error {
+	log.Debug("Restore")
+	result, err := p.db.Exec(restoreCNSI, guid)
+	if err != nil {
+		return fmt.Errorf("Unable to Restore CNSI record: %v", err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to Restore CNSI record: could not determine number of rows that were updated")
+	}
+
+	if rowsUpdates < 1 {
+		return errors.New("No match for that deleted Endpoint")
+	}
+
+	return nil
+}
+
+// Purge - Permanently remove a CNSI along with its attributes and metadata. This is done in a single transaction, so
+// either everything is removed or nothing is
+func (p *PostgresCNSIRepository) Purge(guid string) error {
+	log.Debug("Purge")
+	txn, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to start transaction to Purge CNSI record: %v", err)
+	}
+
+	if err = p.PurgeInTransaction(txn, guid); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to commit transaction to Purge CNSI record: %v", err)
+	}
+
+	return nil
+}
+
+// PurgeInTransaction - Permanently remove a CNSI along with its attributes and metadata as part of a transaction, so
+// that its tokens can be removed in the same transaction. The caller commits or rolls back
+func (p *PostgresCNSIRepository) PurgeInTransaction(txn *sql.Tx, guid string) error {
+	log.Debug("PurgeInTransaction")
+	for _, statement := range []string{deleteCNSIAttributes, deleteCNSIMetadata} {
+		if _, err := txn.Exec(statement, guid); err != nil {
+			return fmt.Errorf("Unable to Purge CNSI record: %v", err)
+		}
+	}
+
+	result, err := txn.Exec(deleteCNSI, guid)
+	if err != nil {
+		return fmt.Errorf("Unable to Purge CNSI record: %v", err)
+	}
+
+	if rowsDeleted, err := result.RowsAffected(); err != nil || rowsDeleted < 1 {
+		return errors.New("No match for that Endpoint")
+	}
+
+	return nil
+}
+
 // UpdateCNSI - Update an endpoint's SSO permitted state
 func (p *PostgresCNSIRepository) Update(guid string, ssoAllowed bool) error {
 	log.Debug("UpdateCNSI")
@@ -263,3 +437,229 @@ func (p *PostgresCNSIRepository) Update(guid string, ssoAllowed bool) error {
 
 	return nil
 }
+
+// UpdateRegistration - Update the user editable details of an endpoint's registration
+func (p *PostgresCNSIRepository) UpdateRegistration(guid string, cnsi interfaces.CNSIRecord, encryptionKey []byte) error {
+	log.Debug("UpdateRegistration")
+
+	if guid == "" {
+		msg := "Unable to update Endpoint without a valid guid."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	cipherTextClientSecret, err := crypto.EncryptToken(encryptionKey, cnsi.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	result, err := p.db.Exec(updateCNSIRegistration, cnsi.Name, cnsi.SkipSSLValidation, cnsi.ClientId, cipherTextClientSecret, cnsi.SSOAllowed, guid)
+	if err != nil {
+		msg := "Unable to UPDATE endpoint: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to UPDATE endpoint: could not determine number of rows that were updated")
+	}
+
+	if rowsUpdates < 1 {
+		return errors.New("Unable to UPDATE endpoint: no rows were updated")
+	}
+
+	return nil
+}
+
+// Overwrite - Replace the whole registration of an endpoint, keeping its guid so that its tokens, token shares,
+// attributes and metadata are kept. This is done in a transaction, so the registration is either replaced or left as is
+func (p *PostgresCNSIRepository) Overwrite(guid string, cnsi interfaces.CNSIRecord, encryptionKey []byte) error {
+	log.Debug("Overwrite")
+
+	if guid == "" {
+		msg := "Unable to overwrite Endpoint without a valid guid."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	cipherTextClientSecret, err := crypto.EncryptToken(encryptionKey, cnsi.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	txn, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to start transaction to Overwrite CNSI record: %v", err)
+	}
+
+	result, err := txn.Exec(overwriteCNSI, cnsi.Name, fmt.Sprintf("%s", cnsi.CNSIType), fmt.Sprintf("%s", cnsi.APIEndpoint),
+		cnsi.AuthorizationEndpoint, cnsi.TokenEndpoint, cnsi.DopplerLoggingEndpoint, cnsi.SkipSSLValidation,
+		cnsi.ClientId, cipherTextClientSecret, cnsi.SSOAllowed, guid)
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Unable to Overwrite CNSI record: %v", err)
+	}
+
+	if rowsUpdated, err := result.RowsAffected(); err != nil || rowsUpdated != 1 {
+		txn.Rollback()
+		return errors.New("Unable to Overwrite CNSI record: no match for that Endpoint")
+	}
+
+	if err = txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to commit transaction to Overwrite CNSI record: %v", err)
+	}
+
+	return nil
+}
+
+// ListAttributes - Returns the user defined attributes of all endpoints, keyed by endpoint guid
+func (p *PostgresCNSIRepository) ListAttributes() (map[string]*interfaces.CNSIAttributes, error) {
+	log.Debug("ListAttributes")
+	rows, err := p.db.Query(listCNSIAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve CNSI attributes: %v", err)
+	}
+	defer rows.Close()
+
+	attributesList := make(map[string]*interfaces.CNSIAttributes)
+	for rows.Next() {
+		var (
+			guid        string
+			description sql.NullString
+			group       sql.NullString
+			labels      sql.NullString
+		)
+
+		if err := rows.Scan(&guid, &description, &group, &labels); err != nil {
+			return nil, fmt.Errorf("Unable to scan CNSI attributes: %v", err)
+		}
+
+		attributes := &interfaces.CNSIAttributes{
+			Description: description.String,
+			Group:       group.String,
+		}
+		if labels.Valid && len(labels.String) > 0 {
+			if err := json.Unmarshal([]byte(labels.String), &attributes.Labels); err != nil {
+				return nil, fmt.Errorf("Unable to parse labels for CNSI %s: %v", guid, err)
+			}
+		}
+		attributesList[guid] = attributes
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to List CNSI attributes: %v", err)
+	}
+
+	return attributesList, nil
+}
+
+// SaveAttributes - Create or replace the user defined attributes of an endpoint
+func (p *PostgresCNSIRepository) SaveAttributes(guid string, attributes interfaces.CNSIAttributes) error {
+	log.Debug("SaveAttributes")
+
+	if guid == "" {
+		msg := "Unable to save Endpoint attributes without a valid guid."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	labels, err := json.Marshal(attributes.Labels)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal labels: %v", err)
+	}
+
+	result, err := p.db.Exec(updateCNSIAttributes, attributes.Description, attributes.Group, string(labels), guid)
+	if err != nil {
+		msg := "Unable to UPDATE endpoint attributes: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to UPDATE endpoint attributes: could not determine number of rows that were updated")
+	}
+
+	// No attributes yet for this endpoint
+	if rowsUpdates < 1 {
+		if _, err := p.db.Exec(insertCNSIAttributes, guid, attributes.Description, attributes.Group, string(labels)); err != nil {
+			msg := "Unable to INSERT endpoint attributes: %v"
+			log.Debugf(msg, err)
+			return fmt.Errorf(msg, err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteAttributes - Delete the user defined attributes of an endpoint
+func (p *PostgresCNSIRepository) DeleteAttributes(guid string) error {
+	log.Debug("DeleteAttributes")
+	if _, err := p.db.Exec(deleteCNSIAttributes, guid); err != nil {
+		return fmt.Errorf("Unable to Delete CNSI attributes: %v", err)
+	}
+
+	return nil
+}
+
+// GetMetadata - Returns the metadata that was set for an endpoint when it was registered
+func (p *PostgresCNSIRepository) GetMetadata(guid string) (map[string]string, error) {
+	log.Debug("GetMetadata")
+	var data sql.NullString
+	err := p.db.QueryRow(findCNSIMetadata, guid).Scan(&data)
+	switch {
+	case err == sql.ErrNoRows:
+		return make(map[string]string), nil
+	case err != nil:
+		return nil, fmt.Errorf("Error trying to Find CNSI metadata: %v", err)
+	}
+
+	metadata := make(map[string]string)
+	if data.Valid && len(data.String) > 0 {
+		if err := json.Unmarshal([]byte(data.String), &metadata); err != nil {
+			return nil, fmt.Errorf("Unable to parse metadata for CNSI %s: %v", guid, err)
+		}
+	}
+
+	return metadata, nil
+}
+
+// SaveMetadata - Create or replace the metadata of an endpoint
+func (p *PostgresCNSIRepository) SaveMetadata(guid string, metadata map[string]string) error {
+	log.Debug("SaveMetadata")
+
+	if guid == "" {
+		msg := "Unable to save Endpoint metadata without a valid guid."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal metadata: %v", err)
+	}
+
+	result, err := p.db.Exec(updateCNSIMetadata, string(data), guid)
+	if err != nil {
+		msg := "Unable to UPDATE endpoint metadata: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	rowsUpdates, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to UPDATE endpoint metadata: could not determine number of rows that were updated")
+	}
+
+	// No metadata yet for this endpoint
+	if rowsUpdates < 1 {
+		if _, err := p.db.Exec(insertCNSIMetadata, guid, string(data)); err != nil {
+			msg := "Unable to INSERT endpoint metadata: %v"
+			log.Debugf(msg, err)
+			return fmt.Errorf(msg, err)
+		}
+	}
+
+	return nil
+}
@@ -594,4 +594,358 @@ func TestPgSQLCNSIs(t *testing.T) {
 		})
 	})
 
+	Convey("Given a request to update the registration of a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if successful", func() {
+
+			mock.ExpectExec(`UPDATE cnsis SET name`).
+				WithArgs("Renamed CF", true, "cf", sqlmock.AnyArg(), false, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Convey("there should be no error returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.UpdateRegistration(mockCFGUID, interfaces.CNSIRecord{Name: "Renamed CF", SkipSSLValidation: true, ClientId: "cf", ClientSecret: "secret"}, mockEncryptionKey)
+				So(err, ShouldBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+
+		Convey("if the CNSI does not exist", func() {
+
+			mock.ExpectExec(`UPDATE cnsis SET name`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Convey("there should be an error returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.UpdateRegistration(mockCFGUID, interfaces.CNSIRecord{Name: "Renamed CF"}, mockEncryptionKey)
+				So(err, ShouldNotBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request to overwrite the registration of a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if successful", func() {
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE cnsis SET name`).
+				WithArgs("Overwritten CF", "cf", mockAPIEndpoint, "", "", "", true, "cf", sqlmock.AnyArg(), false, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectCommit()
+
+			Convey("there should be no error returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				apiEndpoint, _ := url.Parse(mockAPIEndpoint)
+				err := repository.Overwrite(mockCFGUID, interfaces.CNSIRecord{Name: "Overwritten CF", CNSIType: "cf", APIEndpoint: apiEndpoint, SkipSSLValidation: true, ClientId: "cf", ClientSecret: "secret"}, mockEncryptionKey)
+				So(err, ShouldBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+
+		Convey("if the CNSI does not exist", func() {
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE cnsis SET name`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			Convey("there should be an error returned and the transaction rolled back", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.Overwrite(mockCFGUID, interfaces.CNSIRecord{Name: "Overwritten CF"}, mockEncryptionKey)
+				So(err, ShouldNotBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request for the attributes of the CNSIs", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if attributes have been saved", func() {
+
+			rs := sqlmock.NewRows([]string{"cnsi_guid", "description", "endpoint_group", "labels"}).
+				AddRow(mockCFGUID, "Production CF", "prod", `{"env":"prod","region":"eu"}`).
+				AddRow(mockCEGUID, nil, nil, nil)
+			mock.ExpectQuery(`SELECT (.+) FROM cnsi_attributes`).
+				WillReturnRows(rs)
+
+			Convey("the attributes should be returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				attributes, err := repository.ListAttributes()
+				So(err, ShouldBeNil)
+				So(attributes, ShouldHaveLength, 2)
+				So(attributes[mockCFGUID].Description, ShouldEqual, "Production CF")
+				So(attributes[mockCFGUID].Group, ShouldEqual, "prod")
+				So(attributes[mockCFGUID].Labels, ShouldResemble, map[string]string{"env": "prod", "region": "eu"})
+				So(attributes[mockCEGUID].Labels, ShouldBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request to save the attributes of a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		attributes := interfaces.CNSIAttributes{Description: "Production CF", Labels: map[string]string{"env": "prod"}}
+
+		Convey("if the CNSI already has attributes", func() {
+
+			mock.ExpectExec(`UPDATE cnsi_attributes`).
+				WithArgs("Production CF", "", `{"env":"prod"}`, mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Convey("they should be updated", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.SaveAttributes(mockCFGUID, attributes)
+				So(err, ShouldBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+
+		Convey("if the CNSI has no attributes", func() {
+
+			mock.ExpectExec(`UPDATE cnsi_attributes`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO cnsi_attributes`).
+				WithArgs(mockCFGUID, "Production CF", "", `{"env":"prod"}`).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Convey("they should be inserted", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.SaveAttributes(mockCFGUID, attributes)
+				So(err, ShouldBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request to soft delete a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if the CNSI is registered", func() {
+
+			mock.ExpectExec(`UPDATE cnsis SET deleted_at`).
+				WithArgs(int64(1539856800), mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			Convey("there should be no error returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.SoftDelete(mockCFGUID, 1539856800)
+				So(err, ShouldBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+
+		Convey("if the CNSI is not registered", func() {
+
+			mock.ExpectExec(`UPDATE cnsis SET deleted_at`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Convey("there should be an error returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.SoftDelete(mockCFGUID, 1539856800)
+				So(err, ShouldNotBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request for the deleted CNSIs", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		rs := sqlmock.NewRows(append(rowFieldsForCNSI, "deleted_at")).
+			AddRow(mockCFGUID, "Some fancy CF Cluster", "cf", mockAPIEndpoint, mockAuthEndpoint, mockAuthEndpoint, mockDopplerEndpoint, true, mockClientId, cipherClientSecret, true, 1539856800)
+		mock.ExpectQuery(`SELECT (.+) FROM cnsis WHERE deleted_at IS NOT NULL`).
+			WillReturnRows(rs)
+
+		Convey("the deleted CNSIs should be returned", func() {
+			repository, _ := NewPostgresCNSIRepository(db)
+			deleted, err := repository.ListDeleted(mockEncryptionKey)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldHaveLength, 1)
+			So(deleted[0].GUID, ShouldEqual, mockCFGUID)
+			So(deleted[0].ClientSecret, ShouldEqual, mockClientSecret)
+			So(deleted[0].DeletedAt, ShouldEqual, 1539856800)
+
+			dberr := mock.ExpectationsWereMet()
+			So(dberr, ShouldBeNil)
+		})
+	})
+
+	Convey("Given a request to restore a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if the CNSI has not been deleted", func() {
+
+			mock.ExpectExec(`UPDATE cnsis SET deleted_at = NULL`).
+				WithArgs(mockCFGUID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Convey("there should be an error returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.Restore(mockCFGUID)
+				So(err, ShouldNotBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request to purge a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if the CNSI does not exist", func() {
+
+			mock.ExpectBegin()
+			mock.ExpectExec(`DELETE FROM cnsi_attributes`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM cnsi_metadata`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(deleteFromCNSIs).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			Convey("there should be an error returned and the transaction rolled back", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.Purge(mockCFGUID)
+				So(err, ShouldNotBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request for the metadata of a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if metadata has been saved", func() {
+
+			mock.ExpectQuery(`SELECT metadata FROM cnsi_metadata`).
+				WithArgs(mockCFGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}).AddRow(`{"type_name":"Inventory"}`))
+
+			Convey("the metadata should be returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				metadata, err := repository.GetMetadata(mockCFGUID)
+				So(err, ShouldBeNil)
+				So(metadata, ShouldResemble, map[string]string{"type_name": "Inventory"})
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+
+		Convey("if no metadata has been saved", func() {
+
+			mock.ExpectQuery(`SELECT metadata FROM cnsi_metadata`).
+				WithArgs(mockCFGUID).
+				WillReturnRows(sqlmock.NewRows([]string{"metadata"}))
+
+			Convey("empty metadata should be returned", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				metadata, err := repository.GetMetadata(mockCFGUID)
+				So(err, ShouldBeNil)
+				So(metadata, ShouldBeEmpty)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a request to save the metadata of a specific CNSI", t, func() {
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Errorf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+
+		Convey("if the CNSI has no metadata", func() {
+
+			mock.ExpectExec(`UPDATE cnsi_metadata`).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO cnsi_metadata`).
+				WithArgs(mockCFGUID, `{"icon":"api","type_name":"Inventory"}`).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+
+			Convey("it should be inserted", func() {
+				repository, _ := NewPostgresCNSIRepository(db)
+				err := repository.SaveMetadata(mockCFGUID, map[string]string{"type_name": "Inventory", "icon": "api"})
+				So(err, ShouldBeNil)
+
+				dberr := mock.ExpectationsWereMet()
+				So(dberr, ShouldBeNil)
+			})
+		})
+	})
+
 }
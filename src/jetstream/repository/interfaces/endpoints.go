@@ -12,7 +12,40 @@ type EndpointPlugin interface {
 	UpdateMetadata(info *Info, userGUID string, echoContext echo.Context)
 }
 
+// EndpointClientPlugin can be implemented by endpoint plugins whose endpoints should not be connected to with the
+// Cloud Foundry client when no client is given at registration
+type EndpointClientPlugin interface {
+	// DefaultClient gets the ID and secret of the client to use
+	DefaultClient() (string, string)
+}
+
+// EndpointMetadataPlugin can be implemented by endpoint plugins whose endpoints are registered with settings that
+// are kept in the endpoint's metadata. The metadata is exported with the endpoint, so that endpoints that are imported
+// or bootstrapped can be registered with the same settings
+type EndpointMetadataPlugin interface {
+	// InfoWithMetadata gets the info function used to register an endpoint with the given metadata
+	InfoWithMetadata(metadata map[string]string) InfoFunc
+}
+
 type RoutePlugin interface {
 	AddSessionGroupRoutes(echoContext *echo.Group)
 	AddAdminGroupRoutes(echoContext *echo.Group)
 }
+
+// EndpointAction is a change that has been made to an endpoint's registration
+type EndpointAction int
+
+const (
+	// EndpointUnregisterAction - the endpoint has been unregistered. It can be restored until it is purged
+	EndpointUnregisterAction EndpointAction = iota
+	// EndpointRestoreAction - an unregistered endpoint has been restored
+	EndpointRestoreAction
+	// EndpointPurgeAction - an unregistered endpoint and its tokens have been permanently removed
+	EndpointPurgeAction
+)
+
+// EndpointNotificationPlugin can be implemented by plugins that need to know when endpoints are unregistered,
+// restored or purged, e.g. so that they can clean up their own state
+type EndpointNotificationPlugin interface {
+	OnEndpointNotification(action EndpointAction, endpoint *CNSIRecord)
+}
@@ -11,6 +11,7 @@ import (
 type PortalProxy interface {
 	GetHttpClient(skipSSLValidation bool) http.Client
 	GetHttpClientForRequest(req *http.Request, skipSSLValidation bool) http.Client
+	GetHttpClientForCertificate(tokenRecord *TokenRecord, skipSSLValidation bool) (http.Client, error)
 	RegisterEndpoint(c echo.Context, fetchInfo InfoFunc) error
 
 	DoRegisterEndpoint(cnsiName string, apiEndpoint string, skipSSLValidation bool, clientId string, clientSecret string, ssoAllowed bool, fetchInfo InfoFunc) (CNSIRecord, error)
@@ -19,6 +20,10 @@ type PortalProxy interface {
 
 	// Auth
 	ConnectOAuth2(c echo.Context, cnsiRecord CNSIRecord) (*TokenRecord, error)
+	ConnectOAuth2ClientCredentials(c echo.Context, cnsiRecord CNSIRecord) (*TokenRecord, error)
+	ConnectBearer(c echo.Context, cnsiRecord CNSIRecord) (*TokenRecord, error)
+	ConnectCertificate(c echo.Context, cnsiRecord CNSIRecord) (*TokenRecord, error)
+	ConnectOidc(c echo.Context, cnsiRecord CNSIRecord) (*TokenRecord, error)
 	InitEndpointTokenRecord(expiry int64, authTok string, refreshTok string, disconnect bool) TokenRecord
 
 	// Session
@@ -37,6 +42,8 @@ type PortalProxy interface {
 	// Expose internal portal proxy records to extensions
 	GetCNSIRecord(guid string) (CNSIRecord, error)
 	GetCNSIRecordByEndpoint(endpoint string) (CNSIRecord, error)
+	GetCNSIMetadata(cnsiGUID string) (map[string]string, error)
+	SetCNSIMetadata(cnsiGUID string, metadata map[string]string) error
 	GetCNSITokenRecord(cnsiGUID string, userGUID string) (TokenRecord, bool)
 	GetCNSITokenRecordWithDisconnected(cnsiGUID string, userGUID string) (TokenRecord, bool)
 	GetCNSIUser(cnsiGUID string, userGUID string) (*ConnectedUser, bool)
@@ -48,7 +55,7 @@ type PortalProxy interface {
 	RefreshUAAToken(userGUID string) (TokenRecord, error)
 
 	GetUsername(userid string) (string, error)
-	RefreshUAALogin(username, password string, store bool) error
+	RefreshUAALogin(c echo.Context, username, password string, store bool) error
 	GetUserTokenInfo(tok string) (u *JWTUserTokenInfo, err error)
 	GetUAAUser(userGUID string) (*ConnectedUser, error)
 
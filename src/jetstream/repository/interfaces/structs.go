@@ -32,6 +32,21 @@ type CNSIRecord struct {
 	ClientId               string   `json:"client_id"`
 	ClientSecret           string   `json:"-"`
 	SSOAllowed             bool     `json:"sso_allowed"`
+	CNSIAttributes
+}
+
+// CNSIAttributes - user defined attributes used to describe and organise endpoints
+type CNSIAttributes struct {
+	Description string            `json:"description,omitempty"`
+	Group       string            `json:"group,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// DeletedCNSIRecord - an endpoint that has been unregistered, but can still be restored
+type DeletedCNSIRecord struct {
+	*CNSIRecord
+	DeletedAt int64 `json:"deleted_at"`
+	PurgeAt   int64 `json:"purge_at,omitempty"`
 }
 
 // ConnectedEndpoint
@@ -48,13 +63,22 @@ type ConnectedEndpoint struct {
 }
 
 const (
-	AuthTypeOAuth2    = "OAuth2"
-	AuthTypeOIDC      = "OIDC"
-	AuthTypeHttpBasic = "HttpBasic"
+	AuthTypeOAuth2      = "OAuth2"
+	AuthTypeOIDC        = "OIDC"
+	AuthTypeHttpBasic   = "HttpBasic"
+	AuthTypeBearer      = "Bearer"
+	AuthTypeCertificate = "Certificate"
+)
+
+const (
+	AuthConnectTypeCreds             = "creds"
+	AuthConnectTypeClientCredentials = "client_credentials"
+	AuthConnectTypeBearer            = "bearer"
+	AuthConnectTypeCertificate       = "certificate"
 )
 
 const (
-	AuthConnectTypeCreds = "creds"
+	OAuth2GrantTypeClientCredentials = "client_credentials"
 )
 
 // Token record for an endpoint (includes the Endpoint GUID)
@@ -87,9 +111,11 @@ type CFInfo struct {
 
 // Structure for optional metadata for an OAuth2 Token
 type OAuth2Metadata struct {
-	ClientID     string
-	ClientSecret string
-	IssuerURL    string
+	ClientID      string
+	ClientSecret  string
+	IssuerURL     string
+	GrantType     string `json:",omitempty"`
+	TokenEndpoint string `json:",omitempty"`
 }
 
 type VCapApplicationData struct {
@@ -134,6 +160,7 @@ type ConnectedUser struct {
 type JWTUserTokenInfo struct {
 	UserGUID    string   `json:"user_id"`
 	UserName    string   `json:"user_name"`
+	ClientID    string   `json:"client_id"`
 	TokenExpiry int64    `json:"exp"`
 	Scope       []string `json:"scope"`
 }
@@ -175,6 +202,7 @@ type EndpointDetail struct {
 	Metadata          map[string]string `json:"metadata,omitempty"`
 	TokenMetadata     string            `json:"-"`
 	SystemSharedToken bool              `json:"system_shared_token"`
+	DisconnectReason  string            `json:"disconnect_reason,omitempty"`
 }
 
 // Versions - response returned to caller from a getVersions action
@@ -233,6 +261,14 @@ type PortalConfig struct {
 	SSOOptions                      string   `configName:"SSO_OPTIONS"`
 	CookieDomain                    string   `configName:"COOKIE_DOMAIN"`
 	LogLevel                        string   `configName:"LOG_LEVEL"`
+	LoginMaxFailedAttempts          int      `configName:"LOGIN_MAX_FAILED_ATTEMPTS"`
+	LoginMaxFailedAttemptsPerIP     int      `configName:"LOGIN_MAX_FAILED_ATTEMPTS_PER_IP"`
+	LoginLockoutInSecs              int64    `configName:"LOGIN_LOCKOUT_IN_SECS"`
+	TrustedProxies                  []string `configName:"TRUSTED_PROXIES"`
+	TokenRefreshIntervalInSecs      int64    `configName:"TOKEN_REFRESH_INTERVAL_IN_SECS"`
+	TokenRefreshThresholdInSecs     int64    `configName:"TOKEN_REFRESH_THRESHOLD_IN_SECS"`
+	EndpointsConfigFile             string   `configName:"ENDPOINTS_CONFIG_FILE"`
+	EndpointRestoreWindowInSecs     int64    `configName:"ENDPOINT_RESTORE_WINDOW_IN_SECS"`
 	CFAdminIdentifier               string
 	CloudFoundryInfo                *CFInfo
 	HTTPS                           bool
@@ -51,7 +51,7 @@ var insertCNSIToken = `INSERT INTO tokens (token_guid, cnsi_guid, user_guid, tok
 										VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 
 var updateCNSIToken = `UPDATE tokens
-										SET auth_token = $1, refresh_token = $2, token_expiry = $3, disconnected = $4, meta_data = $5, linked_token = $6
+										SET auth_token = $1, refresh_token = $2, token_expiry = $3, disconnected = $4, meta_data = $5, linked_token = $6, disconnect_reason = NULL
 										WHERE cnsi_guid = $7 AND user_guid = $8 AND token_type = $9 AND auth_type = $10`
 var deleteCNSIToken = `DELETE FROM tokens
 										WHERE token_type = 'cnsi' AND cnsi_guid = $1 AND user_guid = $2`
@@ -62,6 +62,33 @@ var updateToken = `UPDATE tokens
 										SET auth_token = $1, refresh_token = $2, token_expiry = $3
 										WHERE token_guid = $4 AND user_guid = $5`
 
+var disconnectCNSIToken = `UPDATE tokens
+										SET disconnected = $1, disconnect_reason = $2
+										WHERE token_type = 'cnsi' AND cnsi_guid = $3 AND user_guid = $4`
+
+var findCNSITokenDisconnectReason = `SELECT disconnect_reason
+										FROM tokens
+										WHERE cnsi_guid = $1 AND (user_guid = $2 OR user_guid = $3) AND token_type = 'cnsi' AND disconnected = $4
+										ORDER BY CASE WHEN user_guid = $2 THEN 0 ELSE 1 END
+										LIMIT 1`
+
+var listExpiringCNSITokens = `SELECT t.cnsi_guid, t.user_guid, t.auth_type, t.token_expiry
+										FROM tokens t, cnsis c
+										WHERE t.cnsi_guid = c.guid AND c.deleted_at IS NULL AND t.token_type = 'cnsi' AND t.disconnected = '0' AND t.linked_token IS NULL AND t.token_expiry < $1`
+
+var listTokenShares = `SELECT cnsi_guid, share_type, share_with
+										FROM token_shares
+										WHERE cnsi_guid = $1`
+
+var insertTokenShare = `INSERT INTO token_shares (cnsi_guid, share_type, share_with)
+										VALUES ($1, $2, $3)`
+
+var deleteTokenShare = `DELETE FROM token_shares
+										WHERE cnsi_guid = $1 AND share_type = $2 AND share_with = $3`
+
+var deleteTokenShares = `DELETE FROM token_shares
+										WHERE cnsi_guid = $1`
+
 // PgsqlTokenRepository is a PostgreSQL-backed token repository
 type PgsqlTokenRepository struct {
 	db *sql.DB
@@ -88,6 +115,13 @@ func InitRepositoryProvider(databaseProvider string) {
 	deleteCNSIToken = datastore.ModifySQLStatement(deleteCNSIToken, databaseProvider)
 	deleteCNSITokens = datastore.ModifySQLStatement(deleteCNSITokens, databaseProvider)
 	updateToken = datastore.ModifySQLStatement(updateToken, databaseProvider)
+	disconnectCNSIToken = datastore.ModifySQLStatement(disconnectCNSIToken, databaseProvider)
+	findCNSITokenDisconnectReason = datastore.ModifySQLStatement(findCNSITokenDisconnectReason, databaseProvider)
+	listExpiringCNSITokens = datastore.ModifySQLStatement(listExpiringCNSITokens, databaseProvider)
+	listTokenShares = datastore.ModifySQLStatement(listTokenShares, databaseProvider)
+	insertTokenShare = datastore.ModifySQLStatement(insertTokenShare, databaseProvider)
+	deleteTokenShare = datastore.ModifySQLStatement(deleteTokenShare, databaseProvider)
+	deleteTokenShares = datastore.ModifySQLStatement(deleteTokenShares, databaseProvider)
 }
 
 // saveAuthToken - Save the Auth token to the datastore
@@ -189,10 +223,14 @@ func (p *PgsqlTokenRepository) FindAuthToken(userGUID string, encryptionKey []by
 		return interfaces.TokenRecord{}, err
 	}
 
-	log.Debug("Decrypting Refresh Token")
-	plaintextRefreshToken, err := crypto.DecryptToken(encryptionKey, ciphertextRefreshToken)
-	if err != nil {
-		return interfaces.TokenRecord{}, err
+	// Refresh token is optional (e.g. for bearer tokens)
+	var plaintextRefreshToken string
+	if len(ciphertextRefreshToken) > 0 {
+		log.Debug("Decrypting Refresh Token")
+		plaintextRefreshToken, err = crypto.DecryptToken(encryptionKey, ciphertextRefreshToken)
+		if err != nil {
+			return interfaces.TokenRecord{}, err
+		}
 	}
 
 	// Build a new TokenRecord based on the decrypted tokens
@@ -315,15 +353,21 @@ func (p *PgsqlTokenRepository) SaveCNSIToken(cnsiGUID string, userGUID string, t
 
 func (p *PgsqlTokenRepository) FindCNSIToken(cnsiGUID string, userGUID string, encryptionKey []byte) (interfaces.TokenRecord, error) {
 	log.Debug("FindCNSIToken")
-	return p.findCNSIToken(cnsiGUID, userGUID, encryptionKey, false)
+	return p.findCNSIToken(cnsiGUID, userGUID, encryptionKey, false, true)
 }
 
 func (p *PgsqlTokenRepository) FindCNSITokenIncludeDisconnected(cnsiGUID string, userGUID string, encryptionKey []byte) (interfaces.TokenRecord, error) {
 	log.Debug("FindCNSITokenIncludeDisconnected")
-	return p.findCNSIToken(cnsiGUID, userGUID, encryptionKey, true)
+	return p.findCNSIToken(cnsiGUID, userGUID, encryptionKey, true, true)
 }
 
-func (p *PgsqlTokenRepository) findCNSIToken(cnsiGUID string, userGUID string, encryptionKey []byte, includeDisconnected bool) (interfaces.TokenRecord, error) {
+// FindUserCNSIToken - find the user's own CNSI token, ignoring any system shared token for the CNSI
+func (p *PgsqlTokenRepository) FindUserCNSIToken(cnsiGUID string, userGUID string, includeDisconnected bool, encryptionKey []byte) (interfaces.TokenRecord, error) {
+	log.Debug("FindUserCNSIToken")
+	return p.findCNSIToken(cnsiGUID, userGUID, encryptionKey, includeDisconnected, false)
+}
+
+func (p *PgsqlTokenRepository) findCNSIToken(cnsiGUID string, userGUID string, encryptionKey []byte, includeDisconnected bool, includeShared bool) (interfaces.TokenRecord, error) {
 	log.Debug("findCNSIToken")
 	if cnsiGUID == "" {
 		msg := "Unable to find CNSI Token without a valid CNSI GUID."
@@ -350,11 +394,17 @@ func (p *PgsqlTokenRepository) findCNSIToken(cnsiGUID string, userGUID string, e
 		linkedTokenGUID        sql.NullString
 	)
 
+	// Only match the user's own token if shared tokens should not be included
+	sharedUserGUID := SystemSharedUserGuid
+	if !includeShared {
+		sharedUserGUID = userGUID
+	}
+
 	var err error
 	if includeDisconnected {
-		err = p.db.QueryRow(findCNSIToken, cnsiGUID, userGUID, SystemSharedUserGuid).Scan(&tokenGUID, &ciphertextAuthToken, &ciphertextRefreshToken, &tokenExpiry, &disconnected, &authType, &metadata, &tokenUserGUID, &linkedTokenGUID)
+		err = p.db.QueryRow(findCNSIToken, cnsiGUID, userGUID, sharedUserGUID).Scan(&tokenGUID, &ciphertextAuthToken, &ciphertextRefreshToken, &tokenExpiry, &disconnected, &authType, &metadata, &tokenUserGUID, &linkedTokenGUID)
 	} else {
-		err = p.db.QueryRow(findCNSITokenConnected, cnsiGUID, userGUID, SystemSharedUserGuid).Scan(&tokenGUID, &ciphertextAuthToken, &ciphertextRefreshToken, &tokenExpiry, &disconnected, &authType, &metadata, &tokenUserGUID, &linkedTokenGUID)
+		err = p.db.QueryRow(findCNSITokenConnected, cnsiGUID, userGUID, sharedUserGUID).Scan(&tokenGUID, &ciphertextAuthToken, &ciphertextRefreshToken, &tokenExpiry, &disconnected, &authType, &metadata, &tokenUserGUID, &linkedTokenGUID)
 	}
 
 	if err != nil {
@@ -393,10 +443,14 @@ func (p *PgsqlTokenRepository) findCNSIToken(cnsiGUID string, userGUID string, e
 		return interfaces.TokenRecord{}, err
 	}
 
-	log.Debug("Decrypting Refresh Token")
-	plaintextRefreshToken, err := crypto.DecryptToken(encryptionKey, ciphertextRefreshToken)
-	if err != nil {
-		return interfaces.TokenRecord{}, err
+	// Refresh token is optional (e.g. for bearer tokens)
+	var plaintextRefreshToken string
+	if len(ciphertextRefreshToken) > 0 {
+		log.Debug("Decrypting Refresh Token")
+		plaintextRefreshToken, err = crypto.DecryptToken(encryptionKey, ciphertextRefreshToken)
+		if err != nil {
+			return interfaces.TokenRecord{}, err
+		}
 	}
 
 	// Build a new TokenRecord based on the decrypted tokens
@@ -467,6 +521,46 @@ func (p *PgsqlTokenRepository) DeleteCNSITokens(cnsiGUID string) error {
 	return nil
 }
 
+// PurgeCNSITokens - remove all of the tokens of a CNSI and the shares of its system shared token, when the CNSI is
+// purged. This is done in a single transaction, so either both are removed or neither is
+func (p *PgsqlTokenRepository) PurgeCNSITokens(cnsiGUID string) error {
+	log.Debug("PurgeCNSITokens")
+	txn, err := p.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Unable to start transaction to Purge CNSI tokens: %v", err)
+	}
+
+	if err = p.PurgeCNSITokensInTransaction(txn, cnsiGUID); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return fmt.Errorf("Unable to commit transaction to Purge CNSI tokens: %v", err)
+	}
+
+	return nil
+}
+
+// PurgeCNSITokensInTransaction - remove all of the tokens of a CNSI and the shares of its system shared token as
+// part of a transaction, so that they are removed along with the CNSI itself. The caller commits or rolls back
+func (p *PgsqlTokenRepository) PurgeCNSITokensInTransaction(txn *sql.Tx, cnsiGUID string) error {
+	log.Debug("PurgeCNSITokensInTransaction")
+	if cnsiGUID == "" {
+		msg := "Unable to purge CNSI Tokens without a valid CNSI GUID."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	for _, statement := range []string{deleteCNSITokens, deleteTokenShares} {
+		if _, err := txn.Exec(statement, cnsiGUID); err != nil {
+			return fmt.Errorf("Unable to Purge CNSI tokens: %v", err)
+		}
+	}
+
+	return nil
+}
+
 // UpdateTokenAuth - Update a token's auth data
 func (p *PgsqlTokenRepository) UpdateTokenAuth(userGUID string, tr interfaces.TokenRecord, encryptionKey []byte) error {
 	log.Debug("UpdateTokenAuth")
@@ -545,3 +639,202 @@ func (p *PgsqlTokenRepository) UpdateTokenAuth(userGUID string, tr interfaces.To
 
 	return nil
 }
+
+// DisconnectCNSIToken - mark a CNSI token as disconnected, recording the reason
+func (p *PgsqlTokenRepository) DisconnectCNSIToken(cnsiGUID string, userGUID string, reason string) error {
+	log.Debug("DisconnectCNSIToken")
+	if cnsiGUID == "" {
+		msg := "Unable to disconnect CNSI Token without a valid CNSI GUID."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if userGUID == "" {
+		msg := "Unable to disconnect CNSI Token without a valid User GUID."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	_, err := p.db.Exec(disconnectCNSIToken, true, reason, cnsiGUID, userGUID)
+	if err != nil {
+		msg := "Unable to disconnect CNSI token: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	return nil
+}
+
+// FindCNSITokenDisconnectReason - get the reason that a disconnected CNSI token was disconnected
+func (p *PgsqlTokenRepository) FindCNSITokenDisconnectReason(cnsiGUID string, userGUID string) (string, error) {
+	log.Debug("FindCNSITokenDisconnectReason")
+	if cnsiGUID == "" {
+		msg := "Unable to find CNSI Token without a valid CNSI GUID."
+		log.Debug(msg)
+		return "", errors.New(msg)
+	}
+
+	if userGUID == "" {
+		msg := "Unable to find CNSI Token without a valid User GUID."
+		log.Debug(msg)
+		return "", errors.New(msg)
+	}
+
+	var reason sql.NullString
+	err := p.db.QueryRow(findCNSITokenDisconnectReason, cnsiGUID, userGUID, SystemSharedUserGuid, true).Scan(&reason)
+	if err != nil {
+		msg := "Unable to Find CNSI token: %v"
+		log.Debugf(msg, err)
+		return "", fmt.Errorf(msg, err)
+	}
+
+	return reason.String, nil
+}
+
+// ListExpiringCNSITokens - list the connected CNSI tokens that expire before the given time
+func (p *PgsqlTokenRepository) ListExpiringCNSITokens(expiry int64) ([]*ExpiringToken, error) {
+	log.Debug("ListExpiringCNSITokens")
+	rows, err := p.db.Query(listExpiringCNSITokens, expiry)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve expiring tokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokenList := make([]*ExpiringToken, 0)
+	for rows.Next() {
+		var (
+			cnsiGUID sql.NullString
+			authType sql.NullString
+		)
+		token := new(ExpiringToken)
+		err := rows.Scan(&cnsiGUID, &token.UserGUID, &authType, &token.TokenExpiry)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan expiring token records: %v", err)
+		}
+		token.CNSIGUID = cnsiGUID.String
+		token.AuthType = authType.String
+		tokenList = append(tokenList, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list expiring token records: %v", err)
+	}
+
+	return tokenList, nil
+}
+
+// ListCNSITokenShares - list the users and groups that the system shared token for a CNSI is shared with
+func (p *PgsqlTokenRepository) ListCNSITokenShares(cnsiGUID string) ([]*TokenShare, error) {
+	log.Debug("ListCNSITokenShares")
+	if cnsiGUID == "" {
+		msg := "Unable to list CNSI Token shares without a valid CNSI GUID."
+		log.Debug(msg)
+		return nil, errors.New(msg)
+	}
+
+	rows, err := p.db.Query(listTokenShares, cnsiGUID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve token shares: %v", err)
+	}
+	defer rows.Close()
+
+	shareList := make([]*TokenShare, 0)
+	for rows.Next() {
+		share := new(TokenShare)
+		err := rows.Scan(&share.CNSIGUID, &share.ShareType, &share.ShareWith)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to scan token share records: %v", err)
+		}
+		shareList = append(shareList, share)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to list token share records: %v", err)
+	}
+
+	return shareList, nil
+}
+
+// AddCNSITokenShare - share the system shared token for a CNSI with a user or group
+func (p *PgsqlTokenRepository) AddCNSITokenShare(share TokenShare) error {
+	log.Debug("AddCNSITokenShare")
+	if err := validateTokenShare(share); err != nil {
+		return err
+	}
+
+	_, err := p.db.Exec(insertTokenShare, share.CNSIGUID, share.ShareType, share.ShareWith)
+	if err != nil {
+		msg := "Unable to INSERT token share: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	return nil
+}
+
+// DeleteCNSITokenShare - stop sharing the system shared token for a CNSI with a user or group
+func (p *PgsqlTokenRepository) DeleteCNSITokenShare(share TokenShare) error {
+	log.Debug("DeleteCNSITokenShare")
+	if err := validateTokenShare(share); err != nil {
+		return err
+	}
+
+	result, err := p.db.Exec(deleteTokenShare, share.CNSIGUID, share.ShareType, share.ShareWith)
+	if err != nil {
+		msg := "Unable to Delete token share: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	rowsDeleted, err := result.RowsAffected()
+	if err != nil {
+		return errors.New("Unable to Delete token share: could not determine number of rows that were deleted")
+	}
+
+	if rowsDeleted < 1 {
+		return errors.New("Unable to Delete token share: no rows were deleted")
+	}
+
+	return nil
+}
+
+// DeleteCNSITokenShares - remove all of the shares for the system shared token of a CNSI
+func (p *PgsqlTokenRepository) DeleteCNSITokenShares(cnsiGUID string) error {
+	log.Debug("DeleteCNSITokenShares")
+	if cnsiGUID == "" {
+		msg := "Unable to delete CNSI Token shares without a valid CNSI GUID."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	_, err := p.db.Exec(deleteTokenShares, cnsiGUID)
+	if err != nil {
+		msg := "Unable to Delete token shares: %v"
+		log.Debugf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	return nil
+}
+
+func validateTokenShare(share TokenShare) error {
+	if share.CNSIGUID == "" {
+		msg := "Unable to share CNSI Token without a valid CNSI GUID."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if share.ShareType != TokenShareTypeUser && share.ShareType != TokenShareTypeGroup {
+		msg := "Unable to share CNSI Token without a valid share type."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	if share.ShareWith == "" {
+		msg := "Unable to share CNSI Token without a valid user or group."
+		log.Debug(msg)
+		return errors.New(msg)
+	}
+
+	return nil
+}
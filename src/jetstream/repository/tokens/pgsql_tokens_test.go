@@ -460,3 +460,228 @@ func TestDeleteCNSITokens(t *testing.T) {
 	})
 
 }
+
+func TestPurgeCNSITokens(t *testing.T) {
+
+	Convey("PurgeCNSITokens Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+
+		Convey("should fail to purge tokens with an invalid CNSI GUID", func() {
+			var cnsiGuid string = ""
+			err := repository.PurgeCNSITokens(cnsiGuid)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should roll back when encountering DB error", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(deleteFromTokensSql).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(`DELETE FROM token_shares`).
+				WillReturnError(errors.New("doesn't exist"))
+			mock.ExpectRollback()
+			err := repository.PurgeCNSITokens(mockCNSIGuid)
+
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Test successful path", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec(deleteFromTokensSql).
+				WithArgs(mockCNSIGuid).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectExec(`DELETE FROM token_shares`).
+				WithArgs(mockCNSIGuid).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			err := repository.PurgeCNSITokens(mockCNSIGuid)
+
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+
+	})
+
+}
+
+func TestDisconnectCNSIToken(t *testing.T) {
+
+	Convey("DisconnectCNSIToken Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+
+		Convey("should fail to disconnect token with an invalid CNSI GUID", func() {
+			var cnsiGuid string = ""
+			err := repository.DisconnectCNSIToken(cnsiGuid, mockUserGuid, "reason")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should fail to disconnect token with an invalid user GUID", func() {
+			var userGuid string = ""
+			err := repository.DisconnectCNSIToken(mockCNSIGuid, userGuid, "reason")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Test successful path", func() {
+			mock.ExpectExec(updateUAATokenSql).
+				WithArgs(true, "reason", mockCNSIGuid, mockUserGuid).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			err := repository.DisconnectCNSIToken(mockCNSIGuid, mockUserGuid, "reason")
+
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should find the disconnect reason", func() {
+			mock.ExpectQuery(`SELECT disconnect_reason FROM tokens`).
+				WithArgs(mockCNSIGuid, mockUserGuid, SystemSharedUserGuid, true).
+				WillReturnRows(sqlmock.NewRows([]string{"disconnect_reason"}).AddRow("reason"))
+			reason, err := repository.FindCNSITokenDisconnectReason(mockCNSIGuid, mockUserGuid)
+
+			So(err, ShouldBeNil)
+			So(reason, ShouldEqual, "reason")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+
+	})
+
+}
+
+func TestListExpiringCNSITokens(t *testing.T) {
+
+	Convey("ListExpiringCNSITokens Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+
+		Convey("should throw exception when encountering DB error", func() {
+			mock.ExpectQuery(`SELECT t.cnsi_guid, t.user_guid, t.auth_type, t.token_expiry FROM tokens t, cnsis c WHERE t.cnsi_guid = c.guid AND c.deleted_at IS NULL`).
+				WillReturnError(errors.New("doesn't exist"))
+			_, err := repository.ListExpiringCNSITokens(mockTokenExpiry)
+
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Test successful path", func() {
+			mock.ExpectQuery(`SELECT t.cnsi_guid, t.user_guid, t.auth_type, t.token_expiry FROM tokens t, cnsis c WHERE t.cnsi_guid = c.guid AND c.deleted_at IS NULL`).
+				WithArgs(mockTokenExpiry).
+				WillReturnRows(sqlmock.NewRows([]string{"cnsi_guid", "user_guid", "auth_type", "token_expiry"}).
+					AddRow(mockCNSIGuid, mockUserGuid, interfaces.AuthTypeOAuth2, mockTokenExpiry))
+			tokens, err := repository.ListExpiringCNSITokens(mockTokenExpiry)
+
+			So(err, ShouldBeNil)
+			So(tokens, ShouldHaveLength, 1)
+			So(tokens[0].CNSIGUID, ShouldEqual, mockCNSIGuid)
+			So(tokens[0].AuthType, ShouldEqual, interfaces.AuthTypeOAuth2)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+
+	})
+
+}
+
+func TestFindUserCNSIToken(t *testing.T) {
+
+	Convey("FindUserCNSIToken Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+
+		Convey("should only match the user's own token", func() {
+			mock.ExpectQuery(findTokenSql).
+				WithArgs(mockCNSIGuid, mockUserGuid, mockUserGuid).
+				WillReturnError(sql.ErrNoRows)
+			_, err := repository.FindUserCNSIToken(mockCNSIGuid, mockUserGuid, false, mockEncryptionKey)
+
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+
+	})
+
+}
+
+func TestCNSITokenShares(t *testing.T) {
+
+	Convey("CNSI Token Shares Tests", t, func() {
+
+		db, mock, repository := initialiseRepo(t)
+
+		Convey("should fail to list shares with an invalid CNSI GUID", func() {
+			_, err := repository.ListCNSITokenShares("")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should list shares", func() {
+			mock.ExpectQuery(`SELECT cnsi_guid, share_type, share_with FROM token_shares`).
+				WithArgs(mockCNSIGuid).
+				WillReturnRows(sqlmock.NewRows([]string{"cnsi_guid", "share_type", "share_with"}).
+					AddRow(mockCNSIGuid, TokenShareTypeGroup, "stratos.devs").
+					AddRow(mockCNSIGuid, TokenShareTypeUser, mockUserGuid))
+			shares, err := repository.ListCNSITokenShares(mockCNSIGuid)
+
+			So(err, ShouldBeNil)
+			So(shares, ShouldHaveLength, 2)
+			So(shares[0].ShareType, ShouldEqual, TokenShareTypeGroup)
+			So(shares[0].ShareWith, ShouldEqual, "stratos.devs")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should fail to add a share with an invalid share type", func() {
+			err := repository.AddCNSITokenShare(TokenShare{CNSIGUID: mockCNSIGuid, ShareType: "team", ShareWith: "devs"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should add a share", func() {
+			mock.ExpectExec(`INSERT INTO token_shares`).
+				WithArgs(mockCNSIGuid, TokenShareTypeGroup, "stratos.devs").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			err := repository.AddCNSITokenShare(TokenShare{CNSIGUID: mockCNSIGuid, ShareType: TokenShareTypeGroup, ShareWith: "stratos.devs"})
+
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should fail to delete a share that does not exist", func() {
+			mock.ExpectExec(`DELETE FROM token_shares`).
+				WithArgs(mockCNSIGuid, TokenShareTypeGroup, "stratos.devs").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			err := repository.DeleteCNSITokenShare(TokenShare{CNSIGUID: mockCNSIGuid, ShareType: TokenShareTypeGroup, ShareWith: "stratos.devs"})
+
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("should delete all shares for a CNSI", func() {
+			mock.ExpectExec(`DELETE FROM token_shares`).
+				WithArgs(mockCNSIGuid).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			err := repository.DeleteCNSITokenShares(mockCNSIGuid)
+
+			So(err, ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Reset(func() {
+			db.Close()
+		})
+
+	})
+
+}
@@ -1,6 +1,10 @@
 package tokens
 
-import "github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+import (
+	"database/sql"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+)
 
 // Token -
 type Token struct {
@@ -9,6 +13,27 @@ type Token struct {
 	Record    interfaces.TokenRecord
 }
 
+// ExpiringToken - identifies an endpoint token that is due to expire
+type ExpiringToken struct {
+	CNSIGUID    string
+	UserGUID    string
+	AuthType    string
+	TokenExpiry int64
+}
+
+// TokenShare - a user or group that the system shared token for an endpoint is shared with
+type TokenShare struct {
+	CNSIGUID  string `json:"cnsi_guid"`
+	ShareType string `json:"share_type"`
+	ShareWith string `json:"share_with"`
+}
+
+// Types of principal that a system shared token can be shared with
+const (
+	TokenShareTypeUser  = "user"
+	TokenShareTypeGroup = "group"
+)
+
 const SystemSharedUserGuid = "00000000-1111-2222-3333-444444444444" // User ID for the system shared user for endpoints
 
 // Repository is an application of the repository pattern for storing tokens
@@ -18,10 +43,26 @@ type Repository interface {
 
 	FindCNSIToken(cnsiGUID string, userGUID string, encryptionKey []byte) (interfaces.TokenRecord, error)
 	FindCNSITokenIncludeDisconnected(cnsiGUID string, userGUID string, encryptionKey []byte) (interfaces.TokenRecord, error)
+	FindUserCNSIToken(cnsiGUID string, userGUID string, includeDisconnected bool, encryptionKey []byte) (interfaces.TokenRecord, error)
 	DeleteCNSIToken(cnsiGUID string, userGUID string) error
 	DeleteCNSITokens(cnsiGUID string) error
+	PurgeCNSITokens(cnsiGUID string) error
+	PurgeCNSITokensInTransaction(txn *sql.Tx, cnsiGUID string) error
 	SaveCNSIToken(cnsiGUID string, userGUID string, tokenRecord interfaces.TokenRecord, encryptionKey []byte) error
 
 	// Update a token's auth data
 	UpdateTokenAuth(userGUID string, tokenRecord interfaces.TokenRecord, encryptionKey []byte) error
+
+	// Mark a token as disconnected (e.g. when its refresh token has been rejected)
+	DisconnectCNSIToken(cnsiGUID string, userGUID string, reason string) error
+	FindCNSITokenDisconnectReason(cnsiGUID string, userGUID string) (string, error)
+
+	// List the connected endpoint tokens that expire before the given time
+	ListExpiringCNSITokens(expiry int64) ([]*ExpiringToken, error)
+
+	// Manage the users and groups that a system shared token is shared with
+	ListCNSITokenShares(cnsiGUID string) ([]*TokenShare, error)
+	AddCNSITokenShare(share TokenShare) error
+	DeleteCNSITokenShare(share TokenShare) error
+	DeleteCNSITokenShares(cnsiGUID string) error
 }
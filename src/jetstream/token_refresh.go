@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// Default number of seconds before expiry that the background refresher will renew a token
+const defaultTokenRefreshThresholdInSecs = 120
+
+// tokenRefreshCall is an in-flight or completed token refresh
+type tokenRefreshCall struct {
+	wg    sync.WaitGroup
+	token interfaces.TokenRecord
+	err   error
+}
+
+// tokenRefreshGroup ensures that only one refresh runs at a time for a given token.
+// Concurrent callers wait for the in-flight refresh and share its result rather than
+// racing to use the same refresh token
+type tokenRefreshGroup struct {
+	mutex sync.Mutex
+	calls map[string]*tokenRefreshCall
+}
+
+func newTokenRefreshGroup() *tokenRefreshGroup {
+	return &tokenRefreshGroup{
+		calls: make(map[string]*tokenRefreshCall),
+	}
+}
+
+// Do executes the refresh function, unless a refresh for the same token is already in-flight,
+// in which case it waits for that refresh to complete and returns its result
+func (g *tokenRefreshGroup) Do(key string, refresh func() (interfaces.TokenRecord, error)) (interfaces.TokenRecord, error) {
+	g.mutex.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		log.Debugf("Waiting for in-flight token refresh for %s", key)
+		call.wg.Wait()
+		return call.token, call.err
+	}
+
+	call := new(tokenRefreshCall)
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mutex.Unlock()
+
+	call.token, call.err = refresh()
+	call.wg.Done()
+
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+
+	return call.token, call.err
+}
+
+// refreshCNSIToken refreshes the user's token for the endpoint. Refreshes are coalesced by token, so a system
+// shared token is only refreshed once when several users need it to be refreshed at the same time
+func (p *portalProxy) refreshCNSIToken(cnsiGUID, userGUID string, refresh func(userToken interfaces.TokenRecord) (interfaces.TokenRecord, error)) (interfaces.TokenRecord, error) {
+	userToken, ok := p.GetCNSITokenRecordWithDisconnected(cnsiGUID, userGUID)
+	if !ok {
+		return interfaces.TokenRecord{}, fmt.Errorf("Info could not be found for user with GUID %s", userGUID)
+	}
+
+	return p.TokenRefresh.Do(userToken.TokenGUID, func() (interfaces.TokenRecord, error) {
+		return refresh(userToken)
+	})
+}
+
+// disconnectRejectedToken marks an endpoint token as disconnected if the error indicates
+// that its refresh token was rejected, so that the user knows they need to reconnect
+func (p *portalProxy) disconnectRejectedToken(cnsiGUID, userGUID string, userToken interfaces.TokenRecord, err error) {
+	if !isLoginRejected(err) {
+		return
+	}
+
+	// Linked tokens are managed by the token they are linked to
+	if len(userToken.LinkedGUID) > 0 {
+		return
+	}
+
+	if userToken.SystemShared {
+		userGUID = tokens.SystemSharedUserGuid
+	}
+
+	reason := "Refresh token was rejected by the endpoint"
+	if httpErr, ok := err.(interfaces.ErrHTTPRequest); ok && len(httpErr.Response) > 0 {
+		reason = fmt.Sprintf("%s: %s", reason, httpErr.Response)
+		if len(reason) > 255 {
+			reason = reason[:255]
+		}
+	}
+
+	log.Warnf("Disconnecting token for endpoint %s and user %s: %s", cnsiGUID, userGUID, reason)
+	if err := p.disconnectCNSITokenRecord(cnsiGUID, userGUID, reason); err != nil {
+		log.Errorf("Unable to disconnect token: %v", err)
+	}
+}
+
+func (p *portalProxy) disconnectCNSITokenRecord(cnsiGUID string, userGUID string, reason string) error {
+	log.Debug("disconnectCNSITokenRecord")
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	err = tokenRepo.DisconnectCNSIToken(cnsiGUID, userGUID, reason)
+	if err != nil {
+		msg := "Unable to disconnect a CNSI Token: %v"
+		log.Errorf(msg, err)
+		return fmt.Errorf(msg, err)
+	}
+
+	return nil
+}
+
+// GetCNSITokenDisconnectReason gets the reason that the user's token for the endpoint was disconnected (if any)
+func (p *portalProxy) GetCNSITokenDisconnectReason(cnsiGUID string, userGUID string) (string, bool) {
+	log.Debug("GetCNSITokenDisconnectReason")
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return "", false
+	}
+
+	reason, err := tokenRepo.FindCNSITokenDisconnectReason(cnsiGUID, userGUID)
+	if err != nil || len(reason) == 0 {
+		return "", false
+	}
+
+	return reason, true
+}
+
+// startTokenRefresher starts the optional background refresher, which renews endpoint tokens shortly before they expire
+func (p *portalProxy) startTokenRefresher() {
+	if p.Config.TokenRefreshIntervalInSecs <= 0 {
+		log.Debug("Background token refresh is disabled")
+		return
+	}
+
+	interval := time.Duration(p.Config.TokenRefreshIntervalInSecs) * time.Second
+	log.Infof("Background token refresh enabled - interval: %v, threshold: %ds", interval, p.Config.TokenRefreshThresholdInSecs)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			p.refreshExpiringTokens()
+		}
+	}()
+}
+
+// refreshExpiringTokens refreshes all of the endpoint tokens that will expire within the configured threshold
+func (p *portalProxy) refreshExpiringTokens() {
+	log.Debug("refreshExpiringTokens")
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return
+	}
+
+	expiry := time.Now().Add(time.Duration(p.Config.TokenRefreshThresholdInSecs) * time.Second).Unix()
+	expiring, err := tokenRepo.ListExpiringCNSITokens(expiry)
+	if err != nil {
+		log.Errorf("Unable to list expiring tokens: %v", err)
+		return
+	}
+
+	for _, token := range expiring {
+		cnsi, err := p.GetCNSIRecord(token.CNSIGUID)
+		if err != nil {
+			log.Warnf("Unable to refresh token - could not find endpoint %s: %v", token.CNSIGUID, err)
+			continue
+		}
+
+		switch token.AuthType {
+		case interfaces.AuthTypeOAuth2:
+			_, err = p.RefreshOAuthToken(cnsi.SkipSSLValidation, token.CNSIGUID, token.UserGUID, cnsi.ClientId, cnsi.ClientSecret, cnsi.TokenEndpoint)
+		case interfaces.AuthTypeOIDC:
+			_, err = p.RefreshOidcToken(cnsi.SkipSSLValidation, token.CNSIGUID, token.UserGUID, cnsi.ClientId, cnsi.ClientSecret, cnsi.TokenEndpoint)
+		default:
+			// Token type can not be refreshed
+			continue
+		}
+
+		if err != nil {
+			log.Warnf("Background refresh of token for endpoint %s and user %s failed: %v", token.CNSIGUID, token.UserGUID, err)
+		}
+	}
+}
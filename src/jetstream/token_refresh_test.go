@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenRefreshGroup(t *testing.T) {
+	t.Parallel()
+
+	Convey("Token refresh group tests", t, func() {
+		group := newTokenRefreshGroup()
+
+		Convey("Concurrent refreshes of the same token should only refresh once", func() {
+			var count int32
+			release := make(chan struct{})
+			refresh := func() (interfaces.TokenRecord, error) {
+				atomic.AddInt32(&count, 1)
+				<-release
+				return interfaces.TokenRecord{AuthToken: "refreshed"}, nil
+			}
+
+			var wg sync.WaitGroup
+			results := make([]interfaces.TokenRecord, 5)
+			for i := 0; i < len(results); i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i], _ = group.Do(mockTokenGUID, refresh)
+				}(i)
+			}
+
+			// Give the goroutines a chance to join the in-flight refresh
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			So(atomic.LoadInt32(&count), ShouldEqual, 1)
+			for _, result := range results {
+				So(result.AuthToken, ShouldEqual, "refreshed")
+			}
+		})
+
+		Convey("Refreshes of different tokens should not be coalesced", func() {
+			var count int32
+			refresh := func() (interfaces.TokenRecord, error) {
+				atomic.AddInt32(&count, 1)
+				return interfaces.TokenRecord{}, nil
+			}
+
+			group.Do(mockTokenGUID, refresh)
+			group.Do("another-token", refresh)
+			group.Do(mockTokenGUID, refresh)
+
+			So(atomic.LoadInt32(&count), ShouldEqual, 3)
+		})
+	})
+}
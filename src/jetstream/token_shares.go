@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+)
+
+// findCNSITokenRecord finds the token that the user should use for the endpoint.
+// A system shared token is only used if it has not been restricted to specific users and groups,
+// or if the user is one of those users or a member of one of those groups. Otherwise the user's own token is used
+func (p *portalProxy) findCNSITokenRecord(cnsiGUID string, userGUID string, includeDisconnected bool) (interfaces.TokenRecord, error) {
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		return interfaces.TokenRecord{}, err
+	}
+
+	var tr interfaces.TokenRecord
+	if includeDisconnected {
+		tr, err = tokenRepo.FindCNSITokenIncludeDisconnected(cnsiGUID, userGUID, p.Config.EncryptionKeyInBytes)
+	} else {
+		tr, err = tokenRepo.FindCNSIToken(cnsiGUID, userGUID, p.Config.EncryptionKeyInBytes)
+	}
+	if err != nil || !tr.SystemShared {
+		return tr, err
+	}
+
+	shared, err := p.isTokenSharedWithUser(tokenRepo, cnsiGUID, userGUID)
+	if err != nil {
+		return interfaces.TokenRecord{}, err
+	}
+	if shared {
+		return tr, nil
+	}
+
+	return tokenRepo.FindUserCNSIToken(cnsiGUID, userGUID, includeDisconnected, p.Config.EncryptionKeyInBytes)
+}
+
+// isTokenSharedWithUser determines if the system shared token for the endpoint can be used by the user.
+// The user's groups are taken from the scopes in their UAA token
+func (p *portalProxy) isTokenSharedWithUser(tokenRepo tokens.Repository, cnsiGUID string, userGUID string) (bool, error) {
+	if userGUID == tokens.SystemSharedUserGuid {
+		return true, nil
+	}
+
+	shares, err := tokenRepo.ListCNSITokenShares(cnsiGUID)
+	if err != nil {
+		return false, err
+	}
+
+	// Not restricted - shared with everyone
+	if len(shares) == 0 {
+		return true, nil
+	}
+
+	for _, share := range shares {
+		if share.ShareType == tokens.TokenShareTypeUser && share.ShareWith == userGUID {
+			return true, nil
+		}
+	}
+
+	user, err := p.GetUAAUser(userGUID)
+	if err != nil {
+		log.Warnf("Unable to check token shares - could not get user %s: %v", userGUID, err)
+		return false, nil
+	}
+
+	// Admins can always use the shared token, so that they can manage it
+	if user.Admin {
+		return true, nil
+	}
+
+	for _, share := range shares {
+		if share.ShareType != tokens.TokenShareTypeGroup {
+			continue
+		}
+		for _, scope := range user.Scopes {
+			if share.ShareWith == scope {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Admin endpoint to list the users and groups that the system shared token for an endpoint is shared with
+func (p *portalProxy) listCNSITokenShares(c echo.Context) error {
+	log.Debug("listCNSITokenShares")
+	cnsiGUID := c.FormValue("cnsi_guid")
+	if len(cnsiGUID) == 0 {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Missing target endpoint",
+			"Need CNSI GUID passed as form param")
+	}
+
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	shares, err := tokenRepo.ListCNSITokenShares(cnsiGUID)
+	if err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusInternalServerError,
+			"Failed to list endpoint shares",
+			"Failed to list endpoint shares: %v", err)
+	}
+
+	return c.JSON(http.StatusOK, shares)
+}
+
+// Admin endpoint to share the system shared token for an endpoint with a user or group
+func (p *portalProxy) addCNSITokenShare(c echo.Context) error {
+	log.Debug("addCNSITokenShare")
+	share, err := getTokenShareParams(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.GetCNSIRecord(share.CNSIGUID); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Requested endpoint not registered",
+			"No Endpoint registered with GUID %s: %s", share.CNSIGUID, err)
+	}
+
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	if err = tokenRepo.AddCNSITokenShare(share); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Failed to share endpoint",
+			"Failed to share endpoint: %v", err)
+	}
+
+	return c.JSON(http.StatusCreated, share)
+}
+
+// Admin endpoint to stop sharing the system shared token for an endpoint with a user or group
+func (p *portalProxy) removeCNSITokenShare(c echo.Context) error {
+	log.Debug("removeCNSITokenShare")
+	share, err := getTokenShareParams(c)
+	if err != nil {
+		return err
+	}
+
+	tokenRepo, err := tokens.NewPgsqlTokenRepository(p.DatabaseConnectionPool)
+	if err != nil {
+		log.Errorf(dbReferenceError, err)
+		return fmt.Errorf(dbReferenceError, err)
+	}
+
+	if err = tokenRepo.DeleteCNSITokenShare(share); err != nil {
+		return interfaces.NewHTTPShadowError(
+			http.StatusNotFound,
+			"Endpoint share not found",
+			"Failed to remove endpoint share: %v", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getTokenShareParams(c echo.Context) (tokens.TokenShare, error) {
+	share := tokens.TokenShare{
+		CNSIGUID:  c.FormValue("cnsi_guid"),
+		ShareType: c.FormValue("share_type"),
+		ShareWith: c.FormValue("share_with"),
+	}
+
+	if len(share.CNSIGUID) == 0 {
+		return share, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Missing target endpoint",
+			"Need CNSI GUID passed as form param")
+	}
+
+	if share.ShareType != tokens.TokenShareTypeUser && share.ShareType != tokens.TokenShareTypeGroup {
+		return share, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Share type must be 'user' or 'group'",
+			"Invalid share type: %s", share.ShareType)
+	}
+
+	if len(share.ShareWith) == 0 {
+		return share, interfaces.NewHTTPShadowError(
+			http.StatusBadRequest,
+			"Missing user or group to share with",
+			"Need share_with passed as form param")
+	}
+
+	return share, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/crypto"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/interfaces"
+	"github.com/cloudfoundry-incubator/stratos/src/jetstream/repository/tokens"
+	. "github.com/smartystreets/goconvey/convey"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+const selectTokenSharesSql = `SELECT cnsi_guid, share_type, share_with FROM token_shares`
+
+func expectSystemSharedTokenRow(mockEncryptionKey []byte) sqlmock.Rows {
+	encryptedUaaToken, _ := crypto.EncryptToken(mockEncryptionKey, mockUAAToken)
+	return sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "disconnected", "auth_type", "meta_data", "user_guid", "linked_token"}).
+		AddRow(mockTokenGUID, encryptedUaaToken, encryptedUaaToken, mockTokenExpiry, false, "OAuth2", "", tokens.SystemSharedUserGuid, nil)
+}
+
+func expectTokenShareRows(shares ...tokens.TokenShare) sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"cnsi_guid", "share_type", "share_with"})
+	for _, share := range shares {
+		rows.AddRow(share.CNSIGUID, share.ShareType, share.ShareWith)
+	}
+	return rows
+}
+
+func TestSharedCNSITokenLookup(t *testing.T) {
+	t.Parallel()
+
+	Convey("Shared endpoint token lookup", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		encryptedUAAToken, _ := crypto.EncryptToken(pp.Config.EncryptionKeyInBytes, mockUAAToken)
+		uaaTokenRow := sqlmock.NewRows([]string{"token_guid", "auth_token", "refresh_token", "token_expiry", "auth_type", "meta_data"}).
+			AddRow(mockTokenGUID, encryptedUAAToken, encryptedUAAToken, mockTokenExpiry, "oauth", "")
+
+		mock.ExpectQuery(selectAnyFromTokens).
+			WithArgs(mockCNSIGUID, mockUserGUID, tokens.SystemSharedUserGuid).
+			WillReturnRows(expectSystemSharedTokenRow(pp.Config.EncryptionKeyInBytes))
+
+		Convey("Should use the shared token when it is not restricted", func() {
+			mock.ExpectQuery(selectTokenSharesSql).
+				WillReturnRows(expectTokenShareRows())
+
+			tr, ok := pp.GetCNSITokenRecord(mockCNSIGUID, mockUserGUID)
+			So(ok, ShouldBeTrue)
+			So(tr.SystemShared, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should use the shared token when shared with the user", func() {
+			mock.ExpectQuery(selectTokenSharesSql).
+				WillReturnRows(expectTokenShareRows(tokens.TokenShare{CNSIGUID: mockCNSIGUID, ShareType: tokens.TokenShareTypeUser, ShareWith: mockUserGUID}))
+
+			tr, ok := pp.GetCNSITokenRecord(mockCNSIGUID, mockUserGUID)
+			So(ok, ShouldBeTrue)
+			So(tr.SystemShared, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should use the shared token when shared with one of the user's groups", func() {
+			mock.ExpectQuery(selectTokenSharesSql).
+				WillReturnRows(expectTokenShareRows(tokens.TokenShare{CNSIGUID: mockCNSIGUID, ShareType: tokens.TokenShareTypeGroup, ShareWith: "scim.read"}))
+			mock.ExpectQuery(findUAATokenSql).
+				WillReturnRows(uaaTokenRow)
+
+			tr, ok := pp.GetCNSITokenRecord(mockCNSIGUID, mockUserGUID)
+			So(ok, ShouldBeTrue)
+			So(tr.SystemShared, ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should fall back to the user's own token when not shared with the user", func() {
+			mock.ExpectQuery(selectTokenSharesSql).
+				WillReturnRows(expectTokenShareRows(tokens.TokenShare{CNSIGUID: mockCNSIGUID, ShareType: tokens.TokenShareTypeGroup, ShareWith: "stratos.devs"}))
+			mock.ExpectQuery(findUAATokenSql).
+				WillReturnRows(uaaTokenRow)
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCNSIGUID, mockUserGUID, mockUserGUID).
+				WillReturnError(sql.ErrNoRows)
+
+			_, ok := pp.GetCNSITokenRecord(mockCNSIGUID, mockUserGUID)
+			So(ok, ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}
+
+func TestRefreshCNSIToken(t *testing.T) {
+	t.Parallel()
+
+	Convey("Refresh an endpoint token", t, func() {
+		req := setupMockReq("GET", "", nil)
+		_, _, _, pp, db, mock := setupHTTPTest(req)
+		defer db.Close()
+
+		// inFlight determines if a refresh of the token is in-flight
+		inFlight := func(key string) bool {
+			pp.TokenRefresh.mutex.Lock()
+			defer pp.TokenRefresh.mutex.Unlock()
+			_, ok := pp.TokenRefresh.calls[key]
+			return ok
+		}
+
+		Convey("Should coalesce refreshes of a shared token by the token", func() {
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCNSIGUID, mockUserGUID, tokens.SystemSharedUserGuid).
+				WillReturnRows(expectSystemSharedTokenRow(pp.Config.EncryptionKeyInBytes))
+			mock.ExpectQuery(selectTokenSharesSql).
+				WillReturnRows(expectTokenShareRows())
+
+			tr, err := pp.refreshCNSIToken(mockCNSIGUID, mockUserGUID, func(userToken interfaces.TokenRecord) (interfaces.TokenRecord, error) {
+				So(userToken.SystemShared, ShouldBeTrue)
+				So(inFlight(mockTokenGUID), ShouldBeTrue)
+				return interfaces.TokenRecord{AuthToken: "refreshed"}, nil
+			})
+			So(err, ShouldBeNil)
+			So(tr.AuthToken, ShouldEqual, "refreshed")
+			So(inFlight(mockTokenGUID), ShouldBeFalse)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Should not refresh if the user has no token", func() {
+			mock.ExpectQuery(selectAnyFromTokens).
+				WithArgs(mockCNSIGUID, mockUserGUID, tokens.SystemSharedUserGuid).
+				WillReturnError(sql.ErrNoRows)
+
+			_, err := pp.refreshCNSIToken(mockCNSIGUID, mockUserGUID, func(userToken interfaces.TokenRecord) (interfaces.TokenRecord, error) {
+				t.Error("Refreshed a token that does not exist")
+				return userToken, nil
+			})
+			So(err, ShouldNotBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+}